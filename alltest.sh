@@ -4,6 +4,10 @@ set -o errexit
 set -o pipefail
 set -o nounset
 
+# the row change capture and the state digest of xenomint require the sqlite preupdate hook, the
+# tests of them are skipped without it
+export CGO_CFLAGS="${CGO_CFLAGS:--O2 -g} -DSQLITE_ENABLE_PREUPDATE_HOOK"
+
 main() {
   go test -tags "${UNITTESTTAGS:-}" -race -failfast -parallel 16 -cpu 16 -coverprofile main.cover.out $(go list ./... | grep -v CovenantSQL/api)
   go test -tags "${UNITTESTTAGS:-}" -race -failfast -parallel 16 -cpu 16 -coverpkg ./api/...,./rpc/jsonrpc -coverprofile api.cover.out ./api/...
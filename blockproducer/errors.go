@@ -81,10 +81,27 @@ var (
 	ErrInvalidGasPrice = errors.New("gas price is invalid")
 	// ErrInvalidMinerCount indicates that the miner node count is invalid.
 	ErrInvalidMinerCount = errors.New("miner node count is invalid")
+	// ErrBillingQuorumNotReached indicates that the update billing transaction is not signed by a
+	// quorum of the database miners.
+	ErrBillingQuorumNotReached = errors.New("billing quorum not reached")
+	// ErrInvalidBillingVersion indicates that the update billing transaction is of a legacy version
+	// without the co-signatures of the miners.
+	ErrInvalidBillingVersion = errors.New("invalid billing version")
+	// ErrInvalidExtensions indicates that the allowed sqlite extensions of the database is invalid.
+	ErrInvalidExtensions = errors.New("sqlite extensions is invalid")
 	// ErrLocalNodeNotFound indicates that the local node id is not found in the given peer list.
 	ErrLocalNodeNotFound = errors.New("local node id not found in peer list")
 	// ErrNoAvailableBranch indicates that there is no available branch from the state storage.
 	ErrNoAvailableBranch = errors.New("no available branch from state storage")
 	// ErrWrongTokenType indicates that token type in transfer is wrong.
 	ErrWrongTokenType = errors.New("wrong token type")
+	// ErrInvalidKeyVersion indicates that the key version of issued keys is not greater than the
+	// current one.
+	ErrInvalidKeyVersion = errors.New("invalid key version")
+	// ErrInvalidPlacement indicates that the replica placement constraints are invalid.
+	ErrInvalidPlacement = errors.New("placement constraint is invalid")
+	// ErrMinerPlacementNotMatch indicates that the miner violates the replica placement constraints.
+	ErrMinerPlacementNotMatch = errors.New("miner placement not match")
+	// ErrInvalidQuota indicates that the storage quota is not greater than the current one.
+	ErrInvalidQuota = errors.New("invalid storage quota")
 )
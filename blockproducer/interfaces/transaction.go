@@ -72,6 +72,8 @@ const (
 	TransactionTypeIssueKeys
 	// TransactionTypeUpdateBilling defines SQLChain update billing information.
 	TransactionTypeUpdateBilling
+	// TransactionTypeUpdateQuota defines SQLChain owner raise storage quota.
+	TransactionTypeUpdateQuota
 	// TransactionTypeNumber defines transaction types number.
 	TransactionTypeNumber
 )
@@ -102,6 +104,8 @@ func (t TransactionType) String() string {
 		return "IssueKeys"
 	case TransactionTypeUpdateBilling:
 		return "UpdateBilling"
+	case TransactionTypeUpdateQuota:
+		return "UpdateQuota"
 	default:
 		return "Unknown"
 	}
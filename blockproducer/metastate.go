@@ -34,6 +34,12 @@ import (
 
 var (
 	sqlchainPeriod uint64 = 60 * 24 * 30
+	// maxMissedBillings is the count of the consecutive billings a miner can miss before it is
+	// considered offline and replaced.
+	maxMissedBillings uint32 = 3
+	// quotaUnit is the storage quota in bytes covered by the minimum deposit of a database, each
+	// started unit of the raised quota requires an extra deposit as much.
+	quotaUnit uint64 = 1 << 30
 )
 
 // TODO(leventeliu): lock optimization.
@@ -546,6 +552,10 @@ func (s *metaState) updateProviderList(tx *types.ProvideService, height uint32)
 		GasPrice:      tx.GasPrice,
 		NodeID:        tx.NodeID,
 	}
+	if tx.Version > 0 {
+		// labels are not covered by the tx hash in the legacy version
+		pp.Labels = tx.Labels
+	}
 	s.dirty.provider[sender] = &pp
 	return
 }
@@ -571,6 +581,23 @@ func (s *metaState) matchProvidersWithUser(tx *types.CreateDatabase) (err error)
 		err = ErrInvalidMinerCount
 		return
 	}
+	// extensions are not covered by the tx hash in the legacy version
+	if ext := tx.ResourceMeta.Extensions; (tx.ResourceMeta.Version == 0 && ext != 0) ||
+		!types.AllExtensions.Has(ext) {
+		err = ErrInvalidExtensions
+		return
+	}
+	// placement constraints are not covered by the tx hash before version 1
+	if len(tx.ResourceMeta.Placement) > 0 && tx.ResourceMeta.Version < 1 {
+		err = ErrInvalidPlacement
+		return
+	}
+	for _, c := range tx.ResourceMeta.Placement {
+		if err = c.Validate(); err != nil {
+			err = errors.Wrap(ErrInvalidPlacement, err.Error())
+			return
+		}
+	}
 	minerCount := uint64(tx.ResourceMeta.Node)
 
 	minAdvancePayment := minDeposit(tx.GasPrice, minerCount)
@@ -582,7 +609,10 @@ func (s *metaState) matchProvidersWithUser(tx *types.CreateDatabase) (err error)
 		return
 	}
 
-	miners := make(MinerInfos, 0, minerCount)
+	var (
+		miners = make(MinerInfos, 0, minerCount)
+		pl     = newPlacement(tx.ResourceMeta.Placement)
+	)
 
 	for _, m := range tx.ResourceMeta.TargetMiners {
 		if po, loaded := s.loadProviderObject(m); !loaded {
@@ -593,7 +623,7 @@ func (s *metaState) matchProvidersWithUser(tx *types.CreateDatabase) (err error)
 			err = ErrNoSuchMiner
 			continue
 		} else {
-			miners, err = filterAndAppendMiner(miners, po, tx, sender)
+			miners, err = filterAndAppendMiner(miners, po, tx, sender, pl)
 			if err != nil {
 				log.Warnf("miner filtered %v", err)
 			}
@@ -612,7 +642,7 @@ func (s *metaState) matchProvidersWithUser(tx *types.CreateDatabase) (err error)
 		}
 		var newMiners MinerInfos
 		// create new merged map
-		newMiners, err = s.filterNMiners(tx, sender, int(minerCount)-miners.Len())
+		newMiners, err = s.filterNMiners(tx, sender, int(minerCount)-miners.Len(), pl)
 		if err != nil {
 			return
 		}
@@ -695,7 +725,8 @@ func (s *metaState) matchProvidersWithUser(tx *types.CreateDatabase) (err error)
 func (s *metaState) filterNMiners(
 	tx *types.CreateDatabase,
 	user proto.AccountAddress,
-	minerCount int) (
+	minerCount int,
+	pl *placement) (
 	m MinerInfos, err error,
 ) {
 	// create new merged map
@@ -716,19 +747,35 @@ func (s *metaState) filterNMiners(
 		delete(allProviderMap, m)
 	}
 
-	// suppose 1/4 miners match
-	newMiners := make(MinerInfos, 0, len(allProviderMap)/4)
-	// filter all miners to slice and sort
+	// sort all miners by preference, gas price, node id and address, and pick the matched ones in
+	// order, so that the cheapest eligible miners are selected deterministically
+	providers := make([]*types.ProviderProfile, 0, len(allProviderMap))
 	for _, po := range allProviderMap {
-		newMiners, _ = filterAndAppendMiner(newMiners, po, tx, user)
+		providers = append(providers, po)
+	}
+	sort.Slice(providers, func(i, j int) bool {
+		if pi, pj := pl.preference(providers[i]), pl.preference(providers[j]); pi != pj {
+			return pi > pj
+		}
+		if providers[i].GasPrice != providers[j].GasPrice {
+			return providers[i].GasPrice < providers[j].GasPrice
+		}
+		if providers[i].NodeID != providers[j].NodeID {
+			return providers[i].NodeID < providers[j].NodeID
+		}
+		return bytes.Compare(providers[i].Provider[:], providers[j].Provider[:]) < 0
+	})
+	newMiners := make(MinerInfos, 0, minerCount)
+	for _, po := range providers {
+		if newMiners, _ = filterAndAppendMiner(newMiners, po, tx, user, pl); newMiners.Len() == minerCount {
+			break
+		}
 	}
 	if newMiners.Len() < minerCount {
 		err = ErrNoEnoughMiner
 		return
 	}
-
-	sort.Slice(newMiners, newMiners.Less)
-	return newMiners[:minerCount], nil
+	return newMiners, nil
 }
 
 func filterAndAppendMiner(
@@ -736,6 +783,7 @@ func filterAndAppendMiner(
 	po *types.ProviderProfile,
 	req *types.CreateDatabase,
 	user proto.AccountAddress,
+	pl *placement,
 ) (newMiners MinerInfos, err error) {
 	newMiners = miners
 	if !isProviderUserMatch(po.TargetUser, user) {
@@ -746,10 +794,15 @@ func filterAndAppendMiner(
 	if match, err = isProviderReqMatch(po, req); !match {
 		return
 	}
+	if err = pl.accept(po); err != nil {
+		return
+	}
 	newMiners = append(miners, &types.MinerInfo{
-		Address: po.Provider,
-		NodeID:  po.NodeID,
-		Deposit: po.Deposit,
+		Address:  po.Provider,
+		NodeID:   po.NodeID,
+		Deposit:  po.Deposit,
+		GasPrice: po.GasPrice,
+		Labels:   po.Labels,
 	})
 	return
 }
@@ -798,6 +851,14 @@ func isProviderReqMatch(po *types.ProviderProfile, req *types.CreateDatabase) (m
 			po.TokenType, req.TokenType)
 		return
 	}
+	for _, c := range req.ResourceMeta.Placement {
+		if c.Type == types.PlacementRequire && !types.HasLabel(po.Labels, c.Label) {
+			err = errors.New("label mismatch")
+			log.WithError(err).Debugf("miner's labels: %v, user's required label: %s",
+				po.Labels, c.Label)
+			return
+		}
+	}
 
 	return true, nil
 }
@@ -880,30 +941,101 @@ func (s *metaState) updateKeys(tx *types.IssueKeys) (err error) {
 	}
 
 	// check sender's permission
+	var isSuper bool
 	for _, user := range so.Users {
 		if sender == user.Address {
-			if !user.Permission.HasSuperPermission() {
-				log.WithFields(log.Fields{
-					"sender": sender,
-					"dbID":   tx.TargetSQLChain,
-				}).WithError(ErrAccountPermissionDeny).Error("unexpected error in updateKeys")
-				return ErrAccountPermissionDeny
-			}
-
+			isSuper = user.Permission.HasSuperPermission()
 			break
 		}
 	}
+	if !isSuper {
+		log.WithFields(log.Fields{
+			"sender": sender,
+			"dbID":   tx.TargetSQLChain,
+		}).WithError(ErrAccountPermissionDeny).Error("unexpected error in updateKeys")
+		return ErrAccountPermissionDeny
+	}
 
 	// update miner's key
 	keyMap := make(map[proto.AccountAddress]string)
 	for i := range tx.MinerKeys {
 		keyMap[tx.MinerKeys[i].Miner] = tx.MinerKeys[i].EncryptionKey
 	}
+	if tx.Version > 0 {
+		// keys of version 1 rotate the current keys, check key version first
+		for _, miner := range so.Miners {
+			if _, ok := keyMap[miner.Address]; ok && tx.KeyVersion <= miner.KeyVersion {
+				err = errors.Wrapf(ErrInvalidKeyVersion,
+					"issue key version %d to miner %s with key version %d",
+					tx.KeyVersion, miner.Address, miner.KeyVersion)
+				return
+			}
+		}
+	}
 	for _, miner := range so.Miners {
 		if key, ok := keyMap[miner.Address]; ok {
 			miner.EncryptionKey = key
+			if tx.Version > 0 {
+				miner.KeyVersion = tx.KeyVersion
+			}
 		}
 	}
+	s.dirty.databases[tx.TargetSQLChain.DatabaseID()] = so
+	return
+}
+
+func (s *metaState) updateQuota(tx *types.UpdateQuota) (err error) {
+	sender := tx.GetAccountAddress()
+	so, loaded := s.loadSQLChainObject(tx.TargetSQLChain.DatabaseID())
+	if !loaded {
+		err = errors.Wrap(ErrDatabaseNotFound, "update quota failed")
+		return
+	}
+
+	// check sender's permission
+	var payer *types.SQLChainUser
+	for _, user := range so.Users {
+		if sender == user.Address && user.Permission.HasSuperPermission() {
+			payer = user
+			break
+		}
+	}
+	if payer == nil {
+		log.WithFields(log.Fields{
+			"sender": sender,
+			"dbID":   tx.TargetSQLChain,
+		}).WithError(ErrAccountPermissionDeny).Error("unexpected error in updateQuota")
+		return ErrAccountPermissionDeny
+	}
+
+	// the quota can only be raised, so that no miner holds more data than the quota, and an
+	// unlimited quota can't be raised at all
+	if so.Meta.Space == 0 || tx.Space <= so.Meta.Space {
+		err = errors.Wrapf(ErrInvalidQuota,
+			"update quota from %d to %d", so.Meta.Space, tx.Space)
+		return
+	}
+	// the sender pays the extra deposit of the raised quota units
+	var (
+		base    = minDeposit(so.GasPrice, uint64(len(so.Miners)))
+		units   = quotaUnits(tx.Space) - quotaUnits(so.Meta.Space)
+		deposit = base * units
+	)
+	if units > 0 && deposit/units != base {
+		err = errors.Wrapf(ErrBalanceOverflow, "deposit of %d quota units", units)
+		return
+	}
+	if deposit > 0 {
+		if err = safeAdd(&payer.Deposit, &deposit); err != nil {
+			return
+		}
+		if err = s.decreaseAccountToken(sender, deposit, so.TokenType); err != nil {
+			err = errors.Wrapf(err, "pay deposit %d for quota %d", deposit, tx.Space)
+			return
+		}
+	}
+	so.Meta.Space = tx.Space
+	s.dirty.databases[tx.TargetSQLChain.DatabaseID()] = so
 	return
 }
 
@@ -914,7 +1046,13 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 		return
 	}
 
-	if tx.Version > 0 && (tx.Range.From >= tx.Range.To || newProfile.LastUpdatedHeight != tx.Range.From) {
+	// the legacy billings are signed by the proposer only, which can't prove the agreement of the
+	// other miners
+	if tx.Version < 2 {
+		err = errors.Wrapf(ErrInvalidBillingVersion, "update billing version %d", tx.Version)
+		return
+	}
+	if tx.Range.From >= tx.Range.To || newProfile.LastUpdatedHeight != tx.Range.From {
 		err = errors.Wrapf(ErrInvalidRange,
 			"update billing within range %d:(%d, %d]",
 			newProfile.LastUpdatedHeight, tx.Range.From, tx.Range.To)
@@ -931,6 +1069,7 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 		userMap   = make(map[proto.AccountAddress]map[proto.AccountAddress]uint64)
 		minerAddr = tx.GetAccountAddress()
 		isMiner   = false
+		offline   []*types.MinerInfo
 	)
 	for _, miner := range newProfile.Miners {
 		isMiner = isMiner || (miner.Address == minerAddr)
@@ -945,6 +1084,12 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 		}).WithError(err).Warning("sender does not exists in sqlchain (updateBilling)")
 		return
 	}
+	if err = verifyBillingQuorum(tx, newProfile.Miners); err != nil {
+		return
+	}
+	if offline, err = s.trackMissedBillings(tx, newProfile); err != nil {
+		return
+	}
 
 	for _, userCost := range tx.Users {
 		log.Debugf("update billing user cost: %s, cost: %d", userCost.User, userCost.Cost)
@@ -957,17 +1102,28 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 		}
 	}
 	for _, user := range newProfile.Users {
-		if user.AdvancePayment >= costMap[user.Address]*newProfile.GasPrice {
-			user.AdvancePayment -= costMap[user.Address] * newProfile.GasPrice
+		// each miner's income is charged by its own gas price, and the rest of the cost, which is
+		// not earned by any current miner, is charged by the database gas price
+		var charge, earned uint64
+		for _, miner := range newProfile.Miners {
+			income := userMap[user.Address][miner.Address]
+			charge += income * newProfile.MinerGasPrice(miner)
+			earned += income
+		}
+		if cost := costMap[user.Address]; cost > earned {
+			charge += (cost - earned) * newProfile.GasPrice
+		}
+		if user.AdvancePayment >= charge {
+			user.AdvancePayment -= charge
 			for _, miner := range newProfile.Miners {
-				miner.PendingIncome += userMap[user.Address][miner.Address] * newProfile.GasPrice
+				miner.PendingIncome += userMap[user.Address][miner.Address] * newProfile.MinerGasPrice(miner)
 			}
 		} else {
-			rate := float64(user.AdvancePayment) / float64(costMap[user.Address]*newProfile.GasPrice)
+			rate := float64(user.AdvancePayment) / float64(charge)
 			user.AdvancePayment = 0
 			user.Status = types.Arrears
 			for _, miner := range newProfile.Miners {
-				income := userMap[user.Address][miner.Address] * newProfile.GasPrice
+				income := userMap[user.Address][miner.Address] * newProfile.MinerGasPrice(miner)
 				minerIncome := uint64(float64(income) * rate)
 				miner.PendingIncome += minerIncome
 				if miner.UserArrears == nil {
@@ -993,11 +1149,164 @@ func (s *metaState) updateBilling(tx *types.UpdateBilling) (err error) {
 			}
 		}
 	}
+	// the offline miners are replaced after they are paid for this billing range, keep the
+	// database under-replicated if no replacement is available for now, and try again on the
+	// next billing
+	if len(offline) > 0 {
+		if err = s.replaceMiners(newProfile, offline); err != nil {
+			log.WithFields(log.Fields{
+				"db_id":   newProfile.ID,
+				"offline": len(offline),
+			}).WithError(err).Warning("failed to replace offline miners")
+			err = nil
+		}
+	}
 	newProfile.LastUpdatedHeight = tx.Range.To
 	s.dirty.databases[tx.Receiver.DatabaseID()] = newProfile
 	return
 }
 
+// verifyBillingQuorum checks that the update billing transaction is signed by a quorum of the
+// database miners, including the proposer. The quorum required by the proposer is respected if it
+// is larger.
+func verifyBillingQuorum(tx *types.UpdateBilling, miners []*types.MinerInfo) (err error) {
+	var signers []proto.AccountAddress
+	if signers, err = tx.Signers(); err != nil {
+		return errors.Wrap(err, "verify billing signatures failed")
+	}
+	var count int
+	for _, v := range signers {
+		for _, miner := range miners {
+			if miner.Address == v {
+				count++
+				break
+			}
+		}
+	}
+	var quorum = types.BillingQuorum(len(miners))
+	if int(tx.Quorum) > quorum {
+		quorum = int(tx.Quorum)
+	}
+	if count < quorum {
+		return errors.Wrapf(ErrBillingQuorumNotReached,
+			"signed by %d of %d miners, quorum %d", count, len(miners), quorum)
+	}
+	return
+}
+
+// trackMissedBillings counts the consecutive billings not signed by each miner, and replaces
+// the miners which have missed too many billings with the newly matched providers.
+func (s *metaState) trackMissedBillings(
+	tx *types.UpdateBilling, profile *types.SQLChainProfile) (offline []*types.MinerInfo, err error,
+) {
+	var (
+		signers []proto.AccountAddress
+		signed  = make(map[proto.AccountAddress]bool)
+	)
+	if signers, err = tx.Signers(); err != nil {
+		err = errors.Wrap(err, "track missed billings failed")
+		return
+	}
+	for _, v := range signers {
+		signed[v] = true
+	}
+	for _, miner := range profile.Miners {
+		if signed[miner.Address] {
+			miner.MissedBillings = 0
+			continue
+		}
+		if miner.MissedBillings++; miner.MissedBillings >= maxMissedBillings {
+			offline = append(offline, miner)
+		}
+	}
+	return
+}
+
+// replaceMiners matches new providers for the database by its resource requirement, and
+// reassigns the database from the offline miners to them. The incomes of the offline miners are
+// settled to their accounts, and the user arrears owed to them are written off.
+func (s *metaState) replaceMiners(
+	profile *types.SQLChainProfile, offline []*types.MinerInfo) (err error,
+) {
+	var (
+		isOffline = make(map[proto.AccountAddress]bool)
+		remaining = make(MinerInfos, 0, len(profile.Miners))
+		exclude   = make([]proto.AccountAddress, 0, len(profile.Miners))
+		pl        = newPlacement(profile.Meta.Placement)
+		newMiners MinerInfos
+	)
+	for _, miner := range offline {
+		isOffline[miner.Address] = true
+	}
+	for _, miner := range profile.Miners {
+		exclude = append(exclude, miner.Address)
+		if !isOffline[miner.Address] {
+			remaining = append(remaining, miner)
+			pl.seed(miner.Labels)
+		}
+	}
+	// match the providers as the database is newly created with the same requirement, excluding
+	// the current miners
+	var req = &types.CreateDatabase{
+		CreateDatabaseHeader: types.CreateDatabaseHeader{
+			Owner:        profile.Owner,
+			ResourceMeta: profile.Meta,
+			GasPrice:     profile.GasPrice,
+			TokenType:    profile.TokenType,
+		},
+	}
+	req.ResourceMeta.TargetMiners = exclude
+	if newMiners, err = s.filterNMiners(req, profile.Owner, len(offline), pl); err != nil {
+		return
+	}
+	for _, miner := range offline {
+		if err = s.settleMinerIncome(profile, miner); err != nil {
+			return
+		}
+	}
+	for _, miner := range newMiners {
+		s.deleteProviderObject(miner.Address)
+	}
+	log.WithFields(log.Fields{
+		"db_id":   profile.ID,
+		"offline": offline,
+		"new":     newMiners,
+	}).Info("replace offline miners of sqlchain")
+	profile.Miners = append(remaining, newMiners...)
+	return
+}
+
+// settleMinerIncome pays the received and pending incomes of the miner leaving the database to
+// its account, and writes off the user arrears owed to it.
+func (s *metaState) settleMinerIncome(
+	profile *types.SQLChainProfile, miner *types.MinerInfo) (err error,
+) {
+	var income = miner.ReceivedIncome
+	if err = safeAdd(&income, &miner.PendingIncome); err != nil {
+		return
+	}
+	if income > 0 {
+		s.loadOrStoreAccountObject(miner.Address, &types.Account{Address: miner.Address})
+		if err = s.increaseAccountToken(miner.Address, income, profile.TokenType); err != nil {
+			return
+		}
+	}
+	for _, ua := range miner.UserArrears {
+		for _, user := range profile.Users {
+			if user.Address != ua.User {
+				continue
+			}
+			if user.Arrears > ua.Arrears {
+				user.Arrears -= ua.Arrears
+			} else {
+				user.Arrears = 0
+			}
+		}
+	}
+	miner.ReceivedIncome, miner.PendingIncome, miner.UserArrears = 0, 0, nil
+	return
+}
+
 func (s *metaState) loadROSQLChains(addr proto.AccountAddress) (dbs []*types.SQLChainProfile) {
 	for _, db := range s.readonly.databases {
 		for _, miner := range db.Miners {
@@ -1146,6 +1455,8 @@ func (s *metaState) applyTransaction(tx pi.Transaction, height uint32) (err erro
 		err = s.updateKeys(t)
 	case *types.UpdateBilling:
 		err = s.updateBilling(t)
+	case *types.UpdateQuota:
+		err = s.updateQuota(t)
 	case *pi.TransactionWrapper:
 		// call again using unwrapped transaction
 		err = s.applyTransaction(t.Unwrap(), height)
@@ -1249,6 +1560,12 @@ func (s *metaState) compileChanges(
 	return
 }
 
+// quotaUnits returns the count of the started quota units of the storage quota space, which must
+// be positive.
+func quotaUnits(space uint64) uint64 {
+	return (space-1)/quotaUnit + 1
+}
+
 func minDeposit(gasPrice uint64, minerNumber uint64) uint64 {
 	return gasPrice * uint64(conf.GConf.QPS) *
 		conf.GConf.BillingBlockCount * minerNumber
@@ -17,6 +17,7 @@
 package blockproducer
 
 import (
+	"fmt"
 	"math"
 	"os"
 	"testing"
@@ -768,6 +769,37 @@ func TestMetaState(t *testing.T) {
 				}
 				err = invalidCd6.Sign(privKey3)
 				So(err, ShouldBeNil)
+				invalidExtCd1 := types.CreateDatabase{
+					CreateDatabaseHeader: types.CreateDatabaseHeader{
+						Owner: addr3,
+						ResourceMeta: types.ResourceMeta{
+							TargetMiners: []proto.AccountAddress{addr2},
+							Node:         1,
+							Extensions:   types.ExtensionFTS5, // legacy version
+						},
+						Nonce:          1,
+						GasPrice:       1,
+						AdvancePayment: uint64(conf.GConf.QPS) * conf.GConf.BillingBlockCount * 1,
+					},
+				}
+				err = invalidExtCd1.Sign(privKey3)
+				So(err, ShouldBeNil)
+				invalidExtCd2 := types.CreateDatabase{
+					CreateDatabaseHeader: types.CreateDatabaseHeader{
+						Owner: addr3,
+						ResourceMeta: types.ResourceMeta{
+							TargetMiners: []proto.AccountAddress{addr2},
+							Node:         1,
+							Extensions:   types.AllExtensions + 1,
+						},
+						Nonce:          1,
+						GasPrice:       1,
+						AdvancePayment: uint64(conf.GConf.QPS) * conf.GConf.BillingBlockCount * 1,
+					},
+				}
+				invalidExtCd2.ResourceMeta.Version = int32(invalidExtCd2.ResourceMeta.HSPDefaultVersion())
+				err = invalidExtCd2.Sign(privKey3)
+				So(err, ShouldBeNil)
 				invalidCd7 := types.CreateDatabase{
 					CreateDatabaseHeader: types.CreateDatabaseHeader{
 						Owner: addr3,
@@ -821,6 +853,10 @@ func TestMetaState(t *testing.T) {
 				So(errors.Cause(err), ShouldEqual, ErrNoEnoughMiner)
 				err = ms.apply(&invalidCd6, 0)
 				So(errors.Cause(err), ShouldEqual, ErrInvalidMinerCount)
+				err = ms.apply(&invalidExtCd1, 0)
+				So(errors.Cause(err), ShouldEqual, ErrInvalidExtensions)
+				err = ms.apply(&invalidExtCd2, 0)
+				So(errors.Cause(err), ShouldEqual, ErrInvalidExtensions)
 				ms.dirty.provider[proto.AccountAddress(hash.HashH([]byte("1")))] = &types.ProviderProfile{
 					TargetUser: nil,
 				}
@@ -930,6 +966,94 @@ func TestMetaState(t *testing.T) {
 				So(mIDs, ShouldContain, "0000003")
 				So(mIDs, ShouldContain, "0000001")
 			})
+			Convey("When placement constraints are given", func() {
+				for i, labels := range [][]string{
+					{"zone=a", "ssd"},
+					{"zone=a", "ssd"},
+					{"zone=b", "ssd"},
+					{"zone=c"},
+					{"ssd"},
+				} {
+					var addr = proto.AccountAddress(hash.HashH([]byte(fmt.Sprint("placement", i))))
+					ms.dirty.provider[addr] = &types.ProviderProfile{
+						Provider: addr,
+						GasPrice: 1,
+						NodeID:   proto.NodeID(fmt.Sprintf("%07d", i)),
+						Labels:   labels,
+					}
+				}
+				var newCd = func(node uint16, placement string, version int32) *types.CreateDatabase {
+					cs, err := types.ParsePlacementConstraints(placement)
+					So(err, ShouldBeNil)
+					cd := types.NewCreateDatabase(&types.CreateDatabaseHeader{
+						Owner: addr1,
+						ResourceMeta: types.ResourceMeta{
+							Node:      node,
+							Placement: cs,
+							Version:   version,
+						},
+						GasPrice:       1,
+						AdvancePayment: uint64(conf.GConf.QPS) * conf.GConf.BillingBlockCount * uint64(node),
+						TokenType:      types.Particle,
+					})
+					cd.Nonce, err = ms.nextNonce(addr1)
+					So(err, ShouldBeNil)
+					err = cd.Sign(privKey1)
+					So(err, ShouldBeNil)
+					return cd
+				}
+				var version = int32((&types.ResourceMeta{}).HSPDefaultVersion())
+				err = ms.apply(newCd(2, "distinct:zone", 0), 0)
+				So(errors.Cause(err), ShouldEqual, ErrInvalidPlacement)
+				err = ms.apply(newCd(3, "distinct:zone,require:ssd", version), 0)
+				So(errors.Cause(err), ShouldEqual, ErrNoEnoughMiner)
+				var cd = newCd(2, "distinct:zone,require:ssd,prefer:zone=b", version)
+				err = ms.apply(cd, 0)
+				So(err, ShouldBeNil)
+				var (
+					dbID   = proto.FromAccountAndNonce(addr1, uint32(cd.Nonce))
+					miners []proto.NodeID
+				)
+				for _, m := range ms.dirty.databases[dbID].Miners {
+					miners = append(miners, m.NodeID)
+				}
+				So(miners, ShouldResemble, []proto.NodeID{"0000002", "0000000"})
+			})
+			Convey("When providers bid different gas prices", func() {
+				for i, price := range []uint64{3, 1, 5, 2, 1} {
+					var addr = proto.AccountAddress(hash.HashH([]byte(fmt.Sprint("price", i))))
+					ms.dirty.provider[addr] = &types.ProviderProfile{
+						Provider: addr,
+						GasPrice: price,
+						NodeID:   proto.NodeID(fmt.Sprintf("%07d", 4-i)),
+					}
+				}
+				cd := types.NewCreateDatabase(&types.CreateDatabaseHeader{
+					Owner:          addr1,
+					ResourceMeta:   types.ResourceMeta{Node: 3},
+					GasPrice:       4,
+					AdvancePayment: uint64(conf.GConf.QPS) * conf.GConf.BillingBlockCount * 4 * 3,
+					TokenType:      types.Particle,
+				})
+				cd.Nonce, err = ms.nextNonce(addr1)
+				So(err, ShouldBeNil)
+				err = cd.Sign(privKey1)
+				So(err, ShouldBeNil)
+				err = ms.apply(cd, 0)
+				So(err, ShouldBeNil)
+				var (
+					dbID   = proto.FromAccountAndNonce(addr1, uint32(cd.Nonce))
+					miners []proto.NodeID
+					prices []uint64
+				)
+				for _, m := range ms.dirty.databases[dbID].Miners {
+					miners = append(miners, m.NodeID)
+					prices = append(prices, m.GasPrice)
+				}
+				// the cheapest miners are selected, ties are broken by node id
+				So(miners, ShouldResemble, []proto.NodeID{"0000000", "0000003", "0000001"})
+				So(prices, ShouldResemble, []uint64{1, 1, 2})
+			})
 			Convey("When SQLChain create", func() {
 				ps := types.ProvideService{
 					ProvideServiceHeader: types.ProvideServiceHeader{
@@ -1076,6 +1200,143 @@ func TestMetaState(t *testing.T) {
 						continue
 					}
 				}
+				Convey("issue and rotate encryption keys", func() {
+					ik := types.NewIssueKeys(&types.IssueKeysHeader{
+						TargetSQLChain: dbAccount,
+						MinerKeys: []types.MinerKey{
+							{Miner: addr2, EncryptionKey: "key1"},
+						},
+					})
+					// addr1(read) issue keys fail
+					ik.Nonce, err = ms.nextNonce(addr1)
+					So(err, ShouldBeNil)
+					err = ik.Sign(privKey1)
+					So(err, ShouldBeNil)
+					err = ms.apply(ik, 0)
+					So(errors.Cause(err), ShouldEqual, ErrAccountPermissionDeny)
+					// addr3(admin) issue keys without key version
+					ik.Nonce, err = ms.nextNonce(addr3)
+					So(err, ShouldBeNil)
+					err = ik.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(ik, 0)
+					So(err, ShouldBeNil)
+					ms.commit()
+					profile, ok := ms.loadSQLChainObject(dbID)
+					So(ok, ShouldBeTrue)
+					So(profile.Miners[0].EncryptionKey, ShouldEqual, "key1")
+					So(profile.Miners[0].KeyVersion, ShouldEqual, 0)
+					// rotate keys
+					ik.Version = int32(ik.HSPDefaultVersion())
+					ik.KeyVersion = 1
+					ik.MinerKeys[0].EncryptionKey = "key2"
+					ik.Nonce, err = ms.nextNonce(addr3)
+					So(err, ShouldBeNil)
+					err = ik.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(ik, 0)
+					So(err, ShouldBeNil)
+					ms.commit()
+					profile, ok = ms.loadSQLChainObject(dbID)
+					So(ok, ShouldBeTrue)
+					So(profile.Miners[0].EncryptionKey, ShouldEqual, "key2")
+					So(profile.Miners[0].KeyVersion, ShouldEqual, 1)
+					// rotate keys with stale key version fail
+					ik.MinerKeys[0].EncryptionKey = "key3"
+					ik.Nonce, err = ms.nextNonce(addr3)
+					So(err, ShouldBeNil)
+					err = ik.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(ik, 0)
+					So(errors.Cause(err), ShouldEqual, ErrInvalidKeyVersion)
+				})
+				Convey("raise storage quota", func() {
+					profile, ok := ms.loadSQLChainObject(dbID)
+					So(ok, ShouldBeTrue)
+					So(profile.Meta.Space, ShouldEqual, 0)
+					uq := types.NewUpdateQuota(&types.UpdateQuotaHeader{
+						TargetSQLChain: dbAccount,
+						Space:          1024,
+					})
+					// unlimited quota can't be raised
+					uq.Nonce, err = ms.nextNonce(addr3)
+					So(err, ShouldBeNil)
+					err = uq.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(uq, 0)
+					So(errors.Cause(err), ShouldEqual, ErrInvalidQuota)
+					var space uint64 = 1024
+					profile.Meta.Space = space
+					ms.dirty.databases[dbID] = profile
+					ms.commit()
+					uq.Space = space + 1024
+					// addr1(read) update quota fail
+					uq.Nonce, err = ms.nextNonce(addr1)
+					So(err, ShouldBeNil)
+					err = uq.Sign(privKey1)
+					So(err, ShouldBeNil)
+					err = ms.apply(uq, 0)
+					So(errors.Cause(err), ShouldEqual, ErrAccountPermissionDeny)
+					// addr3(admin) raise quota
+					uq.Nonce, err = ms.nextNonce(addr3)
+					So(err, ShouldBeNil)
+					err = uq.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(uq, 0)
+					So(err, ShouldBeNil)
+					ms.commit()
+					profile, ok = ms.loadSQLChainObject(dbID)
+					So(ok, ShouldBeTrue)
+					So(profile.Meta.Space, ShouldEqual, space+1024)
+					// raise quota by another unit with extra deposit
+					var (
+						deposit     = minDeposit(profile.GasPrice, uint64(len(profile.Miners)))
+						balance, _  = ms.loadAccountTokenBalance(addr3, profile.TokenType)
+						loadDeposit = func() uint64 {
+							for _, user := range profile.Users {
+								if user.Address == addr3 {
+									return user.Deposit
+								}
+							}
+							return 0
+						}
+						userDeposit = loadDeposit()
+					)
+					err = ms.increaseAccountToken(addr3, deposit, profile.TokenType)
+					So(err, ShouldBeNil)
+					ms.commit()
+					balance, _ = ms.loadAccountTokenBalance(addr3, profile.TokenType)
+					uq.Space = quotaUnit + 1
+					uq.Nonce, err = ms.nextNonce(addr3)
+					So(err, ShouldBeNil)
+					err = uq.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(uq, 0)
+					So(err, ShouldBeNil)
+					ms.commit()
+					profile, ok = ms.loadSQLChainObject(dbID)
+					So(ok, ShouldBeTrue)
+					So(profile.Meta.Space, ShouldEqual, quotaUnit+1)
+					So(loadDeposit()-userDeposit, ShouldEqual, deposit)
+					newBalance, _ := ms.loadAccountTokenBalance(addr3, profile.TokenType)
+					So(balance-newBalance, ShouldEqual, deposit)
+					// raise quota without enough balance fail
+					uq.Space = quotaUnit * (newBalance/deposit + 3)
+					uq.Nonce, err = ms.nextNonce(addr3)
+					So(err, ShouldBeNil)
+					err = uq.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(uq, 0)
+					So(errors.Cause(err), ShouldEqual, ErrInsufficientBalance)
+					// lower quota fail
+					uq.Space = space
+					uq.Nonce, err = ms.nextNonce(addr3)
+					So(err, ShouldBeNil)
+					err = uq.Sign(privKey3)
+					So(err, ShouldBeNil)
+					err = ms.apply(uq, 0)
+					So(errors.Cause(err), ShouldEqual, ErrInvalidQuota)
+				})
 				Convey("transfer token", func() {
 					addr1B1, ok := ms.loadAccountTokenBalance(addr1, types.Particle)
 					So(ok, ShouldBeTrue)
@@ -1505,6 +1766,154 @@ func TestMetaState(t *testing.T) {
 					So(len(sqlchain.Miners), ShouldEqual, 1)
 					So(sqlchain.Miners[0].PendingIncome, ShouldEqual, 115)
 					So(sqlchain.Miners[0].ReceivedIncome, ShouldEqual, 115)
+
+					// billing of a database with more miners should be co-signed by a quorum
+					sqlchain.Miners = append(sqlchain.Miners,
+						&types.MinerInfo{Address: addr3}, &types.MinerInfo{Address: addr4})
+					ms.dirty.databases[dbID] = sqlchain
+					ub4 := &types.UpdateBilling{
+						UpdateBillingHeader: types.UpdateBillingHeader{
+							Receiver: dbAccount,
+							Nonce:    4,
+							Range: types.Range{
+								From: 20,
+								To:   30,
+							},
+						},
+					}
+					ub4.Version = int32(ub4.HSPDefaultVersion())
+					err = ub4.Sign(privKey2)
+					So(err, ShouldBeNil)
+					err = ms.apply(ub4, 0)
+					So(errors.Cause(err), ShouldEqual, ErrBillingQuorumNotReached)
+					// not a miner of the database
+					sig, err := ub4.CoSign(privKey1)
+					So(err, ShouldBeNil)
+					ub4.Signatures = append(ub4.Signatures, sig)
+					err = ms.apply(ub4, 0)
+					So(errors.Cause(err), ShouldEqual, ErrBillingQuorumNotReached)
+					sig, err = ub4.CoSign(privKey3)
+					So(err, ShouldBeNil)
+					ub4.Signatures = append(ub4.Signatures, sig)
+					err = ms.apply(ub4, 0)
+					So(err, ShouldBeNil)
+					sqlchain, loaded = ms.loadSQLChainObject(dbID)
+					So(loaded, ShouldBeTrue)
+					So(sqlchain.LastUpdatedHeight, ShouldEqual, 30)
+
+					// legacy billing without co-signatures should be rejected
+					ub5 := &types.UpdateBilling{
+						UpdateBillingHeader: types.UpdateBillingHeader{
+							Receiver: dbAccount,
+							Nonce:    5,
+							Range: types.Range{
+								From: 30,
+								To:   40,
+							},
+							Version: 1,
+						},
+					}
+					err = ub5.Sign(privKey2)
+					So(err, ShouldBeNil)
+					err = ms.apply(ub5, 0)
+					So(errors.Cause(err), ShouldEqual, ErrInvalidBillingVersion)
+					sqlchain, loaded = ms.loadSQLChainObject(dbID)
+					So(loaded, ShouldBeTrue)
+					So(sqlchain.LastUpdatedHeight, ShouldEqual, 30)
+					So(sqlchain.Miners[2].MissedBillings, ShouldEqual, 1)
+
+					// the quorum required by the proposer should be respected
+					ub6 := &types.UpdateBilling{
+						UpdateBillingHeader: types.UpdateBillingHeader{
+							Receiver: dbAccount,
+							Nonce:    5,
+							Range: types.Range{
+								From: 30,
+								To:   40,
+							},
+							Quorum: 3,
+						},
+					}
+					ub6.Version = int32(ub6.HSPDefaultVersion())
+					err = ub6.Sign(privKey2)
+					So(err, ShouldBeNil)
+					sig, err = ub6.CoSign(privKey3)
+					So(err, ShouldBeNil)
+					ub6.Signatures = append(ub6.Signatures, sig)
+					err = ms.apply(ub6, 0)
+					So(errors.Cause(err), ShouldEqual, ErrBillingQuorumNotReached)
+
+					// miner which keeps missing the billings should be replaced
+					var newUpdateBilling = func(
+						from, to uint32, users ...*types.UserCost) *types.UpdateBilling {
+						ub := &types.UpdateBilling{
+							UpdateBillingHeader: types.UpdateBillingHeader{
+								Receiver: dbAccount,
+								Range:    types.Range{From: from, To: to},
+								Users:    users,
+							},
+						}
+						ub.Nonce, err = ms.nextNonce(addr2)
+						So(err, ShouldBeNil)
+						ub.Version = int32(ub.HSPDefaultVersion())
+						err = ub.Sign(privKey2)
+						So(err, ShouldBeNil)
+						sig, err := ub.CoSign(privKey3)
+						So(err, ShouldBeNil)
+						ub.Signatures = append(ub.Signatures, sig)
+						return ub
+					}
+					for i := uint32(3); i < 5; i++ {
+						err = ms.apply(newUpdateBilling(i*10, i*10+10), 0)
+						So(err, ShouldBeNil)
+					}
+					sqlchain, loaded = ms.loadSQLChainObject(dbID)
+					So(loaded, ShouldBeTrue)
+					So(sqlchain.Miners, ShouldHaveLength, 3)
+					So(sqlchain.Miners[0].MissedBillings, ShouldEqual, 0)
+					So(sqlchain.Miners[2].MissedBillings, ShouldEqual, 3)
+					var newAddr = proto.AccountAddress(hash.HashH([]byte("replacement")))
+					ms.dirty.provider[newAddr] = &types.ProviderProfile{
+						Provider:  newAddr,
+						GasPrice:  1,
+						TokenType: types.Particle,
+						NodeID:    "0000005",
+					}
+					// the offline miner should be paid for the last billing before it's replaced
+					var (
+						income      = 20 * sqlchain.MinerGasPrice(sqlchain.Miners[2])
+						balance, _  = ms.loadAccountTokenBalance(addr4, types.Particle)
+						advance     uint64
+						loadAdvance = func() uint64 {
+							for _, user := range sqlchain.Users {
+								if user.Address == addr1 {
+									return user.AdvancePayment
+								}
+							}
+							return 0
+						}
+					)
+					advance = loadAdvance()
+					err = ms.apply(newUpdateBilling(50, 60, &types.UserCost{
+						User: addr1,
+						Cost: 20,
+						Miners: []*types.MinerIncome{
+							&types.MinerIncome{Miner: addr4, Income: 20},
+						},
+					}), 0)
+					So(err, ShouldBeNil)
+					sqlchain, loaded = ms.loadSQLChainObject(dbID)
+					So(loaded, ShouldBeTrue)
+					So(advance-loadAdvance(), ShouldEqual, income)
+					newBalance, _ := ms.loadAccountTokenBalance(addr4, types.Particle)
+					So(newBalance-balance, ShouldEqual, income)
+					var miners []proto.AccountAddress
+					for _, m := range sqlchain.Miners {
+						miners = append(miners, m.Address)
+					}
+					So(miners, ShouldResemble, []proto.AccountAddress{addr2, addr3, newAddr})
+					_, loaded = ms.loadProviderObject(newAddr)
+					So(loaded, ShouldBeFalse)
 				})
 			})
 		})
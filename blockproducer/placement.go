@@ -0,0 +1,90 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package blockproducer
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// placement tracks the labels of the selected miners to enforce the distinct constraints, and
+// scores the candidate miners by the prefer constraints.
+type placement struct {
+	distinct []string
+	prefer   []string
+	// used is the label values of the selected miners for each distinct label key.
+	used map[string]map[string]struct{}
+}
+
+func newPlacement(cs []types.PlacementConstraint) (p *placement) {
+	p = &placement{
+		used: make(map[string]map[string]struct{}),
+	}
+	for _, c := range cs {
+		switch c.Type {
+		case types.PlacementDistinct:
+			p.distinct = append(p.distinct, c.Label)
+			p.used[c.Label] = make(map[string]struct{})
+		case types.PlacementPrefer:
+			p.prefer = append(p.prefer, c.Label)
+		}
+	}
+	return
+}
+
+// accept checks the distinct constraints against the miner, and records its labels if accepted.
+// A miner without the label of a distinct constraint is not accepted.
+func (p *placement) accept(po *types.ProviderProfile) (err error) {
+	var values = make([]string, len(p.distinct))
+	for i, key := range p.distinct {
+		value, ok := types.LabelValue(po.Labels, key)
+		if !ok {
+			return errors.Wrapf(ErrMinerPlacementNotMatch, "miner %s has no label %s",
+				po.Provider, key)
+		}
+		if _, ok = p.used[key][value]; ok {
+			return errors.Wrapf(ErrMinerPlacementNotMatch, "label %s=%s is already used",
+				key, value)
+		}
+		values[i] = value
+	}
+	for i, key := range p.distinct {
+		p.used[key][values[i]] = struct{}{}
+	}
+	return
+}
+
+// preference returns the count of the preferred labels of the miner.
+func (p *placement) preference(po *types.ProviderProfile) (n int) {
+	for _, label := range p.prefer {
+		if types.HasLabel(po.Labels, label) {
+			n++
+		}
+	}
+	return
+}
+
+// seed records the labels of an already selected miner, so that the replacement miners still
+// satisfy the distinct constraints together with the remaining ones.
+func (p *placement) seed(labels []string) {
+	for _, key := range p.distinct {
+		if value, ok := types.LabelValue(labels, key); ok {
+			p.used[key][value] = struct{}{}
+		}
+	}
+}
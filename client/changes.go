@@ -0,0 +1,143 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// ChangeHandler handles a row change received from the change stream of a database.
+type ChangeHandler func(change *types.RowChange) error
+
+// SubscribeRetryInterval defines the interval to retry fetching row changes on failure.
+var SubscribeRetryInterval = 3 * time.Second
+
+// FetchChanges fetches at most limit row changes of the database from position since, and returns
+// the position to fetch the following changes from. It returns an empty result if there is not any
+// new change in a few seconds.
+func FetchChanges(dsn string, since types.ChangePosition, limit uint32) (
+	changes []*types.RowChange, next types.ChangePosition, err error,
+) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var (
+		cfg     *Config
+		privKey *asymmetric.PrivateKey
+		peers   *proto.Peers
+	)
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if peers, err = cacheGetPeers(proto.DatabaseID(cfg.DatabaseID), privKey); err != nil {
+		return
+	}
+
+	req := &types.ChangesRequest{
+		Header: types.SignedChangesRequestHeader{
+			ChangesRequestHeader: types.ChangesRequestHeader{
+				DatabaseID: proto.DatabaseID(cfg.DatabaseID),
+				Since:      since,
+				Limit:      limit,
+				Timestamp:  getLocalTime(),
+			},
+		},
+	}
+	if err = req.Sign(privKey); err != nil {
+		return
+	}
+
+	var resp = new(types.ChangesResponse)
+	if err = rpc.NewCaller().CallNode(
+		peers.Leader, route.DBSFetchChanges.String(), req, resp,
+	); err != nil {
+		if cerr, ok := parseChangesError(err); ok {
+			err = errors.Wrap(cerr, err.Error())
+		}
+		err = errors.Wrapf(err, "fetch changes from node %s failed", peers.Leader)
+		return
+	}
+	return resp.Changes, resp.Next, nil
+}
+
+// SubscribeChanges subscribes the row changes of the database from position since, and calls
+// handler on each change in order until ctx is done or handler returns an error. The failed fetches
+// are retried unless the error is permanent, e.g. ErrChangesPruned or ErrPermissionDeny. A
+// subscriber could resume the change stream by subscribing from the position next to the last
+// handled one.
+func SubscribeChanges(
+	ctx context.Context, dsn string, since types.ChangePosition, handler ChangeHandler,
+) (err error) {
+	var (
+		changes []*types.RowChange
+		next    types.ChangePosition
+	)
+	// fail fast on an invalid dsn, which is never fixed by retrying
+	if _, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if changes, next, err = FetchChanges(dsn, since, 0); err != nil {
+			if isPermanentChangesError(err) {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(SubscribeRetryInterval):
+				continue
+			}
+		}
+		for _, c := range changes {
+			if err = handler(c); err != nil {
+				return
+			}
+		}
+		since = next
+	}
+}
+
+// isPermanentChangesError reports whether err of a change fetch is not fixed by retrying.
+func isPermanentChangesError(err error) bool {
+	switch errors.Cause(err) {
+	case ErrNotInitialized, ErrChangesPruned, ErrChangeCaptureDisabled, ErrPermissionDeny:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestSubscribeChanges(t *testing.T) {
+	Convey("test permanent errors of SubscribeChanges", t, func() {
+		var handler = func(*types.RowChange) error { return nil }
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// should not be retried until ctx is done
+		var err = SubscribeChanges(ctx, "covenantsql://db", types.ChangePosition{}, handler)
+		So(errors.Cause(err), ShouldEqual, ErrNotInitialized)
+		err = SubscribeChanges(ctx, "invalid://db", types.ChangePosition{}, handler)
+		So(err, ShouldNotBeNil)
+		So(ctx.Err(), ShouldBeNil)
+
+		for _, e := range []error{ErrChangesPruned, ErrChangeCaptureDisabled, ErrPermissionDeny} {
+			var remote = errors.New("rpc: " + e.Error() + ": changes before offset 4")
+			var cerr, ok = parseChangesError(remote)
+			So(ok, ShouldBeTrue)
+			So(cerr, ShouldEqual, e)
+			So(isPermanentChangesError(errors.Wrap(cerr, remote.Error())), ShouldBeTrue)
+		}
+		_, ok := parseChangesError(errors.New("connection refused"))
+		So(ok, ShouldBeFalse)
+		So(isPermanentChangesError(errors.New("connection refused")), ShouldBeFalse)
+	})
+}
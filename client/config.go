@@ -20,6 +20,8 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -27,6 +29,7 @@ const (
 	paramUseFollower  = "use_follower"
 	paramUseDirectRPC = "use_direct_rpc"
 	paramMirror       = "mirror"
+	paramAsOf         = "as_of"
 )
 
 // Config is a configuration parsed from a DSN string.
@@ -48,6 +51,10 @@ type Config struct {
 
 	// Mirror option forces client to query from mirror server
 	Mirror string
+
+	// AsOfHeight reads the database as it was at the specified SQLChain height, the connection
+	// is read-only if it's set.
+	AsOfHeight int32
 }
 
 // NewConfig creates a new config with default value.
@@ -78,6 +85,9 @@ func (cfg *Config) FormatDSN() string {
 	if cfg.UseDirectRPC {
 		newQuery.Add(paramUseDirectRPC, strconv.FormatBool(cfg.UseDirectRPC))
 	}
+	if cfg.AsOfHeight > 0 {
+		newQuery.Add(paramAsOf, strconv.FormatInt(int64(cfg.AsOfHeight), 10))
+	}
 	u.RawQuery = newQuery.Encode()
 
 	return u.String()
@@ -106,6 +116,13 @@ func ParseDSN(dsn string) (cfg *Config, err error) {
 	}
 	cfg.Mirror = q.Get(paramMirror)
 	cfg.UseDirectRPC, _ = strconv.ParseBool(q.Get(paramUseDirectRPC))
+	if v := q.Get(paramAsOf); v != "" {
+		var height int64
+		if height, err = strconv.ParseInt(v, 10, 32); err != nil || height <= 0 {
+			return nil, errors.Wrapf(ErrInvalidAsOfHeight, "parse %s option %q", paramAsOf, v)
+		}
+		cfg.AsOfHeight = int32(height)
+	}
 
 	return cfg, nil
 }
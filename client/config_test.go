@@ -19,6 +19,7 @@ package client
 import (
 	"testing"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -100,4 +101,18 @@ func TestConfig(t *testing.T) {
 		cfg.Mirror = ""
 		So(cfg.FormatDSN(), ShouldEqual, "covenantsql://db")
 	})
+
+	Convey("test format and parse dsn with as-of height option", t, func() {
+		cfg, err := ParseDSN("covenantsql://db?as_of=100")
+		So(err, ShouldBeNil)
+		So(cfg.AsOfHeight, ShouldEqual, 100)
+		So(cfg.FormatDSN(), ShouldEqual, "covenantsql://db?as_of=100")
+		cfg.AsOfHeight = 0
+		So(cfg.FormatDSN(), ShouldEqual, "covenantsql://db")
+
+		_, err = ParseDSN("covenantsql://db?as_of=0")
+		So(errors.Cause(err), ShouldEqual, ErrInvalidAsOfHeight)
+		_, err = ParseDSN("covenantsql://db?as_of=latest")
+		So(errors.Cause(err), ShouldEqual, ErrInvalidAsOfHeight)
+	})
 }
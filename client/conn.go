@@ -47,6 +47,7 @@ type conn struct {
 
 	inTransaction bool
 	closed        int32
+	asOfHeight    int32 // reads as of the height, writes are rejected
 
 	leader   *pconn
 	follower *pconn
@@ -80,6 +81,7 @@ func newConn(cfg *Config) (c *conn, err error) {
 		localNodeID: localNodeID,
 		privKey:     privKey,
 		queries:     make([]types.Query, 0),
+		asOfHeight:  cfg.AsOfHeight,
 	}
 
 	// get peers from BP
@@ -390,6 +392,11 @@ func (c *conn) addQuery(ctx context.Context, queryType types.QueryType, query *t
 func (c *conn) sendQuery(ctx context.Context, queryType types.QueryType, queries []types.Query) (affectedRows int64, lastInsertID int64, rows driver.Rows, err error) {
 	var uc *pconn // peer connection used to execute the queries
 
+	if c.asOfHeight > 0 && queryType != types.ReadQuery {
+		err = ErrAsOfReadOnly
+		return
+	}
+
 	uc = c.leader
 	// use follower pconn only when the query is readonly
 	if queryType == types.ReadQuery && c.follower != nil {
@@ -424,12 +431,17 @@ func (c *conn) sendQuery(ctx context.Context, queryType types.QueryType, queries
 				ConnectionID: connID,
 				SeqNo:        seqNo,
 				Timestamp:    getLocalTime(),
+				AsOfHeight:   c.asOfHeight,
 			},
 		},
 		Payload: types.RequestPayload{
 			Queries: queries,
 		},
 	}
+	if deadline, ok := ctx.Deadline(); ok {
+		// the miner interrupts the queries on the deadline
+		req.Header.Deadline = deadline.UTC()
+	}
 
 	if err = req.Sign(c.privKey); err != nil {
 		return
@@ -443,8 +455,30 @@ func (c *conn) sendQuery(ctx context.Context, queryType types.QueryType, queries
 	}
 
 	var response types.Response
-	if err = uc.pCaller.Call(route.DBSQuery.String(), req, &response); err != nil {
-		return
+	for retries := 0; ; retries++ {
+		if err = uc.pCaller.Call(route.DBSQuery.String(), req, &response); err == nil {
+			break
+		}
+		if terr, ok := parseQueryTimeout(err); ok {
+			err = terr
+			return
+		}
+		if le, ok := parseLimitError(err); ok {
+			err = errors.Wrap(le, err.Error())
+			return
+		}
+		if !isDatabaseLoading(err) || retries >= MaxLoadingRetries {
+			return
+		}
+		// the database is still being opened by the miner on startup
+		select {
+		case <-ctx.Done():
+			if err = ctx.Err(); err == context.DeadlineExceeded {
+				err = errors.Wrap(ErrQueryTimeout, err.Error())
+			}
+			return
+		case <-time.After(LoadingRetryInterval):
+		}
 	}
 	rows = newRows(&response)
 
@@ -22,6 +22,7 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
@@ -318,3 +319,19 @@ func TestConnAndSeqAllocation(t *testing.T) {
 		wg.Wait()
 	})
 }
+
+func TestParseQueryTimeout(t *testing.T) {
+	Convey("test query timeout returned by the miner", t, func() {
+		var remote = errors.New("rpc: apply failed: deadline exceeded before logged: " +
+			ErrQueryTimeout.Error())
+		var err, ok = parseQueryTimeout(remote)
+		So(ok, ShouldBeTrue)
+		So(errors.Cause(err), ShouldEqual, ErrQueryTimeout)
+		So(err.Error(), ShouldContainSubstring, remote.Error())
+
+		_, ok = parseQueryTimeout(errors.New("connection refused"))
+		So(ok, ShouldBeFalse)
+		_, ok = parseQueryTimeout(nil)
+		So(ok, ShouldBeFalse)
+	})
+}
@@ -102,17 +102,21 @@ func (d *covenantSQLDriver) Open(dsn string) (conn driver.Conn, err error) {
 // ResourceMeta defines new database resources requirement descriptions.
 type ResourceMeta struct {
 	// copied fields from types.ResourceMeta
-	TargetMiners           []proto.AccountAddress `json:"target-miners,omitempty"`        // designated miners
-	Node                   uint16                 `json:"node,omitempty"`                 // reserved node count
-	Space                  uint64                 `json:"space,omitempty"`                // reserved storage space in bytes
-	Memory                 uint64                 `json:"memory,omitempty"`               // reserved memory in bytes
-	LoadAvgPerCPU          float64                `json:"load-avg-per-cpu,omitempty"`     // max loadAvg15 per CPU
-	EncryptionKey          string                 `json:"encrypt-key,omitempty"`          // encryption key for database instance
-	UseEventualConsistency bool                   `json:"eventual-consistency,omitempty"` // use eventual consistency replication if enabled
-	ConsistencyLevel       float64                `json:"consistency-level,omitempty"`    // customized strong consistency level
-	IsolationLevel         int                    `json:"isolation-level,omitempty"`      // customized isolation level
-
-	GasPrice       uint64 `json:"gas-price"`       // customized gas price
+	TargetMiners           []proto.AccountAddress      `json:"target-miners,omitempty"`        // designated miners
+	Node                   uint16                      `json:"node,omitempty"`                 // reserved node count
+	Space                  uint64                      `json:"space,omitempty"`                // reserved storage space in bytes
+	Memory                 uint64                      `json:"memory,omitempty"`               // reserved memory in bytes
+	LoadAvgPerCPU          float64                     `json:"load-avg-per-cpu,omitempty"`     // max loadAvg15 per CPU
+	EncryptionKey          string                      `json:"encrypt-key,omitempty"`          // encryption key for database instance
+	UseEventualConsistency bool                        `json:"eventual-consistency,omitempty"` // use eventual consistency replication if enabled
+	ConsistencyLevel       float64                     `json:"consistency-level,omitempty"`    // customized strong consistency level
+	IsolationLevel         int                         `json:"isolation-level,omitempty"`      // customized isolation level
+	Extensions             types.SQLiteExtension       `json:"extensions,omitempty"`           // allowed sqlite extensions, unrestricted if unset
+	Placement              []types.PlacementConstraint `json:"placement,omitempty"`            // replica placement constraints
+	MaxQueryMillisecond    uint64                      `json:"max-query-ms,omitempty"`         // max execution time of a query
+	Limits                 types.QueryLimits           `json:"limits"`                         // sandbox limits of each statement
+
+	GasPrice       uint64 `json:"gas-price"`       // maximum acceptable gas price of the miners
 	AdvancePayment uint64 `json:"advance-payment"` // customized advance payment
 }
 
@@ -196,20 +200,32 @@ func Create(meta ResourceMeta) (txHash hash.Hash, dsn string, err error) {
 		meta.AdvancePayment = DefaultAdvancePayment
 	}
 
+	var resourceMeta = types.ResourceMeta{
+		TargetMiners:           meta.TargetMiners,
+		Node:                   meta.Node,
+		Space:                  meta.Space,
+		Memory:                 meta.Memory,
+		LoadAvgPerCPU:          meta.LoadAvgPerCPU,
+		EncryptionKey:          meta.EncryptionKey,
+		UseEventualConsistency: meta.UseEventualConsistency,
+		ConsistencyLevel:       meta.ConsistencyLevel,
+		IsolationLevel:         meta.IsolationLevel,
+		Extensions:             meta.Extensions,
+		Placement:              meta.Placement,
+		MaxQueryMillisecond:    meta.MaxQueryMillisecond,
+		Limits:                 meta.Limits,
+	}
+	if meta.Extensions != 0 || len(meta.Placement) > 0 || meta.MaxQueryMillisecond > 0 ||
+		!meta.Limits.IsZero() {
+		// extensions, placement constraints, max query time and query limits are only covered by
+		// the tx hash since version 1, 2, 3 and 4 respectively
+		resourceMeta.Version = int32(resourceMeta.HSPDefaultVersion())
+	}
+
 	req.TTL = 1
 	req.Tx = types.NewCreateDatabase(&types.CreateDatabaseHeader{
-		Owner: clientAddr,
-		ResourceMeta: types.ResourceMeta{
-			TargetMiners:           meta.TargetMiners,
-			Node:                   meta.Node,
-			Space:                  meta.Space,
-			Memory:                 meta.Memory,
-			LoadAvgPerCPU:          meta.LoadAvgPerCPU,
-			EncryptionKey:          meta.EncryptionKey,
-			UseEventualConsistency: meta.UseEventualConsistency,
-			ConsistencyLevel:       meta.ConsistencyLevel,
-			IsolationLevel:         meta.IsolationLevel,
-		},
+		Owner:          clientAddr,
+		ResourceMeta:   resourceMeta,
 		GasPrice:       meta.GasPrice,
 		AdvancePayment: meta.AdvancePayment,
 		TokenType:      types.Particle,
@@ -380,6 +396,11 @@ func UpdatePermission(targetUser proto.AccountAddress,
 		return
 	}
 
+	if perm != nil && (!perm.Limits.IsZero() || len(perm.Procedures) > 0) {
+		// query limits and procedures are only covered by the tx hash since version 1 and 2
+		// respectively
+		perm.Version = int32(perm.HSPDefaultVersion())
+	}
 	up := types.NewUpdatePermission(&types.UpdatePermissionHeader{
 		TargetSQLChain: targetChain,
 		TargetUser:     targetUser,
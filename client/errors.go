@@ -16,7 +16,17 @@
 
 package client
 
-import "github.com/pkg/errors"
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
 
 // Various errors the driver might returns.
 var (
@@ -32,4 +42,92 @@ var (
 	ErrInvalidProfile = errors.New("invalid sqlchain profile")
 	// ErrNoSuchTokenBalance indicates no such token balance in chain.
 	ErrNoSuchTokenBalance = errors.New("no such token balance")
+	// ErrInvalidAsOfHeight indicates the as-of height option of DSN is not a positive height.
+	ErrInvalidAsOfHeight = errors.New("invalid as-of height")
+	// ErrAsOfReadOnly represents a write query is presented on an as-of height connection.
+	ErrAsOfReadOnly = errors.New("only read is supported as of a past height")
+	// ErrQueryTimeout represents the query is interrupted by the miner on its deadline or the max
+	// execution time of the database, or the deadline of the query context is exceeded. Match it
+	// with errors.Cause.
+	ErrQueryTimeout = types.ErrQueryTimeout
+	// ErrLimitExceeded represents the statement is interrupted by the miner as it exceeds a query
+	// limit, see LimitError for the limit exceeded.
+	ErrLimitExceeded = errors.New("query limit exceeded")
+	// ErrChangesPruned represents the row changes requested are pruned from the change log of the
+	// miner, the subscriber should resync the database before subscribing again.
+	ErrChangesPruned = errors.New("changes pruned")
+	// ErrChangeCaptureDisabled represents the row change capture is not enabled on the database.
+	ErrChangeCaptureDisabled = errors.New("change capture disabled")
+	// ErrPermissionDeny represents the requester has no permission on the database.
+	ErrPermissionDeny = errors.New("permission deny")
 )
+
+// LimitError represents the statement is interrupted by the miner as it exceeds one of the query
+// limits of the database or the requester, e.g., the max rows returned by a statement.
+type LimitError struct {
+	Limit string // name of the limit: rows, steps or memory
+	Max   uint64 // max value of the limit
+}
+
+// Error implements error.Error.
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: max %s %d", ErrLimitExceeded.Error(), e.Limit, e.Max)
+}
+
+var limitErrorPattern = regexp.MustCompile(ErrLimitExceeded.Error() + `: max (\w+) (\d+)`)
+
+var (
+	// LoadingRetryInterval defines the interval to retry a query on a database which is still
+	// being opened by the miner.
+	LoadingRetryInterval = time.Second
+	// MaxLoadingRetries defines the max retries of a query on a database which is still being
+	// opened by the miner.
+	MaxLoadingRetries = 30
+)
+
+// parseQueryTimeout returns ErrQueryTimeout wrapping err if err is returned by the miner for a
+// query interrupted or rejected on its deadline. The error identity is lost across RPC, so it's
+// matched by message here once, and callers should match the returned error with errors.Cause.
+func parseQueryTimeout(err error) (terr error, ok bool) {
+	if err == nil || !strings.Contains(err.Error(), ErrQueryTimeout.Error()) {
+		return
+	}
+	return errors.Wrap(ErrQueryTimeout, err.Error()), true
+}
+
+// parseLimitError returns the LimitError in err returned by the miner, the error is matched by
+// message across RPC.
+func parseLimitError(err error) (le *LimitError, ok bool) {
+	if err == nil {
+		return
+	}
+	var m = limitErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return
+	}
+	var max, perr = strconv.ParseUint(m[2], 10, 64)
+	if perr != nil {
+		return
+	}
+	return &LimitError{Limit: m[1], Max: max}, true
+}
+
+// parseChangesError returns the client error of the permanent failure in err returned by the miner
+// for a change fetch, the error is matched by message across RPC.
+func parseChangesError(err error) (cerr error, ok bool) {
+	if err == nil {
+		return
+	}
+	for _, e := range []error{ErrChangesPruned, ErrChangeCaptureDisabled, ErrPermissionDeny} {
+		if strings.Contains(err.Error(), e.Error()) {
+			return e, true
+		}
+	}
+	return
+}
+
+// isDatabaseLoading reports whether err is the retryable error returned by the miner for a
+// database still being opened on startup, the error is matched by message across RPC.
+func isDatabaseLoading(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "database is loading")
+}
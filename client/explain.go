@@ -0,0 +1,60 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// ExplainQueryPlan returns the query plan of the statement reported by the database miner.
+// The statement itself is not executed.
+func ExplainQueryPlan(
+	ctx context.Context, db *sql.DB, query string, args ...interface{},
+) (plan *types.QueryPlan, err error) {
+	var (
+		rows    *sql.Rows
+		columns []string
+		data    [][]interface{}
+	)
+	if rows, err = db.QueryContext(ctx, "EXPLAIN QUERY PLAN "+query, args...); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	if columns, err = rows.Columns(); err != nil {
+		return
+	}
+	for rows.Next() {
+		var (
+			row  = make([]interface{}, len(columns))
+			dest = make([]interface{}, len(columns))
+		)
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+		data = append(data, row)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	return types.NewQueryPlanFromRows(columns, data)
+}
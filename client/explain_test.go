@@ -0,0 +1,55 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestExplainQueryPlan(t *testing.T) {
+	Convey("test explain query plan", t, func() {
+		var stopTestService func()
+		var err error
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		var db *sql.DB
+		db, err = sql.Open("covenantsql", "covenantsql://db")
+		So(db, ShouldNotBeNil)
+		So(err, ShouldBeNil)
+
+		_, err = db.Exec("create table test (test int)")
+		So(err, ShouldBeNil)
+
+		var plan *types.QueryPlan
+		plan, err = ExplainQueryPlan(context.Background(), db, "select * from test where test = ?", 1)
+		So(err, ShouldBeNil)
+		So(plan, ShouldNotBeNil)
+		So(plan.Roots, ShouldNotBeEmpty)
+		So(plan.Roots[0].Detail, ShouldContainSubstring, "test")
+
+		_, err = ExplainQueryPlan(context.Background(), db, "select * from not_exists")
+		So(err, ShouldNotBeNil)
+	})
+}
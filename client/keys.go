@@ -0,0 +1,170 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// RotateKey sends IssueKeys transaction to chain to rotate the encryption key of the database
+// to key on all its miners, which requires the super permission of the database. The database
+// keeps serving while the miners re-encrypt it, use KeyRotationStatus to track the progress.
+func RotateKey(dsn string, key string) (txHash hash.Hash, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var (
+		cfg     *Config
+		pubKey  *asymmetric.PublicKey
+		privKey *asymmetric.PrivateKey
+		addr    proto.AccountAddress
+		dbAddr  proto.AccountAddress
+		nonce   interfaces.AccountNonce
+	)
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	if pubKey, err = kms.GetLocalPublicKey(); err != nil {
+		return
+	}
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if addr, err = crypto.PubKeyHash(pubKey); err != nil {
+		return
+	}
+	var dbID = proto.DatabaseID(cfg.DatabaseID)
+	if dbAddr, err = dbID.AccountAddress(); err != nil {
+		return
+	}
+
+	profileReq := &types.QuerySQLChainProfileReq{DBID: dbID}
+	profileResp := &types.QuerySQLChainProfileResp{}
+	if err = rpc.RequestBP(route.MCCQuerySQLChainProfile.String(), profileReq, profileResp); err != nil {
+		err = errors.Wrap(err, "get sqlchain profile failed")
+		return
+	}
+	if len(profileResp.Profile.Miners) <= 0 {
+		err = ErrInvalidProfile
+		return
+	}
+
+	// issue the same key with the next key version to all miners
+	var (
+		version   uint32
+		minerKeys = make([]types.MinerKey, len(profileResp.Profile.Miners))
+	)
+	for i, miner := range profileResp.Profile.Miners {
+		if miner.KeyVersion > version {
+			version = miner.KeyVersion
+		}
+		minerKeys[i] = types.MinerKey{
+			Miner:         miner.Address,
+			EncryptionKey: key,
+		}
+	}
+
+	if nonce, err = getNonce(addr); err != nil {
+		return
+	}
+
+	ik := types.NewIssueKeys(&types.IssueKeysHeader{
+		TargetSQLChain: dbAddr,
+		MinerKeys:      minerKeys,
+		Nonce:          nonce,
+		KeyVersion:     version + 1,
+	})
+	ik.Version = int32(ik.HSPDefaultVersion())
+	if err = ik.Sign(privKey); err != nil {
+		log.WithError(err).Warning("sign failed")
+		return
+	}
+	addTxReq := new(types.AddTxReq)
+	addTxResp := new(types.AddTxResp)
+	addTxReq.Tx = ik
+	if err = requestBP(route.MCCAddTx, addTxReq, addTxResp); err != nil {
+		log.WithError(err).Warning("send tx failed")
+		return
+	}
+
+	txHash = ik.Hash()
+	return
+}
+
+// KeyRotationStatus fetches the per-miner encryption key rotation status of the database, which
+// requires the super permission of the database.
+func KeyRotationStatus(dsn string) (status []*types.KeyRotationStatusResponse, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var (
+		cfg     *Config
+		privKey *asymmetric.PrivateKey
+		peers   *proto.Peers
+	)
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if peers, err = cacheGetPeers(proto.DatabaseID(cfg.DatabaseID), privKey); err != nil {
+		return
+	}
+
+	req := &types.KeyRotationStatusRequest{
+		Header: types.SignedKeyRotationStatusRequestHeader{
+			KeyRotationStatusRequestHeader: types.KeyRotationStatusRequestHeader{
+				DatabaseID: proto.DatabaseID(cfg.DatabaseID),
+				Timestamp:  getLocalTime(),
+			},
+		},
+	}
+	if err = req.Sign(privKey); err != nil {
+		return
+	}
+
+	caller := rpc.NewCaller()
+	status = make([]*types.KeyRotationStatusResponse, 0, len(peers.Servers))
+	for _, node := range peers.Servers {
+		var resp = new(types.KeyRotationStatusResponse)
+		if err = caller.CallNode(node, route.DBSKeyRotationStatus.String(), req, resp); err != nil {
+			err = errors.Wrapf(err, "query key rotation status from node %s failed", node)
+			return
+		}
+		status = append(status, resp)
+	}
+
+	return
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/xenomint"
+)
+
+// RegisterProcedure registers the stored procedure p on the database, which replaces the existing
+// one with the same name and requires the super permission of the database.
+//
+// The procedure is called by name with the arguments bound to its parameters in order or by name,
+// e.g. db.ExecContext(ctx, "CALL transfer", from, to, amount) or db.QueryContext for the read
+// procedures. The users are permitted to call it by the Procedures of their permissions.
+func RegisterProcedure(ctx context.Context, db *sql.DB, p *types.Procedure) (err error) {
+	var queries []types.Query
+	if queries, err = xenomint.ProcedureQueries(p); err != nil {
+		return
+	}
+	return execQueries(ctx, db, queries)
+}
+
+// DropProcedure drops the stored procedure from the database, which requires the super permission
+// of the database.
+func DropProcedure(ctx context.Context, db *sql.DB, name string) error {
+	return execQueries(ctx, db, xenomint.DropProcedureQueries(name))
+}
+
+// execQueries executes the write queries in a transaction.
+func execQueries(ctx context.Context, db *sql.DB, queries []types.Query) (err error) {
+	var tx *sql.Tx
+	if tx, err = db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	for _, q := range queries {
+		var args = make([]interface{}, len(q.Args))
+		for i, v := range q.Args {
+			args[i] = v.Value
+		}
+		if _, err = tx.ExecContext(ctx, q.Pattern, args...); err != nil {
+			_ = tx.Rollback()
+			return
+		}
+	}
+	return tx.Commit()
+}
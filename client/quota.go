@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"sync/atomic"
+
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// UpdateQuota sends UpdateQuota transaction to chain to raise the storage quota of the database
+// to space bytes, which requires the super permission of the database. The sender pays an extra
+// deposit for each started GiB of the raised quota, which is as much as the minimum deposit of the
+// database. The miners reject write queries once the database storage reaches the quota.
+func UpdateQuota(dsn string, space uint64) (txHash hash.Hash, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var (
+		cfg    *Config
+		addr   proto.AccountAddress
+		dbAddr proto.AccountAddress
+		nonce  interfaces.AccountNonce
+	)
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	pubKey, err := kms.GetLocalPublicKey()
+	if err != nil {
+		return
+	}
+	privKey, err := kms.GetLocalPrivateKey()
+	if err != nil {
+		return
+	}
+	if addr, err = crypto.PubKeyHash(pubKey); err != nil {
+		return
+	}
+	var dbID = proto.DatabaseID(cfg.DatabaseID)
+	if dbAddr, err = dbID.AccountAddress(); err != nil {
+		return
+	}
+	if nonce, err = getNonce(addr); err != nil {
+		return
+	}
+
+	uq := types.NewUpdateQuota(&types.UpdateQuotaHeader{
+		TargetSQLChain: dbAddr,
+		Space:          space,
+		Nonce:          nonce,
+	})
+	if err = uq.Sign(privKey); err != nil {
+		log.WithError(err).Warning("sign failed")
+		return
+	}
+	addTxReq := new(types.AddTxReq)
+	addTxResp := new(types.AddTxResp)
+	addTxReq.Tx = uq
+	if err = requestBP(route.MCCAddTx, addTxReq, addTxResp); err != nil {
+		log.WithError(err).Warning("send tx failed")
+		return
+	}
+
+	txHash = uq.Hash()
+	return
+}
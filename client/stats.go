@@ -0,0 +1,91 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// NodeQueryStats defines the query statistics fetched from a miner of the database.
+type NodeQueryStats struct {
+	NodeID proto.NodeID              `json:"node"`
+	Stats  *types.QueryStatsResponse `json:"stats,omitempty"` // nil if the fetch failed
+	Error  string                    `json:"error,omitempty"` // error of the fetch
+}
+
+// QueryStats fetches the per-miner query statistics of the database, which requires the super
+// permission of the database, and the statistics are reset after they are collected if reset is
+// true.
+// The statistics are fetched from each miner independently, a failed miner is reported by the
+// Error of its result instead of err.
+func QueryStats(dsn string, reset bool) (stats []*NodeQueryStats, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+
+	var (
+		cfg     *Config
+		privKey *asymmetric.PrivateKey
+		peers   *proto.Peers
+	)
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	if peers, err = cacheGetPeers(proto.DatabaseID(cfg.DatabaseID), privKey); err != nil {
+		return
+	}
+
+	req := &types.QueryStatsRequest{
+		Header: types.SignedQueryStatsRequestHeader{
+			QueryStatsRequestHeader: types.QueryStatsRequestHeader{
+				DatabaseID: proto.DatabaseID(cfg.DatabaseID),
+				Reset:      reset,
+				Timestamp:  getLocalTime(),
+			},
+		},
+	}
+	if err = req.Sign(privKey); err != nil {
+		return
+	}
+
+	caller := rpc.NewCaller()
+	stats = make([]*NodeQueryStats, len(peers.Servers))
+	for i, node := range peers.Servers {
+		var resp = new(types.QueryStatsResponse)
+		stats[i] = &NodeQueryStats{NodeID: node}
+		if ierr := caller.CallNode(node, route.DBSQueryStats.String(), req, resp); ierr != nil {
+			stats[i].Error = errors.Wrapf(ierr, "query stats from node %s failed", node).Error()
+			continue
+		}
+		stats[i].Stats = resp
+	}
+
+	return
+}
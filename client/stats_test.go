@@ -0,0 +1,65 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestQueryStats(t *testing.T) {
+	Convey("test QueryStats of the miners of a database", t, func() {
+		var stopTestService func()
+		var err error
+
+		_, err = QueryStats("covenantsql://db", false)
+		So(err, ShouldEqual, ErrNotInitialized)
+
+		stopTestService, _, err = startTestService()
+		So(err, ShouldBeNil)
+		defer stopTestService()
+
+		var (
+			dbID   = proto.DatabaseID("db")
+			nodeID proto.NodeID
+			peers  *proto.Peers
+		)
+		nodeID, err = kms.GetLocalNodeID()
+		So(err, ShouldBeNil)
+		peers, err = genPeers(1)
+		So(err, ShouldBeNil)
+		// the stats of the other miners should be reported despite the unreachable one
+		peers.Servers = append([]proto.NodeID{"00000000000000000000000000000000000000000000000000000000deadbeef"},
+			peers.Servers...)
+		peerList.Store(dbID, peers)
+		defer peerList.Delete(dbID)
+
+		stats, err := QueryStats("covenantsql://db", false)
+		So(err, ShouldBeNil)
+		So(stats, ShouldHaveLength, 2)
+		So(stats[0].Stats, ShouldBeNil)
+		So(stats[0].Error, ShouldNotBeEmpty)
+		So(stats[1].NodeID, ShouldEqual, nodeID)
+		So(stats[1].Error, ShouldBeEmpty)
+		So(stats[1].Stats, ShouldNotBeNil)
+		So(stats[1].Stats.NodeID, ShouldEqual, nodeID)
+	})
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/twopc"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// DefaultDistributedTxTimeout defines the default timeout of distributed transaction.
+const DefaultDistributedTxTimeout = 10 * time.Second
+
+// DistributedTx defines an atomic write transaction across multiple databases, the write queries
+// of each database are committed or rolled back together by two-phase commit.
+//
+// The databases are locked for writing once they are prepared, until the transaction is decided.
+// If the coordinator crashes meanwhile, the in-doubt databases resolve the transaction by querying
+// each other after the timeout.
+type DistributedTx struct {
+	timeout  time.Duration
+	branches []*txBranch
+}
+
+// NewDistributedTx returns a new distributed transaction, which should be decided in timeout once
+// the databases are prepared.
+func NewDistributedTx(timeout time.Duration) *DistributedTx {
+	if timeout <= 0 {
+		timeout = DefaultDistributedTxTimeout
+	}
+	return &DistributedTx{
+		timeout: timeout,
+	}
+}
+
+// Exec adds the write query to the transaction on the database of dsn.
+func (t *DistributedTx) Exec(dsn string, query string, args ...interface{}) (err error) {
+	var cfg *Config
+	if cfg, err = ParseDSN(dsn); err != nil {
+		return
+	}
+	var (
+		dbID   = proto.DatabaseID(cfg.DatabaseID)
+		branch *txBranch
+		named  = make([]driver.NamedValue, len(args))
+	)
+	for _, v := range t.branches {
+		if v.dbID == dbID {
+			branch = v
+			break
+		}
+	}
+	if branch == nil {
+		branch = &txBranch{dbID: dbID}
+		t.branches = append(t.branches, branch)
+	}
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	branch.queries = append(branch.queries, *convertQuery(query, named))
+	return
+}
+
+// Commit commits the write queries on all the databases atomically, the results are returned in
+// the order of the databases added to the transaction.
+func (t *DistributedTx) Commit() (results []sql.Result, err error) {
+	if atomic.LoadUint32(&driverInitialized) == 0 {
+		err = ErrNotInitialized
+		return
+	}
+	if len(t.branches) == 0 {
+		return
+	}
+
+	var (
+		nodeID  proto.NodeID
+		privKey *asymmetric.PrivateKey
+		header  = &types.TxRequestHeader{
+			Participants: make([]proto.DatabaseID, len(t.branches)),
+			Deadline:     getLocalTime().Add(t.timeout),
+		}
+		workers = make([]twopc.Worker, len(t.branches))
+		txIDBuf bytes.Buffer
+	)
+	if nodeID, err = kms.GetLocalNodeID(); err != nil {
+		return
+	}
+	if privKey, err = kms.GetLocalPrivateKey(); err != nil {
+		return
+	}
+	defer func() {
+		for _, v := range t.branches {
+			if v.request != nil {
+				putBackConn(v.connID)
+			}
+		}
+	}()
+	for i, v := range t.branches {
+		if err = v.init(nodeID, privKey); err != nil {
+			err = errors.Wrapf(err, "init transaction on database %s", v.dbID)
+			return
+		}
+		header.Participants[i] = v.dbID
+		workers[i] = v
+		var h = v.request.Header.Hash()
+		txIDBuf.Write(h[:])
+	}
+	header.TxID = hash.THashH(txIDBuf.Bytes())
+
+	var coordinator = twopc.NewCoordinator(twopc.NewOptions(t.timeout))
+	if _, err = coordinator.Put(workers, header); err != nil {
+		err = errors.Wrapf(err, "distributed transaction %s failed", header.TxID.String())
+		return
+	}
+
+	results = make([]sql.Result, len(t.branches))
+	for i, v := range t.branches {
+		results[i] = &execResult{
+			affectedRows: v.response.Header.AffectedRows,
+			lastInsertID: v.response.Header.LastInsertID,
+		}
+		v.ack(nodeID, privKey)
+	}
+	return
+}
+
+// txBranch implements twopc.Worker on the leader of a database for distributed transaction.
+type txBranch struct {
+	dbID     proto.DatabaseID
+	queries  []types.Query
+	connID   uint64
+	leader   proto.NodeID
+	caller   *rpc.Caller
+	privKey  *asymmetric.PrivateKey
+	request  *types.Request
+	response *types.Response
+}
+
+func (b *txBranch) init(nodeID proto.NodeID, privKey *asymmetric.PrivateKey) (err error) {
+	var peers *proto.Peers
+	if peers, err = cacheGetPeers(b.dbID, privKey); err != nil {
+		return
+	}
+	var seqNo uint64
+	b.connID, seqNo = allocateConnAndSeq()
+	b.leader = peers.Leader
+	b.caller = rpc.NewCaller()
+	b.privKey = privKey
+	b.request = &types.Request{
+		Header: types.SignedRequestHeader{
+			RequestHeader: types.RequestHeader{
+				QueryType:    types.WriteQuery,
+				NodeID:       nodeID,
+				DatabaseID:   b.dbID,
+				ConnectionID: b.connID,
+				SeqNo:        seqNo,
+				Timestamp:    getLocalTime(),
+			},
+		},
+		Payload: types.RequestPayload{
+			Queries: b.queries,
+		},
+	}
+	return b.request.Sign(privKey)
+}
+
+func (b *txBranch) call(
+	ctx context.Context, wb twopc.WriteBatch, op types.TxOp) (res *types.TxResponse, err error,
+) {
+	var h, ok = wb.(*types.TxRequestHeader)
+	if !ok {
+		err = errors.New("unexpected WriteBatch type")
+		return
+	}
+	var req = &types.TxRequest{
+		Header: types.SignedTxRequestHeader{
+			TxRequestHeader: *h,
+		},
+	}
+	req.Header.Op = op
+	req.Header.DatabaseID = b.dbID
+	req.Header.Timestamp = getLocalTime()
+	if op == types.TxPrepare {
+		req.Request = b.request
+	}
+	if err = req.Sign(b.privKey); err != nil {
+		return
+	}
+	res = &types.TxResponse{}
+	if err = b.caller.CallNodeWithContext(ctx, b.leader, route.DBSTx.String(), req, res); err != nil {
+		err = errors.Wrapf(err, "%s transaction on database %s", op, b.dbID)
+	}
+	return
+}
+
+// Prepare implements twopc.Worker.Prepare.
+func (b *txBranch) Prepare(ctx context.Context, wb twopc.WriteBatch) (err error) {
+	_, err = b.call(ctx, wb, types.TxPrepare)
+	return
+}
+
+// Commit implements twopc.Worker.Commit.
+func (b *txBranch) Commit(ctx context.Context, wb twopc.WriteBatch) (result interface{}, err error) {
+	var res *types.TxResponse
+	if res, err = b.call(ctx, wb, types.TxCommit); err != nil {
+		return
+	}
+	if res.Response == nil {
+		err = errors.Errorf("commit transaction on database %s without response", b.dbID)
+		return
+	}
+	b.response = res.Response
+	return res.Response, nil
+}
+
+// Rollback implements twopc.Worker.Rollback.
+func (b *txBranch) Rollback(ctx context.Context, wb twopc.WriteBatch) (err error) {
+	_, err = b.call(ctx, wb, types.TxRollback)
+	return
+}
+
+// ack acknowledges the write response of the branch.
+func (b *txBranch) ack(nodeID proto.NodeID, privKey *asymmetric.PrivateKey) {
+	var ack = &types.Ack{
+		Header: types.SignedAckHeader{
+			AckHeader: types.AckHeader{
+				Response:     b.response.Header.ResponseHeader,
+				ResponseHash: b.response.Header.Hash(),
+				NodeID:       nodeID,
+				Timestamp:    getLocalTime(),
+			},
+		},
+	}
+	var (
+		ackRes types.AckResponse
+		err    = ack.Sign(privKey)
+	)
+	if err == nil {
+		err = b.caller.CallNode(b.leader, route.DBSAck.String(), ack, &ackRes)
+	}
+	if err != nil {
+		log.WithField("db", b.dbID).WithError(err).Debug("failed to ack transaction response")
+	}
+}
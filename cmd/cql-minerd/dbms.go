@@ -35,11 +35,17 @@ func startDBMS(server *mux.Server, direct *rpc.Server, onCreateDB func()) (dbms
 	}
 
 	cfg := &worker.DBMSConfig{
-		RootDir:          conf.GConf.Miner.RootDir,
-		Server:           server,
-		DirectServer:     direct,
-		MaxReqTimeGap:    conf.GConf.Miner.MaxReqTimeGap,
-		OnCreateDatabase: onCreateDB,
+		RootDir:            conf.GConf.Miner.RootDir,
+		Server:             server,
+		DirectServer:       direct,
+		MaxReqTimeGap:      conf.GConf.Miner.MaxReqTimeGap,
+		ChangeCapture:      conf.GConf.Miner.ChangeCapture,
+		HistoryRetention:   conf.GConf.Miner.HistoryRetention,
+		ResyncOnDivergence: conf.GConf.Miner.ResyncOnDivergence,
+		IdleTimeout:        conf.GConf.Miner.IdleTimeout,
+		InitConcurrency:    conf.GConf.Miner.InitConcurrency,
+		MaxQueryTime:       conf.GConf.Miner.MaxQueryTime,
+		OnCreateDatabase:   onCreateDB,
 	}
 
 	if dbms, err = worker.NewDBMS(cfg); err != nil {
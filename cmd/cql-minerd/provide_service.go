@@ -156,6 +156,11 @@ func sendProvideService(reg *prometheus.Registry) {
 	if conf.GConf.Miner != nil && len(conf.GConf.Miner.TargetUsers) > 0 {
 		tx.ProvideServiceHeader.TargetUser = conf.GConf.Miner.TargetUsers
 	}
+	if conf.GConf.Miner != nil && len(conf.GConf.Miner.Labels) > 0 {
+		// labels are only covered by the tx hash since version 1
+		tx.ProvideServiceHeader.Labels = conf.GConf.Miner.Labels
+		tx.Version = int32(tx.HSPDefaultVersion())
+	}
 
 	tx.Nonce = nonceResp.Nonce
 
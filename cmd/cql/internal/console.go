@@ -229,7 +229,7 @@ func usqlRegister() {
 				return
 			}
 
-			return sql.Open, nil
+			return openQueryPlanDB, nil
 		},
 	})
 
@@ -27,6 +27,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/client"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
 )
 
 var meta client.ResourceMeta
@@ -81,6 +82,8 @@ func (l *List) Get() interface{} { return List(*l) }
 
 var targetMiners List
 var node32 uint
+var extensions string
+var placement string
 
 func addCreateFlags(cmd *Command) {
 	cmd.Flag.Var(&targetMiners, "db-target-miners", "List of target miner addresses(separated by ',')")
@@ -88,11 +91,17 @@ func addCreateFlags(cmd *Command) {
 	cmd.Flag.Uint64Var(&meta.Space, "db-space", 0, "Minimum disk space requirement, 0 for none")
 	cmd.Flag.Uint64Var(&meta.Memory, "db-memory", 0, "Minimum memory requirement, 0 for none")
 	cmd.Flag.Float64Var(&meta.LoadAvgPerCPU, "db-load-avg-per-cpu", 0, "Minimum idle CPU requirement, 0 for none")
+	cmd.Flag.StringVar(&placement, "db-placement", "", "List of replica placement constraints, e.g., distinct:zone,require:ssd,prefer:zone=us-east(separated by ',')")
 	cmd.Flag.StringVar(&meta.EncryptionKey, "db-encrypt-key", "", "Encryption key for persistence data")
 	cmd.Flag.BoolVar(&meta.UseEventualConsistency, "db-eventual-consistency", false, "Use eventual consistency to sync among miner nodes")
 	cmd.Flag.Float64Var(&meta.ConsistencyLevel, "db-consistency-level", 0, "Consistency level, node*consistency_level is the node count to perform strong consistency")
 	cmd.Flag.IntVar(&meta.IsolationLevel, "db-isolation-level", 0, "Isolation level in a single node")
-	cmd.Flag.Uint64Var(&meta.GasPrice, "db-gas-price", 0, "Customized gas price")
+	cmd.Flag.Uint64Var(&meta.MaxQueryMillisecond, "db-max-query-ms", 0, "Max execution time of a query in milliseconds, 0 for the miner limit")
+	cmd.Flag.Uint64Var(&meta.Limits.MaxRows, "db-max-rows", 0, "Max rows returned by a read statement, 0 for unlimited")
+	cmd.Flag.Uint64Var(&meta.Limits.MaxSteps, "db-max-steps", 0, "Max sqlite VM instructions executed by a statement, 0 for unlimited")
+	cmd.Flag.Uint64Var(&meta.Limits.MaxMemory, "db-max-memory", 0, "Max bytes of the page cache, the values and the rows of a read statement, 0 for unlimited")
+	cmd.Flag.StringVar(&extensions, "db-extensions", "", "List of allowed sqlite extensions: fts5, json1, rtree(separated by ','), unrestricted if empty")
+	cmd.Flag.Uint64Var(&meta.GasPrice, "db-gas-price", 0, "Maximum acceptable gas price of the miners, the cheapest miners are selected")
 	cmd.Flag.Uint64Var(&meta.AdvancePayment, "db-advance-payment", 0, "Customized advance payment")
 }
 
@@ -123,6 +132,26 @@ func runCreate(cmd *Command, args []string) {
 	}
 	meta.Node = uint16(node32)
 
+	if extensions != "" {
+		ext, err := types.ParseSQLiteExtensions(extensions)
+		if err != nil {
+			ConsoleLog.WithError(err).Error("create extensions param is not valid")
+			SetExitStatus(1)
+			return
+		}
+		meta.Extensions = ext
+	}
+
+	if placement != "" {
+		cs, err := types.ParsePlacementConstraints(placement)
+		if err != nil {
+			ConsoleLog.WithError(err).Error("create placement param is not valid")
+			SetExitStatus(1)
+			return
+		}
+		meta.Placement = cs
+	}
+
 	if len(args) == 1 && args[0] != "" {
 		// fill the meta with params
 		if err := json.Unmarshal([]byte(args[0]), &meta); err != nil {
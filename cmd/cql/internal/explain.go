@@ -0,0 +1,141 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"regexp"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+var queryPlanRegexp = regexp.MustCompile(`(?is)^\s*explain\s+query\s+plan\s`)
+
+// covenantSQLConn defines the interfaces implemented by the CovenantSQL driver connection.
+type covenantSQLConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+}
+
+// queryPlanConnector opens CovenantSQL connections which render the results of EXPLAIN QUERY PLAN
+// queries as trees for the console.
+type queryPlanConnector struct {
+	dsn string
+	drv driver.Driver
+}
+
+func openQueryPlanDB(driverName, dsn string) (db *sql.DB, err error) {
+	if db, err = sql.Open(driverName, dsn); err != nil {
+		return
+	}
+	var drv = db.Driver()
+	_ = db.Close()
+	return sql.OpenDB(&queryPlanConnector{dsn: dsn, drv: drv}), nil
+}
+
+// Connect implements driver.Connector.Connect.
+func (c *queryPlanConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.drv.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	cc, ok := conn.(covenantSQLConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, errors.Errorf("unexpected connection type %T", conn)
+	}
+	return &queryPlanConn{covenantSQLConn: cc}, nil
+}
+
+// Driver implements driver.Connector.Driver.
+func (c *queryPlanConnector) Driver() driver.Driver {
+	return c.drv
+}
+
+type queryPlanConn struct {
+	covenantSQLConn
+}
+
+// QueryContext implements driver.QueryerContext.QueryContext.
+func (c *queryPlanConn) QueryContext(
+	ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error,
+) {
+	if rows, err = c.covenantSQLConn.QueryContext(ctx, query, args); err != nil {
+		return
+	}
+	if !queryPlanRegexp.MatchString(query) {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	var (
+		columns = rows.Columns()
+		data    [][]interface{}
+		plan    *types.QueryPlan
+	)
+	for {
+		var (
+			dest = make([]driver.Value, len(columns))
+			row  = make([]interface{}, len(columns))
+		)
+		if err = rows.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return
+		}
+		for i, v := range dest {
+			row[i] = v
+		}
+		data = append(data, row)
+	}
+	if plan, err = types.NewQueryPlanFromRows(columns, data); err != nil {
+		return
+	}
+	return &queryPlanRows{lines: plan.Lines()}, nil
+}
+
+// queryPlanRows returns the rendered query plan tree line by line.
+type queryPlanRows struct {
+	lines []string
+}
+
+// Columns implements driver.Rows.Columns.
+func (r *queryPlanRows) Columns() []string {
+	return []string{"QUERY PLAN"}
+}
+
+// Close implements driver.Rows.Close.
+func (r *queryPlanRows) Close() error {
+	return nil
+}
+
+// Next implements driver.Rows.Next.
+func (r *queryPlanRows) Next(dest []driver.Value) error {
+	if len(r.lines) == 0 {
+		return io.EOF
+	}
+	dest[0], r.lines = r.lines[0], r.lines[1:]
+	return nil
+}
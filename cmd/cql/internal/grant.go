@@ -67,6 +67,11 @@ type userPermPayload struct {
 	// SQL pattern regulations for user queries
 	// only a fully matched (case-sensitive) sql query is permitted to execute.
 	Patterns []string `json:"patterns"`
+	// Overrides of the database query limits for the read queries of the user,
+	// e.g. {"max-rows": 1000, "max-steps": 1000000, "max-memory": 1048576}.
+	Limits types.QueryLimits `json:"limits"`
+	// Stored procedures the user is permitted to call, independent of the role and patterns.
+	Procedures []string `json:"procedures"`
 }
 
 func runGrant(cmd *Command, args []string) {
@@ -117,8 +122,10 @@ func runGrant(cmd *Command, args []string) {
 	}
 
 	p := &types.UserPermission{
-		Role:     permPayload.Role,
-		Patterns: permPayload.Patterns,
+		Role:       permPayload.Role,
+		Patterns:   permPayload.Patterns,
+		Limits:     permPayload.Limits,
+		Procedures: permPayload.Procedures,
 	}
 
 	if !p.IsValid() {
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package internal
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/CovenantSQL/CovenantSQL/client"
+)
+
+var (
+	resetStats bool
+	statsJSON  bool
+)
+
+// CmdStats is cql stats command entity.
+var CmdStats = &Command{
+	UsageLine: "cql stats [common params] [-reset] [-json] dsn",
+	Short:     "show the query statistics of a database",
+	Long: `
+Stats shows the aggregated statistics of the normalized query patterns on each miner of the database,
+including call count, mean and 99th percentile latency, rows returned or affected and errors. The
+statistics are only shown to the users with the admin permission of the database.
+e.g.
+    cql stats covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+
+The statistics could also be reset after they are shown.
+e.g.
+    cql stats -reset covenantsql://4119ef997dedc585bfbcfae00ab6b87b8486fab323a8e107ea1fd4fc4f7eba5c
+`,
+	Flag:       flag.NewFlagSet("Stats params", flag.ExitOnError),
+	CommonFlag: flag.NewFlagSet("Common params", flag.ExitOnError),
+	DebugFlag:  flag.NewFlagSet("Debug params", flag.ExitOnError),
+}
+
+func init() {
+	CmdStats.Run = runStats
+
+	addCommonFlags(CmdStats)
+	addConfigFlag(CmdStats)
+	CmdStats.Flag.BoolVar(&resetStats, "reset", false, "Reset the query statistics after they are shown.")
+	CmdStats.Flag.BoolVar(&statsJSON, "json", false, "Print the query statistics in json format.")
+}
+
+func runStats(cmd *Command, args []string) {
+	commonFlagsInit(cmd)
+
+	if len(args) != 1 {
+		ConsoleLog.Error("stats command need CovenantSQL dsn or database_id string as param")
+		SetExitStatus(1)
+		printCommandHelp(cmd)
+		Exit()
+	}
+
+	configInit()
+
+	dsn := args[0]
+
+	stats, err := client.QueryStats(dsn, resetStats)
+	if err != nil {
+		ConsoleLog.WithField("db", dsn).WithError(err).Error("query stats failed")
+		SetExitStatus(1)
+		return
+	}
+	for _, s := range stats {
+		if s.Stats == nil {
+			// the statistics of the other miners are still reported
+			SetExitStatus(1)
+		}
+	}
+
+	if statsJSON {
+		out, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	for _, s := range stats {
+		if s.Stats == nil {
+			fmt.Printf("node: %s, error: %s\n\n", s.NodeID, s.Error)
+			continue
+		}
+		fmt.Printf("node: %s, since: %s\n", s.NodeID, s.Stats.Since.String())
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CALLS\tERRORS\tROWS\tMEAN(us)\tP99(us)\tMAX(us)\tTYPE\tPATTERN")
+		for _, v := range s.Stats.Stats {
+			fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+				v.Calls, v.Errors, v.Rows, v.MeanTime, v.P99Time, v.MaxTime, v.QueryType, v.Pattern)
+		}
+		_ = w.Flush()
+		fmt.Println()
+	}
+
+	if resetStats {
+		ConsoleLog.Infof("query stats of database %#v reset", dsn)
+	}
+}
@@ -39,6 +39,7 @@ func init() {
 		internal.CmdDrop,
 		internal.CmdTransfer,
 		internal.CmdGrant,
+		internal.CmdStats,
 		internal.CmdMirror,
 		internal.CmdExplorer,
 		internal.CmdAdapter,
@@ -96,6 +96,13 @@ type MinerInfo struct {
 	ProvideServiceInterval time.Duration          `yaml:"ProvideServiceInterval,omitempty"`
 	DiskUsageInterval      time.Duration          `yaml:"DiskUsageInterval,omitempty"`
 	TargetUsers            []proto.AccountAddress `yaml:"TargetUsers,omitempty"`
+	Labels                 []string               `yaml:"Labels,omitempty"` // replica placement labels, e.g., zone=us-east
+	ChangeCapture          bool                   `yaml:"ChangeCapture,omitempty"`
+	HistoryRetention       int                    `yaml:"HistoryRetention,omitempty"` // retained storage snapshots for as-of reads
+	ResyncOnDivergence     bool                   `yaml:"ResyncOnDivergence,omitempty"`
+	IdleTimeout            time.Duration          `yaml:"IdleTimeout,omitempty"`     // hibernate the databases idle for the period
+	InitConcurrency        int                    `yaml:"InitConcurrency,omitempty"` // max databases opened concurrently on startup
+	MaxQueryTime           time.Duration          `yaml:"MaxQueryTime,omitempty"`    // max execution time of the read queries
 }
 
 // DNSSeed defines seed DNS info.
@@ -144,6 +151,25 @@ type Config struct {
 	SQLChainTick       time.Duration `yaml:"SQLChainTick"`
 	SQLChainTTL        int32         `yaml:"SQLChainTTL"`
 	MinProviderDeposit uint64        `yaml:"MinProviderDeposit"`
+
+	// SQLChainStorageProofPeriod is the storage proof challenge period in sql chain blocks, the
+	// default period is used if it's 0, and the challenge is disabled if it's negative.
+	SQLChainStorageProofPeriod int32 `yaml:"SQLChainStorageProofPeriod"`
+	// SQLChainPriceSchedule is the price schedule of the sql chain billing, which should be the
+	// same on all the miners of the network to co-sign the billing, the default schedule is used
+	// if it's not set.
+	SQLChainPriceSchedule *PriceSchedule `yaml:"SQLChainPriceSchedule,omitempty"`
+}
+
+// PriceSchedule defines the unit prices of the metered resources in the sql chain billing, see
+// types.PriceSchedule for the details.
+type PriceSchedule struct {
+	Query           uint64 `yaml:"Query"`
+	RowScanned      uint64 `yaml:"RowScanned"`
+	KiloVMStep      uint64 `yaml:"KiloVMStep"`
+	RowWritten      uint64 `yaml:"RowWritten"`
+	ResultKiB       uint64 `yaml:"ResultKiB"`
+	ExecMillisecond uint64 `yaml:"ExecMillisecond"`
 }
 
 // GConf is the global config pointer.
@@ -47,6 +47,11 @@ func (r *Runtime) doLeaderPrepare(ctx context.Context, tm *timer.Timer, req inte
 
 	tm.Add("leader_encode_payload")
 
+	// the request is never interrupted once logged, reject it if the caller has already given up
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
 	// create prepare request
 	if prepareLog, err = r.leaderLogPrepare(ctx, tm, encBuf); err != nil {
 		// serve error, leader could not write logs, change leader in block producer
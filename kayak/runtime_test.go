@@ -412,6 +412,8 @@ func TestRuntime(t *testing.T) {
 		cancelCtxFunc()
 		_, _, err = rt1.Apply(cancelCtx, q)
 		So(err, ShouldNotBeNil)
+		// rejected before logged
+		So(errors.Cause(err), ShouldEqual, context.Canceled)
 
 		total := atomic.LoadUint64(&count)
 		_, _, d1, _ := db1.Query(context.Background(), []storage.Query{
@@ -79,12 +79,27 @@ const (
 	DBSDeploy
 	// DBSObserverFetchBlock is used by observer to fetch block.
 	DBSObserverFetchBlock
+	// DBSQueryStats is used by client to fetch or reset the query statistics of database
+	DBSQueryStats
+	// DBSFetchChanges is used by client to subscribe the row changes of database
+	DBSFetchChanges
+	// DBSKeyRotationStatus is used by client to fetch the key rotation status of database
+	DBSKeyRotationStatus
+	// DBSFetchSnapshot is used by miner to fetch the storage snapshot of database from its peers
+	DBSFetchSnapshot
+	// DBSStateHash is used by miner to query the local state hash of database block from its peers
+	DBSStateHash
+	// DBSTx is used by client to prepare/commit/rollback multi-database transaction, and by miner to
+	// query the transaction state of other databases
+	DBSTx
 	// DBCCall is used by Miner for data consistency
 	DBCCall
 	// SQLCAdviseNewBlock is used by sqlchain to advise new block between adjacent node
 	SQLCAdviseNewBlock
 	// SQLCFetchBlock is used by sqlchain to fetch block from adjacent nodes
 	SQLCFetchBlock
+	// SQLCChallengeStorage is used by sqlchain to raise storage proof challenge to adjacent nodes
+	SQLCChallengeStorage
 	// SQLCSignBilling is used by sqlchain to response billing signature for periodic billing request
 	SQLCSignBilling
 	// SQLCLaunchBilling is used by blockproducer to trigger the billing process in sqlchain
@@ -152,12 +167,26 @@ func (s RemoteFunc) String() string {
 		return "DBS.Deploy"
 	case DBSObserverFetchBlock:
 		return "DBS.ObserverFetchBlock"
+	case DBSQueryStats:
+		return "DBS.QueryStats"
+	case DBSFetchChanges:
+		return "DBS.FetchChanges"
+	case DBSKeyRotationStatus:
+		return "DBS.KeyRotationStatus"
+	case DBSFetchSnapshot:
+		return "DBS.FetchSnapshot"
+	case DBSStateHash:
+		return "DBS.StateHash"
+	case DBSTx:
+		return "DBS.Tx"
 	case DBCCall:
 		return "DBC.Call"
 	case SQLCAdviseNewBlock:
 		return "SQLC.AdviseNewBlock"
 	case SQLCFetchBlock:
 		return "SQLC.FetchBlock"
+	case SQLCChallengeStorage:
+		return "SQLC.ChallengeStorage"
 	case SQLCSignBilling:
 		return "SQLC.SignBilling"
 	case SQLCLaunchBilling:
@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/CovenantSQL/CovenantSQL/client"
 	"github.com/CovenantSQL/CovenantSQL/sqlchain/adapter/config"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
 )
@@ -34,6 +35,8 @@ func init() {
 	adminRoutes.Use(adminPrivilegeChecker)
 	adminRoutes.HandleFunc("/create", api.CreateDatabase).Methods("POST")
 	adminRoutes.HandleFunc("/drop", api.DropDatabase).Methods("DELETE")
+	adminRoutes.HandleFunc("/stats", api.QueryStats).Methods("GET")
+	adminRoutes.HandleFunc("/stats", api.ResetQueryStats).Methods("DELETE")
 }
 
 func adminPrivilegeChecker(next http.Handler) http.Handler {
@@ -117,3 +120,42 @@ func (a *adminAPI) DropDatabase(rw http.ResponseWriter, r *http.Request) {
 		"data":    map[string]interface{}{},
 	})
 }
+
+// QueryStats defines query statistics admin API.
+func (a *adminAPI) QueryStats(rw http.ResponseWriter, r *http.Request) {
+	a.queryStats(rw, r, false)
+}
+
+// ResetQueryStats defines query statistics reset admin API, the statistics before reset are
+// returned.
+func (a *adminAPI) ResetQueryStats(rw http.ResponseWriter, r *http.Request) {
+	a.queryStats(rw, r, true)
+}
+
+func (a *adminAPI) queryStats(rw http.ResponseWriter, r *http.Request, reset bool) {
+	var (
+		dbID  string
+		stats []*client.NodeQueryStats
+		err   error
+	)
+
+	defer func() {
+		log.WithFields(log.Fields{
+			"db":    dbID,
+			"reset": reset,
+		}).WithError(err).Debug("query stats")
+	}()
+
+	if dbID = getDatabaseID(rw, r); dbID == "" {
+		return
+	}
+
+	if stats, err = config.GetConfig().StorageInstance.QueryStats(dbID, reset); err != nil {
+		sendResponse(http.StatusInternalServerError, false, err, nil, rw)
+		return
+	}
+
+	sendResponse(http.StatusOK, true, nil, map[string]interface{}{
+		"stats": stats,
+	}, rw)
+}
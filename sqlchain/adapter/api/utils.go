@@ -46,6 +46,7 @@ func getDatabaseID(rw http.ResponseWriter, r *http.Request) string {
 			sendResponse(http.StatusBadRequest, false, err, nil, rw)
 			return ""
 		}
+		return database
 	}
 
 	// try header
@@ -54,6 +55,7 @@ func getDatabaseID(rw http.ResponseWriter, r *http.Request) string {
 			sendResponse(http.StatusBadRequest, false, err, nil, rw)
 			return ""
 		}
+		return database
 	}
 
 	sendResponse(http.StatusBadRequest, false, "missing database id", nil, rw)
@@ -115,6 +115,13 @@ func (s *CovenantSQLStorage) Exec(dbID string, query string, args ...interface{}
 	return
 }
 
+// QueryStats implements the Storage abstraction interface.
+func (s *CovenantSQLStorage) QueryStats(dbID string, reset bool) (stats []*client.NodeQueryStats, err error) {
+	cfg := client.NewConfig()
+	cfg.DatabaseID = dbID
+	return client.QueryStats(cfg.FormatDSN(), reset)
+}
+
 func (s *CovenantSQLStorage) getConn(dbID string) (db *sql.DB, err error) {
 	cfg := client.NewConfig()
 	cfg.DatabaseID = dbID
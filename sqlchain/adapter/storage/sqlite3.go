@@ -27,6 +27,8 @@ import (
 
 	// Import sqlite3 manually.
 	_ "github.com/CovenantSQL/go-sqlite3-encrypt"
+
+	"github.com/CovenantSQL/CovenantSQL/client"
 )
 
 // SQLite3Storage defines the sqlite3 database abstraction.
@@ -132,6 +134,12 @@ func (s *SQLite3Storage) Exec(dbID string, query string, args ...interface{}) (a
 	return
 }
 
+// QueryStats implements the Storage abstraction interface.
+func (s *SQLite3Storage) QueryStats(dbID string, reset bool) (stats []*client.NodeQueryStats, err error) {
+	err = ErrNotSupported
+	return
+}
+
 func (s *SQLite3Storage) getConn(dbID string, readonly bool) (db *sql.DB, err error) {
 	dbFile := filepath.Join(s.rootDir, dbID+".db3")
 	dbDSN := fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=NORMAL", dbFile)
@@ -19,6 +19,15 @@ package storage
 import (
 	"database/sql"
 	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/client"
+)
+
+var (
+	// ErrNotSupported defines error on unsupported storage operation.
+	ErrNotSupported = errors.New("operation not supported by storage")
 )
 
 // Storage defines the storage abstraction layer interface.
@@ -31,6 +40,8 @@ type Storage interface {
 	Query(dbID string, query string, args ...interface{}) (columns []string, types []string, rows [][]interface{}, err error)
 	// Exec for update.
 	Exec(dbID string, query string, args ...interface{}) (affectedRows int64, lastInsertID int64, err error)
+	// QueryStats for per-node query statistics, and reset them if required.
+	QueryStats(dbID string, reset bool) (stats []*client.NodeQueryStats, err error)
 }
 
 // golang does trick convert, use rowScanner to return the original result type in sqlite3 driver.
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlchain
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// launchBilling runs a billing round at height h as the proposer: it builds the UpdateBilling
+// transaction from the chain ended with node, collects the co-signatures of the other peers, and
+// sends the transaction to block producer once a quorum of the peers signed it.
+func (c *Chain) launchBilling(ctx context.Context, h int32, node *blockNode) (err error) {
+	var ub *types.UpdateBilling
+	if ub, err = c.billing(c.rt.getLastBillingHeight(), h, node); err != nil {
+		return
+	}
+	// allocate nonce
+	var (
+		nonceReq  = &types.NextAccountNonceReq{Addr: *c.addr}
+		nonceResp = &types.NextAccountNonceResp{}
+	)
+	if err = rpc.RequestBP(route.MCCNextAccountNonce.String(), nonceReq, nonceResp); err != nil {
+		err = errors.Wrap(err, "allocate nonce for transaction failed")
+		return
+	}
+	ub.Nonce = nonceResp.Nonce
+	ub.Quorum = uint32(types.BillingQuorum(len(c.rt.getPeers().Servers)))
+	if err = ub.Sign(c.pk); err != nil {
+		err = errors.Wrap(err, "sign tx failed")
+		return
+	}
+	if err = c.collectBillingSignatures(ctx, ub); err != nil {
+		return
+	}
+
+	var (
+		addTxReq  = &types.AddTxReq{TTL: 1, Tx: ub}
+		addTxResp = &types.AddTxResp{}
+	)
+	c.logEntryWithHeadState().Debugf("nonce in billing: %d, addr: %s, signatures: %d",
+		ub.GetAccountNonce(), ub.GetAccountAddress(), len(ub.Signatures))
+	if err = rpc.RequestBP(route.MCCAddTx.String(), addTxReq, addTxResp); err != nil {
+		err = errors.Wrap(err, "send tx failed")
+	}
+	return
+}
+
+// collectBillingSignatures requests the other peers to verify and co-sign the UpdateBilling
+// transaction, and returns an error if the quorum is not reached.
+func (c *Chain) collectBillingSignatures(ctx context.Context, ub *types.UpdateBilling) (err error) {
+	var (
+		peers  = c.rt.getPeers()
+		me     = c.rt.getServer()
+		quorum = types.BillingQuorum(len(peers.Servers))
+		wg     = &sync.WaitGroup{}
+		mu     sync.Mutex
+		sigs   []*types.MinerSignature
+	)
+	for _, s := range peers.Servers {
+		if s == me {
+			continue
+		}
+		wg.Add(1)
+		go func(remote proto.NodeID) {
+			defer wg.Done()
+			var (
+				req = &MuxSignBillingReq{
+					DatabaseID:     c.databaseID,
+					SignBillingReq: SignBillingReq{Bill: ub},
+				}
+				resp = &MuxSignBillingResp{}
+				le   = c.logEntry().WithField("remote", remote)
+			)
+			if err := c.cl.CallNodeWithContext(
+				ctx, remote, route.SQLCSignBilling.String(), req, resp,
+			); err != nil {
+				le.WithError(err).Warning("failed to request billing signature")
+				return
+			}
+			if sig := resp.Signature; sig == nil || sig.Signee == nil ||
+				!sig.Signature.Verify(ub.DataHash[:], sig.Signee) {
+				le.Warning("invalid billing signature")
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			sigs = append(sigs, resp.Signature)
+		}(s)
+	}
+	wg.Wait()
+
+	ub.Signatures = sigs
+	if len(sigs)+1 < quorum {
+		err = errors.Wrapf(ErrBillingQuorumNotReached,
+			"signed by %d of %d peers, quorum %d", len(sigs)+1, len(peers.Servers), quorum)
+	}
+	return
+}
+
+// signBilling verifies the UpdateBilling transaction proposed by another peer against the local
+// chain, and co-signs it if the local chain builds the same transaction. The quorum required by the
+// proposer must not be lower than the one of the local peer list.
+func (c *Chain) signBilling(ub *types.UpdateBilling) (sig *types.MinerSignature, err error) {
+	if ub == nil {
+		err = errors.Wrap(ErrInvalidBilling, "missing billing")
+		return
+	}
+	if receiver, _ := c.databaseID.AccountAddress(); ub.Receiver != receiver {
+		err = errors.Wrapf(ErrInvalidBilling, "unexpected receiver %s", ub.Receiver)
+		return
+	}
+	var (
+		from = int32(ub.Range.From)
+		to   = int32(ub.Range.To)
+	)
+	if from >= to {
+		err = errors.Wrapf(ErrInvalidBilling, "invalid range (%d, %d]", from, to)
+		return
+	}
+	if quorum := types.BillingQuorum(len(c.rt.getPeers().Servers)); int(ub.Quorum) < quorum {
+		err = errors.Wrapf(ErrInvalidBilling, "quorum %d is lower than %d", ub.Quorum, quorum)
+		return
+	}
+	// Wait for the local chain to reach the billing height
+	var ctx, cancel = context.WithTimeout(c.rt.ctx, c.rt.period)
+	defer cancel()
+	for c.rt.getNextTurn() <= to {
+		select {
+		case <-time.After(c.rt.tick):
+		case <-ctx.Done():
+			err = errors.Wrapf(ctx.Err(), "wait for billing height %d", to)
+			return
+		}
+	}
+
+	var expected *types.UpdateBilling
+	if expected, err = c.billing(from, to, c.rt.getHead().node); err != nil {
+		return
+	}
+	expected.Nonce = ub.Nonce
+	expected.Quorum = ub.Quorum
+	var exp, act []byte
+	if exp, err = expected.UpdateBillingHeader.MarshalHash(); err != nil {
+		return
+	}
+	if act, err = ub.UpdateBillingHeader.MarshalHash(); err != nil {
+		return
+	}
+	if !bytes.Equal(exp, act) {
+		err = errors.Wrap(ErrInvalidBilling, "billing does not match the local chain")
+		c.logEntryWithHeadState().WithFields(log.Fields{
+			"proposer": ub.GetAccountAddress(),
+			"from":     from,
+			"to":       to,
+		}).WithError(err).Warning("refuse to sign billing")
+		return
+	}
+	return ub.CoSign(c.pk)
+}
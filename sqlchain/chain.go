@@ -23,11 +23,13 @@ import (
 	"encoding/binary"
 	"expvar"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/pkg/errors"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -36,6 +38,7 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/crypto"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
@@ -49,12 +52,24 @@ import (
 )
 
 const (
-	mwMinerChain               = "service:miner:chain"
-	mwMinerChainBlockCount     = "head:count"
-	mwMinerChainBlockHeight    = "head:height"
-	mwMinerChainBlockHash      = "head:hash"
-	mwMinerChainBlockTimestamp = "head:timestamp"
-	mwMinerChainRequestsCount  = "requests:count"
+	mwMinerChain                   = "service:miner:chain"
+	mwMinerChainBlockCount         = "head:count"
+	mwMinerChainBlockHeight        = "head:height"
+	mwMinerChainBlockHash          = "head:hash"
+	mwMinerChainBlockTimestamp     = "head:timestamp"
+	mwMinerChainRequestsCount      = "requests:count"
+	mwMinerChainStmtCacheHits      = "stmtcache:hits"
+	mwMinerChainStmtCacheMiss      = "stmtcache:misses"
+	mwMinerChainProofsChallenged   = "proofs:challenged"
+	mwMinerChainProofsPassed       = "proofs:passed"
+	mwMinerChainProofsFailed       = "proofs:failed"
+	mwMinerChainProofsUnreachable  = "proofs:unreachable"
+	mwMinerChainProofsInconclusive = "proofs:inconclusive"
+	mwMinerChainStateDivergence    = "state:divergences"
+	mwMinerChainLimitViolations    = "limits:violations:"
+
+	// localStateCacheSize is the number of recent blocks to keep the local state hashes.
+	localStateCacheSize = 1024
 )
 
 var (
@@ -108,11 +123,23 @@ type Chain struct {
 	responses chan *types.ResponseHeader
 	acks      chan *types.AckHeader
 
+	// pp keeps the storage proof states if the local server is a verifier.
+	pp          *proofPool
+	proofPeriod int32
+
 	// DBAccount info
 	databaseID   proto.DatabaseID
 	tokenType    types.TokenType
 	gasPrice     uint64
 	updatePeriod uint64
+	schedule     types.PriceSchedule
+
+	onStateDiverged func(height int32, block hash.Hash)
+	// stateDigest indicates whether the local state digests the write queries.
+	stateDigest bool
+	// localStates keeps the state hashes of the recent blocks replayed on the local state, keyed
+	// by block hash.
+	localStates *lru.Cache
 
 	// Cached fileds, may need to renew some of this fields later.
 	//
@@ -192,21 +219,43 @@ func NewChainWithContext(ctx context.Context, c *Config) (chain *Chain, err erro
 		return
 	}
 
+	localStates, err := lru.New(localStateCacheSize)
+	if err != nil {
+		err = errors.Wrap(err, "failed to create local state cache")
+		return
+	}
+
 	// Create chain state
 	chain = &Chain{
-		bi:           newBlockIndex(),
-		ai:           newAckIndex(),
-		st:           x.NewState(sql.IsolationLevel(c.IsolationLevel), c.Server, strg),
-		cl:           rpc.NewCaller(),
-		rt:           newRunTime(ctx, c),
-		blocks:       make(chan *types.Block),
-		heights:      make(chan int32, 1),
-		responses:    make(chan *types.ResponseHeader),
-		acks:         make(chan *types.AckHeader),
+		bi:        newBlockIndex(),
+		ai:        newAckIndex(),
+		st:        x.NewState(sql.IsolationLevel(c.IsolationLevel), c.Server, strg),
+		cl:        rpc.NewCaller(),
+		rt:        newRunTime(ctx, c),
+		blocks:    make(chan *types.Block),
+		heights:   make(chan int32, 1),
+		responses: make(chan *types.ResponseHeader),
+		acks:      make(chan *types.AckHeader),
+		pp:        newProofPool(),
+		proofPeriod: func() int32 {
+			if c.StorageProofPeriod == 0 {
+				return DefaultStorageProofPeriod
+			}
+			return c.StorageProofPeriod
+		}(),
 		tokenType:    c.TokenType,
 		gasPrice:     c.GasPrice,
 		updatePeriod: c.UpdatePeriod,
 		databaseID:   c.DatabaseID,
+		schedule: func() types.PriceSchedule {
+			if c.PriceSchedule == nil {
+				return types.DefaultPriceSchedule()
+			}
+			return *c.PriceSchedule
+		}(),
+
+		onStateDiverged: c.OnStateDiverged,
+		localStates:     localStates,
 
 		pk:                pk,
 		addr:              &addr,
@@ -217,11 +266,50 @@ func NewChainWithContext(ctx context.Context, c *Config) (chain *Chain, err erro
 		expVars: new(expvar.Map).Init(),
 	}
 
+	if err = chain.st.SetExtensions(c.Extensions); err != nil {
+		err = errors.Wrap(err, "failed to set allowed sqlite extensions")
+		return
+	}
+	chain.st.SetMaxQueryTime(c.MaxQueryTime)
+	chain.st.SetLimits(c.Limits)
+	if err = chain.st.EnableStateDigest(); err != nil {
+		if errors.Cause(err) != x.ErrStateDigestNotSupported {
+			err = errors.Wrap(err, "failed to enable state digest")
+			return
+		}
+		// the blocks produced by this miner carry no state hash, and the ones of the others are
+		// not checked
+		log.WithError(err).Warning("state digest disabled")
+		err = nil
+	} else {
+		chain.stateDigest = true
+	}
+
 	chain.expVars.Set(mwMinerChainBlockCount, new(expvar.Int))
 	chain.expVars.Set(mwMinerChainBlockHeight, new(expvar.Int))
 	chain.expVars.Set(mwMinerChainBlockHash, new(expvar.String))
 	chain.expVars.Set(mwMinerChainBlockTimestamp, new(expvar.String))
 	chain.expVars.Set(mwMinerChainRequestsCount, mw.NewCounter("5m1m"))
+	chain.expVars.Set(mwMinerChainProofsChallenged, new(expvar.Int))
+	chain.expVars.Set(mwMinerChainProofsPassed, new(expvar.Int))
+	chain.expVars.Set(mwMinerChainProofsFailed, new(expvar.Int))
+	chain.expVars.Set(mwMinerChainProofsUnreachable, new(expvar.Int))
+	chain.expVars.Set(mwMinerChainProofsInconclusive, new(expvar.Int))
+	chain.expVars.Set(mwMinerChainStateDivergence, new(expvar.Int))
+	chain.expVars.Set(mwMinerChainStmtCacheHits, expvar.Func(func() interface{} {
+		hits, _ := chain.st.StmtCacheStats()
+		return hits
+	}))
+	chain.expVars.Set(mwMinerChainStmtCacheMiss, expvar.Func(func() interface{} {
+		_, misses := chain.st.StmtCacheStats()
+		return misses
+	}))
+	for _, v := range []string{x.LimitRows, x.LimitSteps, x.LimitMemory} {
+		var limit = v
+		chain.expVars.Set(mwMinerChainLimitViolations+limit, expvar.Func(func() interface{} {
+			return chain.st.LimitViolations(limit)
+		}))
+	}
 
 	chainVars.Set(string(c.DatabaseID), chain.expVars)
 
@@ -288,8 +376,13 @@ func NewChainWithContext(ctx context.Context, c *Config) (chain *Chain, err erro
 		if err = chain.genesis(c.Genesis); err != nil {
 			return nil, err
 		}
+		chain.st.SetSeq(c.SnapshotSeq)
 		return
 	}
+	// The blocks may be not fully synchronized after the storage is restored from a snapshot
+	if id < c.SnapshotSeq {
+		id = c.SnapshotSeq
+	}
 
 	// Set chain state
 	var head = &state{
@@ -418,12 +511,24 @@ func (c *Chain) pushBlock(b *types.Block) (err error) {
 			}).WithError(ierr).Warn("failed to remove Ack from ackIndex")
 		}
 	}
+	if len(b.FailedProofs) > 0 {
+		c.pp.removeProofs(b.FailedProofs)
+		c.expVars.Get(mwMinerChainProofsFailed).(*expvar.Int).Add(int64(len(b.FailedProofs)))
+		for _, v := range b.FailedProofs {
+			le.WithFields(log.Fields{
+				"verifier": v.Verifier,
+				"miner":    v.Miner,
+				"record":   v.Record,
+			}).Warn("failed storage proof recorded")
+		}
+	}
 
 	c.logEntry().WithFields(log.Fields{
 		"block":      b.BlockHash().String()[:8],
 		"producer":   b.Producer()[:8],
 		"queryCount": len(b.QueryTxs),
 		"ackCount":   len(b.Acks),
+		"proofCount": len(b.FailedProofs),
 		"blockTime":  b.Timestamp().Format(time.RFC3339Nano),
 		"height":     c.rt.getHeightFromTime(b.Timestamp()),
 		"head": fmt.Sprintf("%s <- %s",
@@ -467,32 +572,39 @@ func (c *Chain) pushAckedQuery(ack *types.SignedAckHeader) (err error) {
 // produceBlock prepares, signs and advises the pending block to the other peers.
 func (c *Chain) produceBlock(now time.Time) (err error) {
 	var (
-		frs []*types.Request
-		qts []*x.QueryTracker
+		frs    []*types.Request
+		qts    []*x.QueryTracker
+		proofs = c.pp.proofs()
 	)
 	if frs, qts, err = c.st.CommitEx(); err != nil {
 		err = errors.Wrap(err, "failed to fetch query list from db state")
 		return
 	}
-	if len(frs) == 0 && len(qts) == 0 {
+	if len(frs) == 0 && len(qts) == 0 && len(proofs) == 0 {
 		c.logEntryWithHeadState().Debug("no query found in current period, skip block producing")
 		return
 	}
-	var block = &types.Block{
-		SignedHeader: types.SignedHeader{
-			Header: types.Header{
-				Version:     0x01000000,
-				Producer:    c.rt.getServer(),
-				GenesisHash: c.rt.genesisHash,
-				ParentHash:  c.rt.getHead().Head,
-				// MerkleRoot: will be set by BPBlock.PackAndSignBlock(PrivateKey)
-				Timestamp: now,
+	var (
+		head  = c.rt.getHead()
+		block = &types.Block{
+			SignedHeader: types.SignedHeader{
+				Header: types.Header{
+					Version:     0x01000000,
+					Producer:    c.rt.getServer(),
+					GenesisHash: c.rt.genesisHash,
+					ParentHash:  head.Head,
+					// MerkleRoot: will be set by BPBlock.PackAndSignBlock(PrivateKey)
+					Timestamp: now,
+				},
 			},
-		},
-		FailedReqs: frs,
-		QueryTxs:   make([]*types.QueryAsTx, len(qts)),
-		Acks:       c.ai.acks(c.rt.getHeightFromTime(now)),
-	}
+			FailedReqs:   frs,
+			QueryTxs:     make([]*types.QueryAsTx, len(qts)),
+			Acks:         c.ai.acks(c.rt.getHeightFromTime(now)),
+			FailedProofs: proofs,
+		}
+		digests  []hash.Hash
+		complete = c.stateDigest
+	)
 	for i, v := range qts {
 		// TODO(leventeliu): maybe block waiting at a ready channel instead?
 		for !v.Ready() {
@@ -507,11 +619,27 @@ func (c *Chain) produceBlock(now time.Time) (err error) {
 			Request:  v.Req,
 			Response: &v.Resp.Header,
 		}
+		if v.Req.Header.QueryType != types.ReadQuery {
+			digests = append(digests, v.Digest)
+			complete = complete && !v.Digest.IsEqual(&hash.Hash{})
+		}
+	}
+	// Set state hash if all the write queries are digested
+	if complete {
+		var parent hash.Hash
+		if parent, err = c.stateHashOf(head.node); err != nil {
+			err = errors.Wrap(err, "failed to load parent state hash")
+			return
+		}
+		block.StateHash = x.NextStateHash(parent, digests)
 	}
 	// Sign block
 	if err = block.PackAndSignBlock(c.pk); err != nil {
 		return
 	}
+	if complete {
+		c.localStates.Add(*block.BlockHash(), block.StateHash)
+	}
 	// Send to pending list
 	le := c.logEntryWithHeadState().WithFields(log.Fields{
 		"using_timestamp": now.Format(time.RFC3339Nano),
@@ -785,31 +913,17 @@ func (c *Chain) processBlocks(ctx context.Context) {
 			isBillingPeriod := (h%period == 0)
 			isMyTurnBilling := (h/period%total == index)
 			if isBillingPeriod && isMyTurnBilling {
-				ub, err := c.billing(h, c.rt.getHead().node)
-				if err != nil {
-					le.WithError(err).Error("billing failed")
-				}
-				// allocate nonce
-				nonceReq := &types.NextAccountNonceReq{}
-				nonceResp := &types.NextAccountNonceResp{}
-				nonceReq.Addr = *c.addr
-				if err = rpc.RequestBP(route.MCCNextAccountNonce.String(), nonceReq, nonceResp); err != nil {
-					// allocate nonce failed
-					le.WithError(err).Warning("allocate nonce for transaction failed")
-				}
-				ub.Nonce = nonceResp.Nonce
-				if err = ub.Sign(c.pk); err != nil {
-					le.WithError(err).Warning("sign tx failed")
-				}
-
-				addTxReq := &types.AddTxReq{TTL: 1}
-				addTxResp := &types.AddTxResp{}
-				addTxReq.Tx = ub
-				le.Debugf("nonce in processBlocks: %d, addr: %s",
-					addTxReq.Tx.GetAccountNonce(), addTxReq.Tx.GetAccountAddress())
-				if err = rpc.RequestBP(route.MCCAddTx.String(), addTxReq, addTxResp); err != nil {
-					le.WithError(err).Warning("send tx failed")
-				}
+				// Run billing round asynchronously, as it waits for the co-signatures of peers
+				var node = c.rt.getHead().node
+				c.rt.goFuncWithTimeout(func(ctx context.Context) {
+					if err := c.launchBilling(ctx, h, node); err != nil {
+						le.WithError(err).Warning("billing failed")
+					}
+				}, c.rt.period)
+			}
+			// Trigger storage proof challenge
+			if c.proofPeriod > 0 && h%c.proofPeriod == 0 {
+				c.challengeStorage(h)
 			}
 			// Return all stashed blocks to pending channel
 			c.logEntryWithHeadState().WithFields(log.Fields{
@@ -909,6 +1023,55 @@ func (c *Chain) FetchBlockByCount(count int32) (b *types.Block, realCount int32,
 	return
 }
 
+// Head returns the height and count of the current head block.
+func (c *Chain) Head() (height int32, count int32) {
+	var n = c.rt.getHead().node
+	return n.height, n.count
+}
+
+// BlockCountAt returns the count of the last block at or before height, ok is false if height is
+// beyond the current head.
+func (c *Chain) BlockCountAt(height int32) (count int32, ok bool) {
+	var n = c.rt.getHead().node
+	if height < 0 || height > n.height {
+		return
+	}
+	for ; n != nil && n.height > height; n = n.parent {
+	}
+	if n == nil {
+		return
+	}
+	return n.count, true
+}
+
+// RangeBlocksByCount calls fn with the blocks in count range [from, to] from local cache in
+// order, it stops on the first error returned by fn.
+func (c *Chain) RangeBlocksByCount(
+	from, to int32, fn func(b *types.Block, height int32) error) (err error,
+) {
+	var n = c.rt.getHead().node.ancestorByCount(to)
+	if n == nil || from < 0 || from > to {
+		return errors.Errorf("block count range [%d, %d] not found", from, to)
+	}
+	var nodes = make([]*blockNode, 0, to-from+1)
+	for ; n != nil && n.count >= from; n = n.parent {
+		nodes = append(nodes, n)
+	}
+	if len(nodes) == 0 || nodes[len(nodes)-1].count != from {
+		return errors.Errorf("block count range [%d, %d] not found", from, to)
+	}
+	for i := len(nodes) - 1; i >= 0; i-- {
+		var b *types.Block
+		if b, err = c.fetchBlockByIndexKey(nodes[i].indexKey()); err != nil {
+			return
+		}
+		if err = fn(b, nodes[i].height); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func (c *Chain) fetchBlockByIndexKey(indexKey []byte) (b *types.Block, err error) {
 	k := utils.ConcatAll(c.metaBlockIndex, indexKey)
 	var v []byte
@@ -985,20 +1148,84 @@ func (c *Chain) CheckAndPushNewBlock(block *types.Block) (err error) {
 		return
 	}
 
+	// Check failed storage proofs
+	if err = verifyFailedProofs(block, peers, c.lookupChallenge); err != nil {
+		le.WithError(err).Error("invalid storage proofs in new block")
+		return
+	}
+
 	// TODO(leventeliu): check if too many periods are skipped or store block for future use.
 	// if height-c.rt.getHead().Height > X {
 	// 	...
 	// }
 
 	// Replicate local state from the new block
-	if err = c.st.ReplayBlockWithContext(c.rt.ctx, block); err != nil {
+	var digests []hash.Hash
+	if digests, err = c.st.ReplayBlockEx(c.rt.ctx, block); err != nil {
 		le.WithError(err).Error("failed to replay new block")
 		return
 	}
+	c.checkStateHash(head.node, block, height, digests, le)
 
 	return c.pushBlock(block)
 }
 
+// stateHashOf returns the state hash of the block of node, or an empty hash if node is nil.
+func (c *Chain) stateHashOf(node *blockNode) (h hash.Hash, err error) {
+	if node == nil {
+		return
+	}
+	var b = node.load()
+	if b == nil {
+		if b, err = c.fetchBlockByIndexKey(node.indexKey()); err != nil {
+			return
+		}
+	}
+	h = b.StateHash
+	return
+}
+
+// checkStateHash checks the state hash of block replayed on the local state with digests, and
+// reports the divergence if it doesn't match. The block is still accepted, as the local state
+// is the one diverged from the majority in most cases.
+func (c *Chain) checkStateHash(
+	parent *blockNode, block *types.Block, height int32, digests []hash.Hash, le *log.Entry,
+) {
+	var empty hash.Hash
+	if block.StateHash.IsEqual(&empty) || digests == nil {
+		// Not digested by the producer or the local state
+		return
+	}
+	var ph, err = c.stateHashOf(parent)
+	if err != nil {
+		le.WithError(err).Warning("failed to load parent state hash, skip state checking")
+		return
+	}
+	var local = x.NextStateHash(ph, digests)
+	c.localStates.Add(*block.BlockHash(), local)
+	if local.IsEqual(&block.StateHash) {
+		return
+	}
+	c.expVars.Get(mwMinerChainStateDivergence).(*expvar.Int).Add(1)
+	le.WithFields(log.Fields{
+		"expected_state": block.StateHash.String(),
+		"local_state":    local.String(),
+	}).Error("local state diverged from the new block")
+	if c.onStateDiverged != nil {
+		c.onStateDiverged(height, *block.BlockHash())
+	}
+}
+
+// LocalStateHash returns the state hash of the block replayed on the local state, which is only
+// kept for the recent blocks with state hash.
+func (c *Chain) LocalStateHash(block hash.Hash) (h hash.Hash, ok bool) {
+	var v interface{}
+	if v, ok = c.localStates.Get(block); ok {
+		h = v.(hash.Hash)
+	}
+	return
+}
+
 // VerifyAndPushAckedQuery verifies a acknowledged and signed query, and pushed it if valid.
 func (c *Chain) VerifyAndPushAckedQuery(ack *types.SignedAckHeader) (err error) {
 	// TODO(leventeliu): check ack.
@@ -1021,6 +1248,11 @@ func (c *Chain) UpdatePeers(peers *proto.Peers) error {
 	return c.rt.updatePeers(peers)
 }
 
+// Peers returns the current peers of the chain.
+func (c *Chain) Peers() *proto.Peers {
+	return c.rt.getPeers()
+}
+
 // Query queries req from local chain state and returns the query results in resp.
 func (c *Chain) Query(
 	req *types.Request, isLeader bool) (tracker *x.QueryTracker, resp *types.Response, err error,
@@ -1033,6 +1265,52 @@ func (c *Chain) Query(
 	return c.st.QueryWithContext(req.GetContext(), req, isLeader)
 }
 
+// Check executes the write request on local chain state and rolls it back.
+func (c *Chain) Check(req *types.Request) (err error) {
+	return c.st.CheckWithContext(req.GetContext(), req)
+}
+
+// QueryAttached queries the read request from local chain state with the databases attached.
+func (c *Chain) QueryAttached(
+	req *types.Request, attached []x.Attachment, pin x.PinFunc,
+) (
+	tracker *x.QueryTracker, resp *types.Response, err error,
+) {
+	c.expVars.Get(mwMinerChainRequestsCount).(mw.Metric).Add(1)
+	return c.st.QueryAttachedWithContext(req.GetContext(), req, attached, pin)
+}
+
+// Pin pauses the storage commits of the chain state until release is called. It returns the
+// current head height, and the log offset of the next query not committed to the storage yet.
+func (c *Chain) Pin() (height int32, seq uint64, release func()) {
+	seq, release = c.st.Pin()
+	height = c.rt.getHead().Height
+	return
+}
+
+// EnableChangeCapture enables the row change capture of the chain state, it should be called
+// before the chain is started.
+func (c *Chain) EnableChangeCapture(sink x.ChangeSink) error {
+	return c.st.EnableChangeCapture(sink)
+}
+
+// SwitchStorage switches the storage of the chain state with fn, the queries are paused during the
+// switchover.
+func (c *Chain) SwitchStorage(fn func(xi.Storage) (xi.Storage, error)) error {
+	return c.st.SwitchStorage(fn)
+}
+
+// Snapshot calls fn with the queries of the chain state paused, and passes the log offset of the
+// next write query which is not applied to the storage yet.
+func (c *Chain) Snapshot(fn func(seq uint64) error) error {
+	return c.st.Snapshot(fn)
+}
+
+// StorageSize returns the size of the database storage of the chain state in bytes.
+func (c *Chain) StorageSize() (uint64, error) {
+	return c.st.StorageSize()
+}
+
 // AddResponse addes a response to the ackIndex, awaiting for acknowledgement.
 func (c *Chain) AddResponse(resp *types.SignedResponseHeader) (err error) {
 	return c.ai.addResponse(c.rt.getHeightFromTime(resp.GetRequestTimestamp()), resp)
@@ -1086,7 +1364,10 @@ func (c *Chain) stat() {
 	c.st.Stat(c.databaseID)
 }
 
-func (c *Chain) billing(h int32, node *blockNode) (ub *types.UpdateBilling, err error) {
+// billing builds the UpdateBilling transaction within the height range (minHeight, h] of the chain
+// ended with node. The users and miners are sorted by address, so that the peers should build the
+// same transaction from the same chain.
+func (c *Chain) billing(minHeight, h int32, node *blockNode) (ub *types.UpdateBilling, err error) {
 	le := c.logEntryWithHeadState()
 	le.WithFields(log.Fields{"given_height": h}).Info("begin to billing")
 	var (
@@ -1094,9 +1375,9 @@ func (c *Chain) billing(h int32, node *blockNode) (ub *types.UpdateBilling, err
 		iter      *blockNode
 		minerAddr proto.AccountAddress
 		userAddr  proto.AccountAddress
-		minHeight = c.rt.getLastBillingHeight()
 		usersMap  = make(map[proto.AccountAddress]uint64)
 		minersMap = make(map[proto.AccountAddress]map[proto.AccountAddress]uint64)
+		schedule  = &c.schedule
 	)
 
 	for iter = node; iter != nil && iter.height > h; iter = iter.parent {
@@ -1119,13 +1400,10 @@ func (c *Chain) billing(h int32, node *blockNode) (ub *types.UpdateBilling, err
 			if _, ok := minersMap[userAddr]; !ok {
 				minersMap[userAddr] = make(map[proto.AccountAddress]uint64)
 			}
-			if tx.Request.Header.QueryType == types.ReadQuery {
-				minersMap[userAddr][minerAddr] += tx.Response.RowCount
-				usersMap[userAddr] += tx.Response.RowCount
-			} else {
-				minersMap[userAddr][minerAddr] += uint64(tx.Response.AffectedRows)
-				usersMap[userAddr] += uint64(tx.Response.AffectedRows)
-			}
+			// Charge by the metered resource usage of the acknowledged response
+			var cost = schedule.Cost(&tx.Response.Usage)
+			minersMap[userAddr][minerAddr] += cost
+			usersMap[userAddr] += cost
 		}
 
 		for _, req := range block.FailedReqs {
@@ -1137,12 +1415,12 @@ func (c *Chain) billing(h int32, node *blockNode) (ub *types.UpdateBilling, err
 				le.WithError(err).Warning("billing fail: user addr")
 				return
 			}
-			if _, ok := minersMap[userAddr][minerAddr]; !ok {
+			if _, ok := minersMap[userAddr]; !ok {
 				minersMap[userAddr] = make(map[proto.AccountAddress]uint64)
 			}
 
-			minersMap[userAddr][minerAddr] += uint64(len(req.Payload.Queries))
-			usersMap[userAddr] += uint64(len(req.Payload.Queries))
+			minersMap[userAddr][minerAddr] += schedule.Query * uint64(len(req.Payload.Queries))
+			usersMap[userAddr] += schedule.Query * uint64(len(req.Payload.Queries))
 		}
 		iter = iter.parent
 	}
@@ -1170,9 +1448,16 @@ func (c *Chain) billing(h int32, node *blockNode) (ub *types.UpdateBilling, err
 			}
 			j++
 		}
+		sort.Slice(ub.Users[i].Miners, func(x, y int) bool {
+			return bytes.Compare(
+				ub.Users[i].Miners[x].Miner[:], ub.Users[i].Miners[y].Miner[:]) < 0
+		})
 		j = 0
 		i++
 	}
+	sort.Slice(ub.Users, func(x, y int) bool {
+		return bytes.Compare(ub.Users[x].User[:], ub.Users[y].User[:]) < 0
+	})
 	ub.Receiver, err = c.databaseID.AccountAddress()
 	ub.Range.From = uint32(minHeight)
 	ub.Range.To = uint32(h)
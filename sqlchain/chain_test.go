@@ -19,6 +19,7 @@ package sqlchain
 import (
 	"bytes"
 	"encoding/hex"
+	"expvar"
 	"fmt"
 	"math/rand"
 	"path"
@@ -26,6 +27,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/consistent"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
@@ -45,6 +48,7 @@ var (
 	testPeriodNumber         int32  = 10
 	testClientNumberPerChain        = 3
 	testUpdatePeriod         uint64 = 2
+	testStorageProofPeriod   int32  = 2
 )
 
 type chainParams struct {
@@ -170,6 +174,8 @@ func TestMultiChain(t *testing.T) {
 			Peers:           peers,
 			QueryTTL:        testQueryTTL,
 			UpdatePeriod:    testUpdatePeriod,
+
+			StorageProofPeriod: testStorageProofPeriod,
 		}
 		chain, err := NewChain(config)
 
@@ -383,4 +389,83 @@ func TestMultiChain(t *testing.T) {
 	}
 
 	time.Sleep(time.Duration(testPeriodNumber) * testPeriod)
+
+	// Storage proof challenges should be raised periodically and passed by the healthy miners
+	var challenged, passed, failed, unreachable, inconclusive int64
+	for _, v := range chains {
+		challenged += v.chain.expVars.Get(mwMinerChainProofsChallenged).(*expvar.Int).Value()
+		passed += v.chain.expVars.Get(mwMinerChainProofsPassed).(*expvar.Int).Value()
+		failed += v.chain.expVars.Get(mwMinerChainProofsFailed).(*expvar.Int).Value()
+		unreachable += v.chain.expVars.Get(mwMinerChainProofsUnreachable).(*expvar.Int).Value()
+		inconclusive += v.chain.expVars.Get(mwMinerChainProofsInconclusive).(*expvar.Int).Value()
+	}
+	t.Logf("storage proof challenges: %d, passed: %d, failed: %d, unreachable: %d, inconclusive: %d",
+		challenged, passed, failed, unreachable, inconclusive)
+	if challenged == 0 {
+		t.Error("no storage proof challenge is raised")
+	}
+	if passed == 0 || passed+unreachable+inconclusive > challenged {
+		t.Errorf("unexpected passed storage proof count: %d of %d", passed, challenged)
+	}
+	if failed != 0 {
+		t.Errorf("unexpected failed storage proof count: %d", failed)
+	}
+
+	// Failed storage proofs raised by an unassigned verifier should be rejected
+	var (
+		c         = chains[0].chain
+		base      = c.rt.getHead().node.parent
+		challenge = storageProofBlock(base, peers)
+		index, _  = getNextVerifier(challenge, challenge)
+		verifier  = peers.Servers[index]
+		miner     = peers.Servers[(int(index)+1)%len(peers.Servers)]
+		newProof  = func(verifier proto.NodeID) *types.Block {
+			var proof = &types.SignedStorageProofHeader{
+				StorageProofHeader: types.StorageProofHeader{
+					Verifier:  verifier,
+					Miner:     miner,
+					BlockHash: base.hash,
+					Record:    1,
+					Expected:  hash.HashH([]byte("expected answer")),
+					Answer:    hash.HashH([]byte("wrong answer")),
+				},
+			}
+			if err := proof.Sign(testPrivKey); err != nil {
+				t.Fatalf("error occurred: %v", err)
+			}
+			return &types.Block{
+				SignedHeader: types.SignedHeader{
+					Header: types.Header{Producer: verifier},
+				},
+				FailedProofs: []*types.SignedStorageProofHeader{proof},
+			}
+		}
+		other = peers.Servers[(int(index)+2)%len(peers.Servers)]
+	)
+	if err = verifyFailedProofs(newProof(verifier), peers, c.lookupChallenge); err != nil {
+		t.Errorf("error occurred: %v", err)
+	}
+	if err = verifyFailedProofs(
+		newProof(other), peers, c.lookupChallenge,
+	); errors.Cause(err) != ErrInvalidStorageProof {
+		t.Errorf("storage proof of unassigned verifier should be rejected: %v", err)
+	}
+
+	// The local state hashes of the recent blocks should be agreed by all the miners
+	if !c.stateDigest {
+		return
+	}
+	expectedState, ok := c.LocalStateHash(base.hash)
+	if !ok {
+		t.Errorf("local state hash of block %s not found", base.hash)
+	}
+	for _, v := range chains {
+		if h, ok := v.chain.LocalStateHash(base.hash); ok && !h.IsEqual(&expectedState) {
+			t.Errorf("unexpected local state hash in peer %s: %s",
+				v.chain.rt.getPeerInfoString(), h)
+		}
+		if n := v.chain.expVars.Get(mwMinerChainStateDivergence).(*expvar.Int).Value(); n != 0 {
+			t.Errorf("unexpected state divergence count: %d", n)
+		}
+	}
 }
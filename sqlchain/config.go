@@ -19,6 +19,7 @@ package sqlchain
 import (
 	"time"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/types"
 )
@@ -40,11 +41,25 @@ type Config struct {
 	// QueryTTL sets the unacknowledged query TTL in block periods.
 	QueryTTL      int32
 	BlockCacheTTL int32
+	// StorageProofPeriod sets the storage proof challenge period in blocks, the default period
+	// is used if it's 0, and the challenge is disabled if it's negative.
+	StorageProofPeriod int32
+	// SnapshotSeq sets the log offset of the next write query of the storage restored from a
+	// snapshot, the queries before it are skipped while replaying the blocks.
+	SnapshotSeq uint64
 
 	// DBAccount info
 	TokenType         types.TokenType
 	GasPrice          uint64
 	UpdatePeriod      uint64
 	LastBillingHeight int32
+	PriceSchedule     *types.PriceSchedule // the default price schedule is used if it's nil
 	IsolationLevel    int
+	Extensions        types.SQLiteExtension
+	MaxQueryTime      time.Duration // max execution time of the read queries, 0 for unlimited
+	Limits            types.QueryLimits
+
+	// OnStateDiverged is called if the state hash of a new block from other peer doesn't match
+	// the local state.
+	OnStateDiverged func(height int32, block hash.Hash)
 }
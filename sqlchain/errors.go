@@ -45,4 +45,15 @@ var (
 	// ErrInitiating indicates that a sqlchain is in initiate state and is not available for sync
 	// requests.
 	ErrInitiating = errors.New("sqlchain is in initiate")
+	// ErrInvalidBilling indicates that a billing proposed by another peer is invalid.
+	ErrInvalidBilling = errors.New("invalid billing")
+	// ErrBillingQuorumNotReached indicates that a billing is not co-signed by a quorum of peers.
+	ErrBillingQuorumNotReached = errors.New("billing quorum not reached")
+	// ErrInvalidStorageChallenge indicates that a storage proof challenge is invalid.
+	ErrInvalidStorageChallenge = errors.New("invalid storage proof challenge")
+	// ErrStorageRecordUnavailable indicates that the challenged storage record is not available at
+	// the log offset, e.g., the local state has passed it or doesn't reach it in time.
+	ErrStorageRecordUnavailable = errors.New("storage record unavailable")
+	// ErrInvalidStorageProof indicates that a storage proof packed in block is invalid.
+	ErrInvalidStorageProof = errors.New("invalid storage proof")
 )
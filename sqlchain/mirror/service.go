@@ -17,6 +17,7 @@
 package mirror
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/ioutil"
@@ -29,6 +30,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/CovenantSQL/CovenantSQL/conf"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
@@ -59,6 +61,10 @@ type Service struct {
 	st       *x.State
 	stopCh   chan struct{}
 	wg       sync.WaitGroup
+
+	// last replayed block and its state hash, to check the state hash of the next block
+	lastBlock hash.Hash
+	lastState hash.Hash
 }
 
 // NewService returns new mirror service handler.
@@ -95,6 +101,14 @@ func NewService(database string, server *rpc.Server) (s *Service, err error) {
 	}
 
 	s.st = x.NewState(sql.LevelDefault, proto.NodeID(""), s.strg)
+	if err = s.st.EnableStateDigest(); err != nil {
+		if errors.Cause(err) != x.ErrStateDigestNotSupported {
+			err = errors.Wrap(err, "enable state digest failed")
+			return
+		}
+		log.WithError(err).Warning("state digest disabled")
+		err = nil
+	}
 
 	// register myself
 	if err = server.RegisterService(route.DBRPCName, s); err != nil {
@@ -122,6 +136,11 @@ func (s *Service) start() (err error) {
 		return
 	}
 
+	if err = s.st.SetExtensions(resp.Profile.Meta.AllowedExtensions()); err != nil {
+		err = errors.Wrap(err, "set allowed sqlite extensions failed")
+		return
+	}
+
 	s.upstream = resp.Profile.Miners[0].NodeID
 
 	// start subscriptions
@@ -212,7 +231,25 @@ func (s *Service) pull(count int32) (err error) {
 
 func (s *Service) saveBlock(b *types.Block) (err error) {
 	// save block
-	return s.st.ReplayBlock(b)
+	var digests []hash.Hash
+	if digests, err = s.st.ReplayBlockEx(context.Background(), b); err != nil {
+		return
+	}
+	// check state hash, the parent state is unknown on the first block after restarted
+	var empty hash.Hash
+	if digests != nil && b.ParentHash().IsEqual(&s.lastBlock) &&
+		!b.StateHash.IsEqual(&empty) {
+		if local := x.NextStateHash(s.lastState, digests); !local.IsEqual(&b.StateHash) {
+			log.WithFields(log.Fields{
+				"db":             s.dbID,
+				"block":          b.BlockHash().String(),
+				"expected_state": b.StateHash.String(),
+				"local_state":    local.String(),
+			}).Error("mirror state diverged from the block")
+		}
+	}
+	s.lastBlock, s.lastState = *b.BlockHash(), b.StateHash
+	return
 }
 
 func (s *Service) getProgress() int32 {
@@ -27,6 +27,7 @@ import (
 type MuxService struct {
 	ServiceName string
 	serviceMap  sync.Map
+	wake        func(id proto.DatabaseID) bool
 }
 
 // NewMuxService creates a new multiplexing service and registers it to rpc server.
@@ -47,6 +48,26 @@ func (s *MuxService) unregister(id proto.DatabaseID) {
 	s.serviceMap.Delete(id)
 }
 
+// SetWakeFunc sets the function to reopen the database closed while idle, it's called on a new
+// block, storage proof challenge or billing request to an unregistered database, and reports
+// whether the database is reopened to serve the request. The block fetches, which are polled by the peers periodically,
+// don't wake the database up. It must be set before the service is started.
+func (s *MuxService) SetWakeFunc(wake func(id proto.DatabaseID) bool) {
+	s.wake = wake
+}
+
+// load returns the chain service of database id, the database is woken up if wake is true.
+func (s *MuxService) load(id proto.DatabaseID, wake bool) (service *ChainRPCService, ok bool) {
+	var v interface{}
+	if v, ok = s.serviceMap.Load(id); !ok && wake && s.wake != nil && s.wake(id) {
+		v, ok = s.serviceMap.Load(id)
+	}
+	if ok {
+		service = v.(*ChainRPCService)
+	}
+	return
+}
+
 // MuxAdviseNewBlockReq defines a request of the AdviseNewBlock RPC method.
 type MuxAdviseNewBlockReq struct {
 	proto.Envelope
@@ -75,12 +96,40 @@ type MuxFetchBlockResp struct {
 	FetchBlockResp
 }
 
+// MuxChallengeStorageReq defines a request of the ChallengeStorage RPC method.
+type MuxChallengeStorageReq struct {
+	proto.Envelope
+	proto.DatabaseID
+	ChallengeStorageReq
+}
+
+// MuxChallengeStorageResp defines a response of the ChallengeStorage RPC method.
+type MuxChallengeStorageResp struct {
+	proto.Envelope
+	proto.DatabaseID
+	ChallengeStorageResp
+}
+
+// MuxSignBillingReq defines a request of the SignBilling RPC method.
+type MuxSignBillingReq struct {
+	proto.Envelope
+	proto.DatabaseID
+	SignBillingReq
+}
+
+// MuxSignBillingResp defines a response of the SignBilling RPC method.
+type MuxSignBillingResp struct {
+	proto.Envelope
+	proto.DatabaseID
+	SignBillingResp
+}
+
 // AdviseNewBlock is the RPC method to advise a new produced block to the target server.
 func (s *MuxService) AdviseNewBlock(req *MuxAdviseNewBlockReq, resp *MuxAdviseNewBlockResp) error {
-	if v, ok := s.serviceMap.Load(req.DatabaseID); ok {
+	if v, ok := s.load(req.DatabaseID, true); ok {
 		resp.Envelope = req.Envelope
 		resp.DatabaseID = req.DatabaseID
-		return v.(*ChainRPCService).AdviseNewBlock(&req.AdviseNewBlockReq, &resp.AdviseNewBlockResp)
+		return v.AdviseNewBlock(&req.AdviseNewBlockReq, &resp.AdviseNewBlockResp)
 	}
 
 	return ErrUnknownMuxRequest
@@ -88,10 +137,36 @@ func (s *MuxService) AdviseNewBlock(req *MuxAdviseNewBlockReq, resp *MuxAdviseNe
 
 // FetchBlock is the RPC method to fetch a known block from the target server.
 func (s *MuxService) FetchBlock(req *MuxFetchBlockReq, resp *MuxFetchBlockResp) (err error) {
-	if v, ok := s.serviceMap.Load(req.DatabaseID); ok {
+	if v, ok := s.load(req.DatabaseID, false); ok {
+		resp.Envelope = req.Envelope
+		resp.DatabaseID = req.DatabaseID
+		return v.FetchBlock(&req.FetchBlockReq, &resp.FetchBlockResp)
+	}
+
+	return ErrUnknownMuxRequest
+}
+
+// ChallengeStorage is the RPC method to answer a storage proof challenge of the target server.
+func (s *MuxService) ChallengeStorage(
+	req *MuxChallengeStorageReq, resp *MuxChallengeStorageResp) (err error,
+) {
+	// a hibernated miner is woken up to answer the challenge instead of failing the proof
+	if v, ok := s.load(req.DatabaseID, true); ok {
+		resp.Envelope = req.Envelope
+		resp.DatabaseID = req.DatabaseID
+		return v.ChallengeStorage(
+			&req.ChallengeStorageReq, &resp.ChallengeStorageResp)
+	}
+
+	return ErrUnknownMuxRequest
+}
+
+// SignBilling is the RPC method to co-sign a billing proposed by another peer.
+func (s *MuxService) SignBilling(req *MuxSignBillingReq, resp *MuxSignBillingResp) (err error) {
+	if v, ok := s.load(req.DatabaseID, true); ok {
 		resp.Envelope = req.Envelope
 		resp.DatabaseID = req.DatabaseID
-		return v.(*ChainRPCService).FetchBlock(&req.FetchBlockReq, &resp.FetchBlockResp)
+		return v.SignBilling(&req.SignBillingReq, &resp.SignBillingResp)
 	}
 
 	return ErrUnknownMuxRequest
@@ -457,6 +457,7 @@ func (a *explorerAPI) formatBlock(height int32, b *types.Block) (res map[string]
 			"height":       height,
 			"hash":         b.BlockHash().String(),
 			"genesis_hash": b.GenesisHash().String(),
+			"state_hash":   b.StateHash.String(),
 			"timestamp":    a.formatTime(b.Timestamp()),
 			"version":      b.SignedHeader.Version,
 			"producer":     b.Producer(),
@@ -17,6 +17,8 @@
 package sqlchain
 
 import (
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/types"
 )
 
@@ -46,6 +48,29 @@ type FetchBlockResp struct {
 	Block  *types.Block
 }
 
+// ChallengeStorageReq defines a request of the ChallengeStorage RPC method.
+type ChallengeStorageReq struct {
+	Verifier  proto.NodeID
+	BlockHash hash.Hash // the block which the challenge is based on
+	Record    int32
+	LogOffset uint64 // the log offset of the database state which the record is read at
+}
+
+// ChallengeStorageResp defines a response of the ChallengeStorage RPC method.
+type ChallengeStorageResp struct {
+	Answer Answer
+}
+
+// SignBillingReq defines a request of the SignBilling RPC method.
+type SignBillingReq struct {
+	Bill *types.UpdateBilling
+}
+
+// SignBillingResp defines a response of the SignBilling RPC method.
+type SignBillingResp struct {
+	Signature *types.MinerSignature
+}
+
 // AdviseNewBlock is the RPC method to advise a new produced block to the target server.
 func (s *ChainRPCService) AdviseNewBlock(req *AdviseNewBlockReq, resp *AdviseNewBlockResp) (
 	err error) {
@@ -62,3 +87,21 @@ func (s *ChainRPCService) FetchBlock(req *FetchBlockReq, resp *FetchBlockResp) (
 	}
 	return
 }
+
+// ChallengeStorage is the RPC method to answer a storage proof challenge of the target server.
+func (s *ChainRPCService) ChallengeStorage(
+	req *ChallengeStorageReq, resp *ChallengeStorageResp) (err error,
+) {
+	var answer *Answer
+	if answer, err = s.chain.answerChallenge(req); err != nil {
+		return
+	}
+	resp.Answer = *answer
+	return
+}
+
+// SignBilling is the RPC method to co-sign a billing proposed by another peer.
+func (s *ChainRPCService) SignBilling(req *SignBillingReq, resp *SignBillingResp) (err error) {
+	resp.Signature, err = s.chain.signBilling(req.Bill)
+	return
+}
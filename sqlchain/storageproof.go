@@ -17,10 +17,27 @@
 package sqlchain
 
 import (
+	"context"
 	"errors"
+	"expvar"
+	"math"
+	nrpc "net/rpc"
+	"strings"
+	"sync"
+
+	pkgerrors "github.com/pkg/errors"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+)
+
+const (
+	// DefaultStorageProofPeriod is the default storage proof challenge period in blocks.
+	DefaultStorageProofPeriod int32 = 10
 )
 
 // Answer is responded by node to confirm other nodes that the node stores data correctly.
@@ -42,11 +59,14 @@ func NewAnswer(previousBlockID BlockID, nodeID proto.NodeID, answer hash.Hash) *
 	}
 }
 
+// recordSelector returns the nth record of the database.
+type recordSelector func(n int32) ([]byte, error)
+
 // getNextPuzzle generate new puzzle which ask other nodes to get a specified record in database.
-// The index of next SQL (puzzle) is determined by the previous answer and previous block hash.
-func getNextPuzzle(answers []Answer, previousBlock StorageProofBlock) (int32, error) {
-	var totalRecordsInSQLChain int32 = 10
-	var sum int32
+// The index of next record (puzzle) is determined by the previous answers and previous block hash,
+// and it's less than the total record count.
+func getNextPuzzle(answers []Answer, previousBlock StorageProofBlock, total int32) (int32, error) {
+	var sum uint32
 	if !CheckValid(answers) {
 		return -1, errors.New("some nodes have not submitted its answer")
 	}
@@ -55,15 +75,18 @@ func getNextPuzzle(answers []Answer, previousBlock StorageProofBlock) (int32, er
 		if len(answer.Answer) != hash.HashSize {
 			return -1, errors.New("invalid answer format")
 		}
-		sum += int32(hash.FNVHash32uint(answer.Answer[:]))
+		sum += hash.FNVHash32uint(answer.Answer[:])
 	}
 	// check if block is valid
 	if len(previousBlock.ID) <= 0 {
 		return -1, errors.New("invalid block format")
 	}
-	sum += int32(hash.FNVHash32uint([]byte(previousBlock.ID)))
+	if total <= 0 {
+		return -1, errors.New("no record to select")
+	}
+	sum += hash.FNVHash32uint([]byte(previousBlock.ID))
 
-	nextPuzzle := sum % totalRecordsInSQLChain
+	nextPuzzle := int32(sum % uint32(total))
 	return nextPuzzle, nil
 }
 
@@ -77,16 +100,11 @@ func getNextVerifier(previousBlock, currentBlock StorageProofBlock) (int32, erro
 	if len(currentBlock.Nodes) <= 0 {
 		return -1, errors.New("invalid current block")
 	}
-	verifier := int32(hash.FNVHash32uint([]byte(previousBlock.ID))) % int32(len(currentBlock.Nodes))
+	verifier := int32(hash.FNVHash32uint([]byte(previousBlock.ID)) % uint32(len(currentBlock.Nodes)))
 
 	return verifier, nil
 }
 
-// selectRecord returns nth record in the table from the database.
-func selectRecord(n int32) string {
-	return "hello world"
-}
-
 // CheckValid returns whether answers is valid
 // Checkvalid checks answers as follows:
 // 1. len(answers) == len(nodes) - 1
@@ -98,18 +116,314 @@ func CheckValid(answers []Answer) bool {
 // GenerateAnswer will select specified record for proving.
 // In order to generate a unique answer which is different with other nodes' answer,
 // we hash(record + nodeID) as the answer.
-func GenerateAnswer(answers []Answer, previousBlock StorageProofBlock, node proto.Node) (*Answer, error) {
-	sqlIndex, err := getNextPuzzle(answers, previousBlock)
-	if err != nil {
-		return nil, err
+func GenerateAnswer(
+	previousBlock StorageProofBlock, puzzle int32, node proto.Node, selectRecord recordSelector,
+) (*Answer, error) {
+	// check if block is valid
+	if len(previousBlock.ID) <= 0 {
+		return nil, errors.New("invalid block format")
 	}
-	record := []byte(selectRecord(sqlIndex))
 	// check if node is valid
 	if len(node.ID) <= 0 {
 		return nil, errors.New("invalid node format")
 	}
-	answer := append(record, []byte(node.ID)...)
+	record, err := selectRecord(puzzle)
+	if err != nil {
+		return nil, err
+	}
+	answer := make([]byte, 0, len(record)+len(node.ID))
+	answer = append(append(answer, record...), node.ID...)
 
 	answerHash := hash.HashH(answer)
 	return NewAnswer(previousBlock.ID, node.ID, answerHash), nil
 }
+
+// proofPool keeps the storage proof states of the local verifier.
+type proofPool struct {
+	sync.Mutex
+	// answers is the answers of the last challenge, which seeds the next puzzle.
+	answers []Answer
+	// pending is the failed proofs to be packed into the next local produced block.
+	pending map[hash.Hash]*types.SignedStorageProofHeader
+}
+
+func newProofPool() *proofPool {
+	return &proofPool{
+		pending: make(map[hash.Hash]*types.SignedStorageProofHeader),
+	}
+}
+
+func (p *proofPool) lastAnswers() []Answer {
+	p.Lock()
+	defer p.Unlock()
+	return p.answers
+}
+
+func (p *proofPool) setAnswers(answers []Answer) {
+	p.Lock()
+	defer p.Unlock()
+	p.answers = answers
+}
+
+func (p *proofPool) addProof(proof *types.SignedStorageProofHeader) {
+	p.Lock()
+	defer p.Unlock()
+	p.pending[proof.Hash()] = proof
+}
+
+func (p *proofPool) proofs() (proofs []*types.SignedStorageProofHeader) {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.pending) == 0 {
+		return
+	}
+	proofs = make([]*types.SignedStorageProofHeader, 0, len(p.pending))
+	for _, v := range p.pending {
+		proofs = append(proofs, v)
+	}
+	return
+}
+
+func (p *proofPool) removeProofs(proofs []*types.SignedStorageProofHeader) {
+	p.Lock()
+	defer p.Unlock()
+	for _, v := range proofs {
+		delete(p.pending, v.Hash())
+	}
+}
+
+// storageProofBlock returns the storage proof view of the block node with the peer list.
+func storageProofBlock(node *blockNode, peers *proto.Peers) StorageProofBlock {
+	var nodes = make([]proto.Node, len(peers.Servers))
+	for i, v := range peers.Servers {
+		nodes[i] = proto.Node{ID: v}
+	}
+	return StorageProofBlock{
+		ID:    BlockID(node.hash.String()),
+		Nodes: nodes,
+	}
+}
+
+// challengeLookup returns the storage proof view of the challenged block with hash h.
+type challengeLookup func(h *hash.Hash) (StorageProofBlock, error)
+
+// verifyFailedProofs checks the failed storage proofs packed in the block, which should be raised
+// by the block producer against the other peers. The verifier assignment of each proof is checked
+// against the challenged block looked up by lookup.
+//
+// The expected answer is read from the database state of the verifier at the log offset of the
+// proof, which is already passed by the local state, so the peers trust the verifier signature on it
+// instead of recomputing it.
+func verifyFailedProofs(block *types.Block, peers *proto.Peers, lookup challengeLookup) (err error) {
+	for _, v := range block.FailedProofs {
+		if v.Verifier != block.Producer() {
+			return pkgerrors.Wrapf(ErrInvalidStorageProof, "unexpected verifier %s", v.Verifier)
+		}
+		if _, found := peers.Find(v.Miner); !found || v.Miner == v.Verifier {
+			return pkgerrors.Wrapf(ErrInvalidStorageProof, "unexpected miner %s", v.Miner)
+		}
+		if !v.Failed() {
+			return pkgerrors.Wrap(ErrInvalidStorageProof, "proof is not failed")
+		}
+		if err = v.Verify(); err != nil {
+			return
+		}
+		var (
+			challenged StorageProofBlock
+			index      int32
+		)
+		if challenged, err = lookup(&v.BlockHash); err != nil {
+			return pkgerrors.Wrap(ErrInvalidStorageProof, err.Error())
+		}
+		if index, err = getNextVerifier(challenged, challenged); err != nil {
+			return pkgerrors.Wrap(ErrInvalidStorageProof, err.Error())
+		}
+		if peers.Servers[index] != v.Verifier {
+			return pkgerrors.Wrapf(ErrInvalidStorageProof,
+				"verifier %s is not assigned by block %s", v.Verifier, v.BlockHash)
+		}
+	}
+	return
+}
+
+// lookupChallenge implements challengeLookup with the local blocks.
+func (c *Chain) lookupChallenge(h *hash.Hash) (block StorageProofBlock, err error) {
+	var base = c.bi.lookupNode(h)
+	if base == nil {
+		err = pkgerrors.Wrapf(ErrInvalidStorageChallenge, "block %s not found", h)
+		return
+	}
+	return storageProofBlock(base, c.rt.getPeers()), nil
+}
+
+// storedRecord returns a record selector which always selects record, which is already read from
+// the database.
+func storedRecord(record []byte) recordSelector {
+	return func(int32) ([]byte, error) { return record, nil }
+}
+
+// unavailableAnswer reports whether the challenge error err returned by the miner indicates that the
+// challenged record is not available, rather than that the miner doesn't store it.
+func unavailableAnswer(err error) bool {
+	var serr, ok = pkgerrors.Cause(err).(nrpc.ServerError)
+	return ok && strings.Contains(string(serr), ErrStorageRecordUnavailable.Error())
+}
+
+// challengeStorage raises a storage proof challenge against the other peers if the local server
+// is the verifier of the current turn. The verifier assignment and the puzzle are based on the
+// parent of the head block, so that all the peers should already have the block. The puzzle
+// selects a row of the database, which is read from the local state at its current log offset, and
+// the miners answer it with their states at the same log offset.
+//
+// The miners which can't be reached, or can't read the record at the log offset in time, are not
+// taken as failed, as the challenge is inconclusive.
+func (c *Chain) challengeStorage(h int32) {
+	var (
+		head  = c.rt.getHead().node
+		peers = c.rt.getPeers()
+		me    = c.rt.getServer()
+		le    = c.logEntryWithHeadState().WithField("challenge_height", h)
+	)
+	if head == nil || head.parent == nil || len(peers.Servers) < 2 {
+		return
+	}
+	var (
+		base  = head.parent
+		block = storageProofBlock(base, peers)
+	)
+	if index, err := getNextVerifier(block, block); err != nil || peers.Servers[index] != me {
+		return
+	}
+	var answers = c.pp.lastAnswers()
+	if !CheckValid(answers) {
+		// Seed the first puzzle with the genesis block
+		answers = []Answer{*NewAnswer(BlockID(c.rt.genesisHash.String()), me, c.rt.genesisHash)}
+	}
+	puzzle, err := getNextPuzzle(answers, block, math.MaxInt32)
+	if err != nil {
+		le.WithError(err).Warning("failed to generate storage proof puzzle")
+		return
+	}
+	seq, record, err := c.st.CurrentStorageRecord(puzzle)
+	if err != nil {
+		le.WithError(err).Warning("failed to read storage proof record")
+		return
+	}
+
+	c.rt.goFuncWithTimeout(func(ctx context.Context) {
+		var (
+			wg      = &sync.WaitGroup{}
+			mu      sync.Mutex
+			answers []Answer
+		)
+		for _, s := range peers.Servers {
+			if s == me {
+				continue
+			}
+			wg.Add(1)
+			go func(miner proto.NodeID) {
+				defer wg.Done()
+				var ile = le.WithFields(log.Fields{
+					"miner": miner, "record": puzzle, "log_offset": seq,
+				})
+				expected, err := GenerateAnswer(
+					block, puzzle, proto.Node{ID: miner}, storedRecord(record))
+				if err != nil {
+					ile.WithError(err).Warning("failed to generate expected storage proof answer")
+					return
+				}
+				var (
+					req = &MuxChallengeStorageReq{
+						DatabaseID: c.databaseID,
+						ChallengeStorageReq: ChallengeStorageReq{
+							Verifier:  me,
+							BlockHash: base.hash,
+							Record:    puzzle,
+							LogOffset: seq,
+						},
+					}
+					resp = &MuxChallengeStorageResp{}
+				)
+				c.expVars.Get(mwMinerChainProofsChallenged).(*expvar.Int).Add(1)
+				if err = c.cl.CallNodeWithContext(
+					ctx, miner, route.SQLCChallengeStorage.String(), req, resp,
+				); err != nil {
+					if _, ok := pkgerrors.Cause(err).(nrpc.ServerError); !ok {
+						ile.WithError(err).Warning("failed to reach miner to challenge storage")
+						c.expVars.Get(mwMinerChainProofsUnreachable).(*expvar.Int).Add(1)
+						return
+					}
+					if unavailableAnswer(err) {
+						ile.WithError(err).Warning("storage proof challenge is inconclusive")
+						c.expVars.Get(mwMinerChainProofsInconclusive).(*expvar.Int).Add(1)
+						return
+					}
+					ile.WithError(err).Warning("miner failed to answer storage challenge")
+					resp.Answer = Answer{}
+				} else if resp.Answer.NodeID == miner && resp.Answer.Answer.IsEqual(&expected.Answer) {
+					c.expVars.Get(mwMinerChainProofsPassed).(*expvar.Int).Add(1)
+					mu.Lock()
+					defer mu.Unlock()
+					answers = append(answers, resp.Answer)
+					return
+				}
+				var proof = &types.SignedStorageProofHeader{
+					StorageProofHeader: types.StorageProofHeader{
+						Verifier:  me,
+						Miner:     miner,
+						Height:    h,
+						BlockHash: base.hash,
+						Record:    puzzle,
+						LogOffset: seq,
+						Expected:  expected.Answer,
+						Answer:    resp.Answer.Answer,
+						Timestamp: c.rt.now(),
+					},
+				}
+				if err = proof.Sign(c.pk); err != nil {
+					ile.WithError(err).Warning("failed to sign storage proof")
+					return
+				}
+				ile.Warning("miner failed to prove its storage")
+				c.pp.addProof(proof)
+			}(s)
+		}
+		wg.Wait()
+		if len(answers) > 0 {
+			c.pp.setAnswers(answers)
+		}
+	}, c.rt.period)
+}
+
+// answerChallenge answers the storage proof challenge with the challenged record read from the local
+// state at the log offset of the challenge. It waits for the local state to reach the log offset
+// for at most half of a block period, so that the verifier gets the error before it times out.
+func (c *Chain) answerChallenge(req *ChallengeStorageReq) (answer *Answer, err error) {
+	var (
+		base  = c.bi.lookupNode(&req.BlockHash)
+		peers = c.rt.getPeers()
+	)
+	if base == nil {
+		err = pkgerrors.Wrapf(ErrInvalidStorageChallenge, "block %s not found", req.BlockHash)
+		return
+	}
+	var block = storageProofBlock(base, peers)
+	index, err := getNextVerifier(block, block)
+	if err != nil {
+		return
+	}
+	if peers.Servers[index] != req.Verifier {
+		err = pkgerrors.Wrapf(ErrInvalidStorageChallenge, "unexpected verifier %s", req.Verifier)
+		return
+	}
+	var ctx, cancel = context.WithTimeout(c.rt.ctx, c.rt.period/2)
+	defer cancel()
+	record, err := c.st.StorageRecord(ctx, req.LogOffset, req.Record)
+	if err != nil {
+		if pkgerrors.Cause(err) == x.ErrStorageRecordStale || ctx.Err() != nil {
+			err = pkgerrors.Wrap(ErrStorageRecordUnavailable, err.Error())
+		}
+		return
+	}
+	return GenerateAnswer(block, req.Record, proto.Node{ID: c.rt.getServer()}, storedRecord(record))
+}
@@ -17,12 +17,15 @@
 package sqlchain
 
 import (
+	"errors"
 	"reflect"
-	"strings"
 	"testing"
 
+	pkgerrors "github.com/pkg/errors"
+
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
 )
 
 var (
@@ -50,16 +53,16 @@ func TestNewAnswer(t *testing.T) {
 
 func TestGetNextPuzzle(t *testing.T) {
 	var totalRecordsInSQLChain int32 = 10
-	index, err := getNextPuzzle(answers, previousBlock)
+	index, err := getNextPuzzle(answers, previousBlock, totalRecordsInSQLChain)
 	if err != nil {
 		t.Error(err)
 	}
-	var wantedIndex int32
+	var sum uint32
 	for _, answer := range answers {
-		wantedIndex += int32(hash.FNVHash32uint(answer.Answer[:]))
+		sum += hash.FNVHash32uint(answer.Answer[:])
 	}
-	wantedIndex += int32(hash.FNVHash32uint([]byte(previousBlock.ID)))
-	wantedIndex %= totalRecordsInSQLChain
+	sum += hash.FNVHash32uint([]byte(previousBlock.ID))
+	wantedIndex := int32(sum % uint32(totalRecordsInSQLChain))
 	if index != wantedIndex {
 		t.Errorf("the next sql index is %+v, should be %+v. "+
 			"Answers are %+v, and the previous block is %+v",
@@ -67,13 +70,19 @@ func TestGetNextPuzzle(t *testing.T) {
 	}
 
 	// void answer
-	index, err = getNextPuzzle(voidAnswer, previousBlock)
+	index, err = getNextPuzzle(voidAnswer, previousBlock, totalRecordsInSQLChain)
 	if err == nil {
 		t.Errorf("index is %d, but should be failed", index)
 	}
 
 	// void block
-	index, err = getNextPuzzle(answers, voidBlock)
+	index, err = getNextPuzzle(answers, voidBlock, totalRecordsInSQLChain)
+	if err == nil {
+		t.Errorf("index is %d, but should be failed", index)
+	}
+
+	// void records
+	index, err = getNextPuzzle(answers, previousBlock, 0)
 	if err == nil {
 		t.Errorf("index is %d, but should be failed", index)
 	}
@@ -84,7 +93,8 @@ func TestGetNextVerifier(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	wantedVerifier := int32(hash.FNVHash32uint([]byte(previousBlock.ID))) % int32(len(currentBlock.Nodes))
+	wantedVerifier := int32(
+		hash.FNVHash32uint([]byte(previousBlock.ID)) % uint32(len(currentBlock.Nodes)))
 	if verifier != wantedVerifier {
 		t.Errorf("the next verifier is %d, should be %d", verifier, wantedVerifier)
 	}
@@ -102,9 +112,137 @@ func TestGetNextVerifier(t *testing.T) {
 	}
 }
 
-func TestSelectRecord(t *testing.T) {
-	if strings.Compare(selectRecord(0), "hello world") != 0 {
-		t.Error("it should be hello world")
+func TestProofPool(t *testing.T) {
+	pool := newProofPool()
+	if CheckValid(pool.lastAnswers()) {
+		t.Error("it should be false")
+	}
+	pool.setAnswers(answers)
+	if !reflect.DeepEqual(pool.lastAnswers(), answers) {
+		t.Errorf("answers are %+v, should be %+v", pool.lastAnswers(), answers)
+	}
+
+	proof := &types.SignedStorageProofHeader{
+		StorageProofHeader: types.StorageProofHeader{
+			Verifier: "a",
+			Miner:    "b",
+			Expected: hash.HashH([]byte{1}),
+		},
+	}
+	if err := proof.Sign(testPrivKey); err != nil {
+		t.Fatalf("error occurred: %v", err)
+	}
+	pool.addProof(proof)
+	pool.addProof(proof)
+	if proofs := pool.proofs(); len(proofs) != 1 || proofs[0] != proof {
+		t.Errorf("proofs are %+v, should be [%+v]", proofs, proof)
+	}
+	pool.removeProofs([]*types.SignedStorageProofHeader{proof})
+	if proofs := pool.proofs(); len(proofs) != 0 {
+		t.Errorf("proofs are %+v, should be empty", proofs)
+	}
+}
+
+func TestVerifyFailedProofs(t *testing.T) {
+	var (
+		peers = &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Servers: []proto.NodeID{"a", "b", "c"},
+			},
+		}
+		nodes = []proto.Node{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+		block = &types.Block{
+			SignedHeader: types.SignedHeader{
+				Header: types.Header{Producer: "a"},
+			},
+		}
+		selectRecord = func(n int32) ([]byte, error) {
+			if n < 0 || n >= 10 {
+				return nil, errors.New("record not found")
+			}
+			return []byte{byte(n)}, nil
+		}
+		challenged hash.Hash
+		otherBlock hash.Hash
+		lookup     = func(h *hash.Hash) (StorageProofBlock, error) {
+			if !h.IsEqual(&challenged) && !h.IsEqual(&otherBlock) {
+				return StorageProofBlock{}, errors.New("block not found")
+			}
+			return StorageProofBlock{ID: BlockID(h.String()), Nodes: nodes}, nil
+		}
+		newProof = func(
+			verifier, miner proto.NodeID, blockHash hash.Hash, answer hash.Hash,
+		) *types.SignedStorageProofHeader {
+			var proof = &types.SignedStorageProofHeader{
+				StorageProofHeader: types.StorageProofHeader{
+					Verifier:  verifier,
+					Miner:     miner,
+					BlockHash: blockHash,
+					Record:    1,
+					Answer:    answer,
+				},
+			}
+			if expected, err := GenerateAnswer(StorageProofBlock{ID: BlockID(blockHash.String())},
+				proof.Record, proto.Node{ID: miner}, selectRecord); err == nil {
+				proof.Expected = expected.Answer
+			}
+			if err := proof.Sign(testPrivKey); err != nil {
+				t.Fatalf("error occurred: %v", err)
+			}
+			return proof
+		}
+	)
+	// find the blocks assigning "a" and "b" as the verifiers
+	for i, found := 0, 0; found != 3; i++ {
+		var (
+			h        = hash.HashH([]byte{byte(i)})
+			index, _ = getNextVerifier(StorageProofBlock{ID: BlockID(h.String())},
+				StorageProofBlock{Nodes: nodes})
+		)
+		if index == 0 && found&1 == 0 {
+			challenged, found = h, found|1
+		} else if index == 1 && found&2 == 0 {
+			otherBlock, found = h, found|2
+		}
+	}
+
+	block.FailedProofs = []*types.SignedStorageProofHeader{
+		newProof("a", "b", challenged, hash.Hash{}),
+		newProof("a", "c", challenged, hash.HashH([]byte{2})),
+	}
+	if err := verifyFailedProofs(block, peers, lookup); err != nil {
+		t.Errorf("error occurred: %v", err)
+	}
+
+	for _, proof := range []*types.SignedStorageProofHeader{
+		newProof("b", "c", challenged, hash.Hash{}),           // not raised by producer
+		newProof("a", "d", challenged, hash.Hash{}),           // unknown miner
+		newProof("a", "a", challenged, hash.Hash{}),           // self challenged
+		newProof("a", "b", otherBlock, hash.Hash{}),           // verifier not assigned
+		newProof("a", "b", hash.HashH([]byte{}), hash.Hash{}), // unknown block
+	} {
+		block.FailedProofs = []*types.SignedStorageProofHeader{proof}
+		if err := verifyFailedProofs(block, peers, lookup); pkgerrors.Cause(err) != ErrInvalidStorageProof {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	// not failed
+	var passed = newProof("a", "b", challenged, hash.Hash{})
+	passed.Answer = passed.Expected
+	if err := passed.Sign(testPrivKey); err != nil {
+		t.Fatalf("error occurred: %v", err)
+	}
+	block.FailedProofs = []*types.SignedStorageProofHeader{passed}
+	if err := verifyFailedProofs(block, peers, lookup); pkgerrors.Cause(err) != ErrInvalidStorageProof {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tampered := newProof("a", "b", challenged, hash.Hash{})
+	tampered.Record++
+	block.FailedProofs = []*types.SignedStorageProofHeader{tampered}
+	if err := verifyFailedProofs(block, peers, lookup); err == nil {
+		t.Error("it should be failed")
 	}
 }
 
@@ -115,35 +253,41 @@ func TestCheckValid(t *testing.T) {
 }
 
 func TestGenerateAnswer(t *testing.T) {
-	answer, err := GenerateAnswer(answers, previousBlock, currentNode)
+	selectRecord := func(n int32) ([]byte, error) {
+		if n < 0 || n >= 10 {
+			return nil, errors.New("record not found")
+		}
+		return []byte{'r', byte(n)}, nil
+	}
+	sqlIndex, err := getNextPuzzle(answers, previousBlock, 10)
 	if err != nil {
 		t.Error(err)
 	}
-	sqlIndex, err := getNextPuzzle(answers, previousBlock)
+	answer, err := GenerateAnswer(previousBlock, sqlIndex, currentNode, selectRecord)
 	if err != nil {
 		t.Error(err)
 	}
-	record := []byte(selectRecord(sqlIndex))
+	record, _ := selectRecord(sqlIndex)
 	answerHash := hash.HashH(append(record, []byte(currentNode.ID)...))
 	wantedAnswer := NewAnswer(previousBlock.ID, currentNode.ID, answerHash)
 	if !reflect.DeepEqual(*answer, *wantedAnswer) {
 		t.Errorf("answer is %s, should be %s", *answer, *wantedAnswer)
 	}
 
-	// void answers
-	answer, err = GenerateAnswer(voidAnswer, previousBlock, currentNode)
+	// void record
+	answer, err = GenerateAnswer(previousBlock, 10, currentNode, selectRecord)
 	if err == nil {
 		t.Errorf("answer is %+v, should be failed", answer)
 	}
 
 	// void block
-	answer, err = GenerateAnswer(answers, voidBlock, currentNode)
+	answer, err = GenerateAnswer(voidBlock, sqlIndex, currentNode, selectRecord)
 	if err == nil {
 		t.Errorf("answer is %+v, should be failed", answer)
 	}
 
 	// void node
-	answer, err = GenerateAnswer(answers, previousBlock, voidNode)
+	answer, err = GenerateAnswer(previousBlock, sqlIndex, voidNode, selectRecord)
 	if err == nil {
 		t.Errorf("answer is %+v, should be failed", answer)
 	}
@@ -21,6 +21,8 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/pkg/errors"
+
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 )
@@ -44,12 +46,19 @@ const (
 type UserPermissionRole int32
 
 // UserPermission defines permissions of a SQLChain user.
+//
+// Since version 1, the query limits and the permitted stored procedures are hashed.
 type UserPermission struct {
 	// User role to access database.
 	Role UserPermissionRole
 	// SQL pattern regulations for user queries
 	// only a fully matched (case-sensitive) sql query is permitted to execute.
 	Patterns []string
+	// Overrides of the database query limits for the read queries of the user.
+	Limits QueryLimits
+	// Stored procedures the user is permitted to call, independent of the role and patterns.
+	Procedures []string
+	Version    int32 `hsp:"v,version"`
 
 	// patterns map cache for matching
 	cachedPatternMapOnce sync.Once
@@ -172,6 +181,37 @@ func (up *UserPermission) HasSuperPermission() bool {
 	return up.Role&Super != 0
 }
 
+// HasProcedurePermission returns true if user is permitted to call the stored procedure.
+func (up *UserPermission) HasProcedurePermission(name string) bool {
+	if up == nil {
+		return false
+	}
+	if up.HasSuperPermission() {
+		return true
+	}
+	for _, v := range up.Procedures {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyVersion checks that the fields not supported by the permission version are unset, which
+// are not covered by the hash otherwise.
+func (up *UserPermission) VerifyVersion() (err error) {
+	if up == nil {
+		return
+	}
+	if up.Version < 1 && !up.Limits.IsZero() {
+		return errors.Wrap(ErrFieldNotSupported, "limits")
+	}
+	if up.Version < 1 && len(up.Procedures) > 0 {
+		return errors.Wrap(ErrFieldNotSupported, "procedures")
+	}
+	return
+}
+
 // IsValid returns whether the permission object is valid or not.
 func (up *UserPermission) IsValid() bool {
 	return up != nil && (up.Role >= Void && up.Role < Invalid)
@@ -263,6 +303,19 @@ type MinerInfo struct {
 	Deposit        uint64
 	Status         Status
 	EncryptionKey  string
+	KeyVersion     uint32 // version of the encryption key, increased by each key rotation
+	GasPrice       uint64 // gas price bid by the miner at placement, 0 for the legacy databases
+	Labels         []string
+	MissedBillings uint32 // count of the consecutive billings not signed by the miner
+}
+
+// MinerGasPrice returns the gas price charged for the miner's income, which falls back to the
+// database gas price if the miner has no gas price recorded.
+func (p *SQLChainProfile) MinerGasPrice(miner *MinerInfo) uint64 {
+	if miner.GasPrice > 0 {
+		return miner.GasPrice
+	}
+	return p.GasPrice
 }
 
 // SQLChainProfile defines a SQLChainProfile related to an account.
@@ -297,6 +350,7 @@ type ProviderProfile struct {
 	GasPrice      uint64
 	TokenType     TokenType // default Particle
 	NodeID        proto.NodeID
+	Labels        []string // free-form labels for replica placement
 }
 
 // Account store its balance, and other mate data.
@@ -3,6 +3,8 @@ package types
 // Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
 
 import (
+	herr "errors"
+
 	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
 )
 
@@ -40,8 +42,8 @@ func (z *Account) Msgsize() (s int) {
 func (z *MinerInfo) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 9
-	o = append(o, 0x89)
+	// map header, size 13
+	o = append(o, 0x8d)
 	if oTemp, err := z.Address.MarshalHash(); err != nil {
 		return nil, err
 	} else {
@@ -49,6 +51,13 @@ func (z *MinerInfo) MarshalHash() (o []byte, err error) {
 	}
 	o = hsp.AppendUint64(o, z.Deposit)
 	o = hsp.AppendString(o, z.EncryptionKey)
+	o = hsp.AppendUint64(o, z.GasPrice)
+	o = hsp.AppendUint32(o, z.KeyVersion)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Labels)))
+	for za0002 := range z.Labels {
+		o = hsp.AppendString(o, z.Labels[za0002])
+	}
+	o = hsp.AppendUint32(o, z.MissedBillings)
 	o = hsp.AppendString(o, z.Name)
 	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
 		return nil, err
@@ -78,7 +87,11 @@ func (z *MinerInfo) MarshalHash() (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *MinerInfo) Msgsize() (s int) {
-	s = 1 + 8 + z.Address.Msgsize() + 8 + hsp.Uint64Size + 14 + hsp.StringPrefixSize + len(z.EncryptionKey) + 5 + hsp.StringPrefixSize + len(z.Name) + 7 + z.NodeID.Msgsize() + 14 + hsp.Uint64Size + 15 + hsp.Uint64Size + 7 + hsp.Int32Size + 12 + hsp.ArrayHeaderSize
+	s = 1 + 8 + z.Address.Msgsize() + 8 + hsp.Uint64Size + 14 + hsp.StringPrefixSize + len(z.EncryptionKey) + 9 + hsp.Uint64Size + 11 + hsp.Uint32Size + 7 + hsp.ArrayHeaderSize
+	for za0002 := range z.Labels {
+		s += hsp.StringPrefixSize + len(z.Labels[za0002])
+	}
+	s += 15 + hsp.Uint32Size + 5 + hsp.StringPrefixSize + len(z.Name) + 7 + z.NodeID.Msgsize() + 14 + hsp.Uint64Size + 15 + hsp.Uint64Size + 7 + hsp.Int32Size + 12 + hsp.ArrayHeaderSize
 	for za0001 := range z.UserArrears {
 		if z.UserArrears[za0001] == nil {
 			s += hsp.NilSize
@@ -93,10 +106,14 @@ func (z *MinerInfo) Msgsize() (s int) {
 func (z *ProviderProfile) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 9
-	o = append(o, 0x89)
+	// map header, size 10
+	o = append(o, 0x8a)
 	o = hsp.AppendUint64(o, z.Deposit)
 	o = hsp.AppendUint64(o, z.GasPrice)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Labels)))
+	for za0002 := range z.Labels {
+		o = hsp.AppendString(o, z.Labels[za0002])
+	}
 	o = hsp.AppendFloat64(o, z.LoadAvgPerCPU)
 	o = hsp.AppendUint64(o, z.Memory)
 	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
@@ -128,7 +145,11 @@ func (z *ProviderProfile) MarshalHash() (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *ProviderProfile) Msgsize() (s int) {
-	s = 1 + 8 + hsp.Uint64Size + 9 + hsp.Uint64Size + 14 + hsp.Float64Size + 7 + hsp.Uint64Size + 7 + z.NodeID.Msgsize() + 9 + z.Provider.Msgsize() + 6 + hsp.Uint64Size + 11 + hsp.ArrayHeaderSize
+	s = 1 + 8 + hsp.Uint64Size + 9 + hsp.Uint64Size + 7 + hsp.ArrayHeaderSize
+	for za0002 := range z.Labels {
+		s += hsp.StringPrefixSize + len(z.Labels[za0002])
+	}
+	s += 14 + hsp.Float64Size + 7 + hsp.Uint64Size + 7 + z.NodeID.Msgsize() + 9 + z.Provider.Msgsize() + 6 + hsp.Uint64Size + 11 + hsp.ArrayHeaderSize
 	for za0001 := range z.TargetUser {
 		s += z.TargetUser[za0001].Msgsize()
 	}
@@ -250,12 +271,10 @@ func (z *SQLChainUser) MarshalHash() (o []byte, err error) {
 	if z.Permission == nil {
 		o = hsp.AppendNil(o)
 	} else {
-		// map header, size 2
-		o = append(o, 0x82)
-		o = hsp.AppendInt32(o, int32(z.Permission.Role))
-		o = hsp.AppendArrayHeader(o, uint32(len(z.Permission.Patterns)))
-		for za0001 := range z.Permission.Patterns {
-			o = hsp.AppendString(o, z.Permission.Patterns[za0001])
+		if oTemp, err := z.Permission.MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
 		}
 	}
 	o = hsp.AppendInt32(o, int32(z.Status))
@@ -268,10 +287,7 @@ func (z *SQLChainUser) Msgsize() (s int) {
 	if z.Permission == nil {
 		s += hsp.NilSize
 	} else {
-		s += 1 + 5 + hsp.Int32Size + 9 + hsp.ArrayHeaderSize
-		for za0001 := range z.Permission.Patterns {
-			s += hsp.StringPrefixSize + len(z.Permission.Patterns[za0001])
-		}
+		s += z.Permission.Msgsize()
 	}
 	s += 7 + hsp.Int32Size
 	return
@@ -312,27 +328,50 @@ func (z *UserArrears) Msgsize() (s int) {
 	return
 }
 
+var hspVersionsUserPermission = []string{
+	"oldver",
+	"214ccf",
+}
+
+// HSPCurrentVersion returns current struct version
+func (z *UserPermission) HSPCurrentVersion() int {
+	return int(z.Version)
+}
+
+// HSPMaxVersion returns max struct version
+func (z *UserPermission) HSPMaxVersion() int {
+	return 1
+}
+
+// HSPDefaultVersion returns default struct version
+func (z *UserPermission) HSPDefaultVersion() int {
+	return 1
+}
+
 // MarshalHash marshals for hash
 func (z *UserPermission) MarshalHash() (o []byte, err error) {
-	var b []byte
-	o = hsp.Require(b, z.Msgsize())
-	// map header, size 2
-	o = append(o, 0x82)
-	o = hsp.AppendArrayHeader(o, uint32(len(z.Patterns)))
-	for za0001 := range z.Patterns {
-		o = hsp.AppendString(o, z.Patterns[za0001])
+	switch z.HSPCurrentVersion() {
+	case 0:
+		return z.MarshalHasholdver()
+	case 1:
+		return z.MarshalHash214ccf()
+	default:
+		err = herr.New("invalid struct version")
+		return
 	}
-	o = hsp.AppendInt32(o, int32(z.Role))
 	return
 }
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *UserPermission) Msgsize() (s int) {
-	s = 1 + 9 + hsp.ArrayHeaderSize
-	for za0001 := range z.Patterns {
-		s += hsp.StringPrefixSize + len(z.Patterns[za0001])
+	switch z.HSPCurrentVersion() {
+	case 0:
+		return z.Msgsizeoldver()
+	case 1:
+		return z.Msgsize214ccf()
+	default:
+		return 0
 	}
-	s += 5 + hsp.Int32Size
 	return
 }
 
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -88,6 +89,41 @@ func TestUserPermission(t *testing.T) {
 		So(UserPermissionFromRole(ReadWrite).HasSuperPermission(), ShouldBeFalse)
 		So(UserPermissionFromRole(Admin).HasSuperPermission(), ShouldBeTrue)
 	})
+	Convey("has procedure permission", t, func() {
+		up := UserPermissionFromRole(Void)
+		up.Procedures = []string{"p1"}
+		So(up.HasProcedurePermission("p1"), ShouldBeTrue)
+		So(up.HasProcedurePermission("p2"), ShouldBeFalse)
+		So(UserPermissionFromRole(ReadWrite).HasProcedurePermission("p1"), ShouldBeFalse)
+		So(UserPermissionFromRole(Admin).HasProcedurePermission("p1"), ShouldBeTrue)
+		So((*UserPermission)(nil).HasProcedurePermission("p1"), ShouldBeFalse)
+	})
+	Convey("verify version", t, func() {
+		So((*UserPermission)(nil).VerifyVersion(), ShouldBeNil)
+		up := UserPermissionFromRole(Read)
+		So(up.VerifyVersion(), ShouldBeNil)
+		legacy, err := up.MarshalHash()
+		So(err, ShouldBeNil)
+
+		// limits and procedures are not covered by the legacy hash
+		up.Limits.MaxRows = 10
+		h, err := up.MarshalHash()
+		So(err, ShouldBeNil)
+		So(h, ShouldResemble, legacy)
+		So(errors.Cause(up.VerifyVersion()), ShouldEqual, ErrFieldNotSupported)
+		up.Limits.MaxRows = 0
+		up.Procedures = []string{"p1"}
+		h, err = up.MarshalHash()
+		So(err, ShouldBeNil)
+		So(h, ShouldResemble, legacy)
+		So(errors.Cause(up.VerifyVersion()), ShouldEqual, ErrFieldNotSupported)
+
+		up.Version = 1
+		So(up.VerifyVersion(), ShouldBeNil)
+		h, err = up.MarshalHash()
+		So(err, ShouldBeNil)
+		So(h, ShouldNotResemble, legacy)
+	})
 	Convey("is valid", t, func() {
 		So(UserPermissionFromRole(Void).IsValid(), ShouldBeTrue)
 		So(UserPermissionFromRole(Read).IsValid(), ShouldBeTrue)
@@ -0,0 +1,45 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash214ccf marshals for hash
+func (z *UserPermission) MarshalHash214ccf() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize214ccf())
+	// map header, size 5
+	o = append(o, 0x85)
+	if oTemp, err := z.Limits.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Patterns)))
+	for za0001 := range z.Patterns {
+		o = hsp.AppendString(o, z.Patterns[za0001])
+	}
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Procedures)))
+	for za0002 := range z.Procedures {
+		o = hsp.AppendString(o, z.Procedures[za0002])
+	}
+	o = hsp.AppendInt32(o, int32(z.Role))
+	o = hsp.AppendInt32(o, z.Version)
+	return
+}
+
+// Msgsize214ccf returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *UserPermission) Msgsize214ccf() (s int) {
+	s = 1 + 7 + z.Limits.Msgsize() + 9 + hsp.ArrayHeaderSize
+	for za0001 := range z.Patterns {
+		s += hsp.StringPrefixSize + len(z.Patterns[za0001])
+	}
+	s += 11 + hsp.ArrayHeaderSize
+	for za0002 := range z.Procedures {
+		s += hsp.StringPrefixSize + len(z.Procedures[za0002])
+	}
+	s += 5 + hsp.Int32Size + 2 + hsp.Int32Size
+	return
+}
@@ -0,0 +1,31 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHasholdver marshals for hash
+func (z *UserPermission) MarshalHasholdver() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+
+	o = append(o, 0x82)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Patterns)))
+	for za0001 := range z.Patterns {
+		o = hsp.AppendString(o, z.Patterns[za0001])
+	}
+	o = hsp.AppendInt32(o, int32(z.Role))
+	return
+}
+
+// Msgsizeoldver returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *UserPermission) Msgsizeoldver() (s int) {
+	s = 1 + 9 + hsp.ArrayHeaderSize
+	for za0001 := range z.Patterns {
+		s += hsp.StringPrefixSize + len(z.Patterns[za0001])
+	}
+	s += 5 + hsp.Int32Size
+	return
+}
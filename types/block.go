@@ -79,6 +79,11 @@ type Block struct {
 	FailedReqs   []*Request
 	QueryTxs     []*QueryAsTx
 	Acks         []*SignedAckHeader
+	FailedProofs []*SignedStorageProofHeader
+	// StateHash is the checksum of the row changes made to the database state by the queries of
+	// the block and all its ancestors, it's left empty if the producer doesn't digest its state. It's committed by the
+	// merkle root instead of the header, so that the header hash of the legacy blocks is kept.
+	StateHash hash.Hash
 }
 
 // CalcNextID calculates the next query id by examinating every query in block, and adds write
@@ -172,7 +177,8 @@ func (b *Block) Signee() *ca.PublicKey {
 }
 
 func (b *Block) computeMerkleRoot() hash.Hash {
-	var hs = make([]*hash.Hash, 0, len(b.FailedReqs)+len(b.QueryTxs)+len(b.Acks))
+	var hs = make([]*hash.Hash, 0,
+		len(b.FailedReqs)+len(b.QueryTxs)+len(b.Acks)+len(b.FailedProofs))
 	for i := range b.FailedReqs {
 		h := b.FailedReqs[i].Header.Hash()
 		hs = append(hs, &h)
@@ -185,6 +191,13 @@ func (b *Block) computeMerkleRoot() hash.Hash {
 		h := b.Acks[i].Hash()
 		hs = append(hs, &h)
 	}
+	for i := range b.FailedProofs {
+		h := b.FailedProofs[i].Hash()
+		hs = append(hs, &h)
+	}
+	if !b.StateHash.IsEqual(&hash.Hash{}) {
+		hs = append(hs, &b.StateHash)
+	}
 	return *merkle.NewMerkle(hs).GetRoot()
 }
 
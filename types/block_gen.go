@@ -10,8 +10,8 @@ import (
 func (z *Block) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 4
-	o = append(o, 0x84)
+	// map header, size 6
+	o = append(o, 0x86)
 	o = hsp.AppendArrayHeader(o, uint32(len(z.Acks)))
 	for za0003 := range z.Acks {
 		if z.Acks[za0003] == nil {
@@ -24,6 +24,18 @@ func (z *Block) MarshalHash() (o []byte, err error) {
 			}
 		}
 	}
+	o = hsp.AppendArrayHeader(o, uint32(len(z.FailedProofs)))
+	for za0004 := range z.FailedProofs {
+		if z.FailedProofs[za0004] == nil {
+			o = hsp.AppendNil(o)
+		} else {
+			if oTemp, err := z.FailedProofs[za0004].MarshalHash(); err != nil {
+				return nil, err
+			} else {
+				o = hsp.AppendBytes(o, oTemp)
+			}
+		}
+	}
 	o = hsp.AppendArrayHeader(o, uint32(len(z.FailedReqs)))
 	for za0001 := range z.FailedReqs {
 		if z.FailedReqs[za0001] == nil {
@@ -60,6 +72,11 @@ func (z *Block) MarshalHash() (o []byte, err error) {
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
+	if oTemp, err := z.StateHash.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
 	return
 }
 
@@ -73,6 +90,14 @@ func (z *Block) Msgsize() (s int) {
 			s += z.Acks[za0003].Msgsize()
 		}
 	}
+	s += 13 + hsp.ArrayHeaderSize
+	for za0004 := range z.FailedProofs {
+		if z.FailedProofs[za0004] == nil {
+			s += hsp.NilSize
+		} else {
+			s += z.FailedProofs[za0004].Msgsize()
+		}
+	}
 	s += 11 + hsp.ArrayHeaderSize
 	for za0001 := range z.FailedReqs {
 		if z.FailedReqs[za0001] == nil {
@@ -89,7 +114,7 @@ func (z *Block) Msgsize() (s int) {
 			s += z.QueryTxs[za0002].Msgsize()
 		}
 	}
-	s += 13 + 1 + 7 + z.SignedHeader.Header.Msgsize() + 4 + z.SignedHeader.HSV.Msgsize()
+	s += 13 + 1 + 7 + z.SignedHeader.Header.Msgsize() + 4 + z.SignedHeader.HSV.Msgsize() + 10 + z.StateHash.Msgsize()
 	return
 }
 
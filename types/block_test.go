@@ -56,6 +56,36 @@ func TestSignAndVerify(t *testing.T) {
 	if err = block.Verify(); err != ErrMerkleRootVerification {
 		t.Fatalf("unexpected error: %v", err)
 	}
+
+	block.Acks = nil
+	block.FailedProofs = append(block.FailedProofs, &SignedStorageProofHeader{
+		DefaultHashSignVerifierImpl: verifier.DefaultHashSignVerifierImpl{
+			DataHash: hash.Hash{0x01},
+		},
+	})
+
+	if err = block.Verify(); err != ErrMerkleRootVerification {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the state hash is committed by the merkle root, and the header hash of the blocks without
+	// state hash is kept
+	block.FailedProofs = nil
+	legacy := block.computeMerkleRoot()
+	block.StateHash = hash.Hash{0x01}
+	if merkleRoot := block.computeMerkleRoot(); merkleRoot.IsEqual(&legacy) {
+		t.Fatal("state hash should be committed by the merkle root")
+	}
+	if err = block.PackAndSignBlock(testingPrivateKey); err != nil {
+		t.Fatalf("error occurred: %v", err)
+	}
+	if err = block.Verify(); err != nil {
+		t.Fatalf("error occurred: %v", err)
+	}
+	block.StateHash = hash.Hash{0x02}
+	if err = block.Verify(); err != ErrMerkleRootVerification {
+		t.Fatalf("unexpected error: %v", err)
+	}
 }
 
 func TestHeaderMarshalUnmarshaler(t *testing.T) {
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// ChangeOp enumerates the row change operations.
+type ChangeOp int32
+
+const (
+	// ChangeInsert defines a row insertion.
+	ChangeInsert ChangeOp = iota
+	// ChangeUpdate defines a row update.
+	ChangeUpdate
+	// ChangeDelete defines a row deletion.
+	ChangeDelete
+)
+
+// String implements fmt.Stringer.String.
+func (o ChangeOp) String() string {
+	switch o {
+	case ChangeInsert:
+		return "insert"
+	case ChangeUpdate:
+		return "update"
+	case ChangeDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// ChangePosition defines the position of a row change in the change stream of a database.
+type ChangePosition struct {
+	Offset uint64 `json:"offset"` // log offset of the write query which makes the change
+	Index  uint32 `json:"index"`  // index of the change in the write query
+}
+
+// Less reports whether the position p is before the position o.
+func (p ChangePosition) Less(o ChangePosition) bool {
+	return p.Offset < o.Offset || (p.Offset == o.Offset && p.Index < o.Index)
+}
+
+// Next returns the position right after p in the same write query.
+func (p ChangePosition) Next() ChangePosition {
+	return ChangePosition{Offset: p.Offset, Index: p.Index + 1}
+}
+
+// String implements fmt.Stringer.String.
+func (p ChangePosition) String() string {
+	return fmt.Sprintf("%d:%d", p.Offset, p.Index)
+}
+
+// RowChange defines a row level change captured on a database.
+type RowChange struct {
+	Position   ChangePosition `json:"pos"`
+	Table      string         `json:"table"`
+	Op         ChangeOp       `json:"op"`
+	RowID      int64          `json:"rowid"`
+	Columns    []string       `json:"columns"`
+	PrimaryKey []interface{}  `json:"pk"`            // primary key values, or the rowid if not declared
+	Old        []interface{}  `json:"old,omitempty"` // column values before update or delete
+	New        []interface{}  `json:"new,omitempty"` // column values after insert or update
+}
+
+// ChangesRequestHeader defines the change stream fetching rpc request header.
+type ChangesRequestHeader struct {
+	DatabaseID proto.DatabaseID
+	Since      ChangePosition // position of the first change to fetch
+	Limit      uint32         // maximum count of changes to fetch
+	Timestamp  time.Time
+}
+
+// SignedChangesRequestHeader defines the signed change stream fetching rpc request header.
+type SignedChangesRequestHeader struct {
+	ChangesRequestHeader
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// Verify checks hash and signature in change stream fetching request header.
+func (sh *SignedChangesRequestHeader) Verify() (err error) {
+	return sh.DefaultHashSignVerifierImpl.Verify(&sh.ChangesRequestHeader)
+}
+
+// Sign the request.
+func (sh *SignedChangesRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return sh.DefaultHashSignVerifierImpl.Sign(&sh.ChangesRequestHeader, signer)
+}
+
+// ChangesRequest defines the change stream fetching rpc request entity.
+type ChangesRequest struct {
+	proto.Envelope
+	Header SignedChangesRequestHeader
+}
+
+// Verify checks hash and signature in request header.
+func (r *ChangesRequest) Verify() error {
+	return r.Header.Verify()
+}
+
+// Sign the request.
+func (r *ChangesRequest) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return r.Header.Sign(signer)
+}
+
+// ChangesResponse defines the change stream fetching rpc response entity.
+type ChangesResponse struct {
+	proto.Envelope
+	NodeID  proto.NodeID
+	Changes []*RowChange
+	Next    ChangePosition // position to resume the change stream from
+}
@@ -0,0 +1,233 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash marshals for hash
+func (z ChangeOp) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	o = hsp.AppendInt32(o, int32(z))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z ChangeOp) Msgsize() (s int) {
+	s = hsp.Int32Size
+	return
+}
+
+// MarshalHash marshals for hash
+func (z ChangePosition) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	o = hsp.AppendUint32(o, z.Index)
+	o = hsp.AppendUint64(o, z.Offset)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z ChangePosition) Msgsize() (s int) {
+	s = 1 + 6 + hsp.Uint32Size + 7 + hsp.Uint64Size
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *ChangesRequest) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.Header.ChangesRequestHeader.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Header.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ChangesRequest) Msgsize() (s int) {
+	s = 1 + 9 + z.Envelope.Msgsize() + 7 + 1 + 21 + z.Header.ChangesRequestHeader.Msgsize() + 28 + z.Header.DefaultHashSignVerifierImpl.Msgsize()
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *ChangesRequestHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 4
+	o = append(o, 0x84)
+	if oTemp, err := z.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendUint32(o, z.Limit)
+	// map header, size 2
+	o = append(o, 0x82)
+	o = hsp.AppendUint64(o, z.Since.Offset)
+	o = hsp.AppendUint32(o, z.Since.Index)
+	o = hsp.AppendTime(o, z.Timestamp)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ChangesRequestHeader) Msgsize() (s int) {
+	s = 1 + 11 + z.DatabaseID.Msgsize() + 6 + hsp.Uint32Size + 6 + 1 + 7 + hsp.Uint64Size + 6 + hsp.Uint32Size + 10 + hsp.TimeSize
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *ChangesResponse) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 4
+	o = append(o, 0x84)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Changes)))
+	for za0001 := range z.Changes {
+		if z.Changes[za0001] == nil {
+			o = hsp.AppendNil(o)
+		} else {
+			if oTemp, err := z.Changes[za0001].MarshalHash(); err != nil {
+				return nil, err
+			} else {
+				o = hsp.AppendBytes(o, oTemp)
+			}
+		}
+	}
+	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	// map header, size 2
+	o = append(o, 0x82)
+	o = hsp.AppendUint64(o, z.Next.Offset)
+	o = hsp.AppendUint32(o, z.Next.Index)
+	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ChangesResponse) Msgsize() (s int) {
+	s = 1 + 8 + hsp.ArrayHeaderSize
+	for za0001 := range z.Changes {
+		if z.Changes[za0001] == nil {
+			s += hsp.NilSize
+		} else {
+			s += z.Changes[za0001].Msgsize()
+		}
+	}
+	s += 9 + z.Envelope.Msgsize() + 5 + 1 + 7 + hsp.Uint64Size + 6 + hsp.Uint32Size + 7 + z.NodeID.Msgsize()
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *RowChange) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 8
+	o = append(o, 0x88)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Columns)))
+	for za0001 := range z.Columns {
+		o = hsp.AppendString(o, z.Columns[za0001])
+	}
+	o = hsp.AppendArrayHeader(o, uint32(len(z.New)))
+	for za0004 := range z.New {
+		o, err = hsp.AppendIntf(o, z.New[za0004])
+		if err != nil {
+			return
+		}
+	}
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Old)))
+	for za0003 := range z.Old {
+		o, err = hsp.AppendIntf(o, z.Old[za0003])
+		if err != nil {
+			return
+		}
+	}
+	o = hsp.AppendInt32(o, int32(z.Op))
+	// map header, size 2
+	o = append(o, 0x82)
+	o = hsp.AppendUint64(o, z.Position.Offset)
+	o = hsp.AppendUint32(o, z.Position.Index)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.PrimaryKey)))
+	for za0002 := range z.PrimaryKey {
+		o, err = hsp.AppendIntf(o, z.PrimaryKey[za0002])
+		if err != nil {
+			return
+		}
+	}
+	o = hsp.AppendInt64(o, z.RowID)
+	o = hsp.AppendString(o, z.Table)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *RowChange) Msgsize() (s int) {
+	s = 1 + 8 + hsp.ArrayHeaderSize
+	for za0001 := range z.Columns {
+		s += hsp.StringPrefixSize + len(z.Columns[za0001])
+	}
+	s += 4 + hsp.ArrayHeaderSize
+	for za0004 := range z.New {
+		s += hsp.GuessSize(z.New[za0004])
+	}
+	s += 4 + hsp.ArrayHeaderSize
+	for za0003 := range z.Old {
+		s += hsp.GuessSize(z.Old[za0003])
+	}
+	s += 3 + hsp.Int32Size + 9 + 1 + 7 + hsp.Uint64Size + 6 + hsp.Uint32Size + 11 + hsp.ArrayHeaderSize
+	for za0002 := range z.PrimaryKey {
+		s += hsp.GuessSize(z.PrimaryKey[za0002])
+	}
+	s += 6 + hsp.Int64Size + 6 + hsp.StringPrefixSize + len(z.Table)
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *SignedChangesRequestHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.ChangesRequestHeader.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *SignedChangesRequestHeader) Msgsize() (s int) {
+	s = 1 + 21 + z.ChangesRequestHeader.Msgsize() + 28 + z.DefaultHashSignVerifierImpl.Msgsize()
+	return
+}
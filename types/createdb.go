@@ -30,7 +30,7 @@ import (
 type CreateDatabaseHeader struct {
 	Owner          proto.AccountAddress
 	ResourceMeta   ResourceMeta
-	GasPrice       uint64
+	GasPrice       uint64 // maximum acceptable gas price of the miners
 	AdvancePayment uint64
 	TokenType      TokenType
 	Nonce          pi.AccountNonce
@@ -34,4 +34,14 @@ var (
 	ErrHashVerification = errors.New("hash verification failed")
 	// ErrInvalidGenesis indicates a failed genesis block verification.
 	ErrInvalidGenesis = errors.New("invalid genesis block")
+	// ErrUnknownExtension indicates an unknown sqlite extension name.
+	ErrUnknownExtension = errors.New("unknown sqlite extension")
+	// ErrInvalidPlacement indicates an invalid replica placement constraint.
+	ErrInvalidPlacement = errors.New("invalid placement constraint")
+	// ErrFieldNotSupported indicates that a field is set but not supported by the struct version,
+	// which is not covered by the hash.
+	ErrFieldNotSupported = errors.New("field not supported by struct version")
+	// ErrQueryTimeout indicates the query is interrupted or rejected as it's not finished before
+	// its deadline, it's shared by the miner and the client to match the error with errors.Cause.
+	ErrQueryTimeout = errors.New("query execution timeout")
 )
@@ -17,6 +17,10 @@
 package types
 
 import (
+	"strings"
+
+	"github.com/pkg/errors"
+
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
 	"github.com/CovenantSQL/CovenantSQL/proto"
@@ -40,6 +44,90 @@ type ResourceMeta struct {
 	UseEventualConsistency bool                   // use eventual consistency replication if enabled
 	ConsistencyLevel       float64                // customized strong consistency level
 	IsolationLevel         int                    // customized isolation level
+	Extensions             SQLiteExtension        // allowed sqlite extensions, since version 1
+	Placement              []PlacementConstraint  // replica placement constraints, since version 1
+	MaxQueryMillisecond    uint64                 // max execution time of a query, since version 1
+	Limits                 QueryLimits            // sandbox limits of each statement, since version 1
+	Version                int32                  `hsp:"v,version"`
+}
+
+// AllowedExtensions returns the sqlite extensions allowed in the database. The extensions are not
+// restricted if they are unset or not covered by the meta version, as the legacy databases do.
+func (m *ResourceMeta) AllowedExtensions() SQLiteExtension {
+	if m.Version < 1 || m.Extensions == 0 {
+		return UnrestrictedExtensions
+	}
+	return m.Extensions
+}
+
+// SQLiteExtension defines the bit set of the sqlite extensions allowed in a database.
+type SQLiteExtension uint32
+
+const (
+	// ExtensionFTS5 allows the FTS5 full-text search virtual tables and auxiliary functions.
+	ExtensionFTS5 SQLiteExtension = 1 << iota
+	// ExtensionJSON1 allows the JSON1 functions.
+	ExtensionJSON1
+	// ExtensionRTree allows the R*Tree virtual tables and functions.
+	ExtensionRTree
+
+	// AllExtensions defines all the supported sqlite extensions.
+	AllExtensions = ExtensionFTS5 | ExtensionJSON1 | ExtensionRTree
+
+	// UnrestrictedExtensions allows any virtual table module and function of the local sqlite
+	// library, which is the policy of the databases created without an extension policy.
+	UnrestrictedExtensions = ^SQLiteExtension(0)
+)
+
+var extensionNames = []struct {
+	ext  SQLiteExtension
+	name string
+}{
+	{ExtensionFTS5, "fts5"},
+	{ExtensionJSON1, "json1"},
+	{ExtensionRTree, "rtree"},
+}
+
+// Has reports whether all the extensions in x are allowed.
+func (e SQLiteExtension) Has(x SQLiteExtension) bool {
+	return e&x == x
+}
+
+// Names returns the names of the extensions.
+func (e SQLiteExtension) Names() (names []string) {
+	for _, v := range extensionNames {
+		if e.Has(v.ext) {
+			names = append(names, v.name)
+		}
+	}
+	return
+}
+
+// String implements fmt.Stringer.String.
+func (e SQLiteExtension) String() string {
+	return strings.Join(e.Names(), ",")
+}
+
+// ParseSQLiteExtensions parses the comma separated extension names, e.g., "fts5,json1".
+func ParseSQLiteExtensions(s string) (e SQLiteExtension, err error) {
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		var found bool
+		for _, v := range extensionNames {
+			if v.name == name {
+				e |= v.ext
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = errors.Wrapf(ErrUnknownExtension, "%s", name)
+			return
+		}
+	}
+	return
 }
 
 // ServiceInstance defines single instance to be initialized.
@@ -3,6 +3,8 @@ package types
 // Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
 
 import (
+	herr "errors"
+
 	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
 )
 
@@ -86,38 +88,64 @@ func (z *InitServiceResponseHeader) Msgsize() (s int) {
 	return
 }
 
+var hspVersionsResourceMeta = []string{
+	"oldver",
+	"2c9fd9",
+}
+
+// HSPCurrentVersion returns current struct version
+func (z *ResourceMeta) HSPCurrentVersion() int {
+	return int(z.Version)
+}
+
+// HSPMaxVersion returns max struct version
+func (z *ResourceMeta) HSPMaxVersion() int {
+	return 1
+}
+
+// HSPDefaultVersion returns default struct version
+func (z *ResourceMeta) HSPDefaultVersion() int {
+	return 1
+}
+
 // MarshalHash marshals for hash
 func (z *ResourceMeta) MarshalHash() (o []byte, err error) {
-	var b []byte
-	o = hsp.Require(b, z.Msgsize())
-	// map header, size 9
-	o = append(o, 0x89)
-	o = hsp.AppendFloat64(o, z.ConsistencyLevel)
-	o = hsp.AppendString(o, z.EncryptionKey)
-	o = hsp.AppendInt(o, z.IsolationLevel)
-	o = hsp.AppendFloat64(o, z.LoadAvgPerCPU)
-	o = hsp.AppendUint64(o, z.Memory)
-	o = hsp.AppendUint16(o, z.Node)
-	o = hsp.AppendUint64(o, z.Space)
-	o = hsp.AppendArrayHeader(o, uint32(len(z.TargetMiners)))
-	for za0001 := range z.TargetMiners {
-		if oTemp, err := z.TargetMiners[za0001].MarshalHash(); err != nil {
-			return nil, err
-		} else {
-			o = hsp.AppendBytes(o, oTemp)
-		}
+	switch z.HSPCurrentVersion() {
+	case 0:
+		return z.MarshalHasholdver()
+	case 1:
+		return z.MarshalHash2c9fd9()
+	default:
+		err = herr.New("invalid struct version")
+		return
 	}
-	o = hsp.AppendBool(o, z.UseEventualConsistency)
 	return
 }
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *ResourceMeta) Msgsize() (s int) {
-	s = 1 + 17 + hsp.Float64Size + 14 + hsp.StringPrefixSize + len(z.EncryptionKey) + 15 + hsp.IntSize + 14 + hsp.Float64Size + 7 + hsp.Uint64Size + 5 + hsp.Uint16Size + 6 + hsp.Uint64Size + 13 + hsp.ArrayHeaderSize
-	for za0001 := range z.TargetMiners {
-		s += z.TargetMiners[za0001].Msgsize()
+	switch z.HSPCurrentVersion() {
+	case 0:
+		return z.Msgsizeoldver()
+	case 1:
+		return z.Msgsize2c9fd9()
+	default:
+		return 0
 	}
-	s += 23 + hsp.BoolSize
+	return
+}
+
+// MarshalHash marshals for hash
+func (z SQLiteExtension) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	o = hsp.AppendUint32(o, uint32(z))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z SQLiteExtension) Msgsize() (s int) {
+	s = hsp.Uint32Size
 	return
 }
 
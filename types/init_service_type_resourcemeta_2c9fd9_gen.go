@@ -0,0 +1,62 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash2c9fd9 marshals for hash
+func (z *ResourceMeta) MarshalHash2c9fd9() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize2c9fd9())
+	// map header, size 14
+	o = append(o, 0x8e)
+	o = hsp.AppendFloat64(o, z.ConsistencyLevel)
+	o = hsp.AppendString(o, z.EncryptionKey)
+	o = hsp.AppendUint32(o, uint32(z.Extensions))
+	o = hsp.AppendInt(o, z.IsolationLevel)
+	if oTemp, err := z.Limits.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendFloat64(o, z.LoadAvgPerCPU)
+	o = hsp.AppendUint64(o, z.MaxQueryMillisecond)
+	o = hsp.AppendUint64(o, z.Memory)
+	o = hsp.AppendUint16(o, z.Node)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Placement)))
+	for za0002 := range z.Placement {
+		if oTemp, err := z.Placement[za0002].MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	o = hsp.AppendUint64(o, z.Space)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.TargetMiners)))
+	for za0001 := range z.TargetMiners {
+		if oTemp, err := z.TargetMiners[za0001].MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	o = hsp.AppendBool(o, z.UseEventualConsistency)
+	o = hsp.AppendInt32(o, z.Version)
+	return
+}
+
+// Msgsize2c9fd9 returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ResourceMeta) Msgsize2c9fd9() (s int) {
+	s = 1 + 17 + hsp.Float64Size + 14 + hsp.StringPrefixSize + len(z.EncryptionKey) + 11 + hsp.Uint32Size + 15 + hsp.IntSize + 7 + z.Limits.Msgsize() + 14 + hsp.Float64Size + 20 + hsp.Uint64Size + 7 + hsp.Uint64Size + 5 + hsp.Uint16Size + 10 + hsp.ArrayHeaderSize
+	for za0002 := range z.Placement {
+		s += z.Placement[za0002].Msgsize()
+	}
+	s += 6 + hsp.Uint64Size + 13 + hsp.ArrayHeaderSize
+	for za0001 := range z.TargetMiners {
+		s += z.TargetMiners[za0001].Msgsize()
+	}
+	s += 23 + hsp.BoolSize + 2 + hsp.Int32Size
+	return
+}
@@ -0,0 +1,47 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalHash2c9fd9ResourceMeta(t *testing.T) {
+	v := ResourceMeta{}
+	binary.Read(rand.Reader, binary.BigEndian, &v)
+	bts1, err := v.MarshalHash2c9fd9()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts2, err := v.MarshalHash2c9fd9()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts1, bts2) {
+		t.Fatal("hash not stable")
+	}
+}
+
+func BenchmarkMarshalHash2c9fd9ResourceMeta(b *testing.B) {
+	v := ResourceMeta{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.MarshalHash2c9fd9()
+	}
+}
+
+func BenchmarkAppendMsg2c9fd9ResourceMeta(b *testing.B) {
+	v := ResourceMeta{}
+	bts := make([]byte, 0, v.Msgsize2c9fd9())
+	bts, _ = v.MarshalHash2c9fd9()
+	b.SetBytes(int64(len(bts)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = v.MarshalHash2c9fd9()
+	}
+}
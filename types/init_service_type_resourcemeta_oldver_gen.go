@@ -0,0 +1,42 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHasholdver marshals for hash
+func (z *ResourceMeta) MarshalHasholdver() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+
+	o = append(o, 0x89)
+	o = hsp.AppendFloat64(o, z.ConsistencyLevel)
+	o = hsp.AppendString(o, z.EncryptionKey)
+	o = hsp.AppendInt(o, z.IsolationLevel)
+	o = hsp.AppendFloat64(o, z.LoadAvgPerCPU)
+	o = hsp.AppendUint64(o, z.Memory)
+	o = hsp.AppendUint16(o, z.Node)
+	o = hsp.AppendUint64(o, z.Space)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.TargetMiners)))
+	for za0001 := range z.TargetMiners {
+		if oTemp, err := z.TargetMiners[za0001].MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	o = hsp.AppendBool(o, z.UseEventualConsistency)
+	return
+}
+
+// Msgsizeoldver returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ResourceMeta) Msgsizeoldver() (s int) {
+	s = 1 + 17 + hsp.Float64Size + 14 + hsp.StringPrefixSize + len(z.EncryptionKey) + 15 + hsp.IntSize + 14 + hsp.Float64Size + 7 + hsp.Uint64Size + 5 + hsp.Uint16Size + 6 + hsp.Uint64Size + 13 + hsp.ArrayHeaderSize
+	for za0001 := range z.TargetMiners {
+		s += z.TargetMiners[za0001].Msgsize()
+	}
+	s += 23 + hsp.BoolSize
+	return
+}
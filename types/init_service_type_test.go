@@ -0,0 +1,40 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResourceMeta_AllowedExtensions(t *testing.T) {
+	Convey("test allowed extensions of resource meta", t, func() {
+		var meta = ResourceMeta{}
+		So(meta.AllowedExtensions(), ShouldEqual, UnrestrictedExtensions)
+		So(meta.AllowedExtensions().Has(AllExtensions), ShouldBeTrue)
+
+		// extensions are ignored in the legacy version
+		meta.Extensions = ExtensionFTS5
+		So(meta.AllowedExtensions(), ShouldEqual, UnrestrictedExtensions)
+
+		meta.Version = int32(meta.HSPDefaultVersion())
+		So(meta.AllowedExtensions(), ShouldEqual, ExtensionFTS5)
+		meta.Extensions = 0
+		So(meta.AllowedExtensions(), ShouldEqual, UnrestrictedExtensions)
+	})
+}
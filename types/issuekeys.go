@@ -33,10 +33,15 @@ type MinerKey struct {
 }
 
 // IssueKeysHeader defines an encryption key header.
+//
+// Since version 1, the keys are issued with a key version, and a key version greater than the
+// current one of a miner rotates the encryption key of its database instance online.
 type IssueKeysHeader struct {
 	TargetSQLChain proto.AccountAddress
 	MinerKeys      []MinerKey
 	Nonce          interfaces.AccountNonce
+	KeyVersion     uint32
+	Version        int32 `hsp:"v,version"`
 }
 
 // GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
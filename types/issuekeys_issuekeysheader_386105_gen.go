@@ -0,0 +1,49 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash386105 marshals for hash
+func (z *IssueKeysHeader) MarshalHash386105() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize386105())
+	// map header, size 5
+	o = append(o, 0x85)
+	o = hsp.AppendUint32(o, z.KeyVersion)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.MinerKeys)))
+	for za0001 := range z.MinerKeys {
+		// map header, size 2
+		o = append(o, 0x82)
+		if oTemp, err := z.MinerKeys[za0001].Miner.MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+		o = hsp.AppendString(o, z.MinerKeys[za0001].EncryptionKey)
+	}
+	if oTemp, err := z.Nonce.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.TargetSQLChain.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendInt32(o, z.Version)
+	return
+}
+
+// Msgsize386105 returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *IssueKeysHeader) Msgsize386105() (s int) {
+	s = 1 + 11 + hsp.Uint32Size + 10 + hsp.ArrayHeaderSize
+	for za0001 := range z.MinerKeys {
+		s += 1 + 6 + z.MinerKeys[za0001].Miner.Msgsize() + 14 + hsp.StringPrefixSize + len(z.MinerKeys[za0001].EncryptionKey)
+	}
+	s += 6 + z.Nonce.Msgsize() + 15 + z.TargetSQLChain.Msgsize() + 2 + hsp.Int32Size
+	return
+}
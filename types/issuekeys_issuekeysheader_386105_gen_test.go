@@ -0,0 +1,47 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalHash386105IssueKeysHeader(t *testing.T) {
+	v := IssueKeysHeader{}
+	binary.Read(rand.Reader, binary.BigEndian, &v)
+	bts1, err := v.MarshalHash386105()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts2, err := v.MarshalHash386105()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts1, bts2) {
+		t.Fatal("hash not stable")
+	}
+}
+
+func BenchmarkMarshalHash386105IssueKeysHeader(b *testing.B) {
+	v := IssueKeysHeader{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.MarshalHash386105()
+	}
+}
+
+func BenchmarkAppendMsg386105IssueKeysHeader(b *testing.B) {
+	v := IssueKeysHeader{}
+	bts := make([]byte, 0, v.Msgsize386105())
+	bts, _ = v.MarshalHash386105()
+	b.SetBytes(int64(len(bts)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = v.MarshalHash386105()
+	}
+}
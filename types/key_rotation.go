@@ -0,0 +1,113 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// KeyRotationState enumerates the states of the encryption key rotation of a database on a miner.
+type KeyRotationState int32
+
+const (
+	// KeyRotationIdle defines that there is no ongoing key rotation.
+	KeyRotationIdle KeyRotationState = iota
+	// KeyRotationCopying defines that the database is being re-encrypted in background.
+	KeyRotationCopying
+	// KeyRotationSwitching defines that the database is switching to the re-encrypted storage.
+	KeyRotationSwitching
+	// KeyRotationFailed defines that the last key rotation is failed.
+	KeyRotationFailed
+)
+
+// String implements fmt.Stringer.String.
+func (s KeyRotationState) String() string {
+	switch s {
+	case KeyRotationIdle:
+		return "idle"
+	case KeyRotationCopying:
+		return "copying"
+	case KeyRotationSwitching:
+		return "switching"
+	case KeyRotationFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// KeyRotationStatus defines the encryption key rotation status of a database on a miner.
+type KeyRotationStatus struct {
+	KeyVersion    uint32           `json:"key_version"`    // key version of the database storage
+	TargetVersion uint32           `json:"target_version"` // key version of the ongoing rotation
+	State         KeyRotationState `json:"state"`
+	CopiedPages   uint64           `json:"copied_pages"`
+	TotalPages    uint64           `json:"total_pages"`
+	Error         string           `json:"error,omitempty"` // error of the last failed rotation
+	UpdateTime    time.Time        `json:"update_time"`
+}
+
+// KeyRotationStatusRequestHeader defines the key rotation status rpc request header.
+type KeyRotationStatusRequestHeader struct {
+	DatabaseID proto.DatabaseID
+	Timestamp  time.Time
+}
+
+// SignedKeyRotationStatusRequestHeader defines the signed key rotation status rpc request header.
+type SignedKeyRotationStatusRequestHeader struct {
+	KeyRotationStatusRequestHeader
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// Verify checks hash and signature in key rotation status request header.
+func (sh *SignedKeyRotationStatusRequestHeader) Verify() (err error) {
+	return sh.DefaultHashSignVerifierImpl.Verify(&sh.KeyRotationStatusRequestHeader)
+}
+
+// Sign the request.
+func (sh *SignedKeyRotationStatusRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return sh.DefaultHashSignVerifierImpl.Sign(&sh.KeyRotationStatusRequestHeader, signer)
+}
+
+// KeyRotationStatusRequest defines the key rotation status rpc request entity.
+type KeyRotationStatusRequest struct {
+	proto.Envelope
+	Header SignedKeyRotationStatusRequestHeader
+}
+
+// Verify checks hash and signature in request header.
+func (r *KeyRotationStatusRequest) Verify() error {
+	return r.Header.Verify()
+}
+
+// Sign the request.
+func (r *KeyRotationStatusRequest) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return r.Header.Sign(signer)
+}
+
+// KeyRotationStatusResponse defines the key rotation status rpc response entity.
+type KeyRotationStatusResponse struct {
+	proto.Envelope
+	NodeID proto.NodeID
+	Status KeyRotationStatus
+}
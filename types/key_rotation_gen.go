@@ -0,0 +1,156 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash marshals for hash
+func (z KeyRotationState) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	o = hsp.AppendInt32(o, int32(z))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z KeyRotationState) Msgsize() (s int) {
+	s = hsp.Int32Size
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *KeyRotationStatus) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 7
+	o = append(o, 0x87)
+	o = hsp.AppendUint64(o, z.CopiedPages)
+	o = hsp.AppendString(o, z.Error)
+	o = hsp.AppendUint32(o, z.KeyVersion)
+	o = hsp.AppendInt32(o, int32(z.State))
+	o = hsp.AppendUint32(o, z.TargetVersion)
+	o = hsp.AppendUint64(o, z.TotalPages)
+	o = hsp.AppendTime(o, z.UpdateTime)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *KeyRotationStatus) Msgsize() (s int) {
+	s = 1 + 12 + hsp.Uint64Size + 6 + hsp.StringPrefixSize + len(z.Error) + 11 + hsp.Uint32Size + 6 + hsp.Int32Size + 14 + hsp.Uint32Size + 11 + hsp.Uint64Size + 11 + hsp.TimeSize
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *KeyRotationStatusRequest) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	// map header, size 2
+	// map header, size 2
+	o = append(o, 0x82, 0x82)
+	if oTemp, err := z.Header.KeyRotationStatusRequestHeader.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendTime(o, z.Header.KeyRotationStatusRequestHeader.Timestamp)
+	if oTemp, err := z.Header.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *KeyRotationStatusRequest) Msgsize() (s int) {
+	s = 1 + 9 + z.Envelope.Msgsize() + 7 + 1 + 31 + 1 + 11 + z.Header.KeyRotationStatusRequestHeader.DatabaseID.Msgsize() + 10 + hsp.TimeSize + 28 + z.Header.DefaultHashSignVerifierImpl.Msgsize()
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *KeyRotationStatusRequestHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendTime(o, z.Timestamp)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *KeyRotationStatusRequestHeader) Msgsize() (s int) {
+	s = 1 + 11 + z.DatabaseID.Msgsize() + 10 + hsp.TimeSize
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *KeyRotationStatusResponse) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 3
+	o = append(o, 0x83)
+	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Status.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *KeyRotationStatusResponse) Msgsize() (s int) {
+	s = 1 + 9 + z.Envelope.Msgsize() + 7 + z.NodeID.Msgsize() + 7 + z.Status.Msgsize()
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *SignedKeyRotationStatusRequestHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.KeyRotationStatusRequestHeader.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendTime(o, z.KeyRotationStatusRequestHeader.Timestamp)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *SignedKeyRotationStatusRequestHeader) Msgsize() (s int) {
+	s = 1 + 28 + z.DefaultHashSignVerifierImpl.Msgsize() + 31 + 1 + 11 + z.KeyRotationStatusRequestHeader.DatabaseID.Msgsize() + 10 + hsp.TimeSize
+	return
+}
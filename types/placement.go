@@ -0,0 +1,142 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//go:generate hsp
+
+// PlacementConstraintType defines the type of a replica placement constraint.
+type PlacementConstraintType uint8
+
+const (
+	// PlacementDistinct places the replicas on the miners with distinct values of the label key,
+	// e.g., "distinct:zone" spreads the replicas across zones.
+	PlacementDistinct PlacementConstraintType = iota + 1
+	// PlacementRequire places the replicas on the miners with the label only, e.g., "require:ssd".
+	PlacementRequire
+	// PlacementPrefer places the replicas on the miners with the label if possible,
+	// e.g., "prefer:zone=us-east".
+	PlacementPrefer
+)
+
+var placementNames = []struct {
+	typ  PlacementConstraintType
+	name string
+}{
+	{PlacementDistinct, "distinct"},
+	{PlacementRequire, "require"},
+	{PlacementPrefer, "prefer"},
+}
+
+// String implements fmt.Stringer.String.
+func (t PlacementConstraintType) String() string {
+	for _, v := range placementNames {
+		if v.typ == t {
+			return v.name
+		}
+	}
+	return "unknown"
+}
+
+// PlacementConstraint defines a replica placement constraint on the provider labels. A label is
+// either a bare key, e.g., "ssd", or a "key=value" pair, e.g., "zone=us-east".
+type PlacementConstraint struct {
+	Type  PlacementConstraintType
+	Label string
+}
+
+// String implements fmt.Stringer.String.
+func (c PlacementConstraint) String() string {
+	return c.Type.String() + ":" + c.Label
+}
+
+// Validate checks the constraint type and label.
+func (c PlacementConstraint) Validate() error {
+	switch c.Type {
+	case PlacementDistinct:
+		if c.Label == "" || strings.Contains(c.Label, "=") {
+			return errors.Wrapf(ErrInvalidPlacement, "%s: distinct requires a label key", c)
+		}
+	case PlacementRequire, PlacementPrefer:
+		if c.Label == "" {
+			return errors.Wrapf(ErrInvalidPlacement, "%s: empty label", c)
+		}
+	default:
+		return errors.Wrapf(ErrInvalidPlacement, "unknown constraint type %d", c.Type)
+	}
+	return nil
+}
+
+// ParsePlacementConstraints parses the comma separated placement constraints,
+// e.g., "distinct:zone,require:ssd,prefer:zone=us-east".
+func ParsePlacementConstraints(s string) (cs []PlacementConstraint, err error) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		var (
+			parts = strings.SplitN(v, ":", 2)
+			c     PlacementConstraint
+		)
+		if len(parts) != 2 {
+			err = errors.Wrapf(ErrInvalidPlacement, "%s: missing constraint type", v)
+			return
+		}
+		for _, n := range placementNames {
+			if n.name == strings.ToLower(strings.TrimSpace(parts[0])) {
+				c.Type = n.typ
+				break
+			}
+		}
+		c.Label = strings.TrimSpace(parts[1])
+		if err = c.Validate(); err != nil {
+			return
+		}
+		cs = append(cs, c)
+	}
+	return
+}
+
+// LabelValue returns the value of the label key in labels, a bare key label has an empty value.
+func LabelValue(labels []string, key string) (value string, ok bool) {
+	for _, l := range labels {
+		var parts = strings.SplitN(l, "=", 2)
+		if parts[0] != key {
+			continue
+		}
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+		return value, true
+	}
+	return
+}
+
+// HasLabel reports whether labels contains label.
+func HasLabel(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
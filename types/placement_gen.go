@@ -0,0 +1,38 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash marshals for hash
+func (z PlacementConstraint) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	o = hsp.AppendString(o, z.Label)
+	o = hsp.AppendUint8(o, uint8(z.Type))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z PlacementConstraint) Msgsize() (s int) {
+	s = 1 + 6 + hsp.StringPrefixSize + len(z.Label) + 5 + hsp.Uint8Size
+	return
+}
+
+// MarshalHash marshals for hash
+func (z PlacementConstraintType) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	o = hsp.AppendUint8(o, uint8(z))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z PlacementConstraintType) Msgsize() (s int) {
+	s = hsp.Uint8Size
+	return
+}
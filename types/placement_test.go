@@ -0,0 +1,59 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPlacementConstraints(t *testing.T) {
+	Convey("Given some placement constraints", t, func() {
+		cs, err := ParsePlacementConstraints(" distinct:zone, REQUIRE:ssd,,prefer:zone=us-east ")
+		So(err, ShouldBeNil)
+		So(cs, ShouldResemble, []PlacementConstraint{
+			{Type: PlacementDistinct, Label: "zone"},
+			{Type: PlacementRequire, Label: "ssd"},
+			{Type: PlacementPrefer, Label: "zone=us-east"},
+		})
+		So(cs[2].String(), ShouldEqual, "prefer:zone=us-east")
+		for _, s := range []string{
+			"zone",
+			"distinct:zone=us-east",
+			"require:",
+			"spread:zone",
+		} {
+			_, err = ParsePlacementConstraints(s)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidPlacement)
+		}
+	})
+	Convey("Given some labels", t, func() {
+		var labels = []string{"zone=us-east", "ssd"}
+		value, ok := LabelValue(labels, "zone")
+		So(ok, ShouldBeTrue)
+		So(value, ShouldEqual, "us-east")
+		value, ok = LabelValue(labels, "ssd")
+		So(ok, ShouldBeTrue)
+		So(value, ShouldBeEmpty)
+		_, ok = LabelValue(labels, "rack")
+		So(ok, ShouldBeFalse)
+		So(HasLabel(labels, "ssd"), ShouldBeTrue)
+		So(HasLabel(labels, "zone"), ShouldBeFalse)
+	})
+}
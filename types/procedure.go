@@ -0,0 +1,26 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+// Procedure defines a named and parameterized SQL procedure registered on a database. It's
+// stored in the database and executed atomically on each call with the arguments bound to its
+// parameters.
+type Procedure struct {
+	Name       string   `json:"name"`
+	Params     []string `json:"params,omitempty"` // parameter names, referenced as :name by the statements
+	Statements []string `json:"statements"`
+}
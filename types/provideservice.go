@@ -38,6 +38,8 @@ type ProvideServiceHeader struct {
 	TokenType     TokenType
 	NodeID        proto.NodeID
 	Nonce         interfaces.AccountNonce
+	Labels        []string // free-form labels for replica placement, e.g., "zone=us-east", since version 1
+	Version       int32    `hsp:"v,version"`
 }
 
 // GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
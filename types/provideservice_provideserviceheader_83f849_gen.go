@@ -0,0 +1,62 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash83f849 marshals for hash
+func (z *ProvideServiceHeader) MarshalHash83f849() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize83f849())
+	// map header, size 10
+	o = append(o, 0x8a)
+	o = hsp.AppendUint64(o, z.GasPrice)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Labels)))
+	for za0002 := range z.Labels {
+		o = hsp.AppendString(o, z.Labels[za0002])
+	}
+	o = hsp.AppendFloat64(o, z.LoadAvgPerCPU)
+	o = hsp.AppendUint64(o, z.Memory)
+	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Nonce.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendUint64(o, z.Space)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.TargetUser)))
+	for za0001 := range z.TargetUser {
+		if oTemp, err := z.TargetUser[za0001].MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	if oTemp, err := z.TokenType.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendInt32(o, z.Version)
+	return
+}
+
+// Msgsize83f849 returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ProvideServiceHeader) Msgsize83f849() (s int) {
+	s = 1 + 9 + hsp.Uint64Size + 7 + hsp.ArrayHeaderSize
+	for za0002 := range z.Labels {
+		s += hsp.StringPrefixSize + len(z.Labels[za0002])
+	}
+	s += 14 + hsp.Float64Size + 7 + hsp.Uint64Size + 7 + z.NodeID.Msgsize() + 6 + z.Nonce.Msgsize() + 6 + hsp.Uint64Size + 11 + hsp.ArrayHeaderSize
+	for za0001 := range z.TargetUser {
+		s += z.TargetUser[za0001].Msgsize()
+	}
+	s += 10 + z.TokenType.Msgsize() + 2 + hsp.Int32Size
+	return
+}
@@ -0,0 +1,47 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalHash83f849ProvideServiceHeader(t *testing.T) {
+	v := ProvideServiceHeader{}
+	binary.Read(rand.Reader, binary.BigEndian, &v)
+	bts1, err := v.MarshalHash83f849()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts2, err := v.MarshalHash83f849()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts1, bts2) {
+		t.Fatal("hash not stable")
+	}
+}
+
+func BenchmarkMarshalHash83f849ProvideServiceHeader(b *testing.B) {
+	v := ProvideServiceHeader{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.MarshalHash83f849()
+	}
+}
+
+func BenchmarkAppendMsg83f849ProvideServiceHeader(b *testing.B) {
+	v := ProvideServiceHeader{}
+	bts := make([]byte, 0, v.Msgsize83f849())
+	bts, _ = v.MarshalHash83f849()
+	b.SetBytes(int64(len(bts)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = v.MarshalHash83f849()
+	}
+}
@@ -0,0 +1,53 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHasholdver marshals for hash
+func (z *ProvideServiceHeader) MarshalHasholdver() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+
+	o = append(o, 0x88)
+	o = hsp.AppendUint64(o, z.GasPrice)
+	o = hsp.AppendFloat64(o, z.LoadAvgPerCPU)
+	o = hsp.AppendUint64(o, z.Memory)
+	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Nonce.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendUint64(o, z.Space)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.TargetUser)))
+	for za0001 := range z.TargetUser {
+		if oTemp, err := z.TargetUser[za0001].MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	if oTemp, err := z.TokenType.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsizeoldver returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ProvideServiceHeader) Msgsizeoldver() (s int) {
+	s = 1 + 9 + hsp.Uint64Size + 14 + hsp.Float64Size + 7 + hsp.Uint64Size + 7 + z.NodeID.Msgsize() + 6 + z.Nonce.Msgsize() + 6 + hsp.Uint64Size + 11 + hsp.ArrayHeaderSize
+	for za0001 := range z.TargetUser {
+		s += z.TargetUser[za0001].Msgsize()
+	}
+	s += 10 + z.TokenType.Msgsize()
+	return
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+//go:generate hsp
+
+// QueryLimits defines the sandbox limits of each statement executed on a database, 0 means
+// unlimited.
+type QueryLimits struct {
+	MaxRows   uint64 `json:"max-rows,omitempty"`   // max rows returned by a read statement
+	MaxSteps  uint64 `json:"max-steps,omitempty"`  // max sqlite VM instructions executed by a statement
+	MaxMemory uint64 `json:"max-memory,omitempty"` // max bytes of page cache, values and rows of a read statement
+}
+
+// IsZero reports whether all the limits are unlimited.
+func (l QueryLimits) IsZero() bool {
+	return l == QueryLimits{}
+}
+
+// Override returns the limits with the ones set in o replacing the ones of l.
+func (l QueryLimits) Override(o QueryLimits) QueryLimits {
+	if o.MaxRows > 0 {
+		l.MaxRows = o.MaxRows
+	}
+	if o.MaxSteps > 0 {
+		l.MaxSteps = o.MaxSteps
+	}
+	if o.MaxMemory > 0 {
+		l.MaxMemory = o.MaxMemory
+	}
+	return l
+}
@@ -0,0 +1,25 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash marshals for hash
+func (z QueryLimits) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 3
+	o = append(o, 0x83)
+	o = hsp.AppendUint64(o, z.MaxMemory)
+	o = hsp.AppendUint64(o, z.MaxRows)
+	o = hsp.AppendUint64(o, z.MaxSteps)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z QueryLimits) Msgsize() (s int) {
+	s = 1 + 10 + hsp.Uint64Size + 8 + hsp.Uint64Size + 9 + hsp.Uint64Size
+	return
+}
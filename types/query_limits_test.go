@@ -0,0 +1,36 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQueryLimits(t *testing.T) {
+	Convey("Given the query limits of a database", t, func() {
+		var limits = QueryLimits{MaxRows: 100, MaxSteps: 10000}
+		So(limits.IsZero(), ShouldBeFalse)
+		So(QueryLimits{}.IsZero(), ShouldBeTrue)
+		Convey("The limits set by the user should override the ones of the database", func() {
+			So(limits.Override(QueryLimits{}), ShouldResemble, limits)
+			So(limits.Override(QueryLimits{MaxRows: 1000, MaxMemory: 1 << 20}), ShouldResemble,
+				QueryLimits{MaxRows: 1000, MaxSteps: 10000, MaxMemory: 1 << 20})
+		})
+	})
+}
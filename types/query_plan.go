@@ -0,0 +1,151 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// QueryPlanNode defines a step of the query plan reported by EXPLAIN QUERY PLAN.
+type QueryPlanNode struct {
+	ID       int64            `json:"id"`
+	Parent   int64            `json:"parent"`
+	Detail   string           `json:"detail"`
+	Children []*QueryPlanNode `json:"children,omitempty"`
+}
+
+// QueryPlan defines the query plan tree of a statement.
+type QueryPlan struct {
+	Roots []*QueryPlanNode `json:"roots"`
+	index map[int64]*QueryPlanNode
+}
+
+// NewQueryPlan returns a new empty query plan.
+func NewQueryPlan() *QueryPlan {
+	return &QueryPlan{
+		Roots: make([]*QueryPlanNode, 0),
+		index: make(map[int64]*QueryPlanNode),
+	}
+}
+
+// NewQueryPlanFromRows builds the query plan from the result of an EXPLAIN QUERY PLAN query.
+// The rows are located by the "id", "parent" and "detail" columns.
+func NewQueryPlanFromRows(columns []string, rows [][]interface{}) (p *QueryPlan, err error) {
+	var id, parent, detail = -1, -1, -1
+	for i, c := range columns {
+		switch strings.ToLower(c) {
+		case "id":
+			id = i
+		case "parent":
+			parent = i
+		case "detail":
+			detail = i
+		}
+	}
+	if id < 0 || parent < 0 || detail < 0 {
+		err = errors.Errorf("unexpected query plan columns: %v", columns)
+		return
+	}
+	p = NewQueryPlan()
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			err = errors.Errorf("unexpected query plan row length: %d", len(row))
+			return
+		}
+		var (
+			nodeID, parentID int64
+			text             string
+		)
+		if nodeID, err = planValueToInt64(row[id]); err != nil {
+			return
+		}
+		if parentID, err = planValueToInt64(row[parent]); err != nil {
+			return
+		}
+		switch v := row[detail].(type) {
+		case string:
+			text = v
+		case []byte:
+			text = string(v)
+		}
+		p.Add(nodeID, parentID, text)
+	}
+	return
+}
+
+// Add appends a query plan step to the tree. Steps are reported by sqlite in order, so the parent
+// of a step should have been added before it, otherwise the step is added as a root.
+func (p *QueryPlan) Add(id, parent int64, detail string) {
+	var node = &QueryPlanNode{ID: id, Parent: parent, Detail: detail}
+	if pn, ok := p.index[parent]; ok && parent != id {
+		pn.Children = append(pn.Children, node)
+	} else {
+		p.Roots = append(p.Roots, node)
+	}
+	p.index[id] = node
+}
+
+// Lines renders the query plan tree as text lines in the form of the sqlite3 shell.
+func (p *QueryPlan) Lines() (lines []string) {
+	lines = make([]string, 0, len(p.index))
+	var render func(nodes []*QueryPlanNode, prefix string)
+	render = func(nodes []*QueryPlanNode, prefix string) {
+		for i, n := range nodes {
+			if i == len(nodes)-1 {
+				lines = append(lines, prefix+"`--"+n.Detail)
+				render(n.Children, prefix+"   ")
+			} else {
+				lines = append(lines, prefix+"|--"+n.Detail)
+				render(n.Children, prefix+"|  ")
+			}
+		}
+	}
+	render(p.Roots, "")
+	return
+}
+
+// String implements fmt.Stringer.String.
+func (p *QueryPlan) String() string {
+	return strings.Join(append([]string{"QUERY PLAN"}, p.Lines()...), "\n")
+}
+
+func planValueToInt64(v interface{}) (i int64, err error) {
+	switch v := v.(type) {
+	case int64:
+		i = v
+	case int:
+		i = int64(v)
+	case int32:
+		i = int64(v)
+	case uint64:
+		i = int64(v)
+	case uint32:
+		i = int64(v)
+	case float64:
+		i = int64(v)
+	case []byte:
+		i, err = strconv.ParseInt(string(v), 10, 64)
+	case string:
+		i, err = strconv.ParseInt(v, 10, 64)
+	default:
+		err = errors.Errorf("unexpected query plan value: %v", v)
+	}
+	return
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQueryPlan(t *testing.T) {
+	Convey("Query plan should be built as a tree", t, func() {
+		var columns = []string{"id", "parent", "notused", "detail"}
+		plan, err := NewQueryPlanFromRows(columns, [][]interface{}{
+			{int64(2), int64(0), int64(0), "COMPOUND QUERY"},
+			{int64(3), int64(2), int64(0), "LEFT-MOST SUBQUERY"},
+			{int64(6), int64(3), int64(0), []byte("SCAN TABLE t1")},
+			{uint64(19), uint64(2), uint64(0), "UNION ALL"},
+			{"22", "19", "0", "SCAN TABLE t2"},
+			{int64(40), int64(0), int64(0), "USE TEMP B-TREE FOR ORDER BY"},
+		})
+		So(err, ShouldBeNil)
+		So(plan.Roots, ShouldHaveLength, 2)
+		So(plan.Roots[0].Children, ShouldHaveLength, 2)
+		So(plan.String(), ShouldEqual, `QUERY PLAN
+|--COMPOUND QUERY
+|  |--LEFT-MOST SUBQUERY
+|  |  `+"`"+`--SCAN TABLE t1
+|  `+"`"+`--UNION ALL
+|     `+"`"+`--SCAN TABLE t2
+`+"`"+`--USE TEMP B-TREE FOR ORDER BY`)
+
+		_, err = NewQueryPlanFromRows([]string{"addr", "opcode"}, nil)
+		So(err, ShouldNotBeNil)
+		_, err = NewQueryPlanFromRows(columns, [][]interface{}{{int64(1)}})
+		So(err, ShouldNotBeNil)
+		_, err = NewQueryPlanFromRows(columns, [][]interface{}{{nil, int64(0), int64(0), "SCAN"}})
+		So(err, ShouldNotBeNil)
+	})
+}
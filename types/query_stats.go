@@ -0,0 +1,87 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// QueryStat defines the aggregated statistics of a normalized query pattern on a miner.
+type QueryStat struct {
+	Pattern   string    `json:"pattern"`
+	QueryType QueryType `json:"type"`
+	Calls     uint64    `json:"calls"`
+	Errors    uint64    `json:"errors"`
+	Rows      uint64    `json:"rows"`     // rows returned or affected
+	MeanTime  uint64    `json:"mean_us"`  // mean latency in microseconds
+	P99Time   uint64    `json:"p99_us"`   // 99th percentile latency in microseconds
+	MaxTime   uint64    `json:"max_us"`   // max latency in microseconds
+	TotalTime uint64    `json:"total_us"` // total latency in microseconds
+}
+
+// QueryStatsRequestHeader defines the query statistics rpc request header.
+type QueryStatsRequestHeader struct {
+	DatabaseID proto.DatabaseID
+	Reset      bool // reset the statistics after they are collected
+	Timestamp  time.Time
+}
+
+// SignedQueryStatsRequestHeader defines the signed query statistics rpc request header.
+type SignedQueryStatsRequestHeader struct {
+	QueryStatsRequestHeader
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// Verify checks hash and signature in query statistics request header.
+func (sh *SignedQueryStatsRequestHeader) Verify() (err error) {
+	return sh.DefaultHashSignVerifierImpl.Verify(&sh.QueryStatsRequestHeader)
+}
+
+// Sign the request.
+func (sh *SignedQueryStatsRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return sh.DefaultHashSignVerifierImpl.Sign(&sh.QueryStatsRequestHeader, signer)
+}
+
+// QueryStatsRequest defines the query statistics rpc request entity.
+type QueryStatsRequest struct {
+	proto.Envelope
+	Header SignedQueryStatsRequestHeader
+}
+
+// Verify checks hash and signature in request header.
+func (r *QueryStatsRequest) Verify() error {
+	return r.Header.Verify()
+}
+
+// Sign the request.
+func (r *QueryStatsRequest) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return r.Header.Sign(signer)
+}
+
+// QueryStatsResponse defines the query statistics rpc response entity.
+type QueryStatsResponse struct {
+	proto.Envelope
+	NodeID proto.NodeID
+	Since  time.Time // time of the last statistics reset
+	Stats  []*QueryStat
+}
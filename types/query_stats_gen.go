@@ -0,0 +1,166 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash marshals for hash
+func (z *QueryStat) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 9
+	o = append(o, 0x89)
+	o = hsp.AppendUint64(o, z.Calls)
+	o = hsp.AppendUint64(o, z.Errors)
+	o = hsp.AppendUint64(o, z.MaxTime)
+	o = hsp.AppendUint64(o, z.MeanTime)
+	o = hsp.AppendUint64(o, z.P99Time)
+	o = hsp.AppendString(o, z.Pattern)
+	if oTemp, err := z.QueryType.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendUint64(o, z.Rows)
+	o = hsp.AppendUint64(o, z.TotalTime)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *QueryStat) Msgsize() (s int) {
+	s = 1 + 6 + hsp.Uint64Size + 7 + hsp.Uint64Size + 8 + hsp.Uint64Size + 9 + hsp.Uint64Size + 8 + hsp.Uint64Size + 8 + hsp.StringPrefixSize + len(z.Pattern) + 10 + z.QueryType.Msgsize() + 5 + hsp.Uint64Size + 10 + hsp.Uint64Size
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *QueryStatsRequest) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	// map header, size 2
+	// map header, size 3
+	o = append(o, 0x82, 0x83)
+	if oTemp, err := z.Header.QueryStatsRequestHeader.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendBool(o, z.Header.QueryStatsRequestHeader.Reset)
+	o = hsp.AppendTime(o, z.Header.QueryStatsRequestHeader.Timestamp)
+	if oTemp, err := z.Header.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *QueryStatsRequest) Msgsize() (s int) {
+	s = 1 + 9 + z.Envelope.Msgsize() + 7 + 1 + 24 + 1 + 11 + z.Header.QueryStatsRequestHeader.DatabaseID.Msgsize() + 6 + hsp.BoolSize + 10 + hsp.TimeSize + 28 + z.Header.DefaultHashSignVerifierImpl.Msgsize()
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *QueryStatsRequestHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 3
+	o = append(o, 0x83)
+	if oTemp, err := z.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendBool(o, z.Reset)
+	o = hsp.AppendTime(o, z.Timestamp)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *QueryStatsRequestHeader) Msgsize() (s int) {
+	s = 1 + 11 + z.DatabaseID.Msgsize() + 6 + hsp.BoolSize + 10 + hsp.TimeSize
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *QueryStatsResponse) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 4
+	o = append(o, 0x84)
+	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendTime(o, z.Since)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Stats)))
+	for za0001 := range z.Stats {
+		if z.Stats[za0001] == nil {
+			o = hsp.AppendNil(o)
+		} else {
+			if oTemp, err := z.Stats[za0001].MarshalHash(); err != nil {
+				return nil, err
+			} else {
+				o = hsp.AppendBytes(o, oTemp)
+			}
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *QueryStatsResponse) Msgsize() (s int) {
+	s = 1 + 9 + z.Envelope.Msgsize() + 7 + z.NodeID.Msgsize() + 6 + hsp.TimeSize + 6 + hsp.ArrayHeaderSize
+	for za0001 := range z.Stats {
+		if z.Stats[za0001] == nil {
+			s += hsp.NilSize
+		} else {
+			s += z.Stats[za0001].Msgsize()
+		}
+	}
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *SignedQueryStatsRequestHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	// map header, size 3
+	o = append(o, 0x83)
+	if oTemp, err := z.QueryStatsRequestHeader.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendBool(o, z.QueryStatsRequestHeader.Reset)
+	o = hsp.AppendTime(o, z.QueryStatsRequestHeader.Timestamp)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *SignedQueryStatsRequestHeader) Msgsize() (s int) {
+	s = 1 + 28 + z.DefaultHashSignVerifierImpl.Msgsize() + 24 + 1 + 11 + z.QueryStatsRequestHeader.DatabaseID.Msgsize() + 6 + hsp.BoolSize + 10 + hsp.TimeSize
+	return
+}
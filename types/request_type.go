@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
 	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
@@ -58,15 +60,33 @@ type RequestPayload struct {
 }
 
 // RequestHeader defines a query request header.
+//
+// Since version 1, the as-of height of the read queries and the deadline of the queries are
+// hashed.
 type RequestHeader struct {
 	QueryType    QueryType        `json:"qt"`
 	NodeID       proto.NodeID     `json:"id"`   // request node id
 	DatabaseID   proto.DatabaseID `json:"dbid"` // request database id
 	ConnectionID uint64           `json:"cid"`
 	SeqNo        uint64           `json:"seq"`
-	Timestamp    time.Time        `json:"t"`  // time in UTC zone
-	BatchCount   uint64           `json:"bc"` // query count in this request
-	QueriesHash  hash.Hash        `json:"qh"` // hash of query payload
+	Timestamp    time.Time        `json:"t"`    // time in UTC zone
+	BatchCount   uint64           `json:"bc"`   // query count in this request
+	QueriesHash  hash.Hash        `json:"qh"`   // hash of query payload
+	AsOfHeight   int32            `json:"asof"` // read as of the block height, 0 for the current state
+	Deadline     time.Time        `json:"dl"`   // deadline to execute the queries, zero for none
+	Version      int32            `json:"v" hsp:"v,version"`
+}
+
+// verifyVersion checks that the fields not supported by the header version are unset, which are
+// not covered by the header signature otherwise.
+func (h *RequestHeader) verifyVersion() (err error) {
+	if h.Version < 1 && h.AsOfHeight != 0 {
+		return errors.Wrap(ErrFieldNotSupported, "as-of height")
+	}
+	if h.Version < 1 && !h.Deadline.IsZero() {
+		return errors.Wrap(ErrFieldNotSupported, "deadline")
+	}
+	return
 }
 
 // GetQueryKey returns a unique query key of this request.
@@ -118,11 +138,15 @@ func (t QueryType) String() string {
 
 // Verify checks hash and signature in request header.
 func (sh *SignedRequestHeader) Verify() (err error) {
+	if err = sh.verifyVersion(); err != nil {
+		return
+	}
 	return sh.DefaultHashSignVerifierImpl.Verify(&sh.RequestHeader)
 }
 
-// Sign the request.
+// Sign the request in the default version.
 func (sh *SignedRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+	sh.Version = int32(sh.HSPDefaultVersion())
 	return sh.DefaultHashSignVerifierImpl.Sign(&sh.RequestHeader, signer)
 }
 
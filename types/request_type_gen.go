@@ -3,6 +3,8 @@ package types
 // Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
 
 import (
+	herr "errors"
+
 	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
 )
 
@@ -137,38 +139,50 @@ func (z *Request) Msgsize() (s int) {
 	return
 }
 
+var hspVersionsRequestHeader = []string{
+	"oldver",
+	"0252b8",
+}
+
+// HSPCurrentVersion returns current struct version
+func (z *RequestHeader) HSPCurrentVersion() int {
+	return int(z.Version)
+}
+
+// HSPMaxVersion returns max struct version
+func (z *RequestHeader) HSPMaxVersion() int {
+	return 1
+}
+
+// HSPDefaultVersion returns default struct version
+func (z *RequestHeader) HSPDefaultVersion() int {
+	return 1
+}
+
 // MarshalHash marshals for hash
 func (z *RequestHeader) MarshalHash() (o []byte, err error) {
-	var b []byte
-	o = hsp.Require(b, z.Msgsize())
-	// map header, size 8
-	o = append(o, 0x88)
-	o = hsp.AppendUint64(o, z.BatchCount)
-	o = hsp.AppendUint64(o, z.ConnectionID)
-	if oTemp, err := z.DatabaseID.MarshalHash(); err != nil {
-		return nil, err
-	} else {
-		o = hsp.AppendBytes(o, oTemp)
-	}
-	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
-		return nil, err
-	} else {
-		o = hsp.AppendBytes(o, oTemp)
-	}
-	if oTemp, err := z.QueriesHash.MarshalHash(); err != nil {
-		return nil, err
-	} else {
-		o = hsp.AppendBytes(o, oTemp)
+	switch z.HSPCurrentVersion() {
+	case 0:
+		return z.MarshalHasholdver()
+	case 1:
+		return z.MarshalHash0252b8()
+	default:
+		err = herr.New("invalid struct version")
+		return
 	}
-	o = hsp.AppendInt32(o, int32(z.QueryType))
-	o = hsp.AppendUint64(o, z.SeqNo)
-	o = hsp.AppendTime(o, z.Timestamp)
 	return
 }
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *RequestHeader) Msgsize() (s int) {
-	s = 1 + 11 + hsp.Uint64Size + 13 + hsp.Uint64Size + 11 + z.DatabaseID.Msgsize() + 7 + z.NodeID.Msgsize() + 12 + z.QueriesHash.Msgsize() + 10 + hsp.Int32Size + 6 + hsp.Uint64Size + 10 + hsp.TimeSize
+	switch z.HSPCurrentVersion() {
+	case 0:
+		return z.Msgsizeoldver()
+	case 1:
+		return z.Msgsize0252b8()
+	default:
+		return 0
+	}
 	return
 }
 
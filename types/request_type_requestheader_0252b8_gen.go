@@ -0,0 +1,45 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash0252b8 marshals for hash
+func (z *RequestHeader) MarshalHash0252b8() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize0252b8())
+	// map header, size 11
+	o = append(o, 0x8b)
+	o = hsp.AppendInt32(o, z.AsOfHeight)
+	o = hsp.AppendUint64(o, z.BatchCount)
+	o = hsp.AppendUint64(o, z.ConnectionID)
+	if oTemp, err := z.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendTime(o, z.Deadline)
+	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.QueriesHash.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendInt32(o, int32(z.QueryType))
+	o = hsp.AppendUint64(o, z.SeqNo)
+	o = hsp.AppendTime(o, z.Timestamp)
+	o = hsp.AppendInt32(o, z.Version)
+	return
+}
+
+// Msgsize0252b8 returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *RequestHeader) Msgsize0252b8() (s int) {
+	s = 1 + 11 + hsp.Int32Size + 11 + hsp.Uint64Size + 13 + hsp.Uint64Size + 11 + z.DatabaseID.Msgsize() + 9 + hsp.TimeSize + 7 + z.NodeID.Msgsize() + 12 + z.QueriesHash.Msgsize() + 10 + hsp.Int32Size + 6 + hsp.Uint64Size + 10 + hsp.TimeSize + 2 + hsp.Int32Size
+	return
+}
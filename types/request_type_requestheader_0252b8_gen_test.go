@@ -0,0 +1,47 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalHash0252b8RequestHeader(t *testing.T) {
+	v := RequestHeader{}
+	binary.Read(rand.Reader, binary.BigEndian, &v)
+	bts1, err := v.MarshalHash0252b8()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts2, err := v.MarshalHash0252b8()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts1, bts2) {
+		t.Fatal("hash not stable")
+	}
+}
+
+func BenchmarkMarshalHash0252b8RequestHeader(b *testing.B) {
+	v := RequestHeader{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.MarshalHash0252b8()
+	}
+}
+
+func BenchmarkAppendMsg0252b8RequestHeader(b *testing.B) {
+	v := RequestHeader{}
+	bts := make([]byte, 0, v.Msgsize0252b8())
+	bts, _ = v.MarshalHash0252b8()
+	b.SetBytes(int64(len(bts)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = v.MarshalHash0252b8()
+	}
+}
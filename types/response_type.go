@@ -39,7 +39,27 @@ type ResponsePayload struct {
 	Rows      []ResponseRow `json:"r"`
 }
 
+// ResourceUsage defines the metered resource units of a query request.
+type ResourceUsage struct {
+	RowsScanned uint64 `json:"rs"` // rows stepped by the full table scans of the statements
+	VMSteps     uint64 `json:"vs"` // sqlite VM instructions executed by the statements
+	RowsWritten uint64 `json:"rw"` // rows affected by write queries
+	ResultBytes uint64 `json:"rb"` // estimated size of the result values
+	ExecTime    uint64 `json:"et"` // execution time in microseconds measured by the responding miner
+}
+
+// DatabaseHeight defines the state of a database read by a cross-database query, which is the
+// head height of the database and the log offset of the next query not read by the query.
+type DatabaseHeight struct {
+	DatabaseID proto.DatabaseID `json:"id"`
+	Height     int32            `json:"h"`
+	LogOffset  uint64           `json:"o"`
+}
+
 // ResponseHeader defines a query response header.
+//
+// Since version 1, the metered resource usage, the heights of the databases read by a
+// cross-database query and the isolation level of the read queries are hashed.
 type ResponseHeader struct {
 	Request         RequestHeader        `json:"r"`
 	RequestHash     hash.Hash            `json:"rh"`
@@ -51,6 +71,25 @@ type ResponseHeader struct {
 	AffectedRows    int64                `json:"a"`  // affected rows
 	PayloadHash     hash.Hash            `json:"dh"` // hash of query response payload
 	ResponseAccount proto.AccountAddress `json:"aa"` // response account
+	Usage           ResourceUsage        `json:"u"`  // metered resource usage
+	Heights         []DatabaseHeight     `json:"hs"` // heights of the databases read by a cross-database query
+	IsolationLevel  int                  `json:"il"` // isolation level the read queries are served at
+	Version         int32                `json:"v" hsp:"v,version"`
+}
+
+// verifyVersion checks that the fields not supported by the header version are unset, which are
+// not covered by the header hash otherwise.
+func (h *ResponseHeader) verifyVersion() (err error) {
+	if h.Version < 1 && h.Usage != (ResourceUsage{}) {
+		return errors.Wrap(ErrFieldNotSupported, "usage")
+	}
+	if h.Version < 1 && len(h.Heights) > 0 {
+		return errors.Wrap(ErrFieldNotSupported, "heights")
+	}
+	if h.Version < 1 && h.IsolationLevel != 0 {
+		return errors.Wrap(ErrFieldNotSupported, "isolation level")
+	}
+	return
 }
 
 // GetRequestHash returns the request hash.
@@ -76,12 +115,16 @@ func (sh *SignedResponseHeader) Hash() hash.Hash {
 
 // VerifyHash verify the hash of the response.
 func (sh *SignedResponseHeader) VerifyHash() (err error) {
+	if err = sh.verifyVersion(); err != nil {
+		return errors.Wrap(err, "verify response header version failed")
+	}
 	return errors.Wrap(verifyHash(&sh.ResponseHeader, &sh.ResponseHash),
 		"verify response header hash failed")
 }
 
-// BuildHash computes the hash of the response header.
+// BuildHash computes the hash of the response header in the default version.
 func (sh *SignedResponseHeader) BuildHash() (err error) {
+	sh.Version = int32(sh.HSPDefaultVersion())
 	return errors.Wrap(buildHash(&sh.ResponseHeader, &sh.ResponseHash),
 		"compute response header hash failed")
 }
@@ -3,9 +3,53 @@ package types
 // Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
 
 import (
+	herr "errors"
+
 	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
 )
 
+// MarshalHash marshals for hash
+func (z *DatabaseHeight) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 3
+	o = append(o, 0x83)
+	if oTemp, err := z.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendInt32(o, z.Height)
+	o = hsp.AppendUint64(o, z.LogOffset)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *DatabaseHeight) Msgsize() (s int) {
+	s = 1 + 11 + z.DatabaseID.Msgsize() + 7 + hsp.Int32Size + 10 + hsp.Uint64Size
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *ResourceUsage) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 5
+	o = append(o, 0x85)
+	o = hsp.AppendUint64(o, z.ExecTime)
+	o = hsp.AppendUint64(o, z.ResultBytes)
+	o = hsp.AppendUint64(o, z.RowsScanned)
+	o = hsp.AppendUint64(o, z.RowsWritten)
+	o = hsp.AppendUint64(o, z.VMSteps)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ResourceUsage) Msgsize() (s int) {
+	s = 1 + 9 + hsp.Uint64Size + 12 + hsp.Uint64Size + 12 + hsp.Uint64Size + 12 + hsp.Uint64Size + 8 + hsp.Uint64Size
+	return
+}
+
 // MarshalHash marshals for hash
 func (z *Response) MarshalHash() (o []byte, err error) {
 	var b []byte
@@ -37,48 +81,50 @@ func (z *Response) Msgsize() (s int) {
 	return
 }
 
+var hspVersionsResponseHeader = []string{
+	"oldver",
+	"ea8983",
+}
+
+// HSPCurrentVersion returns current struct version
+func (z *ResponseHeader) HSPCurrentVersion() int {
+	return int(z.Version)
+}
+
+// HSPMaxVersion returns max struct version
+func (z *ResponseHeader) HSPMaxVersion() int {
+	return 1
+}
+
+// HSPDefaultVersion returns default struct version
+func (z *ResponseHeader) HSPDefaultVersion() int {
+	return 1
+}
+
 // MarshalHash marshals for hash
 func (z *ResponseHeader) MarshalHash() (o []byte, err error) {
-	var b []byte
-	o = hsp.Require(b, z.Msgsize())
-	// map header, size 10
-	o = append(o, 0x8a)
-	o = hsp.AppendInt64(o, z.AffectedRows)
-	o = hsp.AppendInt64(o, z.LastInsertID)
-	o = hsp.AppendUint64(o, z.LogOffset)
-	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
-		return nil, err
-	} else {
-		o = hsp.AppendBytes(o, oTemp)
+	switch z.HSPCurrentVersion() {
+	case 0:
+		return z.MarshalHasholdver()
+	case 1:
+		return z.MarshalHashea8983()
+	default:
+		err = herr.New("invalid struct version")
+		return
 	}
-	if oTemp, err := z.PayloadHash.MarshalHash(); err != nil {
-		return nil, err
-	} else {
-		o = hsp.AppendBytes(o, oTemp)
-	}
-	if oTemp, err := z.Request.MarshalHash(); err != nil {
-		return nil, err
-	} else {
-		o = hsp.AppendBytes(o, oTemp)
-	}
-	if oTemp, err := z.RequestHash.MarshalHash(); err != nil {
-		return nil, err
-	} else {
-		o = hsp.AppendBytes(o, oTemp)
-	}
-	if oTemp, err := z.ResponseAccount.MarshalHash(); err != nil {
-		return nil, err
-	} else {
-		o = hsp.AppendBytes(o, oTemp)
-	}
-	o = hsp.AppendUint64(o, z.RowCount)
-	o = hsp.AppendTime(o, z.Timestamp)
 	return
 }
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *ResponseHeader) Msgsize() (s int) {
-	s = 1 + 13 + hsp.Int64Size + 13 + hsp.Int64Size + 10 + hsp.Uint64Size + 7 + z.NodeID.Msgsize() + 12 + z.PayloadHash.Msgsize() + 8 + z.Request.Msgsize() + 12 + z.RequestHash.Msgsize() + 16 + z.ResponseAccount.Msgsize() + 9 + hsp.Uint64Size + 10 + hsp.TimeSize
+	switch z.HSPCurrentVersion() {
+	case 0:
+		return z.Msgsizeoldver()
+	case 1:
+		return z.Msgsizeea8983()
+	default:
+		return 0
+	}
 	return
 }
 
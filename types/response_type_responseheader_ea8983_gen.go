@@ -0,0 +1,75 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHashea8983 marshals for hash
+func (z *ResponseHeader) MarshalHashea8983() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsizeea8983())
+	// map header, size 14
+	o = append(o, 0x8e)
+	o = hsp.AppendInt64(o, z.AffectedRows)
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Heights)))
+	for za0001 := range z.Heights {
+		// map header, size 3
+		o = append(o, 0x83)
+		if oTemp, err := z.Heights[za0001].DatabaseID.MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+		o = hsp.AppendInt32(o, z.Heights[za0001].Height)
+		o = hsp.AppendUint64(o, z.Heights[za0001].LogOffset)
+	}
+	o = hsp.AppendInt(o, z.IsolationLevel)
+	o = hsp.AppendInt64(o, z.LastInsertID)
+	o = hsp.AppendUint64(o, z.LogOffset)
+	if oTemp, err := z.NodeID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.PayloadHash.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Request.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.RequestHash.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.ResponseAccount.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendUint64(o, z.RowCount)
+	o = hsp.AppendTime(o, z.Timestamp)
+	if oTemp, err := z.Usage.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendInt32(o, z.Version)
+	return
+}
+
+// Msgsizeea8983 returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *ResponseHeader) Msgsizeea8983() (s int) {
+	s = 1 + 13 + hsp.Int64Size + 8 + hsp.ArrayHeaderSize
+	for za0001 := range z.Heights {
+		s += 1 + 11 + z.Heights[za0001].DatabaseID.Msgsize() + 7 + hsp.Int32Size + 10 + hsp.Uint64Size
+	}
+	s += 15 + hsp.IntSize + 13 + hsp.Int64Size + 10 + hsp.Uint64Size + 7 + z.NodeID.Msgsize() + 12 + z.PayloadHash.Msgsize() + 8 + z.Request.Msgsize() + 12 + z.RequestHash.Msgsize() + 16 + z.ResponseAccount.Msgsize() + 9 + hsp.Uint64Size + 10 + hsp.TimeSize + 6 + z.Usage.Msgsize() + 2 + hsp.Int32Size
+	return
+}
@@ -0,0 +1,47 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalHashea8983ResponseHeader(t *testing.T) {
+	v := ResponseHeader{}
+	binary.Read(rand.Reader, binary.BigEndian, &v)
+	bts1, err := v.MarshalHashea8983()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts2, err := v.MarshalHashea8983()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts1, bts2) {
+		t.Fatal("hash not stable")
+	}
+}
+
+func BenchmarkMarshalHashea8983ResponseHeader(b *testing.B) {
+	v := ResponseHeader{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.MarshalHashea8983()
+	}
+}
+
+func BenchmarkAppendMsgea8983ResponseHeader(b *testing.B) {
+	v := ResponseHeader{}
+	bts := make([]byte, 0, v.Msgsizeea8983())
+	bts, _ = v.MarshalHashea8983()
+	b.SetBytes(int64(len(bts)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = v.MarshalHashea8983()
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// StorageProofHeader defines the result of a storage proof challenge raised by a verifier against
+// a miner of the sql-chain.
+type StorageProofHeader struct {
+	Verifier  proto.NodeID // the node which raises the challenge
+	Miner     proto.NodeID // the challenged node
+	Height    int32        // chain height of the challenge
+	BlockHash hash.Hash    // the block which the challenge is based on
+	Record    int32        // the challenged record, which selects a row of the database
+	LogOffset uint64       // the log offset of the database state which the record is read at
+	Expected  hash.Hash    // the expected answer
+	Answer    hash.Hash    // the answer of the miner, or empty if the miner didn't answer
+	Timestamp time.Time    // time in UTC zone
+}
+
+// Failed returns whether the miner failed to prove its storage.
+func (h *StorageProofHeader) Failed() bool {
+	return !h.Answer.IsEqual(&h.Expected)
+}
+
+// SignedStorageProofHeader defines a storage proof result signed by the verifier.
+type SignedStorageProofHeader struct {
+	StorageProofHeader
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// Verify checks hash and signature in storage proof header.
+func (sh *SignedStorageProofHeader) Verify() (err error) {
+	return sh.DefaultHashSignVerifierImpl.Verify(&sh.StorageProofHeader)
+}
+
+// Sign the storage proof header.
+func (sh *SignedStorageProofHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return sh.DefaultHashSignVerifierImpl.Sign(&sh.StorageProofHeader, signer)
+}
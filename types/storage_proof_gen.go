@@ -0,0 +1,76 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash marshals for hash
+func (z *SignedStorageProofHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.StorageProofHeader.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *SignedStorageProofHeader) Msgsize() (s int) {
+	s = 1 + 28 + z.DefaultHashSignVerifierImpl.Msgsize() + 19 + z.StorageProofHeader.Msgsize()
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *StorageProofHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 9
+	o = append(o, 0x89)
+	if oTemp, err := z.Answer.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.BlockHash.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Expected.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendInt32(o, z.Height)
+	o = hsp.AppendUint64(o, z.LogOffset)
+	if oTemp, err := z.Miner.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendInt32(o, z.Record)
+	o = hsp.AppendTime(o, z.Timestamp)
+	if oTemp, err := z.Verifier.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *StorageProofHeader) Msgsize() (s int) {
+	s = 1 + 7 + z.Answer.Msgsize() + 10 + z.BlockHash.Msgsize() + 9 + z.Expected.Msgsize() + 7 + hsp.Int32Size + 10 + hsp.Uint64Size + 6 + z.Miner.Msgsize() + 7 + hsp.Int32Size + 10 + hsp.TimeSize + 9 + z.Verifier.Msgsize()
+	return
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// TxOp defines the operation of a multi-database transaction request.
+type TxOp int32
+
+const (
+	// TxPrepare prepares the transaction branch of a database.
+	TxPrepare TxOp = iota
+	// TxCommit commits the prepared transaction branch of a database.
+	TxCommit
+	// TxRollback rolls back the transaction branch of a database.
+	TxRollback
+	// TxStatus queries the state of the transaction branch of a database.
+	TxStatus
+)
+
+// String implements fmt.Stringer.String.
+func (o TxOp) String() string {
+	switch o {
+	case TxPrepare:
+		return "prepare"
+	case TxCommit:
+		return "commit"
+	case TxRollback:
+		return "rollback"
+	case TxStatus:
+		return "status"
+	default:
+		return "unknown"
+	}
+}
+
+// TxState defines the state of a multi-database transaction branch on a database.
+type TxState int32
+
+const (
+	// TxUnknown indicates that the transaction branch is never seen by the database.
+	TxUnknown TxState = iota
+	// TxPrepared indicates that the transaction branch is prepared and waits for the decision.
+	TxPrepared
+	// TxCommitted indicates that the transaction branch is committed.
+	TxCommitted
+	// TxRolledBack indicates that the transaction branch is rolled back.
+	TxRolledBack
+)
+
+// String implements fmt.Stringer.String.
+func (s TxState) String() string {
+	switch s {
+	case TxUnknown:
+		return "unknown"
+	case TxPrepared:
+		return "prepared"
+	case TxCommitted:
+		return "committed"
+	case TxRolledBack:
+		return "rolled back"
+	default:
+		return "invalid"
+	}
+}
+
+// TxRequestHeader defines the multi-database transaction rpc request header.
+type TxRequestHeader struct {
+	TxID         hash.Hash
+	Op           TxOp
+	DatabaseID   proto.DatabaseID
+	Participants []proto.DatabaseID // all the databases written by the transaction
+	Deadline     time.Time          // the prepared branch waits for the decision until deadline
+	Timestamp    time.Time
+}
+
+// SignedTxRequestHeader defines the signed multi-database transaction rpc request header.
+type SignedTxRequestHeader struct {
+	TxRequestHeader
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// Verify checks hash and signature in multi-database transaction request header.
+func (sh *SignedTxRequestHeader) Verify() (err error) {
+	return sh.DefaultHashSignVerifierImpl.Verify(&sh.TxRequestHeader)
+}
+
+// Sign the request.
+func (sh *SignedTxRequestHeader) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return sh.DefaultHashSignVerifierImpl.Sign(&sh.TxRequestHeader, signer)
+}
+
+// TxRequest defines the multi-database transaction rpc request entity.
+type TxRequest struct {
+	proto.Envelope
+	Header  SignedTxRequestHeader
+	Request *Request // write request of the transaction branch, only set on prepare
+}
+
+// Verify checks hash and signature in request header and the write request.
+func (r *TxRequest) Verify() (err error) {
+	if err = r.Header.Verify(); err != nil {
+		return
+	}
+	if r.Request != nil {
+		err = r.Request.Verify()
+	}
+	return
+}
+
+// Sign the request.
+func (r *TxRequest) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return r.Header.Sign(signer)
+}
+
+// TxResponse defines the multi-database transaction rpc response entity.
+type TxResponse struct {
+	proto.Envelope
+	State    TxState
+	Expired  bool      // the prepared branch does not accept the commit decision any more
+	Response *Response // write response of the transaction branch, only set on commit
+}
@@ -0,0 +1,183 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	hsp "github.com/CovenantSQL/HashStablePack/marshalhash"
+)
+
+// MarshalHash marshals for hash
+func (z *SignedTxRequestHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.TxRequestHeader.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *SignedTxRequestHeader) Msgsize() (s int) {
+	s = 1 + 28 + z.DefaultHashSignVerifierImpl.Msgsize() + 16 + z.TxRequestHeader.Msgsize()
+	return
+}
+
+// MarshalHash marshals for hash
+func (z TxOp) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	o = hsp.AppendInt32(o, int32(z))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z TxOp) Msgsize() (s int) {
+	s = hsp.Int32Size
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *TxRequest) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 3
+	o = append(o, 0x83)
+	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	// map header, size 2
+	o = append(o, 0x82)
+	if oTemp, err := z.Header.TxRequestHeader.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if oTemp, err := z.Header.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	if z.Request == nil {
+		o = hsp.AppendNil(o)
+	} else {
+		if oTemp, err := z.Request.MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *TxRequest) Msgsize() (s int) {
+	s = 1 + 9 + z.Envelope.Msgsize() + 7 + 1 + 16 + z.Header.TxRequestHeader.Msgsize() + 28 + z.Header.DefaultHashSignVerifierImpl.Msgsize() + 8
+	if z.Request == nil {
+		s += hsp.NilSize
+	} else {
+		s += z.Request.Msgsize()
+	}
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *TxRequestHeader) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 6
+	o = append(o, 0x86)
+	if oTemp, err := z.DatabaseID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendTime(o, z.Deadline)
+	o = hsp.AppendInt32(o, int32(z.Op))
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Participants)))
+	for za0001 := range z.Participants {
+		if oTemp, err := z.Participants[za0001].MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	o = hsp.AppendTime(o, z.Timestamp)
+	if oTemp, err := z.TxID.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *TxRequestHeader) Msgsize() (s int) {
+	s = 1 + 11 + z.DatabaseID.Msgsize() + 9 + hsp.TimeSize + 3 + hsp.Int32Size + 13 + hsp.ArrayHeaderSize
+	for za0001 := range z.Participants {
+		s += z.Participants[za0001].Msgsize()
+	}
+	s += 10 + hsp.TimeSize + 5 + z.TxID.Msgsize()
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *TxResponse) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 4
+	o = append(o, 0x84)
+	if oTemp, err := z.Envelope.MarshalHash(); err != nil {
+		return nil, err
+	} else {
+		o = hsp.AppendBytes(o, oTemp)
+	}
+	o = hsp.AppendBool(o, z.Expired)
+	if z.Response == nil {
+		o = hsp.AppendNil(o)
+	} else {
+		if oTemp, err := z.Response.MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	o = hsp.AppendInt32(o, int32(z.State))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *TxResponse) Msgsize() (s int) {
+	s = 1 + 9 + z.Envelope.Msgsize() + 8 + hsp.BoolSize + 9
+	if z.Response == nil {
+		s += hsp.NilSize
+	} else {
+		s += z.Response.Msgsize()
+	}
+	s += 6 + hsp.Int32Size
+	return
+}
+
+// MarshalHash marshals for hash
+func (z TxState) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	o = hsp.AppendInt32(o, int32(z))
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z TxState) Msgsize() (s int) {
+	s = hsp.Int32Size
+	return
+}
@@ -24,6 +24,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/ugorji/go/codec"
 
@@ -76,6 +77,35 @@ func TestSignedRequestHeader_Sign(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(s, ShouldNotBeEmpty)
 		})
+		Convey("as-of height change", func() {
+			So(req.Version, ShouldEqual, req.HSPDefaultVersion())
+			req.AsOfHeight = 1
+
+			err = req.Verify()
+			So(err, ShouldNotBeNil)
+		})
+		Convey("legacy version", func() {
+			req.Version = 0
+			err = req.DefaultHashSignVerifierImpl.Sign(&req.RequestHeader, privKey)
+			So(err, ShouldBeNil)
+			err = req.Verify()
+			So(err, ShouldBeNil)
+
+			// fields not covered by the legacy signature should be rejected
+			req.AsOfHeight = 1
+			err = req.Verify()
+			So(errors.Cause(err), ShouldEqual, ErrFieldNotSupported)
+			req.AsOfHeight = 0
+			req.Deadline = req.Timestamp.Add(time.Second)
+			err = req.Verify()
+			So(errors.Cause(err), ShouldEqual, ErrFieldNotSupported)
+		})
+		Convey("deadline change", func() {
+			req.Deadline = req.Timestamp.Add(time.Second)
+
+			err = req.Verify()
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
 
@@ -248,6 +278,45 @@ func TestResponse_Sign(t *testing.T) {
 			Convey("header change", func() {
 				res.Header.Timestamp = res.Header.Timestamp.Add(time.Second)
 
+				err = res.VerifyHash()
+				So(err, ShouldNotBeNil)
+			})
+			Convey("usage change", func() {
+				So(res.Header.Version, ShouldEqual, res.Header.HSPDefaultVersion())
+				res.Header.Usage.RowsScanned = 100
+
+				err = res.VerifyHash()
+				So(err, ShouldNotBeNil)
+			})
+			Convey("legacy version", func() {
+				res.Header.Version = 0
+				err = buildHash(&res.Header.ResponseHeader, &res.Header.ResponseHash)
+				So(err, ShouldBeNil)
+				err = res.VerifyHash()
+				So(err, ShouldBeNil)
+
+				// fields not covered by the legacy hash should be rejected
+				res.Header.Usage.RowsScanned = 100
+				err = res.VerifyHash()
+				So(errors.Cause(err), ShouldEqual, ErrFieldNotSupported)
+				res.Header.Usage.RowsScanned = 0
+				res.Header.Heights = []DatabaseHeight{{DatabaseID: "db2", Height: 1}}
+				err = res.VerifyHash()
+				So(errors.Cause(err), ShouldEqual, ErrFieldNotSupported)
+				res.Header.Heights = nil
+				res.Header.IsolationLevel = 1
+				err = res.VerifyHash()
+				So(errors.Cause(err), ShouldEqual, ErrFieldNotSupported)
+			})
+			Convey("isolation level change", func() {
+				res.Header.IsolationLevel = 1
+
+				err = res.VerifyHash()
+				So(err, ShouldNotBeNil)
+			})
+			Convey("heights change", func() {
+				res.Header.Heights = []DatabaseHeight{{DatabaseID: "db2", Height: 1}}
+
 				err = res.VerifyHash()
 				So(err, ShouldNotBeNil)
 			})
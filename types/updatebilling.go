@@ -17,6 +17,8 @@
 package types
 
 import (
+	"github.com/pkg/errors"
+
 	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
 	"github.com/CovenantSQL/CovenantSQL/crypto"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
@@ -44,13 +46,54 @@ type UserCost struct {
 	Miners []*MinerIncome
 }
 
+// PriceSchedule defines the unit prices of the metered resources in billing, the cost of a
+// request is then charged by the gas price of each miner.
+type PriceSchedule struct {
+	Query           uint64 // base price of each request
+	RowScanned      uint64 // price of each row scanned
+	KiloVMStep      uint64 // price of each thousand VM instructions, rounded up
+	RowWritten      uint64 // price of each row written
+	ResultKiB       uint64 // price of each KiB of result, rounded up
+	ExecMillisecond uint64 // price of each millisecond of execution time, rounded down
+}
+
+// DefaultPriceSchedule returns the published price schedule used by SQLChain billing if the
+// network doesn't configure one.
+func DefaultPriceSchedule() PriceSchedule {
+	return PriceSchedule{
+		Query:           1,
+		RowScanned:      1,
+		KiloVMStep:      1,
+		RowWritten:      2,
+		ResultKiB:       1,
+		ExecMillisecond: 1,
+	}
+}
+
+// Cost returns the cost of a request with resource usage u.
+func (p *PriceSchedule) Cost(u *ResourceUsage) uint64 {
+	return p.Query +
+		p.RowScanned*u.RowsScanned +
+		p.KiloVMStep*((u.VMSteps+999)/1000) +
+		p.RowWritten*u.RowsWritten +
+		p.ResultKiB*((u.ResultBytes+1023)/1024) +
+		p.ExecMillisecond*(u.ExecTime/1000)
+}
+
 // UpdateBillingHeader defines the UpdateBilling transaction header.
 type UpdateBillingHeader struct {
 	Receiver proto.AccountAddress
 	Nonce    pi.AccountNonce
 	Users    []*UserCost
 	Range    Range
-	Version  int32 `hsp:"v,version"`
+	Quorum   uint32 // minimum co-signers of the database miners, since version 2
+	Version  int32  `hsp:"v,version"`
+}
+
+// MinerSignature defines a signature of a miner on the UpdateBilling header hash.
+type MinerSignature struct {
+	Signee    *asymmetric.PublicKey
+	Signature *asymmetric.Signature
 }
 
 // UpdateBilling defines the UpdateBilling transaction.
@@ -58,6 +101,8 @@ type UpdateBilling struct {
 	UpdateBillingHeader
 	pi.TransactionTypeMixin
 	verifier.DefaultHashSignVerifierImpl
+	// Signatures are the co-signatures of the other miners of the database.
+	Signatures []*MinerSignature
 }
 
 // NewUpdateBilling returns new instance.
@@ -89,6 +134,52 @@ func (ub *UpdateBilling) Verify() (err error) {
 	return ub.DefaultHashSignVerifierImpl.Verify(&ub.UpdateBillingHeader)
 }
 
+// BillingQuorum returns the minimum signer count of an UpdateBilling transaction of a database
+// with n miners.
+func BillingQuorum(n int) int {
+	return n/2 + 1
+}
+
+// CoSign returns the co-signature of the transaction signed by the proposer.
+func (ub *UpdateBilling) CoSign(signer *asymmetric.PrivateKey) (sig *MinerSignature, err error) {
+	if err = ub.Verify(); err != nil {
+		return
+	}
+	sig = &MinerSignature{Signee: signer.PubKey()}
+	if sig.Signature, err = signer.Sign(ub.DataHash[:]); err != nil {
+		sig = nil
+	}
+	return
+}
+
+// Signers returns the distinct account addresses of the proposer and the co-signers of the
+// transaction, the co-signatures should be valid.
+func (ub *UpdateBilling) Signers() (signers []proto.AccountAddress, err error) {
+	var (
+		addr proto.AccountAddress
+		seen = make(map[proto.AccountAddress]struct{})
+	)
+	if addr, err = crypto.PubKeyHash(ub.Signee); err != nil {
+		return
+	}
+	seen[addr] = struct{}{}
+	signers = append(signers, addr)
+	for _, v := range ub.Signatures {
+		if v == nil || v.Signee == nil || !v.Signature.Verify(ub.DataHash[:], v.Signee) {
+			err = errors.WithStack(verifier.ErrSignatureNotMatch)
+			return
+		}
+		if addr, err = crypto.PubKeyHash(v.Signee); err != nil {
+			return
+		}
+		if _, ok := seen[addr]; !ok {
+			seen[addr] = struct{}{}
+			signers = append(signers, addr)
+		}
+	}
+	return
+}
+
 func init() {
 	pi.RegisterTransaction(pi.TransactionTypeUpdateBilling, (*UpdateBilling)(nil))
 }
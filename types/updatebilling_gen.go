@@ -29,6 +29,71 @@ func (z *MinerIncome) Msgsize() (s int) {
 	return
 }
 
+// MarshalHash marshals for hash
+func (z *MinerSignature) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 2
+	o = append(o, 0x82)
+	if z.Signature == nil {
+		o = hsp.AppendNil(o)
+	} else {
+		if oTemp, err := z.Signature.MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	if z.Signee == nil {
+		o = hsp.AppendNil(o)
+	} else {
+		if oTemp, err := z.Signee.MarshalHash(); err != nil {
+			return nil, err
+		} else {
+			o = hsp.AppendBytes(o, oTemp)
+		}
+	}
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *MinerSignature) Msgsize() (s int) {
+	s = 1 + 10
+	if z.Signature == nil {
+		s += hsp.NilSize
+	} else {
+		s += z.Signature.Msgsize()
+	}
+	s += 7
+	if z.Signee == nil {
+		s += hsp.NilSize
+	} else {
+		s += z.Signee.Msgsize()
+	}
+	return
+}
+
+// MarshalHash marshals for hash
+func (z *PriceSchedule) MarshalHash() (o []byte, err error) {
+	var b []byte
+	o = hsp.Require(b, z.Msgsize())
+	// map header, size 6
+	o = append(o, 0x86)
+	o = hsp.AppendUint64(o, z.ExecMillisecond)
+	o = hsp.AppendUint64(o, z.KiloVMStep)
+	o = hsp.AppendUint64(o, z.Query)
+	o = hsp.AppendUint64(o, z.ResultKiB)
+	o = hsp.AppendUint64(o, z.RowScanned)
+	o = hsp.AppendUint64(o, z.RowWritten)
+	return
+}
+
+// Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
+func (z *PriceSchedule) Msgsize() (s int) {
+	s = 1 + 16 + hsp.Uint64Size + 11 + hsp.Uint64Size + 6 + hsp.Uint64Size + 10 + hsp.Uint64Size + 11 + hsp.Uint64Size + 11 + hsp.Uint64Size
+	return
+}
+
 // MarshalHash marshals for hash
 func (z Range) MarshalHash() (o []byte, err error) {
 	var b []byte
@@ -50,13 +115,25 @@ func (z Range) Msgsize() (s int) {
 func (z *UpdateBilling) MarshalHash() (o []byte, err error) {
 	var b []byte
 	o = hsp.Require(b, z.Msgsize())
-	// map header, size 3
-	o = append(o, 0x83)
+	// map header, size 4
+	o = append(o, 0x84)
 	if oTemp, err := z.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
 		return nil, err
 	} else {
 		o = hsp.AppendBytes(o, oTemp)
 	}
+	o = hsp.AppendArrayHeader(o, uint32(len(z.Signatures)))
+	for za0001 := range z.Signatures {
+		if z.Signatures[za0001] == nil {
+			o = hsp.AppendNil(o)
+		} else {
+			if oTemp, err := z.Signatures[za0001].MarshalHash(); err != nil {
+				return nil, err
+			} else {
+				o = hsp.AppendBytes(o, oTemp)
+			}
+		}
+	}
 	if oTemp, err := z.TransactionTypeMixin.MarshalHash(); err != nil {
 		return nil, err
 	} else {
@@ -72,13 +149,22 @@ func (z *UpdateBilling) MarshalHash() (o []byte, err error) {
 
 // Msgsize returns an upper bound estimate of the number of bytes occupied by the serialized message
 func (z *UpdateBilling) Msgsize() (s int) {
-	s = 1 + 28 + z.DefaultHashSignVerifierImpl.Msgsize() + 21 + z.TransactionTypeMixin.Msgsize() + 20 + z.UpdateBillingHeader.Msgsize()
+	s = 1 + 28 + z.DefaultHashSignVerifierImpl.Msgsize() + 11 + hsp.ArrayHeaderSize
+	for za0001 := range z.Signatures {
+		if z.Signatures[za0001] == nil {
+			s += hsp.NilSize
+		} else {
+			s += z.Signatures[za0001].Msgsize()
+		}
+	}
+	s += 21 + z.TransactionTypeMixin.Msgsize() + 20 + z.UpdateBillingHeader.Msgsize()
 	return
 }
 
 var hspVersionsUpdateBillingHeader = []string{
 	"oldver",
 	"9ef447",
+	"76a0a0",
 }
 
 // HSPCurrentVersion returns current struct version
@@ -88,12 +174,12 @@ func (z *UpdateBillingHeader) HSPCurrentVersion() int {
 
 // HSPMaxVersion returns max struct version
 func (z *UpdateBillingHeader) HSPMaxVersion() int {
-	return 1
+	return 2
 }
 
 // HSPDefaultVersion returns default struct version
 func (z *UpdateBillingHeader) HSPDefaultVersion() int {
-	return 1
+	return 2
 }
 
 // MarshalHash marshals for hash
@@ -103,6 +189,8 @@ func (z *UpdateBillingHeader) MarshalHash() (o []byte, err error) {
 		return z.MarshalHasholdver()
 	case 1:
 		return z.MarshalHash9ef447()
+	case 2:
+		return z.MarshalHash76a0a0()
 	default:
 		err = herr.New("invalid struct version")
 		return
@@ -117,6 +205,8 @@ func (z *UpdateBillingHeader) Msgsize() (s int) {
 		return z.Msgsizeoldver()
 	case 1:
 		return z.Msgsize9ef447()
+	case 2:
+		return z.Msgsize76a0a0()
 	default:
 		return 0
 	}
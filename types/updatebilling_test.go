@@ -0,0 +1,113 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+func TestPriceSchedule(t *testing.T) {
+	Convey("test PriceSchedule", t, func() {
+		var schedule = &PriceSchedule{
+			Query:           10,
+			RowScanned:      1,
+			KiloVMStep:      4,
+			RowWritten:      2,
+			ResultKiB:       3,
+			ExecMillisecond: 5,
+		}
+		So(schedule.Cost(&ResourceUsage{}), ShouldEqual, 10)
+		So(schedule.Cost(&ResourceUsage{
+			RowsScanned: 100,
+			VMSteps:     1001,
+			ResultBytes: 1025,
+			ExecTime:    2999,
+		}), ShouldEqual, 10+100+4*2+3*2+5*2)
+		So(schedule.Cost(&ResourceUsage{
+			RowsWritten: 5,
+			VMSteps:     999,
+			ExecTime:    999,
+		}), ShouldEqual, 10+4+2*5)
+		// A full scan should cost more than a point lookup
+		var defaults = DefaultPriceSchedule()
+		So(defaults.Cost(&ResourceUsage{RowsScanned: 10000, VMSteps: 80000, ResultBytes: 32}),
+			ShouldBeGreaterThan, defaults.Cost(&ResourceUsage{VMSteps: 80, ResultBytes: 32}))
+		// The returned schedule is a copy
+		defaults.Query = 0
+		So(DefaultPriceSchedule().Query, ShouldEqual, 1)
+	})
+}
+
+func TestUpdateBillingCoSign(t *testing.T) {
+	Convey("Given an UpdateBilling proposed by a miner", t, func() {
+		var (
+			keys = make([]*asymmetric.PrivateKey, 3)
+			addr = make([]proto.AccountAddress, len(keys))
+			err  error
+		)
+		for i := range keys {
+			keys[i], _, err = asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			addr[i], err = crypto.PubKeyHash(keys[i].PubKey())
+			So(err, ShouldBeNil)
+		}
+		var ub = NewUpdateBilling(&UpdateBillingHeader{
+			Range: Range{From: 0, To: 10},
+		})
+		ub.Version = int32(ub.HSPDefaultVersion())
+		err = ub.Sign(keys[0])
+		So(err, ShouldBeNil)
+		signers, err := ub.Signers()
+		So(err, ShouldBeNil)
+		So(signers, ShouldResemble, []proto.AccountAddress{addr[0]})
+
+		Convey("The co-signers should be counted distinctly", func() {
+			for _, k := range []*asymmetric.PrivateKey{keys[1], keys[2], keys[1]} {
+				sig, err := ub.CoSign(k)
+				So(err, ShouldBeNil)
+				ub.Signatures = append(ub.Signatures, sig)
+			}
+			signers, err = ub.Signers()
+			So(err, ShouldBeNil)
+			So(signers, ShouldResemble, addr)
+		})
+		Convey("The co-signature of another header should be rejected", func() {
+			var other = *ub
+			other.Nonce++
+			err = other.Sign(keys[0])
+			So(err, ShouldBeNil)
+			sig, err := other.CoSign(keys[1])
+			So(err, ShouldBeNil)
+			ub.Signatures = append(ub.Signatures, sig)
+			_, err = ub.Signers()
+			So(errors.Cause(err), ShouldEqual, verifier.ErrSignatureNotMatch)
+		})
+		Convey("A tampered transaction should not be co-signed", func() {
+			ub.Range.To++
+			_, err = ub.CoSign(keys[1])
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
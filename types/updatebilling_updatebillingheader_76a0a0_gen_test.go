@@ -0,0 +1,47 @@
+package types
+
+// Code generated by github.com/CovenantSQL/HashStablePack DO NOT EDIT.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"testing"
+)
+
+func TestMarshalHash76a0a0UpdateBillingHeader(t *testing.T) {
+	v := UpdateBillingHeader{}
+	binary.Read(rand.Reader, binary.BigEndian, &v)
+	bts1, err := v.MarshalHash76a0a0()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bts2, err := v.MarshalHash76a0a0()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bts1, bts2) {
+		t.Fatal("hash not stable")
+	}
+}
+
+func BenchmarkMarshalHash76a0a0UpdateBillingHeader(b *testing.B) {
+	v := UpdateBillingHeader{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.MarshalHash76a0a0()
+	}
+}
+
+func BenchmarkAppendMsg76a0a0UpdateBillingHeader(b *testing.B) {
+	v := UpdateBillingHeader{}
+	bts := make([]byte, 0, v.Msgsize76a0a0())
+	bts, _ = v.MarshalHash76a0a0()
+	b.SetBytes(int64(len(bts)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bts, _ = v.MarshalHash76a0a0()
+	}
+}
@@ -60,7 +60,10 @@ func (up *UpdatePermission) Sign(signer *asymmetric.PrivateKey) (err error) {
 }
 
 // Verify implements interfaces/Transaction.Verify.
-func (up *UpdatePermission) Verify() error {
+func (up *UpdatePermission) Verify() (err error) {
+	if err = up.Permission.VerifyVersion(); err != nil {
+		return
+	}
 	return up.DefaultHashSignVerifierImpl.Verify(&up.UpdatePermissionHeader)
 }
 
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/verifier"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+)
+
+//go:generate hsp
+
+// UpdateQuotaHeader defines the storage quota update of a database.
+type UpdateQuotaHeader struct {
+	TargetSQLChain proto.AccountAddress
+	Space          uint64 // new storage quota in bytes, which should be greater than the current one
+	Nonce          interfaces.AccountNonce
+}
+
+// GetAccountNonce implements interfaces/Transaction.GetAccountNonce.
+func (h *UpdateQuotaHeader) GetAccountNonce() interfaces.AccountNonce {
+	return h.Nonce
+}
+
+// UpdateQuota defines the database storage quota raising transaction.
+type UpdateQuota struct {
+	UpdateQuotaHeader
+	interfaces.TransactionTypeMixin
+	verifier.DefaultHashSignVerifierImpl
+}
+
+// NewUpdateQuota returns new instance.
+func NewUpdateQuota(header *UpdateQuotaHeader) *UpdateQuota {
+	return &UpdateQuota{
+		UpdateQuotaHeader:    *header,
+		TransactionTypeMixin: *interfaces.NewTransactionTypeMixin(interfaces.TransactionTypeUpdateQuota),
+	}
+}
+
+// Sign implements interfaces/Transaction.Sign.
+func (uq *UpdateQuota) Sign(signer *asymmetric.PrivateKey) (err error) {
+	return uq.DefaultHashSignVerifierImpl.Sign(&uq.UpdateQuotaHeader, signer)
+}
+
+// Verify implements interfaces/Transaction.Verify.
+func (uq *UpdateQuota) Verify() error {
+	return uq.DefaultHashSignVerifierImpl.Verify(&uq.UpdateQuotaHeader)
+}
+
+// GetAccountAddress implements interfaces/Transaction.GetAccountAddress.
+func (uq *UpdateQuota) GetAccountAddress() proto.AccountAddress {
+	addr, _ := crypto.PubKeyHash(uq.Signee)
+	return addr
+}
+
+func init() {
+	interfaces.RegisterTransaction(interfaces.TransactionTypeUpdateQuota, (*UpdateQuota)(nil))
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	pi "github.com/CovenantSQL/CovenantSQL/blockproducer/interfaces"
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+func TestUpdateQuota(t *testing.T) {
+	Convey("test UpdateQuota", t, func() {
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		addr, err := crypto.PubKeyHash(privKey.PubKey())
+		So(err, ShouldBeNil)
+
+		uq := NewUpdateQuota(&UpdateQuotaHeader{
+			TargetSQLChain: addr,
+			Space:          1 << 30,
+			Nonce:          2,
+		})
+		So(uq.GetTransactionType(), ShouldEqual, pi.TransactionTypeUpdateQuota)
+		So(uq.GetAccountNonce(), ShouldEqual, 2)
+		err = uq.Verify()
+		So(err, ShouldNotBeNil)
+		err = uq.Sign(privKey)
+		So(err, ShouldBeNil)
+		err = uq.Verify()
+		So(err, ShouldBeNil)
+		So(uq.GetAccountAddress(), ShouldEqual, addr)
+
+		Convey("The transaction should be encoded and decoded as a wrapped transaction", func() {
+			buf, err := utils.EncodeMsgPack(pi.WrapTransaction(uq))
+			So(err, ShouldBeNil)
+			var dec pi.Transaction
+			err = utils.DecodeMsgPack(buf.Bytes(), &dec)
+			So(err, ShouldBeNil)
+			So(dec.(*pi.TransactionWrapper).Unwrap(), ShouldResemble, uq)
+		})
+	})
+}
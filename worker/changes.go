@@ -0,0 +1,201 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+const (
+	// ChangeLogFileName defines the row change log file name of database instance.
+	ChangeLogFileName = "changes.ldb"
+
+	// ChangeLogRetention defines the log offset range of the row changes kept in change log.
+	ChangeLogRetention = 1 << 20
+
+	// MaxChangesPerFetch defines the maximum count of row changes returned by a single fetch.
+	MaxChangesPerFetch = 1000
+
+	// ChangesFetchWait defines the maximum wait time of a fetch for new row changes.
+	ChangesFetchWait = 5 * time.Second
+)
+
+var (
+	changeKeyPrefix  = []byte("C")
+	changeMetaPruned = []byte("M:pruned")
+)
+
+// changeLog persists the row changes captured on a database, which are indexed by their positions
+// so that a subscriber could resume the change stream from any retained position.
+type changeLog struct {
+	sync.RWMutex
+	db     *leveldb.DB
+	notify chan struct{} // closed on new changes
+	last   uint64        // last log offset appended
+	pruned uint64        // changes before this log offset are pruned
+}
+
+func changeKey(pos types.ChangePosition) (key []byte) {
+	key = make([]byte, len(changeKeyPrefix)+12)
+	copy(key, changeKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(changeKeyPrefix):], pos.Offset)
+	binary.BigEndian.PutUint32(key[len(changeKeyPrefix)+8:], pos.Index)
+	return
+}
+
+func newChangeLog(path string) (l *changeLog, err error) {
+	var db *leveldb.DB
+	if db, err = leveldb.OpenFile(path, nil); err != nil {
+		err = errors.Wrapf(err, "open change log %s", path)
+		return
+	}
+	l = &changeLog{
+		db:     db,
+		notify: make(chan struct{}),
+	}
+	if v, ierr := db.Get(changeMetaPruned, nil); ierr == nil && len(v) == 8 {
+		l.pruned = binary.BigEndian.Uint64(v)
+	}
+	var iter = db.NewIterator(util.BytesPrefix(changeKeyPrefix), nil)
+	defer iter.Release()
+	if iter.Last() {
+		l.last = binary.BigEndian.Uint64(iter.Key()[len(changeKeyPrefix):])
+	}
+	return
+}
+
+// append implements xenomint.ChangeSink.
+func (l *changeLog) append(changes []*types.RowChange) (err error) {
+	var batch = new(leveldb.Batch)
+	for _, c := range changes {
+		var buf, ierr = utils.EncodeMsgPack(c)
+		if ierr != nil {
+			err = errors.Wrapf(ierr, "encode row change at %d:%d", c.Position.Offset, c.Position.Index)
+			return
+		}
+		batch.Put(changeKey(c.Position), buf.Bytes())
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if err = l.db.Write(batch, nil); err != nil {
+		err = errors.Wrap(err, "write change log")
+		return
+	}
+	if last := changes[len(changes)-1].Position.Offset; last > l.last {
+		l.last = last
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+	if l.last > l.pruned+2*ChangeLogRetention {
+		err = l.pruneLocked(l.last - ChangeLogRetention)
+	}
+	return
+}
+
+func (l *changeLog) pruneLocked(before uint64) (err error) {
+	var (
+		batch = new(leveldb.Batch)
+		iter  = l.db.NewIterator(&util.Range{
+			Start: changeKey(types.ChangePosition{}),
+			Limit: changeKey(types.ChangePosition{Offset: before}),
+		}, nil)
+		meta = make([]byte, 8)
+	)
+	defer iter.Release()
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	binary.BigEndian.PutUint64(meta, before)
+	batch.Put(changeMetaPruned, meta)
+	if err = l.db.Write(batch, nil); err != nil {
+		err = errors.Wrap(err, "prune change log")
+		return
+	}
+	l.pruned = before
+	return
+}
+
+func (l *changeLog) read(since types.ChangePosition, limit int) (changes []*types.RowChange, err error) {
+	l.RLock()
+	defer l.RUnlock()
+	if since.Offset < l.pruned {
+		err = errors.Wrapf(ErrChangesPruned, "changes before offset %d are pruned", l.pruned)
+		return
+	}
+	var iter = l.db.NewIterator(&util.Range{
+		Start: changeKey(since),
+		Limit: util.BytesPrefix(changeKeyPrefix).Limit,
+	}, nil)
+	defer iter.Release()
+	for iter.Next() && len(changes) < limit {
+		var c *types.RowChange
+		if err = utils.DecodeMsgPack(iter.Value(), &c); err != nil {
+			return
+		}
+		changes = append(changes, c)
+	}
+	err = iter.Error()
+	return
+}
+
+// fetch returns at most limit row changes from position since, it waits for new changes up to
+// wait if there is not any.
+func (l *changeLog) fetch(
+	ctx context.Context, since types.ChangePosition, limit int, wait time.Duration,
+) (changes []*types.RowChange, next types.ChangePosition, err error) {
+	if limit <= 0 || limit > MaxChangesPerFetch {
+		limit = MaxChangesPerFetch
+	}
+	l.RLock()
+	var notify = l.notify
+	l.RUnlock()
+	if changes, err = l.read(since, limit); err != nil {
+		return
+	}
+	if len(changes) == 0 && wait > 0 {
+		var timer = time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-notify:
+			if changes, err = l.read(since, limit); err != nil {
+				return
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	next = since
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Position.Next()
+	}
+	return
+}
+
+func (l *changeLog) close() error {
+	return l.db.Close()
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestChangeLog(t *testing.T) {
+	Convey("test change log", t, func() {
+		dir, err := ioutil.TempDir("", "changelog")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		var (
+			path  = filepath.Join(dir, ChangeLogFileName)
+			l     *changeLog
+			ctx   = context.Background()
+			build = func(offset uint64, n int) (changes []*types.RowChange) {
+				for i := 0; i < n; i++ {
+					changes = append(changes, &types.RowChange{
+						Position: types.ChangePosition{Offset: offset, Index: uint32(i)},
+						Table:    "foo",
+						Op:       types.ChangeInsert,
+						RowID:    int64(i + 1),
+						Columns:  []string{"k"},
+						New:      []interface{}{int64(i + 1)},
+					})
+				}
+				return
+			}
+			changes []*types.RowChange
+			next    types.ChangePosition
+		)
+		l, err = newChangeLog(path)
+		So(err, ShouldBeNil)
+
+		// Empty log should time out with nothing
+		changes, next, err = l.fetch(ctx, types.ChangePosition{}, 0, 10*time.Millisecond)
+		So(err, ShouldBeNil)
+		So(changes, ShouldBeEmpty)
+		So(next, ShouldResemble, types.ChangePosition{})
+
+		err = l.append(build(1, 2))
+		So(err, ShouldBeNil)
+		err = l.append(build(3, 3))
+		So(err, ShouldBeNil)
+
+		changes, next, err = l.fetch(ctx, types.ChangePosition{}, 3, 0)
+		So(err, ShouldBeNil)
+		So(changes, ShouldHaveLength, 3)
+		So(changes[0].Position, ShouldResemble, types.ChangePosition{Offset: 1, Index: 0})
+		So(changes[2].Position, ShouldResemble, types.ChangePosition{Offset: 3, Index: 0})
+		So(changes[2].New, ShouldResemble, []interface{}{int64(1)})
+		So(next, ShouldResemble, types.ChangePosition{Offset: 3, Index: 1})
+
+		// Resume from next
+		changes, next, err = l.fetch(ctx, next, 0, 0)
+		So(err, ShouldBeNil)
+		So(changes, ShouldHaveLength, 2)
+		So(next, ShouldResemble, types.ChangePosition{Offset: 3, Index: 3})
+
+		// Waiting fetch should be notified by new changes
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			l.append(build(5, 1))
+		}()
+		changes, next, err = l.fetch(ctx, next, 0, 5*time.Second)
+		So(err, ShouldBeNil)
+		So(changes, ShouldHaveLength, 1)
+		So(next, ShouldResemble, types.ChangePosition{Offset: 5, Index: 1})
+
+		// Reopen and check the restored state
+		err = l.close()
+		So(err, ShouldBeNil)
+		l, err = newChangeLog(path)
+		So(err, ShouldBeNil)
+		So(l.last, ShouldEqual, 5)
+		changes, _, err = l.fetch(ctx, types.ChangePosition{}, 0, 0)
+		So(err, ShouldBeNil)
+		So(changes, ShouldHaveLength, 6)
+
+		// Pruned changes should not be fetched
+		l.Lock()
+		err = l.pruneLocked(4)
+		l.Unlock()
+		So(err, ShouldBeNil)
+		_, _, err = l.fetch(ctx, types.ChangePosition{Offset: 3}, 0, 0)
+		So(errors.Cause(err), ShouldEqual, ErrChangesPruned)
+		changes, _, err = l.fetch(ctx, types.ChangePosition{Offset: 4}, 0, 0)
+		So(err, ShouldBeNil)
+		So(changes, ShouldHaveLength, 1)
+		err = l.close()
+		So(err, ShouldBeNil)
+	})
+}
@@ -20,15 +20,18 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/pkg/errors"
 
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/kayak"
 	kt "github.com/CovenantSQL/CovenantSQL/kayak/types"
@@ -71,6 +74,10 @@ const (
 
 	// SlowQuerySampleSize defines the maximum slow query log size (default: 1KB).
 	SlowQuerySampleSize = 1 << 10
+
+	// MaxRecordedProcedureCalls defines the max responses of the stored procedure calls recorded
+	// to verify their acks.
+	MaxRecordedProcedureCalls = 10000
 )
 
 // Database defines a single database instance in worker runtime.
@@ -87,6 +94,26 @@ type Database struct {
 	mux            *DBKayakMuxService
 	privateKey     *asymmetric.PrivateKey
 	accountAddr    proto.AccountAddress
+	stats          *queryStats
+	changes        *changeLog
+	keys           *keyRotation
+	txs            *txBranches
+	history        *history
+	procedureCalls *lru.Cache // procedure calls of the responses not acked yet, by response hash
+
+	// spaceLimit is the storage quota of the database, and spaceUsed is the size of the database
+	// storage tracked by write queries, both are accessed atomically.
+	spaceLimit uint64
+	spaceUsed  uint64
+
+	// lastActive is the unix time in nanoseconds of the last query, and active is the number of
+	// the ongoing queries, both are accessed atomically to find the idle database to hibernate.
+	lastActive int64
+	active     int32
+
+	// snapshotLock guards the storage snapshots taken for the new peers.
+	snapshotLock sync.Mutex
+	snapshots    map[string]*storageSnapshot
 }
 
 // NewDatabase create a single database instance using config.
@@ -102,6 +129,11 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		return
 	}
 
+	// remove the snapshots left by the last run
+	if err = removeStaleSnapshots(cfg.DataDir); err != nil {
+		return
+	}
+
 	// get private key
 	var privateKey *asymmetric.PrivateKey
 	if privateKey, err = kms.GetLocalPrivateKey(); err != nil {
@@ -121,6 +153,13 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		connSeqEvictCh: make(chan uint64, 1),
 		privateKey:     privateKey,
 		accountAddr:    accountAddr,
+		stats:          newQueryStats(),
+		spaceLimit:     cfg.SpaceLimit,
+		lastActive:     time.Now().UnixNano(),
+		snapshots:      make(map[string]*storageSnapshot),
+	}
+	if db.procedureCalls, err = lru.New(MaxRecordedProcedureCalls); err != nil {
+		return
 	}
 
 	defer func() {
@@ -135,6 +174,11 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 			if db.chain != nil {
 				db.chain.Stop()
 			}
+
+			// close change log
+			if db.changes != nil {
+				_ = db.changes.close()
+			}
 		}
 	}()
 
@@ -145,8 +189,13 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		return
 	}
 
-	if cfg.EncryptionKey != "" {
-		storageDSN.AddParam("_crypto_key", cfg.EncryptionKey)
+	var key *keyMeta
+	if key, err = initKeyMeta(cfg, storageFile, db.privateKey); err != nil {
+		return
+	}
+	baseDSN := storageDSN.Clone()
+	if key.Key != "" {
+		storageDSN.AddParam("_crypto_key", key.Key)
 	}
 
 	// init chain
@@ -166,19 +215,38 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 		MuxService: cfg.ChainMux,
 		Server:     db.nodeID,
 
-		Period:            conf.GConf.SQLChainPeriod,
-		Tick:              conf.GConf.SQLChainTick,
-		QueryTTL:          conf.GConf.SQLChainTTL,
-		LastBillingHeight: cfg.LastBillingHeight,
-		UpdatePeriod:      cfg.UpdateBlockCount,
-		IsolationLevel:    cfg.IsolationLevel,
+		Period:             conf.GConf.SQLChainPeriod,
+		Tick:               conf.GConf.SQLChainTick,
+		QueryTTL:           conf.GConf.SQLChainTTL,
+		StorageProofPeriod: conf.GConf.SQLChainStorageProofPeriod,
+		LastBillingHeight:  cfg.LastBillingHeight,
+		UpdatePeriod:       cfg.UpdateBlockCount,
+		PriceSchedule:      priceSchedule(conf.GConf.SQLChainPriceSchedule),
+		IsolationLevel:     cfg.IsolationLevel,
+		Extensions:         cfg.Extensions,
+		MaxQueryTime:       cfg.MaxQueryTime,
+		Limits:             cfg.Limits,
+		OnStateDiverged:    cfg.OnStateDiverged,
+	}
+	if chainCfg.SnapshotSeq, err = loadSnapshotSeq(cfg.DataDir); err != nil {
+		return
 	}
 	if db.chain, err = sqlchain.NewChain(chainCfg); err != nil {
 		return
 	}
+	if cfg.ChangeCapture {
+		// init row change log before any query is replayed
+		if db.changes, err = newChangeLog(filepath.Join(cfg.DataDir, ChangeLogFileName)); err != nil {
+			return
+		}
+		if err = db.chain.EnableChangeCapture(db.changes.append); err != nil {
+			return
+		}
+	}
 	if err = db.chain.Start(); err != nil {
 		return
 	}
+	db.updateSpaceUsed()
 
 	// init kayak config
 	kayakWalPath := filepath.Join(cfg.DataDir, KayakWalFileName)
@@ -217,6 +285,44 @@ func NewDatabase(cfg *DBConfig, peers *proto.Peers,
 	// init sequence eviction processor
 	go db.evictSequences()
 
+	// rotate to the issued key if needed
+	db.keys = newKeyRotation(cfg.DataDir, baseDSN, *key, db.privateKey, db.chain.SwitchStorage)
+	db.keys.rotate(keyMeta{Version: cfg.IssuedKeyVersion, Key: cfg.IssuedKey})
+
+	// load multi-database transaction branches, the prepared one is resumed
+	if db.txs, err = newTxBranches(db.dbID, filepath.Join(cfg.DataDir, TxMetaFileName),
+		cfg.MaxWriteTimeGap, db.checkTx, db.applyTx, cfg.TxResolver,
+	); err != nil {
+		return
+	}
+
+	// retain the storage history for as-of reads
+	if cfg.HistoryRetention > 0 {
+		if db.history, err = newHistory(filepath.Join(cfg.DataDir, HistoryDirName),
+			cfg.HistoryRetention, db.nodeID, cfg.Extensions, cfg.MaxQueryTime, cfg.Limits, db.chain,
+			db.keys,
+		); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// initKeyMeta returns the encryption key of the database storage, a new storage is encrypted with
+// the latest issued key directly.
+func initKeyMeta(
+	cfg *DBConfig, storageFile string, nodeKey *asymmetric.PrivateKey,
+) (key *keyMeta, err error) {
+	if key, err = loadKeyMeta(cfg.DataDir, nodeKey); err != nil || key != nil {
+		return
+	}
+	if _, ierr := os.Stat(storageFile); os.IsNotExist(ierr) && cfg.IssuedKeyVersion > 0 {
+		key = &keyMeta{Version: cfg.IssuedKeyVersion, Key: cfg.IssuedKey}
+		err = writeKeyMeta(filepath.Join(cfg.DataDir, KeyMetaFileName), key, nodeKey)
+		return
+	}
+	key = &keyMeta{Key: cfg.EncryptionKey}
 	return
 }
 
@@ -231,6 +337,24 @@ func (db *Database) UpdatePeers(peers *proto.Peers) (err error) {
 
 // Query defines database query interface.
 func (db *Database) Query(request *types.Request) (response *types.Response, err error) {
+	if request.Header.QueryType == types.WriteQuery {
+		// write queries are blocked while a multi-database transaction branch is prepared
+		db.txs.writes.RLock()
+		defer db.txs.writes.RUnlock()
+	}
+	return db.query(request, nil)
+}
+
+// QueryAttached executes the read request with the referenced databases attached read-only.
+func (db *Database) QueryAttached(
+	request *types.Request, refs []*Database) (response *types.Response, err error,
+) {
+	return db.query(request, refs)
+}
+
+func (db *Database) query(
+	request *types.Request, refs []*Database) (response *types.Response, err error,
+) {
 	// Just need to verify signature in db.saveAck
 	//if err = request.Verify(); err != nil {
 	//	return
@@ -242,6 +366,12 @@ func (db *Database) Query(request *types.Request) (response *types.Response, err
 		tmStart     = time.Now()
 	)
 
+	atomic.AddInt32(&db.active, 1)
+	defer func() {
+		db.touch()
+		atomic.AddInt32(&db.active, -1)
+	}()
+
 	// log the query if the underlying storage layer take too long to response
 	slowQueryTimer := time.AfterFunc(db.cfg.SlowQueryTime, func() {
 		// mark as slow query
@@ -254,15 +384,29 @@ func (db *Database) Query(request *types.Request) (response *types.Response, err
 			// slow query
 			db.logSlow(request, true, tmStart)
 		}
+		db.stats.record(request, response, err != nil, time.Since(tmStart))
 	}()
 
 	switch request.Header.QueryType {
 	case types.ReadQuery:
-		if tracker, response, err = db.chain.Query(request, false); err != nil {
+		if request.Header.AsOfHeight > 0 {
+			if tracker, response, err = db.readAsOf(request, refs); err != nil {
+				err = errors.Wrap(err, "failed to query as-of read query")
+				return
+			}
+		} else if len(refs) > 0 {
+			if tracker, response, err = db.readAttached(request, refs); err != nil {
+				err = errors.Wrap(err, "failed to query cross-database read query")
+				return
+			}
+		} else if tracker, response, err = db.chain.Query(request, false); err != nil {
 			err = errors.Wrap(err, "failed to query read query")
 			return
 		}
 	case types.WriteQuery:
+		if request.Header.AsOfHeight != 0 {
+			return nil, errors.Wrap(ErrInvalidRequest, "as-of height on write query")
+		}
 		if db.cfg.UseEventualConsistency {
 			// reset context
 			request.SetContext(context.Background())
@@ -293,11 +437,103 @@ func (db *Database) Query(request *types.Request) (response *types.Response, err
 		log.WithError(err).Debug("failed to add response to index")
 		return
 	}
+	db.recordProcedureCall(request, &response.Header)
 	tracker.UpdateResp(response)
 
 	return
 }
 
+// procedureCall is the requester and the stored procedures called by a request.
+type procedureCall struct {
+	caller     proto.AccountAddress
+	procedures []string
+}
+
+// recordProcedureCall records the requester and the procedures called by the request if it only
+// calls stored procedures, so that the response could be acked by the requester with only the
+// grants of the procedures.
+func (db *Database) recordProcedureCall(request *types.Request, response *types.SignedResponseHeader) {
+	var procedures = make([]string, 0, len(request.Payload.Queries))
+	for _, q := range request.Payload.Queries {
+		var name, ok = x.CalledProcedure(q.Pattern)
+		if !ok {
+			return
+		}
+		procedures = append(procedures, name)
+	}
+	if len(procedures) == 0 || request.Header.Signee == nil {
+		return
+	}
+	var caller, err = crypto.PubKeyHash(request.Header.Signee)
+	if err != nil {
+		return
+	}
+	db.procedureCalls.Add(response.Hash(), &procedureCall{caller: caller, procedures: procedures})
+}
+
+// ProcedureCall returns the requester and the stored procedures called by the request of the
+// response, it's not found if the request doesn't only call procedures or the response is acked.
+func (db *Database) ProcedureCall(response hash.Hash) (
+	caller proto.AccountAddress, procedures []string, ok bool,
+) {
+	var v interface{}
+	if v, ok = db.procedureCalls.Get(response); !ok {
+		return
+	}
+	var call = v.(*procedureCall)
+	return call.caller, call.procedures, true
+}
+
+// readAttached executes the read request with the referenced databases attached read-only, the
+// states of all the databases read by the request are stated in the response.
+func (db *Database) readAttached(
+	request *types.Request, refs []*Database) (tracker *x.QueryTracker, response *types.Response, err error,
+) {
+	var (
+		attached = make([]x.Attachment, len(refs))
+		pinned   = append([]*Database{db}, refs...)
+		heights  = make([]types.DatabaseHeight, len(pinned))
+	)
+	for i, v := range refs {
+		var file, key = v.keys.storage()
+		attached[i] = x.Attachment{Name: string(v.dbID), File: file, Key: key}
+	}
+	// pin the databases in a fixed order to avoid deadlock between the concurrent queries
+	sort.Slice(pinned, func(i, j int) bool { return pinned[i].dbID < pinned[j].dbID })
+	var pin = func() (release func(), err error) {
+		var releases = make([]func(), len(pinned))
+		for i, v := range pinned {
+			heights[i].DatabaseID = v.dbID
+			heights[i].Height, heights[i].LogOffset, releases[i] = v.chain.Pin()
+		}
+		return func() {
+			for i := len(releases) - 1; i >= 0; i-- {
+				releases[i]()
+			}
+		}, nil
+	}
+	if tracker, response, err = db.chain.QueryAttached(request, attached, pin); err != nil {
+		return
+	}
+	response.Header.Heights = heights
+	return
+}
+
+// readAsOf executes the read request on the retained storage history as of the requested height.
+func (db *Database) readAsOf(
+	request *types.Request, refs []*Database) (tracker *x.QueryTracker, response *types.Response, err error,
+) {
+	if len(refs) > 0 {
+		err = errors.Wrap(ErrInvalidRequest, "cross-database query as of height")
+		return
+	}
+	if db.history == nil {
+		err = ErrHistoryDisabled
+		return
+	}
+	return db.history.query(request)
+}
+
 func (db *Database) logSlow(request *types.Request, isFinished bool, tmStart time.Time) {
 	if request == nil {
 		return
@@ -333,6 +569,74 @@ func (db *Database) logSlow(request *types.Request, isFinished bool, tmStart tim
 	}).Error("slow query detected")
 }
 
+// QueryStats returns the aggregated query statistics of the database since the last reset, and
+// resets the statistics if required.
+func (db *Database) QueryStats(reset bool) (since time.Time, stats []*types.QueryStat) {
+	return db.stats.snapshot(reset)
+}
+
+// FetchChanges returns the captured row changes from position since, it waits for new changes
+// up to wait if there is not any.
+func (db *Database) FetchChanges(
+	ctx context.Context, since types.ChangePosition, limit int, wait time.Duration,
+) (changes []*types.RowChange, next types.ChangePosition, err error) {
+	if db.changes == nil {
+		err = ErrChangeCaptureDisabled
+		return
+	}
+	return db.changes.fetch(ctx, since, limit, wait)
+}
+
+// RotateKey starts to rotate the encryption key of the database storage to key in background if
+// the key version is greater than the current one.
+func (db *Database) RotateKey(version uint32, key string) {
+	db.keys.rotate(keyMeta{Version: version, Key: key})
+}
+
+// KeyRotationStatus returns the encryption key rotation status of the database.
+func (db *Database) KeyRotationStatus() types.KeyRotationStatus {
+	return db.keys.stat()
+}
+
+// SetSpaceLimit sets the storage quota of the database, 0 means unlimited.
+func (db *Database) SetSpaceLimit(limit uint64) {
+	atomic.StoreUint64(&db.spaceLimit, limit)
+}
+
+// SpaceUsage returns the tracked size of the database storage and the storage quota in bytes.
+func (db *Database) SpaceUsage() (used, limit uint64) {
+	return atomic.LoadUint64(&db.spaceUsed), atomic.LoadUint64(&db.spaceLimit)
+}
+
+// updateSpaceUsed updates the tracked size of the database storage, which is counted by the
+// sqlite page_count * page_size.
+func (db *Database) updateSpaceUsed() {
+	size, err := db.chain.StorageSize()
+	if err != nil {
+		log.WithField("db", db.dbID).WithError(err).Warning("failed to get storage size")
+		return
+	}
+	atomic.StoreUint64(&db.spaceUsed, size)
+}
+
+// touch marks the database as active.
+func (db *Database) touch() {
+	atomic.StoreInt64(&db.lastActive, time.Now().UnixNano())
+}
+
+// idleTime returns how long the database has been idle, i.e., without any query.
+func (db *Database) idleTime() time.Duration {
+	if atomic.LoadInt32(&db.active) > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&db.lastActive)))
+}
+
+// LocalStateHash returns the state hash of a recent block replayed on the local database.
+func (db *Database) LocalStateHash(block hash.Hash) (h hash.Hash, ok bool) {
+	return db.chain.LocalStateHash(block)
+}
+
 // Ack defines client response ack interface.
 func (db *Database) Ack(ack *types.Ack) (err error) {
 	// Just need to verify signature in db.saveAck
@@ -340,7 +644,11 @@ func (db *Database) Ack(ack *types.Ack) (err error) {
 	//	return
 	//}
 
-	return db.saveAck(&ack.Header)
+	if err = db.saveAck(&ack.Header); err != nil {
+		return
+	}
+	db.procedureCalls.Remove(ack.Header.ResponseHash)
+	return
 }
 
 // Shutdown stop database handles and stop service the database.
@@ -360,6 +668,21 @@ func (db *Database) Shutdown() (err error) {
 		db.kayakWal.Close()
 	}
 
+	if db.keys != nil {
+		// stop key rotation before chain is stopped
+		db.keys.stop()
+	}
+
+	if db.txs != nil {
+		// stop the recovery of in-doubt transaction branch
+		db.txs.stop()
+	}
+
+	if db.history != nil {
+		// stop retaining history snapshots before chain is stopped
+		db.history.stop()
+	}
+
 	if db.chain != nil {
 		// stop chain
 		if err = db.chain.Stop(); err != nil {
@@ -367,6 +690,17 @@ func (db *Database) Shutdown() (err error) {
 		}
 	}
 
+	if err = db.removeSnapshots(); err != nil {
+		return
+	}
+
+	if db.changes != nil {
+		// close change log after chain is stopped
+		if err = db.changes.close(); err != nil {
+			return
+		}
+	}
+
 	if db.connSeqEvictCh != nil {
 		// stop connection sequence evictions
 		select {
@@ -396,25 +730,29 @@ func (db *Database) Destroy() (err error) {
 
 func (db *Database) writeQuery(request *types.Request) (tracker *x.QueryTracker, response *types.Response, err error) {
 	// check database size first, wal/kayak/chain database size is not included
-	if db.cfg.SpaceLimit > 0 {
-		path := filepath.Join(db.cfg.DataDir, StorageFileName)
-		var statInfo os.FileInfo
-		if statInfo, err = os.Stat(path); err != nil {
-			if !os.IsNotExist(err) {
-				return
-			}
-		} else {
-			if uint64(statInfo.Size()) > db.cfg.SpaceLimit {
-				// rejected
-				err = ErrSpaceLimitExceeded
-				return
-			}
-		}
+	if used, limit := db.SpaceUsage(); limit > 0 && used >= limit {
+		err = errors.Wrapf(ErrSpaceLimitExceeded, "database size %d reaches quota %d", used, limit)
+		return
+	}
+
+	// the request is rejected if its deadline is exceeded before it's logged. Once logged, it's
+	// never interrupted on the deadline, as the followers must apply it identically, the time it
+	// holds the writer is bounded by the deterministic step budget of the database instead
+	var ctx = request.GetContext()
+	if deadline := request.Header.Deadline; !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
 	}
 
 	// call kayak runtime Process
 	var result interface{}
-	if result, _, err = db.kayakRuntime.Apply(request.GetContext(), request); err != nil {
+	defer db.updateSpaceUsed()
+	if result, _, err = db.kayakRuntime.Apply(ctx, request); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = errors.Wrapf(x.ErrQueryTimeout, "apply failed: %v", err)
+			return
+		}
 		err = errors.Wrap(err, "apply failed")
 		return
 	}
@@ -439,3 +777,19 @@ func (db *Database) saveAck(ackHeader *types.SignedAckHeader) (err error) {
 func getLocalTime() time.Time {
 	return time.Now().UTC()
 }
+
+// priceSchedule returns the sql chain billing price schedule configured by c, or nil for the
+// default schedule.
+func priceSchedule(c *conf.PriceSchedule) *types.PriceSchedule {
+	if c == nil {
+		return nil
+	}
+	return &types.PriceSchedule{
+		Query:           c.Query,
+		RowScanned:      c.RowScanned,
+		KiloVMStep:      c.KiloVMStep,
+		RowWritten:      c.RowWritten,
+		ResultKiB:       c.ResultKiB,
+		ExecMillisecond: c.ExecMillisecond,
+	}
+}
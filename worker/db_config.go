@@ -19,8 +19,10 @@ package worker
 import (
 	"time"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/sqlchain"
+	"github.com/CovenantSQL/CovenantSQL/types"
 )
 
 // DBConfig defines the database config.
@@ -32,11 +34,20 @@ type DBConfig struct {
 	ChainMux               *sqlchain.MuxService
 	MaxWriteTimeGap        time.Duration
 	EncryptionKey          string
+	IssuedKey              string // latest encryption key issued to the miner
+	IssuedKeyVersion       uint32 // key version of the issued key, it's rotated to if greater
 	SpaceLimit             uint64
 	UpdateBlockCount       uint64
 	LastBillingHeight      int32
 	UseEventualConsistency bool
 	ConsistencyLevel       float64
 	IsolationLevel         int
+	Extensions             types.SQLiteExtension
 	SlowQueryTime          time.Duration
+	MaxQueryTime           time.Duration // max execution time of the read queries, 0 for unlimited
+	Limits                 types.QueryLimits
+	ChangeCapture          bool
+	HistoryRetention       int        // count of the retained storage snapshots for as-of reads
+	TxResolver             TxResolver // queries the transaction branches of the other databases
+	OnStateDiverged        func(height int32, block hash.Hash)
 }
@@ -74,6 +74,18 @@ func (db *Database) Check(rawReq interface{}) (err error) {
 		return
 	}
 
+	if err = db.verifyRequest(req); err != nil {
+		return
+	}
+
+	// record sequence
+	db.recordSequence(req.Header.ConnectionID, req.Header.SeqNo)
+
+	return
+}
+
+// verifyRequest verifies the signature, time and sequence of the write request.
+func (db *Database) verifyRequest(req *types.Request) (err error) {
 	// verify signature, check time/sequence only
 	if err = req.Verify(); err != nil {
 		return
@@ -90,14 +102,7 @@ func (db *Database) Check(rawReq interface{}) (err error) {
 	}
 
 	// verify sequence
-	if err = db.verifySequence(req.Header.ConnectionID, req.Header.SeqNo); err != nil {
-		return
-	}
-
-	// record sequence
-	db.recordSequence(req.Header.ConnectionID, req.Header.SeqNo)
-
-	return
+	return db.verifySequence(req.Header.ConnectionID, req.Header.SeqNo)
 }
 
 // TrackerAndResponse defines a query tracker used by xenomint and an unsigned response.
@@ -106,7 +111,8 @@ type TrackerAndResponse struct {
 	Response *types.Response
 }
 
-// Commit implements kayak.types.Handler.Commit.
+// Commit implements kayak.types.Handler.Commit. The requests are applied one by one in the log
+// order on both leader and follower, see followerPipeline for the work done ahead on follower.
 func (db *Database) Commit(rawReq interface{}, isLeader bool) (result interface{}, err error) {
 	// convert query and check syntax
 	var (
@@ -122,6 +128,10 @@ func (db *Database) Commit(rawReq interface{}, isLeader bool) (result interface{
 
 	// reset context, commit should never be canceled
 	req.SetContext(context.Background())
+	if !isLeader {
+		// the writes applied on follower keep it from hibernation like the queries
+		defer db.touch()
+	}
 
 	// execute
 	if tracker, response, err = db.chain.Query(req, isLeader); err != nil {
@@ -17,15 +17,18 @@
 package worker
 
 import (
+	"bytes"
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/fortytw2/leaktest"
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
@@ -36,6 +39,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/sqlchain"
 	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
 )
 
 var rootHash = hash.Hash{}
@@ -71,6 +75,7 @@ func TestSingleDatabase(t *testing.T) {
 		// create file
 		cfg := &DBConfig{
 			DatabaseID:       "00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9",
+			RootDir:          rootDir,
 			DataDir:          rootDir,
 			KayakMux:         kayakMuxService,
 			ChainMux:         chainMuxService,
@@ -228,6 +233,92 @@ func TestSingleDatabase(t *testing.T) {
 			So(err, ShouldBeNil)
 		})
 
+		Convey("test storage quota", func() {
+			var writeQuery *types.Request
+			writeQuery, err = buildQuery(types.WriteQuery, 1, 1, []string{
+				"create table test (test int)",
+				"insert into test values(1)",
+			})
+			So(err, ShouldBeNil)
+			_, err = db.Query(writeQuery)
+			So(err, ShouldBeNil)
+
+			used, limit := db.SpaceUsage()
+			So(used, ShouldBeGreaterThan, 0)
+			So(limit, ShouldEqual, 0)
+
+			// writes are rejected once the database reaches the quota
+			db.SetSpaceLimit(used)
+			writeQuery, err = buildQuery(types.WriteQuery, 1, 2, []string{
+				"insert into test values(2)",
+			})
+			So(err, ShouldBeNil)
+			_, err = db.Query(writeQuery)
+			So(errors.Cause(err), ShouldEqual, ErrSpaceLimitExceeded)
+
+			// raised quota accepts writes again
+			db.SetSpaceLimit(used * 2)
+			_, err = db.Query(writeQuery)
+			So(err, ShouldBeNil)
+
+			err = db.Shutdown()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("test storage snapshot", func() {
+			var writeQuery *types.Request
+			writeQuery, err = buildQuery(types.WriteQuery, 1, 1, []string{
+				"create table test (test int)",
+				"insert into test values(1)",
+			})
+			So(err, ShouldBeNil)
+			_, err = db.Query(writeQuery)
+			So(err, ShouldBeNil)
+
+			_, err = db.fetchSnapshot("", 1<<30)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidRequest)
+			_, err = db.fetchSnapshot("snapshot-unknown.db3", 0)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidRequest)
+			// each fetcher reads its own snapshot
+			res, err := db.fetchSnapshot("", 0)
+			So(err, ShouldBeNil)
+			other, err := db.fetchSnapshot("", 0)
+			So(err, ShouldBeNil)
+			So(other.Snapshot, ShouldNotEqual, res.Snapshot)
+			So(other.Key, ShouldNotEqual, res.Key)
+			So(res.Seq, ShouldEqual, 2)
+			So(res.Size, ShouldEqual, len(res.Data))
+			// snapshot files are removed once fully read
+			snaps, err := filepath.Glob(filepath.Join(db.cfg.DataDir, SnapshotFilePattern))
+			So(err, ShouldBeNil)
+			So(snaps, ShouldBeEmpty)
+			_, err = db.fetchSnapshot(res.Snapshot, 0)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidRequest)
+
+			var (
+				snapFile = filepath.Join(rootDir, "restored.db3")
+				strg     *xs.SQLite3
+				count    int
+				dsn      string
+			)
+			// snapshot is encrypted with its own key
+			So(bytes.HasPrefix(res.Data, []byte("SQLite format 3")), ShouldBeFalse)
+			err = ioutil.WriteFile(snapFile, res.Data, 0600)
+			So(err, ShouldBeNil)
+			dsn, err = snapshotDSN(snapFile, res.Key)
+			So(err, ShouldBeNil)
+			strg, err = xs.NewSqlite(dsn)
+			So(err, ShouldBeNil)
+			err = strg.Reader().QueryRow("select count(1) from test").Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+			err = strg.Close()
+			So(err, ShouldBeNil)
+
+			err = db.Shutdown()
+			So(err, ShouldBeNil)
+		})
+
 		Convey("test invalid request", func() {
 			var writeQuery *types.Request
 			var res *types.Response
@@ -410,6 +501,7 @@ func TestInitFailed(t *testing.T) {
 		// create file
 		cfg := &DBConfig{
 			DatabaseID:       "00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9",
+			RootDir:          rootDir,
 			DataDir:          rootDir,
 			KayakMux:         kayakMuxService,
 			ChainMux:         chainMuxService,
@@ -431,6 +523,140 @@ func TestInitFailed(t *testing.T) {
 	})
 }
 
+func TestDatabaseHistory(t *testing.T) {
+	Convey("test read as of height", t, func() {
+		var err error
+		var server *rpc.Server
+		var cleanup func()
+		cleanup, server, err = initNode()
+		So(err, ShouldBeNil)
+		defer cleanup()
+
+		var rootDir string
+		rootDir, err = ioutil.TempDir("", "db_test_")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(rootDir)
+
+		var interval, check = HistorySnapshotInterval, HistoryCheckInterval
+		HistorySnapshotInterval, HistoryCheckInterval = 1, 100*time.Millisecond
+		defer func() { HistorySnapshotInterval, HistoryCheckInterval = interval, check }()
+
+		kayakMuxService, err := NewDBKayakMuxService("DBKayak", server)
+		So(err, ShouldBeNil)
+		chainMuxService, err := sqlchain.NewMuxService("sqlchain", server)
+		So(err, ShouldBeNil)
+		var peers *proto.Peers
+		peers, err = getPeers(1)
+		So(err, ShouldBeNil)
+		cfg := &DBConfig{
+			DatabaseID:       "00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9",
+			RootDir:          rootDir,
+			DataDir:          rootDir,
+			KayakMux:         kayakMuxService,
+			ChainMux:         chainMuxService,
+			MaxWriteTimeGap:  time.Second * 5,
+			UpdateBlockCount: 2,
+			HistoryRetention: 100,
+		}
+		var block *types.Block
+		block, err = types.CreateRandomBlock(rootHash, true)
+		So(err, ShouldBeNil)
+		var db *Database
+		db, err = NewDatabase(cfg, peers, block)
+		So(err, ShouldBeNil)
+		defer db.Shutdown()
+
+		var (
+			nextBlock = func(height int32) int32 {
+				for {
+					if h, _ := db.chain.Head(); h > height {
+						return h
+					}
+					time.Sleep(100 * time.Millisecond)
+				}
+			}
+			write = func(seqNo uint64, queries ...string) {
+				var q, err = buildQuery(types.WriteQuery, 1, seqNo, queries)
+				So(err, ShouldBeNil)
+				_, err = db.Query(q)
+				So(err, ShouldBeNil)
+			}
+			readAsOf = func(height int32, seqNo uint64) (res *types.Response, err error) {
+				var q *types.Request
+				if q, err = buildQuery(types.ReadQuery, 1, seqNo, []string{
+					"select count(1) from test",
+				}); err != nil {
+					return
+				}
+				q.Header.AsOfHeight = height
+				return db.Query(q)
+			}
+			retained = func(height int32) {
+				for {
+					db.history.RLock()
+					var n = len(db.history.snapshots)
+					if n > 0 && db.history.snapshots[n-1].Height >= height {
+						So(db.history.snapshots[0].Count, ShouldEqual, 0)
+						db.history.RUnlock()
+						return
+					}
+					db.history.RUnlock()
+					time.Sleep(100 * time.Millisecond)
+				}
+			}
+			res *types.Response
+		)
+
+		// blocks are produced for the periods with queries only, and the table is created after
+		// the first block, as the height 0 is taken as the current height
+		h0, _ := db.chain.Head()
+		write(1, "create table other (test int)")
+		h0 = nextBlock(h0)
+		write(2, "create table test (test int)", "insert into test values(1)")
+		h1 := nextBlock(h0)
+		write(3, "insert into test values(2)")
+		h2 := nextBlock(h1)
+		retained(h2)
+
+		// all the heights are retained while the chain is shorter than the retention
+		_, err = readAsOf(h1-1, 4)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no such table")
+		res, err = readAsOf(h1, 5)
+		So(err, ShouldBeNil)
+		So(res.Payload.Rows[0].Values[0], ShouldEqual, 1)
+		So(res.Header.Heights, ShouldHaveLength, 1)
+		So(res.Header.Heights[0].Height, ShouldEqual, h1)
+		So(res.Header.Heights[0].LogOffset, ShouldEqual, 3)
+		res, err = readAsOf(h2, 6)
+		So(err, ShouldBeNil)
+		So(res.Payload.Rows[0].Values[0], ShouldEqual, 2)
+		So(res.Header.Heights[0].LogOffset, ShouldEqual, 4)
+
+		// heights beyond head are rejected
+		_, err = readAsOf(h2+1000, 7)
+		So(errors.Cause(err), ShouldEqual, ErrHeightNotReached)
+
+		// as-of height is not allowed on write query
+		var q *types.Request
+		q, err = buildQuery(types.WriteQuery, 1, 8, []string{"insert into test values(3)"})
+		So(err, ShouldBeNil)
+		q.Header.AsOfHeight = h2
+		_, err = db.Query(q)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidRequest)
+
+		// heights before the oldest retained snapshot are rejected
+		db.history.Lock()
+		db.history.pruneLocked(len(db.history.snapshots) - 1)
+		db.history.Unlock()
+		_, err = readAsOf(h1, 9)
+		So(errors.Cause(err), ShouldEqual, ErrHeightNotRetained)
+		res, err = readAsOf(h2, 10)
+		So(err, ShouldBeNil)
+		So(res.Payload.Rows[0].Values[0], ShouldEqual, 2)
+	})
+}
+
 func TestDatabaseRecycle(t *testing.T) {
 	defer leaktest.Check(t)()
 	defer kms.ClosePublicKeyStore()
@@ -449,6 +675,7 @@ func TestDatabaseRecycle(t *testing.T) {
 		var rootDir string
 		rootDir, err = ioutil.TempDir("", "db_test_")
 		So(err, ShouldBeNil)
+		defer os.RemoveAll(rootDir)
 
 		// create mux service
 		kayakMuxService, err := NewDBKayakMuxService("DBKayak", server)
@@ -465,6 +692,7 @@ func TestDatabaseRecycle(t *testing.T) {
 		// create file
 		cfg := &DBConfig{
 			DatabaseID:       "00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9",
+			RootDir:          rootDir,
 			DataDir:          rootDir,
 			KayakMux:         kayakMuxService,
 			ChainMux:         chainMuxService,
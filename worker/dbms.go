@@ -32,13 +32,16 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/conf"
 	"github.com/CovenantSQL/CovenantSQL/crypto"
 	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/crypto/kms"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/rpc/mux"
 	"github.com/CovenantSQL/CovenantSQL/sqlchain"
 	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
 )
 
 const (
@@ -51,15 +54,27 @@ const (
 	// DefaultSlowQueryTime defines the default slow query log time
 	DefaultSlowQueryTime = time.Second * 5
 
-	mwMinerDBCount = "service:miner:db:count"
+	mwMinerDBCount        = "service:miner:db:count"
+	mwMinerDBHibernated   = "service:miner:db:hibernated"
+	mwMinerDBHibernations = "service:miner:db:hibernations"
+	mwMinerDBWakeups      = "service:miner:db:wakeups"
+	mwMinerDBReadiness    = "service:miner:db:readiness"
 )
 
 var (
-	dbCount = new(expvar.Int)
+	dbCount        = new(expvar.Int)
+	dbHibernated   = new(expvar.Int)
+	dbHibernations = new(expvar.Int)
+	dbWakeups      = new(expvar.Int)
+	dbReadiness    = new(expvar.Map)
 )
 
 func init() {
 	expvar.Publish(mwMinerDBCount, dbCount)
+	expvar.Publish(mwMinerDBHibernated, dbHibernated)
+	expvar.Publish(mwMinerDBHibernations, dbHibernations)
+	expvar.Publish(mwMinerDBWakeups, dbWakeups)
+	expvar.Publish(mwMinerDBReadiness, dbReadiness)
 }
 
 // DBMS defines a database management instance.
@@ -72,12 +87,22 @@ type DBMS struct {
 	busService *BusService
 	address    proto.AccountAddress
 	privKey    *asymmetric.PrivateKey
+	joining    sync.Map // databases being restored from snapshot
+
+	instances  sync.Map // service instances of the databases to reopen the hibernated ones
+	hibernated sync.Map // databases closed down to their on-disk state while idle
+	transits   sync.Map // locks of the databases being hibernated or woken up
+	loading    sync.Map // databases being opened on startup
+	failed     sync.Map // databases failed to open on startup
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
 }
 
 // NewDBMS returns new database management instance.
 func NewDBMS(cfg *DBMSConfig) (dbms *DBMS, err error) {
 	dbms = &DBMS{
-		cfg: cfg,
+		cfg:    cfg,
+		stopCh: make(chan struct{}),
 	}
 
 	// init kayak rpc mux
@@ -92,6 +117,10 @@ func NewDBMS(cfg *DBMSConfig) (dbms *DBMS, err error) {
 		return
 	}
 
+	// reopen the hibernated databases on the replicated writes and blocks
+	dbms.kayakMux.wake = dbms.wake
+	dbms.chainMux.SetWakeFunc(dbms.wake)
+
 	// cache address of node
 	var (
 		pk   *asymmetric.PublicKey
@@ -159,6 +188,10 @@ func (dbms *DBMS) writeMeta() (err error) {
 		meta.DBS[dbID] = true
 		return true
 	})
+	dbms.hibernated.Range(func(key, value interface{}) bool {
+		meta.DBS[key.(proto.DatabaseID)] = true
+		return true
+	})
 
 	var buf *bytes.Buffer
 	if buf, err = utils.EncodeMsgPack(meta); err != nil {
@@ -197,8 +230,21 @@ func (dbms *DBMS) Init() (err error) {
 		err = errors.Wrap(err, "init chain bus failed")
 		return
 	}
+	if err = dbms.busService.Subscribe("/IssueKeys/", dbms.issueKeys); err != nil {
+		err = errors.Wrap(err, "init chain bus failed")
+		return
+	}
+	if err = dbms.busService.Subscribe("/UpdateQuota/", dbms.updateQuota); err != nil {
+		err = errors.Wrap(err, "init chain bus failed")
+		return
+	}
 	dbms.busService.Start()
 
+	if dbms.cfg.IdleTimeout > 0 {
+		dbms.wg.Add(1)
+		go dbms.hibernateIdle()
+	}
+
 	return
 }
 
@@ -218,19 +264,145 @@ func (dbms *DBMS) updateBilling(itx interfaces.Transaction, count uint32) {
 		id       = tx.Receiver.DatabaseID()
 		profile  *types.SQLChainProfile
 		database *Database
+		instance *types.ServiceInstance
+		exists   bool
+		err      error
 	)
 	le := log.WithFields(log.Fields{
 		"id": id,
 	})
-	if database, ok = dbms.getMeta(id); !ok {
-		le.Warn("cannot find database")
+	database, exists = dbms.getMeta(id)
+	_, hibernated := dbms.hibernated.Load(id)
+	if _, loading := dbms.loading.Load(id); loading {
+		// the profile is applied once the database is loaded
 		return
 	}
 	if profile, ok = dbms.busService.RequestSQLProfile(id); !ok {
+		if exists || hibernated {
+			// this miner is replaced by the block producer
+			le.Info("miner is removed from database, drop database")
+			if err = dbms.Drop(id); err != nil {
+				le.WithError(err).Error("failed to drop database")
+			}
+			return
+		}
 		le.Warn("cannot find profile")
 		return
 	}
+	if hibernated {
+		// the billing height is loaded from profile on wakeup
+		if instance, err = dbms.buildSQLChainServiceInstance(profile); err != nil {
+			le.WithError(err).Warn("failed to build database instance")
+			return
+		}
+		if err = dbms.Update(instance); err != nil {
+			le.WithError(err).Error("failed to update hibernated database")
+		}
+		return
+	}
+	if !exists {
+		// this miner is newly assigned to replace an offline miner
+		go dbms.joinDatabase(profile, nil)
+		return
+	}
 	database.chain.SetLastBillingHeight(int32(profile.LastUpdatedHeight))
+
+	// update peers if the miners are reassigned
+	if instance, err = dbms.buildSQLChainServiceInstance(profile); err != nil {
+		le.WithError(err).Warn("failed to build database instance")
+		return
+	}
+	if !isPeersEqual(database.chain.Peers(), instance.Peers) {
+		le.Info("update peers of database")
+		if err = dbms.Update(instance); err != nil {
+			le.WithError(err).Error("failed to update peers")
+		}
+	}
+}
+
+// isPeersEqual returns whether the two peers have the same leader and servers.
+func isPeersEqual(a, b *proto.Peers) bool {
+	if a.Leader != b.Leader || len(a.Servers) != len(b.Servers) {
+		return false
+	}
+	for i := range a.Servers {
+		if a.Servers[i] != b.Servers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (dbms *DBMS) issueKeys(itx interfaces.Transaction, count uint32) {
+	var (
+		tx *types.IssueKeys
+		ok bool
+	)
+	if tx, ok = itx.(*types.IssueKeys); !ok {
+		log.WithFields(log.Fields{
+			"type": itx.GetTransactionType(),
+		}).WithError(ErrInvalidTransactionType).Warn("invalid tx type in issue keys")
+		return
+	}
+	var (
+		id       = tx.TargetSQLChain.DatabaseID()
+		profile  *types.SQLChainProfile
+		database *Database
+	)
+	le := log.WithFields(log.Fields{
+		"id": id,
+	})
+	if database, ok = dbms.getMeta(id); !ok {
+		le.Debug("cannot find database")
+		return
+	}
+	if profile, ok = dbms.busService.RequestSQLProfile(id); !ok {
+		le.Warn("cannot find profile")
+		return
+	}
+	// rotate to the key issued to this miner, profile is always updated before the tx event
+	for _, miner := range profile.Miners {
+		if miner.Address == dbms.address {
+			database.RotateKey(miner.KeyVersion, miner.EncryptionKey)
+			break
+		}
+	}
+}
+
+func (dbms *DBMS) updateQuota(itx interfaces.Transaction, count uint32) {
+	var (
+		tx *types.UpdateQuota
+		ok bool
+	)
+	if tx, ok = itx.(*types.UpdateQuota); !ok {
+		log.WithFields(log.Fields{
+			"type": itx.GetTransactionType(),
+		}).WithError(ErrInvalidTransactionType).Warn("invalid tx type in update quota")
+		return
+	}
+	var (
+		id       = tx.TargetSQLChain.DatabaseID()
+		profile  *types.SQLChainProfile
+		database *Database
+	)
+	le := log.WithFields(log.Fields{
+		"id": id,
+	})
+	if profile, ok = dbms.busService.RequestSQLProfile(id); !ok {
+		le.Warn("cannot find profile")
+		return
+	}
+	if dbms.setHibernatedSpaceLimit(id, profile.Meta.Space) {
+		le.WithField("space", profile.Meta.Space).Info("storage quota of hibernated database updated")
+		return
+	}
+	if database, ok = dbms.getMeta(id); !ok {
+		le.Debug("cannot find database")
+		return
+	}
+	// the quota in profile is always updated before the tx event
+	database.SetSpaceLimit(profile.Meta.Space)
+	le.WithField("space", profile.Meta.Space).Info("storage quota updated")
 }
 
 func (dbms *DBMS) createDatabase(tx interfaces.Transaction, count uint32) {
@@ -371,7 +543,7 @@ func (dbms *DBMS) initDatabases(
 	meta *DBMSMeta, profiles map[proto.DatabaseID]*types.SQLChainProfile) (err error,
 ) {
 	currentInstance := make(map[proto.DatabaseID]bool)
-	wg := &sync.WaitGroup{}
+	instances := make([]*types.ServiceInstance, 0, len(profiles))
 
 	for id, profile := range profiles {
 		currentInstance[id] = true
@@ -379,17 +551,8 @@ func (dbms *DBMS) initDatabases(
 		if instance, err = dbms.buildSQLChainServiceInstance(profile); err != nil {
 			return
 		}
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := dbms.Create(instance, false); err != nil {
-				log.WithFields(log.Fields{
-					"id": instance.DatabaseID,
-				}).WithError(err).Error("failed to create database instance")
-			}
-		}()
+		instances = append(instances, instance)
 	}
-	wg.Wait()
 
 	// calculate to drop databases
 	toDropInstance := make(map[proto.DatabaseID]bool)
@@ -407,11 +570,38 @@ func (dbms *DBMS) initDatabases(
 		}
 	}
 
+	// open databases in background, the loaded ones are served without waiting for the others
+	dbms.loadDatabases(instances)
+
 	return
 }
 
 // Create add new database to the miner dbms.
 func (dbms *DBMS) Create(instance *types.ServiceInstance, cleanup bool) (err error) {
+	if _, hibernated := dbms.hibernated.Load(instance.DatabaseID); hibernated {
+		return ErrAlreadyExists
+	}
+	if _, loading := dbms.loading.Load(instance.DatabaseID); loading {
+		return ErrAlreadyExists
+	}
+	return dbms.create(instance, cleanup)
+}
+
+func (dbms *DBMS) create(instance *types.ServiceInstance, cleanup bool) (err error) {
+	if err = dbms.open(instance, cleanup); err != nil {
+		return
+	}
+	dbms.failed.Delete(instance.DatabaseID)
+	setReadiness(instance.DatabaseID, DatabaseReady)
+
+	// update metrics
+	dbCount.Add(1)
+
+	return
+}
+
+// open starts the database instance, it's called on creation or wakeup of the database.
+func (dbms *DBMS) open(instance *types.ServiceInstance, cleanup bool) (err error) {
 	if _, alreadyExists := dbms.getMeta(instance.DatabaseID); alreadyExists {
 		return ErrAlreadyExists
 	}
@@ -453,12 +643,32 @@ func (dbms *DBMS) Create(instance *types.ServiceInstance, cleanup bool) (err err
 		UseEventualConsistency: instance.ResourceMeta.UseEventualConsistency,
 		ConsistencyLevel:       instance.ResourceMeta.ConsistencyLevel,
 		IsolationLevel:         instance.ResourceMeta.IsolationLevel,
+		Extensions:             instance.ResourceMeta.AllowedExtensions(),
 		SlowQueryTime:          DefaultSlowQueryTime,
+		MaxQueryTime:           dbms.maxQueryTime(&instance.ResourceMeta),
+		Limits:                 instance.ResourceMeta.Limits,
+		ChangeCapture:          dbms.cfg.ChangeCapture,
+		HistoryRetention:       dbms.cfg.HistoryRetention,
+		TxResolver:             dbms.txStatus,
+	}
+	if dbms.cfg.ResyncOnDivergence {
+		var dbID = instance.DatabaseID
+		dbCfg.OnStateDiverged = func(height int32, block hash.Hash) {
+			// called by the chain routine, which is stopped by the resync
+			go dbms.resyncDatabase(dbID, height, block)
+		}
 	}
 
-	// set last billing height
+	// set last billing height and issued key
 	if profile, ok := dbms.busService.RequestSQLProfile(dbCfg.DatabaseID); ok {
 		dbCfg.LastBillingHeight = int32(profile.LastUpdatedHeight)
+		for _, miner := range profile.Miners {
+			if miner.Address == dbms.address {
+				dbCfg.IssuedKey = miner.EncryptionKey
+				dbCfg.IssuedKeyVersion = miner.KeyVersion
+				break
+			}
+		}
 	}
 
 	if db, err = NewDatabase(dbCfg, instance.Peers, instance.GenesisBlock); err != nil {
@@ -466,11 +676,21 @@ func (dbms *DBMS) Create(instance *types.ServiceInstance, cleanup bool) (err err
 	}
 
 	// add to meta
-	err = dbms.addMeta(instance.DatabaseID, db)
+	if err = dbms.addMeta(instance.DatabaseID, db); err != nil {
+		return
+	}
+	dbms.instances.Store(instance.DatabaseID, instance)
 
-	// update metrics
-	dbCount.Add(1)
+	return
+}
 
+// maxQueryTime returns the max execution time of the read queries on the database, which is set in
+// its resource meta and capped by the miner config.
+func (dbms *DBMS) maxQueryTime(meta *types.ResourceMeta) (d time.Duration) {
+	d = time.Duration(meta.MaxQueryMillisecond) * time.Millisecond
+	if max := dbms.cfg.MaxQueryTime; max > 0 && (d <= 0 || d > max) {
+		d = max
+	}
 	return
 }
 
@@ -479,17 +699,40 @@ func (dbms *DBMS) Drop(dbID proto.DatabaseID) (err error) {
 	var db *Database
 	var exists bool
 
-	if db, exists = dbms.getMeta(dbID); !exists {
-		return ErrNotExists
-	}
+	defer dbms.transit(dbID)()
 
-	// shutdown database
-	if err = db.Destroy(); err != nil {
+	if _, failed := dbms.failed.Load(dbID); failed {
+		// the database is never opened on startup
+		if err = os.RemoveAll(filepath.Join(dbms.cfg.RootDir, string(dbID))); err != nil {
+			return
+		}
+		dbms.failed.Delete(dbID)
+		dbms.instances.Delete(dbID)
+		dbReadiness.Delete(string(dbID))
 		return
 	}
+	if _, hibernated := dbms.hibernated.Load(dbID); hibernated {
+		// the database is closed already
+		if err = os.RemoveAll(filepath.Join(dbms.cfg.RootDir, string(dbID))); err != nil {
+			return
+		}
+		dbms.hibernated.Delete(dbID)
+		dbHibernated.Add(-1)
+	} else {
+		if db, exists = dbms.getMeta(dbID); !exists {
+			return ErrNotExists
+		}
+
+		// shutdown database
+		if err = db.Destroy(); err != nil {
+			return
+		}
+	}
+	dbms.instances.Delete(dbID)
 
 	// update metrics
 	dbCount.Add(-1)
+	dbReadiness.Delete(string(dbID))
 
 	// remove meta
 	return dbms.removeMeta(dbID)
@@ -500,18 +743,29 @@ func (dbms *DBMS) Update(instance *types.ServiceInstance) (err error) {
 	var db *Database
 	var exists bool
 
+	defer dbms.transit(instance.DatabaseID)()
+
+	if _, hibernated := dbms.hibernated.Load(instance.DatabaseID); hibernated {
+		// the new peers are applied on wakeup
+		dbms.instances.Store(instance.DatabaseID, instance)
+		return
+	}
+
 	if db, exists = dbms.getMeta(instance.DatabaseID); !exists {
 		return ErrNotExists
 	}
 
 	// update peers
-	return db.UpdatePeers(instance.Peers)
+	if err = db.UpdatePeers(instance.Peers); err != nil {
+		return
+	}
+	dbms.instances.Store(instance.DatabaseID, instance)
+	return
 }
 
 // Query handles query request in dbms.
 func (dbms *DBMS) Query(req *types.Request) (res *types.Response, err error) {
 	var db *Database
-	var exists bool
 
 	// check permission
 	addr, err := crypto.PubKeyHash(req.Header.Signee)
@@ -524,31 +778,371 @@ func (dbms *DBMS) Query(req *types.Request) (res *types.Response, err error) {
 	}
 
 	// find database
-	if db, exists = dbms.getMeta(req.Header.DatabaseID); !exists {
-		err = ErrNotExists
+	if db, err = dbms.getDatabase(req.Header.DatabaseID); err != nil {
 		return
 	}
 
+	// attach the databases referenced by read query
+	if req.Header.QueryType == types.ReadQuery {
+		dbms.applyUserLimits(addr, req)
+		var refs []*Database
+		if refs, err = dbms.referencedDatabases(addr, req); err != nil {
+			return
+		}
+		if len(refs) > 0 {
+			return db.QueryAttached(req, refs)
+		}
+	}
+
 	return db.Query(req)
 }
 
+// applyUserLimits overrides the query limits of the database with the ones of the requester in the
+// read request. The write requests are always executed with the limits of the database, so that
+// they are applied identically by all the replicas.
+func (dbms *DBMS) applyUserLimits(addr proto.AccountAddress, req *types.Request) {
+	var permStat, ok = dbms.busService.RequestPermStat(req.Header.DatabaseID, addr)
+	if !ok || permStat.Permission == nil || permStat.Permission.Limits.IsZero() {
+		return
+	}
+	req.SetContext(x.WithLimits(req.GetContext(), permStat.Permission.Limits))
+}
+
+// referencedDatabases returns the local databases referenced by the read request, the requester
+// should have read permission on each of them.
+func (dbms *DBMS) referencedDatabases(
+	addr proto.AccountAddress, req *types.Request) (refs []*Database, err error,
+) {
+	var seen = map[proto.DatabaseID]bool{req.Header.DatabaseID: true}
+	for _, q := range req.Payload.Queries {
+		var names []string
+		if names, err = x.ReferencedDatabases(q.Pattern); err != nil {
+			return
+		}
+		for _, name := range names {
+			var (
+				dbID = proto.DatabaseID(name)
+				db   *Database
+			)
+			if seen[dbID] {
+				continue
+			}
+			seen[dbID] = true
+			if err = dbms.checkPermission(addr, dbID, types.ReadQuery, req.Payload.Queries); err != nil {
+				err = errors.Wrapf(err, "check permission of database %s", dbID)
+				return
+			}
+			if db, err = dbms.getDatabase(dbID); err != nil {
+				err = errors.Wrapf(err, "database %s is not available on this miner", dbID)
+				return
+			}
+			refs = append(refs, db)
+		}
+	}
+	return
+}
+
+// QueryStats handles query statistics request in dbms.
+func (dbms *DBMS) QueryStats(req *types.QueryStatsRequest) (res *types.QueryStatsResponse, err error) {
+	var (
+		db       *Database
+		addr     proto.AccountAddress
+		permStat *types.PermStat
+		ok       bool
+	)
+
+	if err = req.Verify(); err != nil {
+		return
+	}
+	if addr, err = crypto.PubKeyHash(req.Header.Signee); err != nil {
+		return
+	}
+
+	// check permission, only users with super permission could read or reset the statistics, as
+	// the query patterns of all the users are collected
+	if permStat, ok = dbms.busService.RequestPermStat(req.Header.DatabaseID, addr); !ok {
+		err = errors.Wrap(ErrPermissionDeny, "database not exists")
+		return
+	}
+	if !permStat.Permission.HasSuperPermission() {
+		err = errors.Wrapf(ErrPermissionDeny, "cannot read stats, permission: %v", permStat.Permission)
+		return
+	}
+
+	// find database
+	if db, err = dbms.getDatabase(req.Header.DatabaseID); err != nil {
+		return
+	}
+
+	res = &types.QueryStatsResponse{NodeID: db.nodeID}
+	res.Since, res.Stats = db.QueryStats(req.Header.Reset)
+	return
+}
+
+// FetchChanges returns the row changes captured on a database to subscribers.
+func (dbms *DBMS) FetchChanges(req *types.ChangesRequest) (res *types.ChangesResponse, err error) {
+	var (
+		db       *Database
+		addr     proto.AccountAddress
+		permStat *types.PermStat
+		ok       bool
+	)
+
+	if err = req.Verify(); err != nil {
+		return
+	}
+	if addr, err = crypto.PubKeyHash(req.Header.Signee); err != nil {
+		return
+	}
+
+	// check permission
+	if permStat, ok = dbms.busService.RequestPermStat(req.Header.DatabaseID, addr); !ok {
+		err = errors.Wrap(ErrPermissionDeny, "database not exists")
+		return
+	}
+	if !permStat.Permission.HasReadPermission() {
+		err = errors.Wrapf(ErrPermissionDeny, "cannot read, permission: %v", permStat.Permission)
+		return
+	}
+
+	// find database
+	if db, err = dbms.getDatabase(req.Header.DatabaseID); err != nil {
+		return
+	}
+
+	res = &types.ChangesResponse{NodeID: db.nodeID}
+	res.Changes, res.Next, err = db.FetchChanges(
+		context.Background(), req.Header.Since, int(req.Header.Limit), ChangesFetchWait)
+	return
+}
+
+// FetchSnapshot reads a chunk of the storage snapshot of the database for its new miner.
+func (dbms *DBMS) FetchSnapshot(req *FetchSnapshotReq) (res *FetchSnapshotResp, err error) {
+	var db *Database
+	if db, err = dbms.getDatabaseForMiner(req.GetNodeID(), req.DatabaseID); err != nil {
+		return
+	}
+	return db.fetchSnapshot(req.Snapshot, req.Offset)
+}
+
+// StateHash returns the state hash of a recent block replayed on the local database.
+func (dbms *DBMS) StateHash(req *StateHashReq) (res *StateHashResp, err error) {
+	var db *Database
+	if db, err = dbms.getDatabaseForMiner(req.GetNodeID(), req.DatabaseID); err != nil {
+		return
+	}
+	res = &StateHashResp{}
+	res.StateHash, res.Found = db.LocalStateHash(req.Block)
+	return
+}
+
+// getDatabaseForMiner returns the database of dbID if nodeID is one of its miners.
+func (dbms *DBMS) getDatabaseForMiner(
+	nodeID *proto.RawNodeID, dbID proto.DatabaseID) (db *Database, err error,
+) {
+	var (
+		profile   *types.SQLChainProfile
+		ok        bool
+		permitted bool
+	)
+	if nodeID == nil {
+		err = errors.Wrap(ErrPermissionDeny, "unknown node")
+		return
+	}
+	if profile, ok = dbms.busService.RequestSQLProfile(dbID); !ok {
+		err = ErrNotExists
+		return
+	}
+	for _, miner := range profile.Miners {
+		if miner.NodeID == nodeID.ToNodeID() {
+			permitted = true
+			break
+		}
+	}
+	if !permitted {
+		err = errors.Wrapf(ErrPermissionDeny, "node %s is not miner of database", nodeID)
+		return
+	}
+	return dbms.getDatabase(dbID)
+}
+
+// KeyRotationStatus returns the encryption key rotation status of a database on this miner.
+func (dbms *DBMS) KeyRotationStatus(
+	req *types.KeyRotationStatusRequest) (res *types.KeyRotationStatusResponse, err error,
+) {
+	var (
+		db       *Database
+		addr     proto.AccountAddress
+		permStat *types.PermStat
+		ok       bool
+	)
+
+	if err = req.Verify(); err != nil {
+		return
+	}
+	if addr, err = crypto.PubKeyHash(req.Header.Signee); err != nil {
+		return
+	}
+
+	// check permission
+	if permStat, ok = dbms.busService.RequestPermStat(req.Header.DatabaseID, addr); !ok {
+		err = errors.Wrap(ErrPermissionDeny, "database not exists")
+		return
+	}
+	if !permStat.Permission.HasSuperPermission() {
+		err = errors.Wrapf(ErrPermissionDeny, "not super user, permission: %v", permStat.Permission)
+		return
+	}
+
+	// find database
+	if db, err = dbms.getDatabase(req.Header.DatabaseID); err != nil {
+		return
+	}
+
+	res = &types.KeyRotationStatusResponse{
+		NodeID: db.nodeID,
+		Status: db.KeyRotationStatus(),
+	}
+	return
+}
+
+// Tx handles multi-database transaction request in dbms, the branch of the database is prepared,
+// committed or rolled back by the writer of the database, and its state can be queried by anyone.
+func (dbms *DBMS) Tx(req *types.TxRequest) (res *types.TxResponse, err error) {
+	var (
+		db   *Database
+		addr proto.AccountAddress
+		w    *TxWorker
+		h    = &req.Header.TxRequestHeader
+	)
+
+	if err = req.Verify(); err != nil {
+		return
+	}
+	if addr, err = crypto.PubKeyHash(req.Header.Signee); err != nil {
+		return
+	}
+
+	// check permission
+	switch h.Op {
+	case types.TxPrepare:
+		if req.Request == nil || !req.Request.Header.Signee.IsEqual(req.Header.Signee) {
+			err = errors.Wrap(ErrInvalidRequest, "write request is not signed by the requester")
+			return
+		}
+		err = dbms.checkPermission(addr, h.DatabaseID, types.WriteQuery, req.Request.Payload.Queries)
+	case types.TxCommit, types.TxRollback:
+		err = dbms.checkPermission(addr, h.DatabaseID, types.WriteQuery, nil)
+	case types.TxStatus:
+	default:
+		err = errors.Wrap(ErrInvalidRequest, "invalid transaction operation")
+	}
+	if err != nil {
+		return
+	}
+
+	// find database
+	if db, err = dbms.getDatabase(h.DatabaseID); err != nil {
+		return
+	}
+
+	w = NewTxWorker(db, req.Request)
+	res = &types.TxResponse{}
+	switch h.Op {
+	case types.TxPrepare:
+		if err = w.Prepare(context.Background(), h); err == nil {
+			res.State = types.TxPrepared
+		}
+	case types.TxCommit:
+		var result interface{}
+		if result, err = w.Commit(context.Background(), h); err == nil {
+			res.State = types.TxCommitted
+			res.Response = result.(*types.Response)
+		}
+	case types.TxRollback:
+		if err = w.Rollback(context.Background(), h); err == nil {
+			res.State = types.TxRolledBack
+		}
+	case types.TxStatus:
+		res = db.TxStatus(h.TxID)
+	}
+	if err != nil {
+		res = nil
+	}
+	return
+}
+
+// txStatus queries the state of the multi-database transaction branch from the leader of
+// database dbID, the branches are only prepared on the leader.
+func (dbms *DBMS) txStatus(dbID proto.DatabaseID, txID hash.Hash) (res *types.TxResponse, err error) {
+	var (
+		db     *Database
+		leader proto.NodeID
+		req    = &types.TxRequest{
+			Header: types.SignedTxRequestHeader{
+				TxRequestHeader: types.TxRequestHeader{
+					TxID:       txID,
+					Op:         types.TxStatus,
+					DatabaseID: dbID,
+					Timestamp:  getLocalTime(),
+				},
+			},
+		}
+	)
+	if db, err = dbms.getDatabase(dbID); err == nil {
+		if leader = db.chain.Peers().Leader; leader == db.nodeID {
+			return db.TxStatus(txID), nil
+		}
+	} else if err != ErrNotExists {
+		return
+	} else {
+		var (
+			profileReq  = &types.QuerySQLChainProfileReq{DBID: dbID}
+			profileResp = &types.QuerySQLChainProfileResp{}
+		)
+		if err = mux.RequestBP(
+			route.MCCQuerySQLChainProfile.String(), profileReq, profileResp,
+		); err != nil {
+			err = errors.Wrap(err, "query sqlchain profile")
+			return
+		}
+		if len(profileResp.Profile.Miners) == 0 {
+			err = errors.Wrapf(ErrNotExists, "no miner of database %s", dbID)
+			return
+		}
+		leader = profileResp.Profile.Miners[0].NodeID
+	}
+	if err = req.Sign(dbms.privKey); err != nil {
+		return
+	}
+	res = &types.TxResponse{}
+	if err = mux.NewCaller().CallNode(leader, route.DBSTx.String(), req, res); err != nil {
+		res = nil
+	}
+	return
+}
+
 // Ack handles ack of previous response.
 func (dbms *DBMS) Ack(ack *types.Ack) (err error) {
 	var db *Database
-	var exists bool
 
 	// check permission
 	addr, err := crypto.PubKeyHash(ack.Header.Signee)
 	if err != nil {
 		return
 	}
-	err = dbms.checkPermission(addr, ack.Header.Response.Request.DatabaseID, types.ReadQuery, nil)
+	var dbID = ack.Header.Response.Request.DatabaseID
+	err = dbms.checkPermission(addr, dbID, types.ReadQuery, nil)
+	if errors.Cause(err) == ErrPermissionDeny {
+		// the responses of the stored procedure calls are acknowledged by the callers, who may
+		// only have the grants of the procedures
+		err = dbms.checkProcedureAck(addr, dbID, ack)
+	}
 	if err != nil {
 		return
 	}
 	// find database
-	if db, exists = dbms.getMeta(ack.Header.Response.Request.DatabaseID); !exists {
-		err = ErrNotExists
+	if db, err = dbms.getDatabase(dbID); err != nil {
 		return
 	}
 
@@ -556,6 +1150,31 @@ func (dbms *DBMS) Ack(ack *types.Ack) (err error) {
 	return db.Ack(ack)
 }
 
+// checkProcedureAck checks that the acked response is of a request sent by addr, which only calls
+// the stored procedures granted to addr.
+func (dbms *DBMS) checkProcedureAck(
+	addr proto.AccountAddress, dbID proto.DatabaseID, ack *types.Ack) (err error,
+) {
+	var permStat, ok = dbms.busService.RequestPermStat(dbID, addr)
+	if !ok || !permStat.Status.EnableQuery() || permStat.Permission == nil {
+		return errors.Wrap(ErrPermissionDeny, "cannot ack")
+	}
+	var db *Database
+	if db, err = dbms.getDatabase(dbID); err != nil {
+		return
+	}
+	var caller, procedures, found = db.ProcedureCall(ack.Header.ResponseHash)
+	if !found || caller != addr {
+		return errors.Wrap(ErrPermissionDeny, "cannot ack, not a procedure call of the requester")
+	}
+	for _, name := range procedures {
+		if !permStat.Permission.HasProcedurePermission(name) {
+			return errors.Wrapf(ErrPermissionDeny, "cannot ack, cannot call procedure %s", name)
+		}
+	}
+	return
+}
+
 func (dbms *DBMS) getMeta(dbID proto.DatabaseID) (db *Database, exists bool) {
 	var rawDB interface{}
 
@@ -605,15 +1224,36 @@ func (dbms *DBMS) checkPermission(addr proto.AccountAddress,
 		return
 	}
 
+	// the stored procedure calls are permitted by the grants of the procedures, independent of
+	// the role and patterns of the user, and only the super users could register procedures
+	var others = make([]types.Query, 0, len(queries))
+	for _, q := range queries {
+		if name, ok := x.CalledProcedure(q.Pattern); ok {
+			if !permStat.Permission.HasProcedurePermission(name) {
+				err = errors.Wrapf(ErrPermissionDeny, "cannot call procedure %s", name)
+				return
+			}
+			continue
+		}
+		if queryType == types.WriteQuery && x.ReferencesProcedures(q.Pattern) &&
+			!permStat.Permission.HasSuperPermission() {
+			err = errors.Wrapf(ErrPermissionDeny,
+				"cannot write procedures, permission: %v", permStat.Permission)
+			return
+		}
+		others = append(others, q)
+	}
+	var onlyCalls = len(queries) > 0 && len(others) == 0
+
 	// check query type permission
 	switch queryType {
 	case types.ReadQuery:
-		if !permStat.Permission.HasReadPermission() {
+		if !onlyCalls && !permStat.Permission.HasReadPermission() {
 			err = errors.Wrapf(ErrPermissionDeny, "cannot read, permission: %v", permStat.Permission)
 			return
 		}
 	case types.WriteQuery:
-		if !permStat.Permission.HasWritePermission() {
+		if !onlyCalls && !permStat.Permission.HasWritePermission() {
 			err = errors.Wrapf(ErrPermissionDeny, "cannot write, permission: %v", permStat.Permission)
 			return
 		}
@@ -629,7 +1269,7 @@ func (dbms *DBMS) checkPermission(addr proto.AccountAddress,
 		hasDisallowedQuery bool
 	)
 
-	if disallowedQuery, hasDisallowedQuery = permStat.Permission.HasDisallowedQueryPatterns(queries); hasDisallowedQuery {
+	if disallowedQuery, hasDisallowedQuery = permStat.Permission.HasDisallowedQueryPatterns(others); hasDisallowedQuery {
 		err = errors.Wrapf(ErrPermissionDeny, "disallowed query %s", disallowedQuery)
 		log.WithError(err).WithFields(log.Fields{
 			"permission": permStat.Permission,
@@ -643,6 +1283,14 @@ func (dbms *DBMS) checkPermission(addr proto.AccountAddress,
 
 // Shutdown defines dbms shutdown logic.
 func (dbms *DBMS) Shutdown() (err error) {
+	// stop hibernating the idle databases
+	select {
+	case <-dbms.stopCh:
+	default:
+		close(dbms.stopCh)
+	}
+	dbms.wg.Wait()
+
 	dbms.dbMap.Range(func(_, rawDB interface{}) bool {
 		db := rawDB.(*Database)
 
@@ -34,5 +34,19 @@ type DBMSConfig struct {
 	Server           *mux.Server
 	DirectServer     *rpc.Server // optional server to provide DBMS service
 	MaxReqTimeGap    time.Duration
+	ChangeCapture    bool
+	HistoryRetention int
+	// ResyncOnDivergence restores the database from the snapshot of a peer if the local state
+	// diverges from the blocks.
+	ResyncOnDivergence bool
+	// IdleTimeout hibernates the databases without any query for the period, i.e., closes them
+	// down to their on-disk state until the next request or block, 0 disables hibernation.
+	IdleTimeout time.Duration
+	// InitConcurrency limits the number of databases opened concurrently on startup, defaults to
+	// DefaultInitConcurrency.
+	InitConcurrency int
+	// MaxQueryTime limits the execution time of the read queries on all the databases, which caps
+	// the max execution time set in the resource meta of a database, 0 for unlimited.
+	MaxQueryTime     time.Duration
 	OnCreateDatabase func()
 }
@@ -38,6 +38,7 @@ const (
 type DBKayakMuxService struct {
 	serviceName string
 	serviceMap  sync.Map
+	wake        func(id proto.DatabaseID) bool // reopens the hibernated database
 }
 
 // NewDBKayakMuxService returns a new kayak mux service.
@@ -58,14 +59,25 @@ func (s *DBKayakMuxService) unregister(id proto.DatabaseID) {
 	s.serviceMap.Delete(id)
 }
 
+func (s *DBKayakMuxService) load(id proto.DatabaseID) (rt *kayak.Runtime, ok bool) {
+	var v interface{}
+	if v, ok = s.serviceMap.Load(id); !ok && s.wake != nil && s.wake(id) {
+		v, ok = s.serviceMap.Load(id)
+	}
+	if ok {
+		rt = v.(*kayak.Runtime)
+	}
+	return
+}
+
 // Apply handles kayak apply call.
 func (s *DBKayakMuxService) Apply(req *kt.ApplyRequest, _ *interface{}) (err error) {
 	// call apply to specified kayak
 	// treat req.Instance as DatabaseID
 	id := proto.DatabaseID(req.Instance)
 
-	if v, ok := s.serviceMap.Load(id); ok {
-		return v.(*kayak.Runtime).FollowerApply(req.Log)
+	if rt, ok := s.load(id); ok {
+		return rt.FollowerApply(req.Log)
 	}
 
 	return errors.Wrapf(ErrUnknownMuxRequest, "instance %v", req.Instance)
@@ -75,9 +87,9 @@ func (s *DBKayakMuxService) Apply(req *kt.ApplyRequest, _ *interface{}) (err err
 func (s *DBKayakMuxService) Fetch(req *kt.FetchRequest, resp *kt.FetchResponse) (err error) {
 	id := proto.DatabaseID(req.Instance)
 
-	if v, ok := s.serviceMap.Load(id); ok {
+	if rt, ok := s.load(id); ok {
 		var l *kt.Log
-		if l, err = v.(*kayak.Runtime).Fetch(req.GetContext(), req.Index); err == nil {
+		if l, err = rt.Fetch(req.GetContext(), req.Index); err == nil {
 			resp.Log = l
 			resp.Instance = req.Instance
 		}
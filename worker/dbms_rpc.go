@@ -20,6 +20,7 @@ import (
 	"github.com/pkg/errors"
 	metrics "github.com/rcrowley/go-metrics"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	"github.com/CovenantSQL/CovenantSQL/rpc"
@@ -45,6 +46,36 @@ type ObserverFetchBlockResp struct {
 	Block *types.Block
 }
 
+// FetchSnapshotReq defines the request for miner to fetch the storage snapshot of database.
+type FetchSnapshotReq struct {
+	proto.Envelope
+	DatabaseID proto.DatabaseID
+	Snapshot   string // id of the snapshot to read, a new snapshot is taken if empty
+	Offset     int64  // offset of the chunk
+}
+
+// FetchSnapshotResp defines the response for miner to fetch the storage snapshot of database.
+type FetchSnapshotResp struct {
+	Snapshot string // id of the snapshot
+	Key      string // encryption key of the snapshot
+	Seq      uint64 // log offset of the next write query not included in the snapshot
+	Size     int64
+	Data     []byte
+}
+
+// StateHashReq defines the request for miner to query the local state hash of a database block.
+type StateHashReq struct {
+	proto.Envelope
+	DatabaseID proto.DatabaseID
+	Block      hash.Hash
+}
+
+// StateHashResp defines the response for miner to query the local state hash of a database block.
+type StateHashResp struct {
+	StateHash hash.Hash
+	Found     bool // whether the block is replayed on the local state recently
+}
+
 // DBMSRPCService is the rpc endpoint of database management.
 type DBMSRPCService struct {
 	dbms *DBMS
@@ -99,6 +130,85 @@ func (rpc *DBMSRPCService) Query(req *types.Request, res *types.Response) (err e
 	return
 }
 
+// QueryStats rpc, called by client to fetch or reset the query statistics of a database.
+func (rpc *DBMSRPCService) QueryStats(
+	req *types.QueryStatsRequest, res *types.QueryStatsResponse) (err error,
+) {
+	var r *types.QueryStatsResponse
+	if r, err = rpc.dbms.QueryStats(req); err != nil {
+		return
+	}
+
+	*res = *r
+
+	return
+}
+
+// FetchChanges rpc, called by client to subscribe the row changes of a database.
+func (rpc *DBMSRPCService) FetchChanges(
+	req *types.ChangesRequest, res *types.ChangesResponse) (err error,
+) {
+	var r *types.ChangesResponse
+	if r, err = rpc.dbms.FetchChanges(req); err != nil {
+		return
+	}
+
+	*res = *r
+
+	return
+}
+
+// KeyRotationStatus rpc, called by client to fetch the key rotation status of a database.
+func (rpc *DBMSRPCService) KeyRotationStatus(
+	req *types.KeyRotationStatusRequest, res *types.KeyRotationStatusResponse) (err error,
+) {
+	var r *types.KeyRotationStatusResponse
+	if r, err = rpc.dbms.KeyRotationStatus(req); err != nil {
+		return
+	}
+
+	*res = *r
+
+	return
+}
+
+// FetchSnapshot rpc, called by the new miner of a database to fetch the storage snapshot.
+func (rpc *DBMSRPCService) FetchSnapshot(req *FetchSnapshotReq, res *FetchSnapshotResp) (err error) {
+	var r *FetchSnapshotResp
+	if r, err = rpc.dbms.FetchSnapshot(req); err != nil {
+		return
+	}
+
+	*res = *r
+
+	return
+}
+
+// StateHash rpc, called by miner to confirm the state divergence with its peers.
+func (rpc *DBMSRPCService) StateHash(req *StateHashReq, res *StateHashResp) (err error) {
+	var r *StateHashResp
+	if r, err = rpc.dbms.StateHash(req); err != nil {
+		return
+	}
+
+	*res = *r
+
+	return
+}
+
+// Tx rpc, called by client to prepare/commit/rollback multi-database transaction, and by miner to
+// query the transaction state.
+func (rpc *DBMSRPCService) Tx(req *types.TxRequest, res *types.TxResponse) (err error) {
+	var r *types.TxResponse
+	if r, err = rpc.dbms.Tx(req); err != nil {
+		return
+	}
+
+	*res = *r
+
+	return
+}
+
 // Ack rpc, called by client to confirm read request.
 func (rpc *DBMSRPCService) Ack(ack *types.Ack, _ *types.AckResponse) (err error) {
 	// Just need to verify signature in db.saveAck
@@ -17,12 +17,16 @@
 package worker
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/CovenantSQL/CovenantSQL/crypto"
@@ -32,7 +36,10 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/route"
 	rpc "github.com/CovenantSQL/CovenantSQL/rpc/mux"
+	"github.com/CovenantSQL/CovenantSQL/sqlchain"
+	"github.com/CovenantSQL/CovenantSQL/twopc"
 	"github.com/CovenantSQL/CovenantSQL/types"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
 )
 
 func TestDBMS(t *testing.T) {
@@ -150,6 +157,24 @@ func TestDBMS(t *testing.T) {
 			So(userState.Permission.Role, ShouldEqual, types.ReadWrite)
 			So(userState.Status, ShouldEqual, types.Normal)
 
+			Convey("query local state hash by the miners", func() {
+				var (
+					stateReq  = &StateHashReq{DatabaseID: dbID, Block: hash.HashH([]byte("block"))}
+					stateResp StateHashResp
+				)
+				err = testRequest(route.DBSStateHash, stateReq, &stateResp)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, ErrPermissionDeny.Error())
+
+				dbms.busService.lock.Lock()
+				profile := dbms.busService.sqlChainProfiles[dbID]
+				profile.Miners = append(profile.Miners, &types.MinerInfo{NodeID: nodeID})
+				dbms.busService.lock.Unlock()
+				err = testRequest(route.DBSStateHash, stateReq, &stateResp)
+				So(err, ShouldBeNil)
+				So(stateResp.Found, ShouldBeFalse)
+			})
+
 			Convey("success write and read", func() {
 				// sending write query
 				var writeQuery *types.Request
@@ -193,6 +218,38 @@ func TestDBMS(t *testing.T) {
 				err = testRequest(route.DBSAck, ack, &ackRes)
 				So(err, ShouldBeNil)
 
+				// the rows returned or affected are collected in the query stats, which are only
+				// shown to the admins
+				var (
+					statsReq = &types.QueryStatsRequest{
+						Header: types.SignedQueryStatsRequestHeader{
+							QueryStatsRequestHeader: types.QueryStatsRequestHeader{
+								DatabaseID: dbID,
+								Timestamp:  getLocalTime(),
+							},
+						},
+					}
+					statsRes *types.QueryStatsResponse
+					rows     = make(map[types.QueryType]uint64)
+				)
+				err = statsReq.Sign(privateKey)
+				So(err, ShouldBeNil)
+				_, err = dbms.QueryStats(statsReq)
+				So(errors.Cause(err), ShouldEqual, ErrPermissionDeny)
+				err = dbms.UpdatePermission(dbID, userAddr,
+					&types.PermStat{Permission: types.UserPermissionFromRole(types.Admin), Status: types.Normal})
+				So(err, ShouldBeNil)
+				statsRes, err = dbms.QueryStats(statsReq)
+				So(err, ShouldBeNil)
+				for _, stat := range statsRes.Stats {
+					rows[stat.QueryType] += stat.Rows
+				}
+				So(rows[types.WriteQuery], ShouldEqual, 1)
+				So(rows[types.ReadQuery], ShouldEqual, 1)
+				err = dbms.UpdatePermission(dbID, userAddr,
+					&types.PermStat{Permission: types.UserPermissionFromRole(types.ReadWrite), Status: types.Normal})
+				So(err, ShouldBeNil)
+
 				_, _, err = dbms.observerFetchBlock(dbID2, nodeID, 1)
 				So(err.Error(), ShouldContainSubstring, ErrPermissionDeny.Error())
 				_, _, err = dbms.observerFetchBlock(dbID, nodeID, 1)
@@ -513,6 +570,229 @@ func TestDBMS(t *testing.T) {
 			So(userState.Permission.Role, ShouldEqual, types.Admin)
 			So(userState.Status, ShouldEqual, types.Normal)
 
+			Convey("cross-database read query", func() {
+				var (
+					writeQuery, readQuery *types.Request
+					queryRes              *types.Response
+				)
+				writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"create table test (test int)",
+						"insert into test values(1), (2)",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, writeQuery, &queryRes)
+				So(err, ShouldBeNil)
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select test.test, t2.v from test join `" + string(dbID2) +
+							"`.test2 as t2 on test.test = t2.test",
+					})
+				So(err, ShouldBeNil)
+
+				// no permission on the referenced database
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, ErrPermissionDeny.Error())
+
+				// referenced database is not served by this miner
+				err = dbms.UpdatePermission(dbID2, userAddr,
+					&types.PermStat{Permission: types.UserPermissionFromRole(types.ReadWrite), Status: types.Normal})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, ErrNotExists.Error())
+
+				// deploy the referenced database
+				var req2 = new(types.UpdateService)
+				req2.Header.Op = types.CreateDB
+				req2.Header.Instance = types.ServiceInstance{
+					DatabaseID:   dbID2,
+					Peers:        peers,
+					GenesisBlock: block,
+				}
+				err = req2.Sign(privateKey)
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSDeploy, req2, &res)
+				So(err, ShouldBeNil)
+				writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID2, []string{
+						"create table test2 (test int, v text)",
+						"insert into test2 values(2, 'v2'), (3, 'v3')",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, writeQuery, &queryRes)
+				So(err, ShouldBeNil)
+
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldBeNil)
+				So(queryRes.Payload.Rows, ShouldHaveLength, 1)
+				So(queryRes.Payload.Rows[0].Values, ShouldResemble, []interface{}{int64(2), "v2"})
+				So(queryRes.Header.Heights, ShouldHaveLength, 2)
+				for _, h := range queryRes.Header.Heights {
+					So(h.DatabaseID, ShouldBeIn, []proto.DatabaseID{dbID, dbID2})
+					So(h.LogOffset, ShouldBeGreaterThan, 0)
+				}
+
+				// referenced database could be read alone
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select count(1) from `" + string(dbID2) + "`.test2",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldBeNil)
+				So(queryRes.Payload.Rows[0].Values[0], ShouldEqual, 2)
+			})
+
+			Convey("multi-database transaction", func() {
+				TxRecoveryInterval = 100 * time.Millisecond
+				defer func() { TxRecoveryInterval = 5 * time.Second }()
+				var (
+					writeQuery, readQuery *types.Request
+					queryRes              *types.Response
+					txRes                 types.TxResponse
+					req2                  = new(types.UpdateService)
+					participants          = []proto.DatabaseID{dbID, dbID2}
+					count                 = func(id proto.DatabaseID, table string) interface{} {
+						readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+							1, atomic.AddUint64(&seqNo, 1), id, []string{"select count(1) from " + table})
+						So(err, ShouldBeNil)
+						err = testRequest(route.DBSQuery, readQuery, &queryRes)
+						So(err, ShouldBeNil)
+						return queryRes.Payload.Rows[0].Values[0]
+					}
+					write = func(id proto.DatabaseID, query string) *types.Request {
+						writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+							1, atomic.AddUint64(&seqNo, 1), id, []string{query})
+						So(err, ShouldBeNil)
+						return writeQuery
+					}
+					txReq = func(
+						op types.TxOp, txID string, id proto.DatabaseID, deadline time.Duration,
+						request *types.Request,
+					) *types.TxRequest {
+						var r = &types.TxRequest{
+							Header: types.SignedTxRequestHeader{
+								TxRequestHeader: types.TxRequestHeader{
+									TxID:         hash.THashH([]byte(txID)),
+									Op:           op,
+									DatabaseID:   id,
+									Participants: participants,
+									Deadline:     getLocalTime().Add(deadline),
+									Timestamp:    getLocalTime(),
+								},
+							},
+							Request: request,
+						}
+						So(r.Sign(privateKey), ShouldBeNil)
+						return r
+					}
+				)
+				req2.Header.Op = types.CreateDB
+				req2.Header.Instance = types.ServiceInstance{
+					DatabaseID:   dbID2,
+					Peers:        peers,
+					GenesisBlock: block,
+				}
+				err = req2.Sign(privateKey)
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSDeploy, req2, &res)
+				So(err, ShouldBeNil)
+				err = dbms.UpdatePermission(dbID2, userAddr,
+					&types.PermStat{Permission: types.UserPermissionFromRole(types.Admin), Status: types.Normal})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, write(dbID, "create table orders (id int)"), &queryRes)
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, write(dbID2, "create table orders (id int)"), &queryRes)
+				So(err, ShouldBeNil)
+
+				// coordinate the local databases
+				var (
+					db1, _      = dbms.getMeta(dbID)
+					db2, _      = dbms.getMeta(dbID2)
+					coordinator = twopc.NewCoordinator(twopc.NewOptions(5 * time.Second))
+					header      = &txReq(types.TxPrepare, "tx1", "", time.Second, nil).Header.TxRequestHeader
+					result      interface{}
+				)
+				result, err = coordinator.Put([]twopc.Worker{
+					NewTxWorker(db1, write(dbID, "insert into orders values (1)")),
+					NewTxWorker(db2, write(dbID2, "insert into orders values (1)")),
+				}, header)
+				So(err, ShouldBeNil)
+				So(result.(*types.Response).Header.AffectedRows, ShouldEqual, 1)
+				So(count(dbID, "orders"), ShouldEqual, 1)
+				So(count(dbID2, "orders"), ShouldEqual, 1)
+				So(db1.TxStatus(header.TxID).State, ShouldEqual, types.TxCommitted)
+
+				// any failed branch rolls back the whole transaction
+				header = &txReq(types.TxPrepare, "tx2", "", time.Second, nil).Header.TxRequestHeader
+				_, err = coordinator.Put([]twopc.Worker{
+					NewTxWorker(db1, write(dbID, "insert into orders values (2)")),
+					NewTxWorker(db2, write(dbID2, "insert into not_exists values (2)")),
+				}, header)
+				So(err, ShouldNotBeNil)
+				So(db1.TxStatus(header.TxID).State, ShouldEqual, types.TxRolledBack)
+				So(db2.TxStatus(header.TxID).State, ShouldEqual, types.TxRolledBack)
+				So(count(dbID, "orders"), ShouldEqual, 1)
+
+				// prepared branch blocks the other writes until it's committed
+				err = testRequest(route.DBSTx, txReq(types.TxPrepare, "tx3", dbID, 2*time.Second,
+					write(dbID, "insert into orders values (3)")), &txRes)
+				So(err, ShouldBeNil)
+				So(txRes.State, ShouldEqual, types.TxPrepared)
+				err = testRequest(route.DBSTx, txReq(types.TxPrepare, "tx4", dbID, 2*time.Second,
+					write(dbID, "insert into orders values (4)")), &txRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, ErrTxBusy.Error())
+				var (
+					blocked = make(chan error)
+					w5      = write(dbID, "insert into orders values (5)")
+				)
+				go func() {
+					var res *types.Response
+					blocked <- testRequest(route.DBSQuery, w5, &res)
+				}()
+				select {
+				case <-blocked:
+					So("write query is not blocked", ShouldBeEmpty)
+				case <-time.After(100 * time.Millisecond):
+				}
+				err = testRequest(route.DBSTx, txReq(types.TxPrepare, "tx3", dbID2, 2*time.Second,
+					write(dbID2, "insert into orders values (3)")), &txRes)
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSTx, txReq(types.TxCommit, "tx3", dbID, 0, nil), &txRes)
+				So(err, ShouldBeNil)
+				So(txRes.State, ShouldEqual, types.TxCommitted)
+				So(txRes.Response.Header.AffectedRows, ShouldEqual, 1)
+				So(<-blocked, ShouldBeNil)
+				So(count(dbID, "orders"), ShouldEqual, 3)
+
+				// the coordinator crashes before committing the other branch
+				time.Sleep(2500 * time.Millisecond)
+				So(count(dbID2, "orders"), ShouldEqual, 2)
+				err = testRequest(route.DBSTx, txReq(types.TxStatus, "tx3", dbID2, 0, nil), &txRes)
+				So(err, ShouldBeNil)
+				So(txRes.State, ShouldEqual, types.TxCommitted)
+
+				// the coordinator crashes before preparing the other branch
+				err = testRequest(route.DBSTx, txReq(types.TxPrepare, "tx5", dbID, 500*time.Millisecond,
+					write(dbID, "insert into orders values (6)")), &txRes)
+				So(err, ShouldBeNil)
+				time.Sleep(time.Second)
+				So(db1.TxStatus(hash.THashH([]byte("tx5"))).State, ShouldEqual, types.TxRolledBack)
+				err = testRequest(route.DBSTx, txReq(types.TxPrepare, "tx5", dbID2, time.Second,
+					write(dbID2, "insert into orders values (6)")), &txRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, ErrTxRolledBack.Error())
+				So(count(dbID, "orders"), ShouldEqual, 3)
+				So(count(dbID2, "orders"), ShouldEqual, 2)
+			})
+
 			Convey("query non-existent database", func() {
 				// sending write query
 				var writeQuery *types.Request
@@ -529,6 +809,398 @@ func TestDBMS(t *testing.T) {
 				So(err, ShouldNotBeNil)
 			})
 
+			Convey("hibernate idle database", func() {
+				var (
+					writeQuery, readQuery *types.Request
+					queryRes              *types.Response
+					hibernations          = dbHibernations.Value()
+					wakeups               = dbWakeups.Value()
+					count                 = dbCount.Value()
+				)
+				writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"create table test (test int)",
+						"insert into test values(1)",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, writeQuery, &queryRes)
+				So(err, ShouldBeNil)
+
+				// not idle yet
+				dbms.cfg.IdleTimeout = time.Hour
+				So(dbms.hibernateDatabase(dbID), ShouldBeFalse)
+				dbms.cfg.IdleTimeout = 100 * time.Millisecond
+				time.Sleep(200 * time.Millisecond)
+				So(dbms.hibernateDatabase(dbID), ShouldBeTrue)
+				So(dbHibernations.Value(), ShouldEqual, hibernations+1)
+				So(dbHibernated.Value(), ShouldEqual, 1)
+				So(dbCount.Value(), ShouldEqual, count)
+				_, ok = dbms.getMeta(dbID)
+				So(ok, ShouldBeFalse)
+				var meta *DBMSMeta
+				meta, err = dbms.readMeta()
+				So(err, ShouldBeNil)
+				So(meta.DBS, ShouldContainKey, dbID)
+
+				// woken up by the next query
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select * from test",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldBeNil)
+				So(queryRes.Payload.Rows, ShouldHaveLength, 1)
+				So(queryRes.Payload.Rows[0].Values[0], ShouldEqual, 1)
+				So(dbWakeups.Value(), ShouldEqual, wakeups+1)
+				So(dbHibernated.Value(), ShouldEqual, 0)
+				_, ok = dbms.getMeta(dbID)
+				So(ok, ShouldBeTrue)
+
+				// the hibernated database is removed on drop
+				time.Sleep(200 * time.Millisecond)
+				So(dbms.hibernateDatabase(dbID), ShouldBeTrue)
+				err = dbms.Drop(dbID)
+				So(err, ShouldBeNil)
+				So(dbHibernated.Value(), ShouldEqual, 0)
+				So(dbCount.Value(), ShouldEqual, count-1)
+				_, err = os.Stat(filepath.Join(rootDir, string(dbID)))
+				So(os.IsNotExist(err), ShouldBeTrue)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("answer storage challenge of hibernated database", func() {
+				var (
+					wakeups    = dbWakeups.Value()
+					head       *types.Block
+					resp       = &sqlchain.MuxChallengeStorageResp{}
+					writeQuery *types.Request
+					queryRes   *types.Response
+				)
+				// the record is challenged at the log offset following the last write
+				writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"create table test (test int)",
+						"insert into test values(1)",
+					})
+				So(err, ShouldBeNil)
+				db, ok := dbms.getMeta(dbID)
+				So(ok, ShouldBeTrue)
+				last, _ := db.chain.Head()
+				err = testRequest(route.DBSQuery, writeQuery, &queryRes)
+				So(err, ShouldBeNil)
+				// wait for the write to be packed, so that the log offset is kept after wakeup
+				height, _ := db.chain.Head()
+				for ; height <= last; height, _ = db.chain.Head() {
+					time.Sleep(100 * time.Millisecond)
+				}
+				head, err = db.chain.FetchBlock(height)
+				So(err, ShouldBeNil)
+				var req = &sqlchain.MuxChallengeStorageReq{
+					DatabaseID: dbID,
+					ChallengeStorageReq: sqlchain.ChallengeStorageReq{
+						Verifier:  nodeID,
+						BlockHash: *head.BlockHash(),
+						LogOffset: queryRes.Header.LogOffset + 2,
+					},
+				}
+				dbms.cfg.IdleTimeout = 100 * time.Millisecond
+				time.Sleep(200 * time.Millisecond)
+				So(dbms.hibernateDatabase(dbID), ShouldBeTrue)
+
+				// woken up by the challenge
+				err = testRequest(route.SQLCChallengeStorage, req, resp)
+				So(err, ShouldBeNil)
+				So(resp.Answer.NodeID, ShouldEqual, nodeID)
+				So(dbWakeups.Value(), ShouldEqual, wakeups+1)
+				_, ok = dbms.getMeta(dbID)
+				So(ok, ShouldBeTrue)
+			})
+
+			Convey("load databases on startup", func() {
+				var (
+					writeQuery, readQuery *types.Request
+					queryRes              *types.Response
+					count                 = dbCount.Value()
+				)
+				writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"create table test (test int)",
+						"insert into test values(1)",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, writeQuery, &queryRes)
+				So(err, ShouldBeNil)
+				So(dbms.Readiness(), ShouldResemble, map[proto.DatabaseID]DatabaseState{
+					dbID: DatabaseReady,
+				})
+
+				// close the database and load it again as on startup
+				var db, ok = dbms.getMeta(dbID)
+				So(ok, ShouldBeTrue)
+				var v, _ = dbms.instances.Load(dbID)
+				err = db.Shutdown()
+				So(err, ShouldBeNil)
+				err = dbms.removeMeta(dbID)
+				So(err, ShouldBeNil)
+				dbCount.Add(-1)
+
+				// the requests for a loading database are rejected with a retryable error
+				dbms.loading.Store(dbID, true)
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select * from test",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, ErrDatabaseLoading.Error())
+				So(dbms.Readiness()[dbID], ShouldEqual, DatabaseLoading)
+				err = dbms.Create(v.(*types.ServiceInstance), false)
+				So(err, ShouldEqual, ErrAlreadyExists)
+
+				dbms.loadDatabases([]*types.ServiceInstance{
+					v.(*types.ServiceInstance),
+					{DatabaseID: dbID2}, // fails to open without peers
+				})
+				So(func() bool {
+					for i := 0; i < 100; i++ {
+						if _, loading := dbms.loading.Load(dbID); !loading {
+							if _, loading = dbms.loading.Load(dbID2); !loading {
+								return true
+							}
+						}
+						time.Sleep(50 * time.Millisecond)
+					}
+					return false
+				}(), ShouldBeTrue)
+				So(dbms.Readiness(), ShouldResemble, map[proto.DatabaseID]DatabaseState{
+					dbID:  DatabaseReady,
+					dbID2: DatabaseFailed,
+				})
+				So(dbCount.Value(), ShouldEqual, count)
+
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select * from test",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldBeNil)
+				So(queryRes.Payload.Rows, ShouldHaveLength, 1)
+
+				// the failed database is removed on drop
+				err = dbms.Drop(dbID2)
+				So(err, ShouldBeNil)
+				So(dbms.Readiness(), ShouldNotContainKey, dbID2)
+			})
+
+			Convey("interrupt query on deadline", func() {
+				var (
+					writeQuery, readQuery *types.Request
+					queryRes              *types.Response
+					values                = make([]string, 100)
+				)
+				for i := range values {
+					values[i] = fmt.Sprintf("(%d)", i)
+				}
+				writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"create table test (test int)",
+						"insert into test values " + strings.Join(values, ", "),
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, writeQuery, &queryRes)
+				So(err, ShouldBeNil)
+
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select count(1) from test a, test b, test c, test d, test e",
+					})
+				So(err, ShouldBeNil)
+				readQuery.Header.Deadline = time.Now().Add(100 * time.Millisecond)
+				err = readQuery.Sign(privateKey)
+				So(err, ShouldBeNil)
+				var start = time.Now()
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, x.ErrQueryTimeout.Error())
+				So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+
+				// the write exceeding its deadline is rejected before it's logged
+				writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"insert into test values (1000)",
+					})
+				So(err, ShouldBeNil)
+				writeQuery.Header.Deadline = time.Now().Add(-time.Millisecond)
+				err = writeQuery.Sign(privateKey)
+				So(err, ShouldBeNil)
+				_, err = dbms.Query(writeQuery)
+				So(errors.Cause(err), ShouldEqual, x.ErrQueryTimeout)
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select count(1) from test where test = 1000",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldBeNil)
+				So(queryRes.Payload.Rows[0].Values[0], ShouldEqual, 0)
+
+				// the max query time in resource meta is capped by the miner config
+				dbms.cfg.MaxQueryTime = time.Second
+				So(dbms.maxQueryTime(&types.ResourceMeta{}), ShouldEqual, time.Second)
+				So(dbms.maxQueryTime(&types.ResourceMeta{MaxQueryMillisecond: 100}),
+					ShouldEqual, 100*time.Millisecond)
+				So(dbms.maxQueryTime(&types.ResourceMeta{MaxQueryMillisecond: 2000}),
+					ShouldEqual, time.Second)
+				dbms.cfg.MaxQueryTime = 0
+				So(dbms.maxQueryTime(&types.ResourceMeta{MaxQueryMillisecond: 2000}),
+					ShouldEqual, 2*time.Second)
+			})
+
+			Convey("limit read queries of the user", func() {
+				var (
+					writeQuery, readQuery *types.Request
+					queryRes              *types.Response
+					values                = make([]string, 100)
+				)
+				for i := range values {
+					values[i] = fmt.Sprintf("(%d)", i)
+				}
+				writeQuery, err = buildQueryWithDatabaseID(types.WriteQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"create table test (test int)",
+						"insert into test values " + strings.Join(values, ", "),
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, writeQuery, &queryRes)
+				So(err, ShouldBeNil)
+
+				var perm = types.UserPermissionFromRole(types.ReadWrite)
+				perm.Limits = types.QueryLimits{MaxRows: 10}
+				err = dbms.UpdatePermission(dbID, userAddr,
+					&types.PermStat{Permission: perm, Status: types.Normal})
+				So(err, ShouldBeNil)
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select * from test",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring,
+					(&x.LimitError{Limit: x.LimitRows, Max: 10}).Error())
+
+				readQuery, err = buildQueryWithDatabaseID(types.ReadQuery,
+					1, atomic.AddUint64(&seqNo, 1),
+					dbID, []string{
+						"select * from test limit 10",
+					})
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSQuery, readQuery, &queryRes)
+				So(err, ShouldBeNil)
+				So(queryRes.Payload.Rows, ShouldHaveLength, 10)
+			})
+
+			Convey("call stored procedures by the grants", func() {
+				var (
+					queryRes *types.Response
+					query    = func(qt types.QueryType, queries ...types.Query) (err error) {
+						var req *types.Request
+						if req, err = buildQueryWithDatabaseID(qt,
+							1, atomic.AddUint64(&seqNo, 1), dbID, make([]string, len(queries)),
+						); err != nil {
+							return
+						}
+						req.Payload.Queries = queries
+						if err = req.Sign(privateKey); err != nil {
+							return
+						}
+						return testRequest(route.DBSQuery, req, &queryRes)
+					}
+					grant = func(role types.UserPermissionRole, procedures ...string) {
+						var perm = types.UserPermissionFromRole(role)
+						perm.Procedures = procedures
+						err = dbms.UpdatePermission(dbID, userAddr,
+							&types.PermStat{Permission: perm, Status: types.Normal})
+						So(err, ShouldBeNil)
+					}
+					register []types.Query
+				)
+				grant(types.Admin)
+				register, err = x.ProcedureQueries(&types.Procedure{
+					Name:       "add",
+					Params:     []string{"v"},
+					Statements: []string{"insert into test values (:v)"},
+				})
+				So(err, ShouldBeNil)
+				err = query(types.WriteQuery, append([]types.Query{
+					{Pattern: "create table test (test int)"},
+				}, register...)...)
+				So(err, ShouldBeNil)
+				var registerRes = *queryRes
+
+				// the procedures are called by the grants without the access to the tables
+				grant(types.Void, "add")
+				err = query(types.WriteQuery, types.Query{
+					Pattern: "CALL add",
+					Args:    []types.NamedArg{{Value: int64(1)}},
+				})
+				So(err, ShouldBeNil)
+				So(queryRes.Header.AffectedRows, ShouldEqual, 1)
+
+				// the responses are acked with the procedure grants only if they're of the
+				// procedure calls of the requester
+				var (
+					ack    *types.Ack
+					ackRes types.AckResponse
+				)
+				ack, err = buildAck(&registerRes)
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSAck, ack, &ackRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "not a procedure call of the requester")
+				grant(types.Void)
+				ack, err = buildAck(queryRes)
+				So(err, ShouldBeNil)
+				err = testRequest(route.DBSAck, ack, &ackRes)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "cannot call procedure add")
+				grant(types.Void, "add")
+				err = testRequest(route.DBSAck, ack, &ackRes)
+				So(err, ShouldBeNil)
+
+				err = query(types.WriteQuery, types.Query{Pattern: "insert into test values (2)"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, ErrPermissionDeny.Error())
+				err = query(types.ReadQuery, types.Query{Pattern: "CALL count"})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "cannot call procedure count")
+
+				// only the super users could register the procedures
+				grant(types.ReadWrite, "add")
+				err = query(types.WriteQuery, register...)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "cannot write procedures")
+				err = query(types.ReadQuery, types.Query{Pattern: "select count(1) from test"})
+				So(err, ShouldBeNil)
+				So(queryRes.Payload.Rows[0].Values[0], ShouldEqual, 1)
+			})
+
 			Convey("update peers", func() {
 				// update database
 				peers, err = getPeers(2)
@@ -39,4 +39,29 @@ var (
 	ErrInvalidPermission = errors.New("invalid permission")
 	// ErrInvalidTransactionType indicates that the transaction type is invalid.
 	ErrInvalidTransactionType = errors.New("invalid transaction type")
+	// ErrChangeCaptureDisabled indicates that the row change capture is not enabled on the database.
+	ErrChangeCaptureDisabled = errors.New("change capture disabled")
+	// ErrChangesPruned indicates that the requested row changes are pruned from the change log.
+	ErrChangesPruned = errors.New("changes pruned")
+	// ErrTxBusy indicates that another multi-database transaction is prepared on the database.
+	ErrTxBusy = errors.New("another transaction is prepared")
+	// ErrTxNotPrepared indicates that the multi-database transaction is not prepared on the database.
+	ErrTxNotPrepared = errors.New("transaction not prepared")
+	// ErrTxExpired indicates that the prepared transaction is past its deadline, and it's left to
+	// be resolved by the recovery.
+	ErrTxExpired = errors.New("transaction expired")
+	// ErrTxRolledBack indicates that the multi-database transaction is rolled back on the database.
+	ErrTxRolledBack = errors.New("transaction rolled back")
+	// ErrTxCommitted indicates that the multi-database transaction is committed on the database.
+	ErrTxCommitted = errors.New("transaction committed")
+	// ErrHistoryDisabled indicates that the storage history is not retained on the database.
+	ErrHistoryDisabled = errors.New("history disabled")
+	// ErrHeightNotRetained indicates that the state of the database at the requested height is
+	// not retained any more.
+	ErrHeightNotRetained = errors.New("height not retained")
+	// ErrHeightNotReached indicates that the requested height is beyond the current head.
+	ErrHeightNotReached = errors.New("height not reached")
+	// ErrDatabaseLoading indicates that the database is still being opened on miner startup, the
+	// request could be retried later.
+	ErrDatabaseLoading = errors.New("database is loading")
 )
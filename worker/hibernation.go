@@ -0,0 +1,185 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// transitLock returns the lock of the database dbID for hibernation, wakeup or the other changes
+// of its instance.
+func (dbms *DBMS) transitLock(dbID proto.DatabaseID) *sync.RWMutex {
+	var v, _ = dbms.transits.LoadOrStore(dbID, &sync.RWMutex{})
+	return v.(*sync.RWMutex)
+}
+
+// transit locks the database for hibernation, wakeup or the other changes of its instance, and
+// returns the function to unlock it.
+func (dbms *DBMS) transit(dbID proto.DatabaseID) (unlock func()) {
+	var lock = dbms.transitLock(dbID)
+	lock.Lock()
+	return lock.Unlock
+}
+
+// getDatabase returns the database to serve a request, it's woken up if hibernated. The request
+// for a database still loading on startup is rejected with the retryable ErrDatabaseLoading.
+//
+// The database is looked up and touched with the transit lock held, so that it's not hibernated
+// in between: the hibernation rechecks the idle time after taking the lock.
+func (dbms *DBMS) getDatabase(dbID proto.DatabaseID) (db *Database, err error) {
+	var exists bool
+	if db, exists = dbms.touchDatabase(dbID); !exists {
+		db, exists = dbms.wakeDatabase(dbID)
+	}
+	if !exists {
+		if _, loading := dbms.loading.Load(dbID); loading {
+			return nil, ErrDatabaseLoading
+		}
+		return nil, ErrNotExists
+	}
+	return
+}
+
+// touchDatabase returns the running database dbID and marks it as active.
+func (dbms *DBMS) touchDatabase(dbID proto.DatabaseID) (db *Database, exists bool) {
+	var lock = dbms.transitLock(dbID)
+	lock.RLock()
+	defer lock.RUnlock()
+	if db, exists = dbms.getMeta(dbID); exists {
+		db.touch()
+	}
+	return
+}
+
+// wake reopens the hibernated database dbID, and reports whether it's served by this miner.
+func (dbms *DBMS) wake(dbID proto.DatabaseID) bool {
+	var _, err = dbms.getDatabase(dbID)
+	return err == nil
+}
+
+// wakeDatabase reopens the hibernated database dbID from its on-disk state and marks it as active.
+func (dbms *DBMS) wakeDatabase(dbID proto.DatabaseID) (db *Database, exists bool) {
+	defer dbms.transit(dbID)()
+	defer func() {
+		if exists {
+			db.touch()
+		}
+	}()
+	if db, exists = dbms.getMeta(dbID); exists {
+		// woken up by another request
+		return
+	}
+	if _, hibernated := dbms.hibernated.Load(dbID); !hibernated {
+		return
+	}
+	var (
+		le    = log.WithField("id", dbID)
+		v, _  = dbms.instances.Load(dbID)
+		start = time.Now()
+	)
+	if err := dbms.open(v.(*types.ServiceInstance), false); err != nil {
+		le.WithError(err).Error("failed to wake up database")
+		return
+	}
+	dbms.hibernated.Delete(dbID)
+	dbHibernated.Add(-1)
+	dbWakeups.Add(1)
+	setReadiness(dbID, DatabaseReady)
+	le.WithField("elapsed", time.Since(start)).Info("database woken up")
+	return dbms.getMeta(dbID)
+}
+
+// hibernateDatabase closes the database dbID down to its on-disk state if it's idle for at least
+// the idle timeout, it's reopened on the next request or block.
+func (dbms *DBMS) hibernateDatabase(dbID proto.DatabaseID) (ok bool) {
+	defer dbms.transit(dbID)()
+	// the idle time is rechecked with the lock held, the requests touch the database under the lock
+	var db, exists = dbms.getMeta(dbID)
+	if !exists || db.idleTime() < dbms.cfg.IdleTimeout {
+		return
+	}
+	if _, joining := dbms.joining.Load(dbID); joining {
+		return
+	}
+	if db.txs != nil && db.txs.pending() {
+		// the in-doubt transaction branch is resolved by the running database
+		return
+	}
+	// The database is marked as hibernated before it's removed from the map, so that the requests
+	// which don't find it wait for the transit lock and wake it up again.
+	dbms.hibernated.Store(dbID, true)
+	dbms.dbMap.Delete(dbID)
+	dbHibernated.Add(1)
+	dbHibernations.Add(1)
+	setReadiness(dbID, DatabaseHibernated)
+	var le = log.WithField("id", dbID)
+	if err := db.Shutdown(); err != nil {
+		le.WithError(err).Error("failed to shutdown hibernated database")
+	}
+	le.Info("database hibernated")
+	return true
+}
+
+// hibernateIdle hibernates the idle databases periodically.
+func (dbms *DBMS) hibernateIdle() {
+	defer dbms.wg.Done()
+	var ticker = time.NewTicker(dbms.cfg.IdleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dbms.stopCh:
+			return
+		case <-ticker.C:
+		}
+		var idle []proto.DatabaseID
+		dbms.dbMap.Range(func(key, value interface{}) bool {
+			if value.(*Database).idleTime() >= dbms.cfg.IdleTimeout {
+				idle = append(idle, key.(proto.DatabaseID))
+			}
+			return true
+		})
+		for _, v := range idle {
+			select {
+			case <-dbms.stopCh:
+				return
+			default:
+			}
+			dbms.hibernateDatabase(v)
+		}
+	}
+}
+
+// setHibernatedSpaceLimit updates the storage quota of the hibernated database dbID, which is
+// applied on wakeup, and reports whether the database is hibernated.
+func (dbms *DBMS) setHibernatedSpaceLimit(dbID proto.DatabaseID, limit uint64) bool {
+	defer dbms.transit(dbID)()
+	if _, hibernated := dbms.hibernated.Load(dbID); !hibernated {
+		return false
+	}
+	var (
+		v, _     = dbms.instances.Load(dbID)
+		instance = *v.(*types.ServiceInstance)
+	)
+	instance.ResourceMeta.Space = limit
+	dbms.instances.Store(dbID, &instance)
+	return true
+}
@@ -0,0 +1,445 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/sqlchain"
+	"github.com/CovenantSQL/CovenantSQL/storage"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	x "github.com/CovenantSQL/CovenantSQL/xenomint"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+const (
+	// HistoryDirName defines the directory name of the retained storage snapshots of database
+	// instance, which answer the read queries as of a past height.
+	HistoryDirName = "history"
+
+	// HistoryMetaFileName defines the meta file name of the retained storage snapshots.
+	HistoryMetaFileName = "history.meta"
+)
+
+var (
+	// HistorySnapshotInterval defines the block count between the retained storage snapshots, a
+	// read query as of a past height replays at most this many blocks on a snapshot.
+	HistorySnapshotInterval int32 = 1000
+
+	// HistoryCheckInterval defines the interval to check for the new storage snapshot to retain.
+	HistoryCheckInterval = time.Minute
+)
+
+// historySnapshot defines a retained storage snapshot taken after the block at count.
+type historySnapshot struct {
+	Count      int32
+	Height     int32
+	Seq        uint64 // log offset of the next write query
+	KeyVersion uint32
+}
+
+// history retains the storage snapshots of the database every HistorySnapshotInterval blocks,
+// and answers the read queries as of a past height from a copy of the nearest snapshot with the
+// following blocks replayed. The heights before the oldest retained snapshot are rejected.
+//
+// The snapshots are rebuilt from the local blocks, and encrypted with the current key of the
+// database storage, so they are dropped and rebuilt after the key is rotated.
+type history struct {
+	sync.RWMutex
+	dir       string
+	retention int
+	nodeID    proto.NodeID
+	ext       types.SQLiteExtension
+	maxTime   time.Duration // max execution time of the as-of reads
+	limits    types.QueryLimits
+	chain     *sqlchain.Chain
+	keys      *keyRotation
+	snapshots []*historySnapshot // in ascending order of count
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newHistory(
+	dir string, retention int, nodeID proto.NodeID, ext types.SQLiteExtension,
+	maxTime time.Duration, limits types.QueryLimits, chain *sqlchain.Chain, keys *keyRotation,
+) (
+	h *history, err error,
+) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	h = &history{
+		dir:       dir,
+		retention: retention,
+		nodeID:    nodeID,
+		ext:       ext,
+		maxTime:   maxTime,
+		limits:    limits,
+		chain:     chain,
+		keys:      keys,
+		stopCh:    make(chan struct{}),
+	}
+	var content []byte
+	if content, err = ioutil.ReadFile(filepath.Join(dir, HistoryMetaFileName)); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else if err = utils.DecodeMsgPack(content, &h.snapshots); err != nil {
+		err = errors.Wrap(err, "decode history meta")
+	}
+	if err != nil {
+		return
+	}
+	// remove the files left by the interrupted builds and the as-of queries
+	var (
+		files []os.FileInfo
+		kept  = make(map[string]bool)
+	)
+	for _, v := range h.snapshots {
+		kept[h.file(v.Count)] = true
+	}
+	if files, err = ioutil.ReadDir(dir); err != nil {
+		return
+	}
+	for _, v := range files {
+		var path = filepath.Join(dir, v.Name())
+		if v.Name() != HistoryMetaFileName && !kept[path] {
+			_ = os.Remove(path)
+		}
+	}
+	h.wg.Add(1)
+	go h.run()
+	return
+}
+
+func (h *history) file(count int32) string {
+	return filepath.Join(h.dir, fmt.Sprintf("%d.db3", count))
+}
+
+func (h *history) dsn(file string, key keyMeta) (dsn string, err error) {
+	var d *storage.DSN
+	if d, err = storage.NewDSN(file); err != nil {
+		return
+	}
+	if key.Key != "" {
+		d.AddParam("_crypto_key", key.Key)
+	}
+	return d.Format(), nil
+}
+
+// open opens the state of the storage file, the state should be closed by the caller.
+func (h *history) open(file string, key keyMeta) (st *x.State, err error) {
+	var (
+		dsn  string
+		strg *xs.SQLite3
+	)
+	if dsn, err = h.dsn(file, key); err != nil {
+		return
+	}
+	if strg, err = xs.NewSqlite(dsn); err != nil {
+		return
+	}
+	st = x.NewState(sql.LevelReadUncommitted, h.nodeID, strg)
+	if err = st.SetExtensions(h.ext); err != nil {
+		_ = st.Close(false)
+		st = nil
+		return
+	}
+	st.SetMaxQueryTime(h.maxTime)
+	st.SetLimits(h.limits)
+	return
+}
+
+// copy copies the snapshot into file and opens its state.
+func (h *history) copy(snap *historySnapshot, file string, key keyMeta) (st *x.State, err error) {
+	var src, dst string
+	if src, err = h.dsn(h.file(snap.Count), key); err != nil {
+		return
+	}
+	if dst, err = h.dsn(file, key); err != nil {
+		return
+	}
+	if err = xs.Backup(src, dst); err != nil {
+		return
+	}
+	return h.open(file, key)
+}
+
+// replay replays the write queries of the blocks in count range [from, to] on st, and returns
+// the height of the last block and the log offset of the next write query.
+func (h *history) replay(
+	st *x.State, from, to int32, seq uint64) (height int32, next uint64, err error,
+) {
+	next = seq
+	err = h.chain.RangeBlocksByCount(from, to, func(b *types.Block, bh int32) (err error) {
+		for _, q := range b.QueryTxs {
+			if q.Request.Header.QueryType != types.WriteQuery {
+				continue
+			}
+			// the log offsets may be skipped by the failed write queries
+			st.SetSeq(q.Response.LogOffset)
+			if err = st.Replay(q.Request, &types.Response{Header: *q.Response}); err != nil {
+				return errors.Wrapf(err, "replay write query at log offset %d", q.Response.LogOffset)
+			}
+			next = q.Response.LogOffset + uint64(len(q.Request.Payload.Queries))
+		}
+		if _, _, err = st.CommitEx(); err != nil {
+			return
+		}
+		height = bh
+		return
+	})
+	return
+}
+
+func (h *history) run() {
+	defer h.wg.Done()
+	for {
+		if err := h.advance(); err != nil {
+			log.WithField("dir", h.dir).WithError(err).Warning("failed to retain history snapshot")
+		}
+		select {
+		case <-h.stopCh:
+			return
+		case <-time.After(HistoryCheckInterval):
+		}
+	}
+}
+
+// advance retains the new snapshots up to the current head.
+func (h *history) advance() (err error) {
+	var (
+		key          = h.keys.key()
+		_, headCount = h.chain.Head()
+		last         *historySnapshot
+	)
+	h.Lock()
+	if len(h.snapshots) > 0 && h.snapshots[0].KeyVersion != key.Version {
+		// the key is rotated, all the snapshots are rebuilt
+		h.pruneLocked(len(h.snapshots))
+		if err = h.saveLocked(); err != nil {
+			h.Unlock()
+			return
+		}
+	}
+	if len(h.snapshots) > 0 {
+		last = h.snapshots[len(h.snapshots)-1]
+	}
+	h.Unlock()
+
+	for {
+		var next int32
+		if last != nil {
+			next = last.Count + HistorySnapshotInterval
+		} else {
+			// start from the oldest snapshot kept by the retention, so that the retained heights
+			// don't depend on when the snapshots are built
+			var oldest = int64(headCount/HistorySnapshotInterval*HistorySnapshotInterval) -
+				int64(h.retention-1)*int64(HistorySnapshotInterval)
+			if oldest > 0 {
+				next = int32(oldest)
+			}
+		}
+		if next > headCount {
+			return
+		}
+		select {
+		case <-h.stopCh:
+			return
+		default:
+		}
+		if last, err = h.build(last, next, key); err != nil {
+			return
+		}
+		h.Lock()
+		h.snapshots = append(h.snapshots, last)
+		if len(h.snapshots) > h.retention {
+			h.pruneLocked(len(h.snapshots) - h.retention)
+		}
+		err = h.saveLocked()
+		h.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// build builds the snapshot at count from the previous one, or from an empty storage with all
+// the blocks replayed if prev is nil.
+func (h *history) build(prev *historySnapshot, count int32, key keyMeta) (
+	snap *historySnapshot, err error,
+) {
+	var (
+		file = h.file(count)
+		st   *x.State
+		from int32
+		seq  uint64
+	)
+	if prev != nil {
+		h.RLock()
+		st, err = h.copy(prev, file, key)
+		h.RUnlock()
+		from, seq = prev.Count+1, prev.Seq
+	} else {
+		if err = removeStorageFiles(file); err != nil {
+			return
+		}
+		st, err = h.open(file, key)
+	}
+	if err != nil {
+		return
+	}
+	snap = &historySnapshot{Count: count, KeyVersion: key.Version}
+	snap.Height, snap.Seq, err = h.replay(st, from, count, seq)
+	if cerr := st.Close(err == nil); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = removeStorageFiles(file)
+		snap = nil
+		return
+	}
+	log.WithFields(log.Fields{
+		"dir":    h.dir,
+		"count":  snap.Count,
+		"height": snap.Height,
+	}).Debug("retained history snapshot")
+	return
+}
+
+// pruneLocked removes the oldest n snapshots. It must be called with the lock held.
+func (h *history) pruneLocked(n int) {
+	for _, v := range h.snapshots[:n] {
+		if err := removeStorageFiles(h.file(v.Count)); err != nil {
+			log.WithField("dir", h.dir).WithError(err).Warning("failed to remove history snapshot")
+		}
+	}
+	h.snapshots = append(h.snapshots[:0], h.snapshots[n:]...)
+}
+
+// saveLocked persists the snapshot list. It must be called with the lock held.
+func (h *history) saveLocked() (err error) {
+	var buf, ierr = utils.EncodeMsgPack(h.snapshots)
+	if ierr != nil {
+		return ierr
+	}
+	var (
+		path = filepath.Join(h.dir, HistoryMetaFileName)
+		tmp  = path + ".tmp"
+	)
+	if err = ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return
+	}
+	return os.Rename(tmp, path)
+}
+
+// query executes the read request on the storage as of the height specified in request.
+func (h *history) query(req *types.Request) (tracker *x.QueryTracker, resp *types.Response, err error) {
+	var (
+		asOf          = req.Header.AsOfHeight
+		headHeight, _ = h.chain.Head()
+		count, ok     = h.chain.BlockCountAt(asOf)
+		key           = h.keys.key()
+		snap          *historySnapshot
+	)
+	if asOf > headHeight {
+		err = errors.Wrapf(ErrHeightNotReached, "height %d is beyond head height %d", asOf, headHeight)
+		return
+	}
+	if !ok {
+		err = errors.Wrapf(ErrHeightNotRetained, "height %d", asOf)
+		return
+	}
+
+	var tmp *os.File
+	if tmp, err = ioutil.TempFile(h.dir, "asof-*.db3"); err != nil {
+		return
+	}
+	_ = tmp.Close()
+	defer func() { _ = removeStorageFiles(tmp.Name()) }()
+
+	var st *x.State
+	if err = func() (err error) {
+		h.RLock()
+		defer h.RUnlock()
+		for i := len(h.snapshots) - 1; i >= 0; i-- {
+			if v := h.snapshots[i]; v.Count <= count && v.KeyVersion == key.Version {
+				snap = v
+				break
+			}
+		}
+		if snap == nil {
+			if len(h.snapshots) > 0 {
+				return errors.Wrapf(ErrHeightNotRetained,
+					"height %d is before the oldest retained height %d", asOf, h.snapshots[0].Height)
+			}
+			return errors.Wrapf(ErrHeightNotRetained, "height %d", asOf)
+		}
+		st, err = h.copy(snap, tmp.Name(), key)
+		return
+	}(); err != nil {
+		return
+	}
+	defer func() { _ = st.Close(false) }()
+
+	var seq = snap.Seq
+	if count > snap.Count {
+		if _, seq, err = h.replay(st, snap.Count+1, count, snap.Seq); err != nil {
+			return
+		}
+	}
+	if tracker, resp, err = st.QueryWithContext(req.GetContext(), req, false); err != nil {
+		return
+	}
+	resp.Header.Heights = []types.DatabaseHeight{{
+		DatabaseID: req.Header.DatabaseID,
+		Height:     asOf,
+		LogOffset:  seq,
+	}}
+	return
+}
+
+// stop stops retaining the new snapshots and waits for the ongoing build to exit.
+func (h *history) stop() {
+	select {
+	case <-h.stopCh:
+	default:
+		close(h.stopCh)
+	}
+	h.wg.Wait()
+}
+
+// removeStorageFiles removes the storage file and its journal files.
+func removeStorageFiles(file string) (err error) {
+	for _, suffix := range []string{"-wal", "-shm", "-journal", ""} {
+		if err = os.Remove(file + suffix); err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto"
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/storage"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+const (
+	// KeyMetaFileName defines the encryption key meta file name of database instance, it's only
+	// written once the encryption key is rotated.
+	KeyMetaFileName = "key.meta"
+
+	// KeyRotationStepPages defines the count of pages re-encrypted in each step of key rotation.
+	KeyRotationStepPages = 256
+
+	// KeyRotationStepInterval defines the interval between the steps of key rotation.
+	KeyRotationStepInterval = 10 * time.Millisecond
+
+	// KeyRotationMaxRestarts defines the maximum restart count of the background copy, which is
+	// restarted on any write to the database. The remaining pages are copied during the switchover
+	// once it's exceeded.
+	KeyRotationMaxRestarts = 16
+
+	keyMetaPendingSuffix = ".new"
+)
+
+// keyMeta defines the encryption key of database storage.
+type keyMeta struct {
+	Version uint32
+	Key     string
+}
+
+// keyMetaFile defines the content of the key meta file, the encryption key is wrapped by the
+// public key of the local node, so that it's never stored in plaintext next to the storage.
+type keyMetaFile struct {
+	Version    uint32
+	WrappedKey []byte
+}
+
+// loadKeyMeta loads the encryption key meta of the database storage in dataDir, it returns nil
+// if the key is never rotated. The key meta of an interrupted switchover is also recovered. The
+// key is unwrapped by the private key of the local node.
+func loadKeyMeta(dataDir string, nodeKey *asymmetric.PrivateKey) (meta *keyMeta, err error) {
+	var (
+		path    = filepath.Join(dataDir, KeyMetaFileName)
+		pending = path + keyMetaPendingSuffix
+		rekey   = filepath.Join(dataDir, StorageFileName) + xs.RekeyFileSuffix
+		content []byte
+		file    keyMetaFile
+		key     []byte
+	)
+	if _, err = os.Stat(pending); err == nil {
+		if _, err = os.Stat(rekey); err == nil {
+			// Storage file is not replaced yet, just drop the new key
+			err = os.Remove(pending)
+		} else if os.IsNotExist(err) {
+			err = os.Rename(pending, path)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "recover key meta")
+			return
+		}
+	} else if !os.IsNotExist(err) {
+		return
+	}
+	if content, err = ioutil.ReadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if err = utils.DecodeMsgPack(content, &file); err != nil {
+		return
+	}
+	if key, err = crypto.DecryptAndCheck(nodeKey, file.WrappedKey); err != nil {
+		err = errors.Wrap(err, "unwrap encryption key")
+		return
+	}
+	meta = &keyMeta{Version: file.Version, Key: string(key)}
+	return
+}
+
+func writeKeyMeta(path string, meta *keyMeta, nodeKey *asymmetric.PrivateKey) (err error) {
+	var file = keyMetaFile{Version: meta.Version}
+	if file.WrappedKey, err = crypto.EncryptAndSign(nodeKey.PubKey(), []byte(meta.Key)); err != nil {
+		return errors.Wrap(err, "wrap encryption key")
+	}
+	var buf, ierr = utils.EncodeMsgPack(&file)
+	if ierr != nil {
+		return ierr
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// storageSwitcher switches the database storage with fn.
+type storageSwitcher func(fn func(xi.Storage) (xi.Storage, error)) error
+
+// keyRotation rotates the encryption key of the database storage online.
+//
+// The storage is re-encrypted into a new file in background while the database is serving. And
+// then the storage is switched to the new file with queries paused, the switchover is recorded
+// by the key meta file to be recovered on restart.
+type keyRotation struct {
+	sync.Mutex
+	dataDir  string
+	dsn      *storage.DSN           // storage dsn without encryption key
+	nodeKey  *asymmetric.PrivateKey // wraps the encryption key in the key meta file
+	switcher storageSwitcher
+	current  keyMeta
+	status   types.KeyRotationStatus
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func newKeyRotation(
+	dataDir string, dsn *storage.DSN, current keyMeta, nodeKey *asymmetric.PrivateKey,
+	switcher storageSwitcher,
+) *keyRotation {
+	return &keyRotation{
+		dataDir:  dataDir,
+		dsn:      dsn,
+		nodeKey:  nodeKey,
+		switcher: switcher,
+		current:  current,
+		status: types.KeyRotationStatus{
+			KeyVersion:    current.Version,
+			TargetVersion: current.Version,
+			State:         types.KeyRotationIdle,
+			UpdateTime:    time.Now().UTC(),
+		},
+	}
+}
+
+// rotate starts the key rotation to target in background, any ongoing rotation to an older key
+// version is cancelled.
+func (kr *keyRotation) rotate(target keyMeta) {
+	kr.Lock()
+	if target.Version <= kr.current.Version ||
+		(kr.cancel != nil && target.Version <= kr.status.TargetVersion) {
+		kr.Unlock()
+		return
+	}
+	var cancel, done = kr.cancel, kr.done
+	kr.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+
+	kr.Lock()
+	defer kr.Unlock()
+	if target.Version <= kr.current.Version || kr.cancel != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, kr.cancel = context.WithCancel(context.Background())
+	kr.done = make(chan struct{})
+	kr.status = types.KeyRotationStatus{
+		KeyVersion:    kr.current.Version,
+		TargetVersion: target.Version,
+		State:         types.KeyRotationCopying,
+		UpdateTime:    time.Now().UTC(),
+	}
+	go kr.run(ctx, target, kr.done)
+}
+
+func (kr *keyRotation) run(ctx context.Context, target keyMeta, done chan struct{}) {
+	defer func() {
+		kr.Lock()
+		kr.cancel, kr.done = nil, nil
+		kr.Unlock()
+		close(done)
+	}()
+	var le = log.WithFields(log.Fields{
+		"dir":     kr.dataDir,
+		"version": target.Version,
+	})
+	le.Info("start key rotation")
+	if err := kr.rekey(ctx, target); err != nil {
+		le.WithError(err).Error("key rotation failed")
+		kr.update(func(s *types.KeyRotationStatus) {
+			s.State = types.KeyRotationFailed
+			s.Error = err.Error()
+		})
+		return
+	}
+	le.Info("key rotation finished")
+}
+
+func (kr *keyRotation) rekey(ctx context.Context, target keyMeta) (err error) {
+	var (
+		dsn      = kr.dsn.Clone()
+		r        *xs.Rekeyer
+		done     bool
+		restarts int
+		last     int
+	)
+	kr.Lock()
+	dsn.AddParam("_crypto_key", kr.current.Key)
+	kr.Unlock()
+	if r, err = xs.NewRekeyer(dsn.Format(), target.Key); err != nil {
+		return
+	}
+	defer func() { _ = r.Close() }()
+
+	// Copy in background
+	for !done && restarts < KeyRotationMaxRestarts {
+		if done, err = r.Step(KeyRotationStepPages); err != nil {
+			return
+		}
+		var copied, total = r.Progress()
+		if copied < last {
+			restarts++
+		}
+		last = copied
+		kr.update(func(s *types.KeyRotationStatus) {
+			s.CopiedPages, s.TotalPages = uint64(copied), uint64(total)
+		})
+		if done {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(KeyRotationStepInterval):
+		}
+	}
+
+	// Switch storage with queries paused
+	var (
+		path    = filepath.Join(kr.dataDir, KeyMetaFileName)
+		pending = path + keyMetaPendingSuffix
+	)
+	kr.update(func(s *types.KeyRotationStatus) { s.State = types.KeyRotationSwitching })
+	if err = writeKeyMeta(pending, &target, kr.nodeKey); err != nil {
+		return
+	}
+	if err = kr.switcher(r.Switch); err != nil {
+		if _, ierr := os.Stat(kr.dsn.GetFileName() + xs.RekeyFileSuffix); ierr == nil {
+			// Storage file is not replaced yet
+			_ = os.Remove(pending)
+		}
+		return
+	}
+	kr.Lock()
+	kr.current = target
+	kr.Unlock()
+	var copied, total = r.Progress()
+	kr.update(func(s *types.KeyRotationStatus) {
+		s.KeyVersion = target.Version
+		s.State = types.KeyRotationIdle
+		s.CopiedPages, s.TotalPages = uint64(copied), uint64(total)
+	})
+	if err = os.Rename(pending, path); err != nil {
+		// The key meta will be recovered on restart
+		log.WithError(err).Warning("failed to write key meta")
+		err = nil
+	}
+	return
+}
+
+// storageDSN returns the dsn of the database storage with the current encryption key.
+func (kr *keyRotation) storageDSN() string {
+	kr.Lock()
+	defer kr.Unlock()
+	var dsn = kr.dsn.Clone()
+	if kr.current.Key != "" {
+		dsn.AddParam("_crypto_key", kr.current.Key)
+	}
+	return dsn.Format()
+}
+
+// storage returns the file name and the current encryption key of the database storage.
+func (kr *keyRotation) storage() (file, key string) {
+	kr.Lock()
+	defer kr.Unlock()
+	return kr.dsn.GetFileName(), kr.current.Key
+}
+
+// key returns the current encryption key of the database storage.
+func (kr *keyRotation) key() keyMeta {
+	kr.Lock()
+	defer kr.Unlock()
+	return kr.current
+}
+
+func (kr *keyRotation) update(fn func(s *types.KeyRotationStatus)) {
+	kr.Lock()
+	defer kr.Unlock()
+	fn(&kr.status)
+	kr.status.UpdateTime = time.Now().UTC()
+}
+
+func (kr *keyRotation) stat() types.KeyRotationStatus {
+	kr.Lock()
+	defer kr.Unlock()
+	return kr.status
+}
+
+// stop cancels the ongoing key rotation and waits for it to exit.
+func (kr *keyRotation) stop() {
+	kr.Lock()
+	var cancel, done = kr.cancel, kr.done
+	kr.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/asymmetric"
+	"github.com/CovenantSQL/CovenantSQL/storage"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestKeyRotation(t *testing.T) {
+	Convey("test key rotation", t, func() {
+		dir, err := ioutil.TempDir("", "keyrotation")
+		So(err, ShouldBeNil)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		var (
+			dsn   *storage.DSN
+			st    xi.Storage
+			lock  sync.Mutex
+			count int
+			meta  *keyMeta
+			priv  *asymmetric.PrivateKey
+			open  = func(key string) (xi.Storage, error) {
+				var d = dsn.Clone()
+				d.AddParam("_crypto_key", key)
+				return xs.NewSqlite(d.Format())
+			}
+			switcher = func(fn func(xi.Storage) (xi.Storage, error)) (err error) {
+				lock.Lock()
+				defer lock.Unlock()
+				var s xi.Storage
+				if s, err = fn(st); err != nil {
+					return
+				}
+				st = s
+				return
+			}
+		)
+		dsn, err = storage.NewDSN(filepath.Join(dir, StorageFileName))
+		So(err, ShouldBeNil)
+		priv, _, err = asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		st, err = open("old")
+		So(err, ShouldBeNil)
+		defer func() { _ = st.Close() }()
+		_, err = st.Writer().Exec(`CREATE TABLE "t1" ("k" INT, PRIMARY KEY("k"))`)
+		So(err, ShouldBeNil)
+		for i := 0; i < 100; i++ {
+			_, err = st.Writer().Exec(`INSERT INTO "t1" VALUES (?)`, i)
+			So(err, ShouldBeNil)
+		}
+
+		// Key meta should not exist before rotation
+		meta, err = loadKeyMeta(dir, priv)
+		So(err, ShouldBeNil)
+		So(meta, ShouldBeNil)
+
+		kr := newKeyRotation(dir, dsn, keyMeta{Key: "old"}, priv, switcher)
+		kr.rotate(keyMeta{Version: 1, Key: "new"})
+		So(kr.stat().TargetVersion, ShouldEqual, 1)
+		var deadline = time.Now().Add(10 * time.Second)
+		for kr.stat().KeyVersion != 1 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		kr.stop()
+		var status = kr.stat()
+		So(status.KeyVersion, ShouldEqual, 1)
+		So(status.State, ShouldEqual, types.KeyRotationIdle)
+		So(status.CopiedPages, ShouldEqual, status.TotalPages)
+
+		// Stale version should be ignored
+		kr.rotate(keyMeta{Version: 1, Key: "stale"})
+		So(kr.stat().State, ShouldEqual, types.KeyRotationIdle)
+
+		lock.Lock()
+		err = st.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+		lock.Unlock()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 100)
+
+		meta, err = loadKeyMeta(dir, priv)
+		So(err, ShouldBeNil)
+		So(meta, ShouldResemble, &keyMeta{Version: 1, Key: "new"})
+
+		// The key is never stored in plaintext, and only unwrapped by the node key
+		var (
+			path    = filepath.Join(dir, KeyMetaFileName)
+			content []byte
+			other   *asymmetric.PrivateKey
+		)
+		content, err = ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(content, []byte("new")), ShouldBeFalse)
+		other, _, err = asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		_, err = loadKeyMeta(dir, other)
+		So(err, ShouldNotBeNil)
+
+		// Pending key meta should be dropped if the storage file is not replaced
+		err = writeKeyMeta(path+keyMetaPendingSuffix, &keyMeta{Version: 2, Key: "newer"}, priv)
+		So(err, ShouldBeNil)
+		err = ioutil.WriteFile(dsn.GetFileName()+xs.RekeyFileSuffix, nil, 0600)
+		So(err, ShouldBeNil)
+		meta, err = loadKeyMeta(dir, priv)
+		So(err, ShouldBeNil)
+		So(meta.Version, ShouldEqual, 1)
+
+		// Pending key meta should be promoted if the storage file is replaced
+		err = os.Remove(dsn.GetFileName() + xs.RekeyFileSuffix)
+		So(err, ShouldBeNil)
+		err = writeKeyMeta(path+keyMetaPendingSuffix, &keyMeta{Version: 2, Key: "newer"}, priv)
+		So(err, ShouldBeNil)
+		meta, err = loadKeyMeta(dir, priv)
+		So(err, ShouldBeNil)
+		So(meta, ShouldResemble, &keyMeta{Version: 2, Key: "newer"})
+	})
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/sqlparser"
+	"github.com/CovenantSQL/sqlparser/dependency/querypb"
+	metrics "github.com/rcrowley/go-metrics"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+const (
+	// MaxQueryStatsEntries defines the maximum number of query patterns tracked per database.
+	MaxQueryStatsEntries = 1000
+
+	// queryStatsSampleSize defines the reservoir size of latency samples per query pattern.
+	queryStatsSampleSize = 1028
+)
+
+type queryStatsKey struct {
+	pattern   string
+	queryType types.QueryType
+}
+
+type queryStatsEntry struct {
+	calls   uint64
+	errors  uint64
+	rows    uint64
+	total   uint64
+	latency metrics.Histogram // latency in microseconds
+}
+
+// queryStats collects the aggregated statistics of normalized query patterns.
+type queryStats struct {
+	sync.Mutex
+	since   time.Time
+	entries map[queryStatsKey]*queryStatsEntry
+}
+
+func newQueryStats() *queryStats {
+	return &queryStats{
+		since:   time.Now().UTC(),
+		entries: make(map[queryStatsKey]*queryStatsEntry),
+	}
+}
+
+// normalizeQuery replaces the literal values in the query pattern with bind variables, so that
+// queries only differ in values are aggregated in the same pattern.
+func normalizeQuery(pattern string) string {
+	if stmt, err := sqlparser.Parse(pattern); err == nil {
+		sqlparser.Normalize(stmt, map[string]*querypb.BindVariable{}, "v")
+		return sqlparser.String(stmt)
+	}
+	// fallback to the raw pattern with collapsed blanks for sqlite specified syntax
+	return strings.Join(strings.Fields(pattern), " ")
+}
+
+func normalizeRequest(req *types.Request) string {
+	var patterns = make([]string, len(req.Payload.Queries))
+	for i, q := range req.Payload.Queries {
+		patterns[i] = normalizeQuery(q.Pattern)
+	}
+	return strings.Join(patterns, "; ")
+}
+
+func (s *queryStats) record(
+	req *types.Request, resp *types.Response, failed bool, elapsed time.Duration,
+) {
+	if req == nil {
+		return
+	}
+	var (
+		key = queryStatsKey{
+			pattern:   normalizeRequest(req),
+			queryType: req.Header.QueryType,
+		}
+		us = elapsed.Nanoseconds() / int64(time.Microsecond)
+	)
+
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		if len(s.entries) >= MaxQueryStatsEntries {
+			s.evictLocked()
+		}
+		e = &queryStatsEntry{
+			latency: metrics.NewHistogram(metrics.NewUniformSample(queryStatsSampleSize)),
+		}
+		s.entries[key] = e
+	}
+	e.calls++
+	e.total += uint64(us)
+	e.latency.Update(us)
+	if failed {
+		e.errors++
+	}
+	if resp != nil {
+		// rows returned by the read queries or affected by the write queries
+		e.rows += resp.Header.RowCount
+		if resp.Header.AffectedRows > 0 {
+			e.rows += uint64(resp.Header.AffectedRows)
+		}
+	}
+}
+
+// evictLocked evicts the least called query pattern.
+func (s *queryStats) evictLocked() {
+	var (
+		victim queryStatsKey
+		calls  uint64
+		first  = true
+	)
+	for k, v := range s.entries {
+		if first || v.calls < calls {
+			victim, calls, first = k, v.calls, false
+		}
+	}
+	delete(s.entries, victim)
+}
+
+// snapshot returns the statistics ordered by total latency in descending order, and resets the
+// statistics if required.
+func (s *queryStats) snapshot(reset bool) (since time.Time, stats []*types.QueryStat) {
+	s.Lock()
+	defer s.Unlock()
+	since = s.since
+	stats = make([]*types.QueryStat, 0, len(s.entries))
+	for k, v := range s.entries {
+		var latency = v.latency.Snapshot()
+		stats = append(stats, &types.QueryStat{
+			Pattern:   k.pattern,
+			QueryType: k.queryType,
+			Calls:     v.calls,
+			Errors:    v.errors,
+			Rows:      v.rows,
+			MeanTime:  v.total / v.calls,
+			P99Time:   uint64(latency.Percentile(0.99)),
+			MaxTime:   uint64(latency.Max()),
+			TotalTime: v.total,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].TotalTime != stats[j].TotalTime {
+			return stats[i].TotalTime > stats[j].TotalTime
+		}
+		return stats[i].Pattern < stats[j].Pattern
+	})
+	if reset {
+		s.since = time.Now().UTC()
+		s.entries = make(map[queryStatsKey]*queryStatsEntry)
+	}
+	return
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+func TestQueryStats(t *testing.T) {
+	Convey("test query stats", t, func() {
+		var (
+			s        = newQueryStats()
+			buildReq = func(qt types.QueryType, pattern string) *types.Request {
+				return &types.Request{
+					Header: types.SignedRequestHeader{
+						RequestHeader: types.RequestHeader{QueryType: qt},
+					},
+					Payload: types.RequestPayload{
+						Queries: []types.Query{{Pattern: pattern}},
+					},
+				}
+			}
+			resp = &types.Response{
+				Header: types.SignedResponseHeader{
+					ResponseHeader: types.ResponseHeader{
+						RowCount: 2,
+						Usage:    types.ResourceUsage{RowsScanned: 10},
+					},
+				},
+			}
+		)
+
+		So(normalizeQuery("SELECT * FROM foo WHERE bar = 1"), ShouldEqual,
+			normalizeQuery("select *   from foo where bar = 'baz'"))
+		So(normalizeQuery("PRAGMA  table_info(foo)"), ShouldEqual, "PRAGMA table_info(foo)")
+
+		for i := 0; i < 100; i++ {
+			s.record(buildReq(types.ReadQuery, fmt.Sprintf("SELECT * FROM foo WHERE bar = %d", i)),
+				resp, false, time.Duration(i+1)*time.Millisecond)
+		}
+		s.record(buildReq(types.WriteQuery, "INSERT INTO foo VALUES (1)"),
+			nil, true, time.Millisecond)
+		s.record(buildReq(types.WriteQuery, "INSERT INTO foo VALUES (2)"), &types.Response{
+			Header: types.SignedResponseHeader{
+				ResponseHeader: types.ResponseHeader{
+					AffectedRows: 1,
+					Usage:        types.ResourceUsage{RowsWritten: 1, RowsScanned: 10},
+				},
+			},
+		}, false, time.Millisecond)
+
+		since, stats := s.snapshot(false)
+		So(since, ShouldHappenBefore, time.Now())
+		So(stats, ShouldHaveLength, 2)
+		So(stats[0].QueryType, ShouldEqual, types.ReadQuery)
+		So(stats[0].Calls, ShouldEqual, 100)
+		So(stats[0].Errors, ShouldEqual, 0)
+		So(stats[0].Rows, ShouldEqual, 200)
+		So(stats[0].MeanTime, ShouldEqual, 50500)
+		So(stats[0].P99Time, ShouldBeBetweenOrEqual, 99000, 100000)
+		So(stats[0].MaxTime, ShouldEqual, 100000)
+		So(stats[1].QueryType, ShouldEqual, types.WriteQuery)
+		So(stats[1].Calls, ShouldEqual, 2)
+		So(stats[1].Errors, ShouldEqual, 1)
+		So(stats[1].Rows, ShouldEqual, 1)
+
+		_, stats = s.snapshot(true)
+		So(stats, ShouldHaveLength, 2)
+		_, stats = s.snapshot(false)
+		So(stats, ShouldBeEmpty)
+
+		for i := 0; i <= MaxQueryStatsEntries; i++ {
+			s.record(buildReq(types.ReadQuery, fmt.Sprintf("SELECT * FROM foo%d", i)),
+				resp, false, time.Millisecond)
+		}
+		_, stats = s.snapshot(false)
+		So(stats, ShouldHaveLength, MaxQueryStatsEntries)
+	})
+}
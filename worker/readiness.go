@@ -0,0 +1,169 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// DefaultInitConcurrency defines the default number of databases opened concurrently on startup.
+const DefaultInitConcurrency = 8
+
+// DatabaseState defines the readiness of a database on the miner.
+type DatabaseState int
+
+const (
+	// DatabaseLoading indicates that the database is being opened on startup.
+	DatabaseLoading DatabaseState = iota
+	// DatabaseReady indicates that the database is serving requests.
+	DatabaseReady
+	// DatabaseHibernated indicates that the database is closed while idle, and it's reopened on
+	// the next request or block.
+	DatabaseHibernated
+	// DatabaseFailed indicates that the database failed to open on startup.
+	DatabaseFailed
+)
+
+func (s DatabaseState) String() string {
+	switch s {
+	case DatabaseLoading:
+		return "Loading"
+	case DatabaseReady:
+		return "Ready"
+	case DatabaseHibernated:
+		return "Hibernated"
+	case DatabaseFailed:
+		return "Failed"
+	default:
+		return "Unknown"
+	}
+}
+
+// setReadiness updates the readiness metric of database dbID.
+func setReadiness(dbID proto.DatabaseID, state DatabaseState) {
+	var v = new(expvar.String)
+	v.Set(state.String())
+	dbReadiness.Set(string(dbID), v)
+}
+
+// Readiness returns the readiness of the databases assigned to this miner.
+func (dbms *DBMS) Readiness() (states map[proto.DatabaseID]DatabaseState) {
+	states = make(map[proto.DatabaseID]DatabaseState)
+	for _, v := range []struct {
+		m     *sync.Map
+		state DatabaseState
+	}{
+		{&dbms.failed, DatabaseFailed},
+		{&dbms.loading, DatabaseLoading},
+		{&dbms.hibernated, DatabaseHibernated},
+		{&dbms.dbMap, DatabaseReady},
+	} {
+		v.m.Range(func(key, _ interface{}) bool {
+			states[key.(proto.DatabaseID)] = v.state
+			return true
+		})
+	}
+	return
+}
+
+// loadDatabases opens the databases assigned to this miner concurrently on startup, at most
+// InitConcurrency databases are opened at a time. Each database serves requests as soon as it's
+// opened, and the requests for the loading ones are rejected with ErrDatabaseLoading.
+func (dbms *DBMS) loadDatabases(instances []*types.ServiceInstance) {
+	for _, v := range instances {
+		dbms.loading.Store(v.DatabaseID, true)
+		setReadiness(v.DatabaseID, DatabaseLoading)
+	}
+	var concurrency = dbms.cfg.InitConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultInitConcurrency
+	}
+
+	dbms.wg.Add(1)
+	go func() {
+		defer dbms.wg.Done()
+		var (
+			sem   = make(chan struct{}, concurrency)
+			wg    sync.WaitGroup
+			start = time.Now()
+		)
+		defer func() {
+			wg.Wait()
+			log.WithFields(log.Fields{
+				"count":   len(instances),
+				"elapsed": time.Since(start),
+			}).Info("databases loaded")
+		}()
+		for i, v := range instances {
+			select {
+			case sem <- struct{}{}:
+			case <-dbms.stopCh:
+				for _, v := range instances[i:] {
+					dbms.loading.Delete(v.DatabaseID)
+					dbReadiness.Delete(string(v.DatabaseID))
+				}
+				return
+			}
+			wg.Add(1)
+			go func(instance *types.ServiceInstance) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				dbms.loadDatabase(instance)
+			}(v)
+		}
+	}()
+}
+
+// loadDatabase opens the database assigned to this miner on startup.
+func (dbms *DBMS) loadDatabase(instance *types.ServiceInstance) {
+	var (
+		id    = instance.DatabaseID
+		le    = log.WithField("id", id)
+		start = time.Now()
+		err   error
+	)
+	defer dbms.loading.Delete(id)
+	if err = dbms.create(instance, false); err != nil {
+		le.WithError(err).Error("failed to create database instance")
+		dbms.failed.Store(id, err)
+		setReadiness(id, DatabaseFailed)
+		return
+	}
+	le.WithField("elapsed", time.Since(start)).Info("database loaded")
+
+	// apply the profile updates which may be missed while loading
+	if profile, ok := dbms.busService.RequestSQLProfile(id); ok {
+		if db, ok := dbms.getMeta(id); ok {
+			db.chain.SetLastBillingHeight(int32(profile.LastUpdatedHeight))
+			db.SetSpaceLimit(profile.Meta.Space)
+			for _, miner := range profile.Miners {
+				if miner.Address == dbms.address {
+					db.RotateKey(miner.KeyVersion, miner.EncryptionKey)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,460 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/route"
+	"github.com/CovenantSQL/CovenantSQL/rpc"
+	"github.com/CovenantSQL/CovenantSQL/storage"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+const (
+	// SnapshotFilePattern defines the file name pattern of the storage snapshots, which are taken
+	// for the new peers of the database or fetched from the peers. A snapshot is encrypted with its
+	// own random key, which is only sent to the fetcher through the encrypted rpc.
+	SnapshotFilePattern = "snapshot-*.db3"
+
+	// SnapshotSeqFileName defines the file name of the log offset of the storage restored from a
+	// snapshot.
+	SnapshotSeqFileName = "snapshot.seq"
+
+	// SnapshotChunkSize defines the maximum size of each chunk in fetching snapshot.
+	SnapshotChunkSize = 1 << 20
+
+	// SnapshotExpireTime defines the time to remove a snapshot which is not read by its fetcher.
+	SnapshotExpireTime = 10 * time.Minute
+
+	// SnapshotFetchMaxRetries defines the maximum retry count of fetching snapshot from all peers.
+	SnapshotFetchMaxRetries = 10
+
+	// SnapshotFetchRetryInterval defines the interval between the retries of fetching snapshot.
+	SnapshotFetchRetryInterval = 10 * time.Second
+
+	snapshotKeySize = 32
+)
+
+// storageSnapshot defines a storage snapshot taken for a fetcher.
+type storageSnapshot struct {
+	path       string
+	key        string
+	seq        uint64 // log offset of the next write query not included in the snapshot
+	size       int64
+	lastAccess time.Time
+}
+
+// newSnapshotKey returns a random encryption key of snapshot.
+func newSnapshotKey() (key string, err error) {
+	var buf = make([]byte, snapshotKeySize)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// snapshotDSN returns the dsn of the snapshot file at path encrypted with key.
+func snapshotDSN(path, key string) (dsn string, err error) {
+	var d *storage.DSN
+	if d, err = storage.NewDSN(path); err != nil {
+		return
+	}
+	d.AddParam("_crypto_key", key)
+	return d.Format(), nil
+}
+
+// removeSnapshotFiles removes the snapshot file at path and its journal files.
+func removeSnapshotFiles(path string) (err error) {
+	for _, suffix := range []string{"", "-journal", "-wal", "-shm"} {
+		if ierr := os.Remove(path + suffix); ierr != nil && !os.IsNotExist(ierr) {
+			err = ierr
+		}
+	}
+	return
+}
+
+// removeStaleSnapshots removes the snapshot files left in dataDir, e.g., by a crash.
+func removeStaleSnapshots(dataDir string) (err error) {
+	var paths []string
+	if paths, err = filepath.Glob(filepath.Join(dataDir, SnapshotFilePattern)); err != nil {
+		return
+	}
+	for _, v := range paths {
+		if err = removeSnapshotFiles(v); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// takeSnapshot takes a new storage snapshot for a fetcher.
+func (db *Database) takeSnapshot() (snap *storageSnapshot, err error) {
+	var f *os.File
+	if f, err = ioutil.TempFile(db.cfg.DataDir, SnapshotFilePattern); err != nil {
+		return
+	}
+	snap = &storageSnapshot{path: f.Name()}
+	if err = f.Close(); err != nil {
+		_ = removeSnapshotFiles(snap.path)
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = removeSnapshotFiles(snap.path)
+		}
+	}()
+	var dsn string
+	if snap.key, err = newSnapshotKey(); err != nil {
+		return
+	}
+	if dsn, err = snapshotDSN(snap.path, snap.key); err != nil {
+		return
+	}
+	if err = db.chain.Snapshot(func(seq uint64) (err error) {
+		if err = xs.Export(db.keys.storageDSN(), dsn); err != nil {
+			return
+		}
+		snap.seq = seq
+		return
+	}); err != nil {
+		return
+	}
+	var fi os.FileInfo
+	if fi, err = os.Stat(snap.path); err != nil {
+		return
+	}
+	snap.size = fi.Size()
+	return
+}
+
+// fetchSnapshot reads a chunk of the storage snapshot id at offset, a new snapshot is taken if id
+// is empty. The snapshot file is removed once the last chunk is read, or it's not read in time.
+func (db *Database) fetchSnapshot(id string, offset int64) (res *FetchSnapshotResp, err error) {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+
+	var now = time.Now()
+	for k, v := range db.snapshots {
+		if now.Sub(v.lastAccess) > SnapshotExpireTime {
+			_ = removeSnapshotFiles(v.path)
+			delete(db.snapshots, k)
+		}
+	}
+
+	var snap *storageSnapshot
+	if id == "" {
+		if offset != 0 {
+			err = errors.Wrapf(ErrInvalidRequest, "new snapshot read at offset %d", offset)
+			return
+		}
+		if snap, err = db.takeSnapshot(); err != nil {
+			return
+		}
+		id = filepath.Base(snap.path)
+		db.snapshots[id] = snap
+	} else if snap = db.snapshots[id]; snap == nil {
+		err = errors.Wrapf(ErrInvalidRequest, "snapshot %s not found", id)
+		return
+	}
+	snap.lastAccess = now
+	if offset < 0 || offset >= snap.size {
+		err = errors.Wrapf(ErrInvalidRequest, "snapshot offset %d out of range %d",
+			offset, snap.size)
+		return
+	}
+
+	var f *os.File
+	if f, err = os.Open(snap.path); err != nil {
+		return
+	}
+	defer func() { _ = f.Close() }()
+	res = &FetchSnapshotResp{
+		Snapshot: id,
+		Key:      snap.key,
+		Seq:      snap.seq,
+		Size:     snap.size,
+	}
+	if res.Data = make([]byte, snap.size-offset); len(res.Data) > SnapshotChunkSize {
+		res.Data = res.Data[:SnapshotChunkSize]
+	}
+	if _, err = f.ReadAt(res.Data, offset); err != nil {
+		return
+	}
+	if offset+int64(len(res.Data)) == snap.size {
+		delete(db.snapshots, id)
+		err = removeSnapshotFiles(snap.path)
+	}
+	return
+}
+
+// removeSnapshots removes the snapshot files which are not fully read yet.
+func (db *Database) removeSnapshots() (err error) {
+	db.snapshotLock.Lock()
+	defer db.snapshotLock.Unlock()
+	for k, v := range db.snapshots {
+		if ierr := removeSnapshotFiles(v.path); ierr != nil {
+			err = ierr
+		}
+		delete(db.snapshots, k)
+	}
+	return
+}
+
+// loadSnapshotSeq returns the log offset of the storage restored from a snapshot, or 0 if the
+// storage is not restored from any snapshot.
+func loadSnapshotSeq(dataDir string) (seq uint64, err error) {
+	var content []byte
+	if content, err = ioutil.ReadFile(filepath.Join(dataDir, SnapshotSeqFileName)); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+}
+
+// restoreSnapshot fetches the storage snapshot of the database from the peers, and writes it into
+// the storage file under dataDir encrypted with key.
+func (dbms *DBMS) restoreSnapshot(
+	dbID proto.DatabaseID, dataDir string, peers []proto.NodeID, key string) (err error,
+) {
+	if len(peers) == 0 {
+		return errors.Wrap(ErrInvalidRequest, "no peer to fetch snapshot")
+	}
+	if err = os.MkdirAll(dataDir, 0755); err != nil {
+		return
+	}
+	var (
+		caller  = rpc.NewCaller()
+		f       *os.File
+		path    string
+		snapKey string
+		seq     uint64
+	)
+	if f, err = ioutil.TempFile(dataDir, SnapshotFilePattern); err != nil {
+		return
+	}
+	path = f.Name()
+	defer func() { _ = removeSnapshotFiles(path) }()
+	if err = f.Close(); err != nil {
+		return
+	}
+	for i := 0; i < SnapshotFetchMaxRetries; i++ {
+		var peer = peers[i%len(peers)]
+		if seq, snapKey, err = fetchSnapshotFrom(caller, peer, dbID, path); err == nil {
+			break
+		}
+		log.WithFields(log.Fields{
+			"id":   dbID,
+			"peer": peer,
+		}).WithError(err).Warning("failed to fetch snapshot")
+		time.Sleep(SnapshotFetchRetryInterval)
+	}
+	if err != nil {
+		return
+	}
+
+	// re-encrypt the snapshot with the local key
+	var src string
+	if src, err = snapshotDSN(path, snapKey); err != nil {
+		return
+	}
+	var dsn *storage.DSN
+	if dsn, err = storage.NewDSN(filepath.Join(dataDir, StorageFileName)); err != nil {
+		return
+	}
+	if key != "" {
+		dsn.AddParam("_crypto_key", key)
+	}
+	if err = xs.Export(src, dsn.Format()); err != nil {
+		return
+	}
+	return ioutil.WriteFile(filepath.Join(dataDir, SnapshotSeqFileName),
+		[]byte(strconv.FormatUint(seq, 10)), 0600)
+}
+
+// fetchSnapshotFrom fetches a new snapshot chunk by chunk from peer into file path, and returns
+// the log offset and the encryption key of the snapshot.
+func fetchSnapshotFrom(
+	caller *rpc.Caller, peer proto.NodeID, dbID proto.DatabaseID, path string,
+) (seq uint64, key string, err error) {
+	var (
+		f      *os.File
+		id     string
+		offset int64
+		size   int64 = -1
+	)
+	if f, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600); err != nil {
+		return
+	}
+	defer func() { _ = f.Close() }()
+	for offset != size {
+		var (
+			req  = &FetchSnapshotReq{DatabaseID: dbID, Snapshot: id, Offset: offset}
+			resp = &FetchSnapshotResp{}
+		)
+		if err = caller.CallNode(peer, route.DBSFetchSnapshot.String(), req, resp); err != nil {
+			return
+		}
+		if offset > 0 && (resp.Snapshot != id || resp.Key != key ||
+			resp.Seq != seq || resp.Size != size) {
+			err = errors.Wrap(ErrInvalidRequest, "snapshot is changed during fetch")
+			return
+		}
+		if resp.Snapshot == "" || resp.Key == "" ||
+			len(resp.Data) == 0 || offset+int64(len(resp.Data)) > resp.Size {
+			err = errors.Wrap(ErrInvalidRequest, "invalid snapshot chunk")
+			return
+		}
+		if _, err = f.WriteAt(resp.Data, offset); err != nil {
+			return
+		}
+		id, key, seq, size = resp.Snapshot, resp.Key, resp.Seq, resp.Size
+		offset += int64(len(resp.Data))
+	}
+	err = f.Sync()
+	return
+}
+
+// resyncDatabase drops the local database diverged at height, and restores it from the snapshot
+// of the other miners. The divergence of block must be confirmed by the majority of the miners,
+// which agree on a state hash other than the local one.
+func (dbms *DBMS) resyncDatabase(dbID proto.DatabaseID, height int32, block hash.Hash) {
+	var le = log.WithFields(log.Fields{"id": dbID, "height": height, "block": block.String()})
+	if _, joining := dbms.joining.Load(dbID); joining {
+		return
+	}
+	profile, ok := dbms.busService.RequestSQLProfile(dbID)
+	if !ok {
+		le.Error("failed to resync diverged database: profile not found")
+		return
+	}
+	db, err := dbms.getDatabase(dbID)
+	if err != nil {
+		le.WithError(err).Error("failed to resync diverged database")
+		return
+	}
+	local, ok := db.LocalStateHash(block)
+	if !ok {
+		le.Warning("local state hash not found, skip resync")
+		return
+	}
+	peers := dbms.confirmDivergence(profile, block, local)
+	if peers == nil {
+		le.Warning("state divergence is not confirmed by the majority, skip resync")
+		return
+	}
+	le.Warning("resync diverged database")
+	if err = dbms.Drop(dbID); err != nil {
+		le.WithError(err).Error("failed to drop diverged database")
+		return
+	}
+	dbms.joinDatabase(profile, peers)
+}
+
+// confirmDivergence queries the state hash of block from the other miners of the database, and
+// returns the ones agreeing on the same state hash other than local if they are the majority of
+// all the miners, or nil if the divergence is not confirmed.
+func (dbms *DBMS) confirmDivergence(
+	profile *types.SQLChainProfile, block, local hash.Hash) (peers []proto.NodeID,
+) {
+	var (
+		caller = rpc.NewCaller()
+		groups = make(map[hash.Hash][]proto.NodeID)
+	)
+	for _, miner := range profile.Miners {
+		if miner.Address == dbms.address {
+			continue
+		}
+		var (
+			req  = &StateHashReq{DatabaseID: profile.ID, Block: block}
+			resp = &StateHashResp{}
+		)
+		if err := caller.CallNode(
+			miner.NodeID, route.DBSStateHash.String(), req, resp,
+		); err != nil {
+			log.WithFields(log.Fields{
+				"id":   profile.ID,
+				"peer": miner.NodeID,
+			}).WithError(err).Warning("failed to query state hash")
+			continue
+		}
+		if resp.Found && !resp.StateHash.IsEqual(&local) {
+			groups[resp.StateHash] = append(groups[resp.StateHash], miner.NodeID)
+		}
+	}
+	for _, v := range groups {
+		if len(v)*2 > len(profile.Miners) {
+			return v
+		}
+	}
+	return nil
+}
+
+// joinDatabase restores the database assigned to this miner by the replica replacement from the
+// snapshot of peers, or of all the other miners if peers is nil, and starts the database instance.
+func (dbms *DBMS) joinDatabase(profile *types.SQLChainProfile, peers []proto.NodeID) {
+	if _, loaded := dbms.joining.LoadOrStore(profile.ID, true); loaded {
+		return
+	}
+	defer dbms.joining.Delete(profile.ID)
+
+	var (
+		le      = log.WithField("id", profile.ID)
+		rootDir = filepath.Join(dbms.cfg.RootDir, string(profile.ID))
+	)
+	if peers == nil {
+		for _, miner := range profile.Miners {
+			if miner.Address != dbms.address {
+				peers = append(peers, miner.NodeID)
+			}
+		}
+	}
+	instance, err := dbms.buildSQLChainServiceInstance(profile)
+	if err != nil {
+		le.WithError(err).Error("failed to build database instance")
+		return
+	}
+	if err = os.RemoveAll(rootDir); err != nil {
+		le.WithError(err).Error("failed to clean database directory")
+		return
+	}
+	le.Info("restore database from snapshot")
+	if err = dbms.restoreSnapshot(
+		profile.ID, rootDir, peers, instance.ResourceMeta.EncryptionKey,
+	); err != nil {
+		le.WithError(err).Error("failed to restore database from snapshot")
+		return
+	}
+	if err = dbms.Create(instance, false); err != nil {
+		le.WithError(err).Error("failed to create database instance")
+	}
+}
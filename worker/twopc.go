@@ -0,0 +1,519 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/proto"
+	"github.com/CovenantSQL/CovenantSQL/twopc"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+const (
+	// TxMetaFileName defines the multi-database transaction meta file name of database instance.
+	TxMetaFileName = "tx.meta"
+
+	// TxRecordRetention defines how long the finished transaction branches are kept, they are
+	// queried by the other branches of the transaction during recovery.
+	TxRecordRetention = time.Hour
+)
+
+var (
+	// TxRecoveryInterval defines the interval to retry the recovery of an in-doubt transaction
+	// branch, which is prepared but not decided by the coordinator before the deadline.
+	TxRecoveryInterval = 5 * time.Second
+)
+
+// TxResolver queries the state of the multi-database transaction branch on database dbID.
+type TxResolver func(dbID proto.DatabaseID, txID hash.Hash) (*types.TxResponse, error)
+
+// txRecord defines a multi-database transaction branch on the database.
+type txRecord struct {
+	TxID         hash.Hash
+	State        types.TxState
+	Participants []proto.DatabaseID
+	Deadline     time.Time
+	Request      *types.Request
+	Response     *types.Response
+	UpdateTime   time.Time
+}
+
+// expired reports whether the prepared branch is past its deadline, it doesn't accept the commit
+// decision of the coordinator any more and is left to the recovery.
+func (r *txRecord) expired() bool {
+	return r.State == types.TxPrepared && time.Now().After(r.Deadline)
+}
+
+// txBranches manages the multi-database transaction branches of the database.
+//
+// At most one branch is prepared at a time, the other write queries of the database are blocked
+// until it's committed or rolled back, so that the checked write request is guaranteed to succeed
+// on commit. The branches are persisted in the tx meta file to be recovered on restart.
+//
+// A prepared branch which is not decided by the coordinator before its deadline is in doubt, and
+// it's resolved by querying the other branches of the transaction: it's committed if any of them
+// is committed, or rolled back if any of them is rolled back or unknown, or if all of them are
+// expired too. An unknown branch is marked as rolled back once it's queried, so it can't be
+// prepared later.
+type txBranches struct {
+	sync.Mutex
+	dbID      proto.DatabaseID
+	path      string
+	records   map[hash.Hash]*txRecord
+	prepared  *txRecord
+	preparing bool
+	timer     *time.Timer
+	closed    bool
+	window    time.Duration // write time window of the requests
+
+	// writes is held exclusively by the prepared branch.
+	writes sync.RWMutex
+
+	check   func(*types.Request) error
+	apply   func(*types.Request) (*types.Response, error)
+	resolve TxResolver
+}
+
+func newTxBranches(
+	dbID proto.DatabaseID, path string, window time.Duration, check func(*types.Request) error,
+	apply func(*types.Request) (*types.Response, error), resolve TxResolver,
+) (
+	t *txBranches, err error,
+) {
+	t = &txBranches{
+		dbID:    dbID,
+		path:    path,
+		records: make(map[hash.Hash]*txRecord),
+		window:  window,
+		check:   check,
+		apply:   apply,
+		resolve: resolve,
+	}
+	var (
+		content []byte
+		records []*txRecord
+	)
+	if content, err = ioutil.ReadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	if err = utils.DecodeMsgPack(content, &records); err != nil {
+		err = errors.Wrap(err, "decode tx meta")
+		return
+	}
+	for _, v := range records {
+		t.records[v.TxID] = v
+	}
+	for _, v := range records {
+		if v.State == types.TxPrepared {
+			// resume the prepared branch
+			t.writes.Lock()
+			t.prepared = v
+			t.schedule(v.Deadline)
+			break
+		}
+	}
+	return
+}
+
+// save persists the branches, the finished ones beyond retention are dropped. It must be called
+// with the lock held.
+func (t *txBranches) save() (err error) {
+	var (
+		expire  = time.Now().Add(-TxRecordRetention)
+		records = make([]*txRecord, 0, len(t.records))
+	)
+	for k, v := range t.records {
+		if v.State != types.TxPrepared && v.UpdateTime.Before(expire) {
+			delete(t.records, k)
+			continue
+		}
+		records = append(records, v)
+	}
+	var buf, ierr = utils.EncodeMsgPack(records)
+	if ierr != nil {
+		return ierr
+	}
+	var tmp = t.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return
+	}
+	return os.Rename(tmp, t.path)
+}
+
+// schedule schedules the recovery of the prepared branch at the given time. It must be called
+// with the lock held.
+func (t *txBranches) schedule(at time.Time) {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.timer = time.AfterFunc(time.Until(at), t.recover)
+}
+
+// finish sets the prepared branch to the final state and unblocks the write queries. It must be
+// called with the lock held.
+func (t *txBranches) finish(rec *txRecord, state types.TxState) (err error) {
+	rec.State = state
+	rec.UpdateTime = time.Now()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+	t.prepared = nil
+	t.writes.Unlock()
+	return t.save()
+}
+
+func (t *txBranches) prepare(h *types.TxRequestHeader, req *types.Request) (err error) {
+	if req == nil || req.Header.QueryType != types.WriteQuery || req.Header.DatabaseID != t.dbID {
+		return errors.Wrap(ErrInvalidRequest, "invalid transaction write request")
+	}
+	var participant bool
+	for _, v := range h.Participants {
+		participant = participant || v == t.dbID
+	}
+	if !participant {
+		return errors.Wrap(ErrInvalidRequest, "database is not a transaction participant")
+	}
+
+	t.Lock()
+	if rec, ok := t.records[h.TxID]; ok {
+		t.Unlock()
+		if rec.State == types.TxRolledBack {
+			return ErrTxRolledBack
+		}
+		return
+	}
+	if t.closed || t.prepared != nil || t.preparing {
+		t.Unlock()
+		return ErrTxBusy
+	}
+	t.preparing = true
+	t.Unlock()
+
+	// wait for the ongoing write queries
+	t.writes.Lock()
+	t.Lock()
+	defer t.Unlock()
+	t.preparing = false
+	if _, ok := t.records[h.TxID]; ok {
+		// rolled back by the coordinator or the recovery of the other branches meanwhile
+		t.writes.Unlock()
+		return ErrTxRolledBack
+	}
+	var rec = &txRecord{
+		TxID:         h.TxID,
+		State:        types.TxPrepared,
+		Participants: h.Participants,
+		Deadline:     h.Deadline,
+		Request:      req,
+		UpdateTime:   time.Now(),
+	}
+	if limit := req.Header.Timestamp.Add(t.window / 2); t.window > 0 && rec.Deadline.After(limit) {
+		// the branch should be committed while the request is still in the write time window
+		rec.Deadline = limit
+	}
+	if time.Now().After(rec.Deadline) {
+		err = ErrTxExpired
+	} else {
+		err = t.check(req)
+	}
+	if err != nil {
+		rec.State = types.TxRolledBack
+		rec.Request = nil
+		t.records[rec.TxID] = rec
+		t.writes.Unlock()
+		if ierr := t.save(); ierr != nil {
+			log.WithField("db", t.dbID).WithError(ierr).Error("failed to save tx meta")
+		}
+		return
+	}
+	t.records[rec.TxID] = rec
+	if err = t.save(); err != nil {
+		delete(t.records, rec.TxID)
+		t.writes.Unlock()
+		return
+	}
+	t.prepared = rec
+	t.schedule(rec.Deadline)
+	return
+}
+
+func (t *txBranches) commit(txID hash.Hash) (resp *types.Response, err error) {
+	t.Lock()
+	defer t.Unlock()
+	var rec, ok = t.records[txID]
+	if !ok {
+		return nil, ErrTxNotPrepared
+	}
+	switch rec.State {
+	case types.TxCommitted:
+		return rec.Response, nil
+	case types.TxRolledBack:
+		return nil, ErrTxRolledBack
+	}
+	if rec.expired() {
+		return nil, ErrTxExpired
+	}
+	return t.commitPrepared(rec)
+}
+
+// commitPrepared writes the request of the prepared branch. It must be called with the lock held.
+func (t *txBranches) commitPrepared(rec *txRecord) (resp *types.Response, err error) {
+	if resp, err = t.apply(rec.Request); err != nil {
+		return
+	}
+	rec.Response = resp
+	if err = t.finish(rec, types.TxCommitted); err != nil {
+		log.WithField("db", t.dbID).WithError(err).Error("failed to save tx meta")
+		err = nil
+	}
+	return
+}
+
+func (t *txBranches) rollback(txID hash.Hash) (err error) {
+	t.Lock()
+	defer t.Unlock()
+	var rec, ok = t.records[txID]
+	if !ok {
+		t.records[txID] = &txRecord{
+			TxID:       txID,
+			State:      types.TxRolledBack,
+			UpdateTime: time.Now(),
+		}
+		return t.save()
+	}
+	switch rec.State {
+	case types.TxCommitted:
+		return ErrTxCommitted
+	case types.TxRolledBack:
+		return
+	}
+	return t.finish(rec, types.TxRolledBack)
+}
+
+func (t *txBranches) status(txID hash.Hash) (res *types.TxResponse) {
+	t.Lock()
+	defer t.Unlock()
+	var rec, ok = t.records[txID]
+	if !ok {
+		// never prepared, presume it's rolled back
+		rec = &txRecord{
+			TxID:       txID,
+			State:      types.TxRolledBack,
+			UpdateTime: time.Now(),
+		}
+		t.records[txID] = rec
+		if err := t.save(); err != nil {
+			log.WithField("db", t.dbID).WithError(err).Error("failed to save tx meta")
+		}
+	}
+	return &types.TxResponse{
+		State:   rec.State,
+		Expired: rec.expired(),
+	}
+}
+
+// decide resolves the state of the in-doubt branch by querying the other branches, it returns
+// TxPrepared if the branch is still in doubt.
+func (t *txBranches) decide(rec *txRecord) types.TxState {
+	var rolledBack, allExpired = false, true
+	for _, v := range rec.Participants {
+		if v == t.dbID {
+			continue
+		}
+		if t.resolve == nil {
+			allExpired = false
+			continue
+		}
+		var res, err = t.resolve(v, rec.TxID)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"db":          t.dbID,
+				"tx":          rec.TxID.String(),
+				"participant": v,
+			}).WithError(err).Warning("failed to query tx state")
+			allExpired = false
+			continue
+		}
+		switch res.State {
+		case types.TxCommitted:
+			return types.TxCommitted
+		case types.TxPrepared:
+			allExpired = allExpired && res.Expired
+		default:
+			rolledBack = true
+		}
+	}
+	if rolledBack || allExpired {
+		return types.TxRolledBack
+	}
+	return types.TxPrepared
+}
+
+// recover resolves the in-doubt branch, it's retried until the branch is decided.
+func (t *txBranches) recover() {
+	t.Lock()
+	var rec = t.prepared
+	if t.closed || rec == nil {
+		t.Unlock()
+		return
+	}
+	if !rec.expired() {
+		t.schedule(rec.Deadline)
+		t.Unlock()
+		return
+	}
+	t.Unlock()
+
+	var state = t.decide(rec)
+
+	t.Lock()
+	defer t.Unlock()
+	if t.closed || t.prepared != rec {
+		return
+	}
+	var (
+		le = log.WithFields(log.Fields{
+			"db":    t.dbID,
+			"tx":    rec.TxID.String(),
+			"state": state.String(),
+		})
+		err error
+	)
+	switch state {
+	case types.TxCommitted:
+		_, err = t.commitPrepared(rec)
+	case types.TxRolledBack:
+		err = t.finish(rec, types.TxRolledBack)
+	}
+	if err != nil || state == types.TxPrepared {
+		le.WithError(err).Warning("in-doubt tx is not resolved yet")
+		t.schedule(time.Now().Add(TxRecoveryInterval))
+		return
+	}
+	le.Info("in-doubt tx resolved")
+}
+
+// pending reports whether a branch is being prepared or prepared and not decided yet.
+func (t *txBranches) pending() bool {
+	t.Lock()
+	defer t.Unlock()
+	return t.preparing || t.prepared != nil
+}
+
+func (t *txBranches) stop() {
+	t.Lock()
+	defer t.Unlock()
+	t.closed = true
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// TxWorker adapts the multi-database transaction branch of a database to twopc.Worker, the
+// twopc.WriteBatch passed to its methods should be the *types.TxRequestHeader shared by all
+// the branches of the transaction.
+type TxWorker struct {
+	db      *Database
+	request *types.Request
+}
+
+// NewTxWorker returns a new TxWorker of database db, request is the write request of the branch,
+// which is only required to prepare the branch.
+func NewTxWorker(db *Database, request *types.Request) *TxWorker {
+	return &TxWorker{
+		db:      db,
+		request: request,
+	}
+}
+
+func txHeader(wb twopc.WriteBatch) (h *types.TxRequestHeader, err error) {
+	var ok bool
+	if h, ok = wb.(*types.TxRequestHeader); !ok {
+		err = errors.Wrap(ErrInvalidRequest, "unexpected WriteBatch type")
+	}
+	return
+}
+
+// Prepare implements twopc.Worker.Prepare.
+func (w *TxWorker) Prepare(ctx context.Context, wb twopc.WriteBatch) (err error) {
+	var h *types.TxRequestHeader
+	if h, err = txHeader(wb); err != nil {
+		return
+	}
+	return w.db.txs.prepare(h, w.request)
+}
+
+// Commit implements twopc.Worker.Commit, the result is the *types.Response of the write request.
+func (w *TxWorker) Commit(ctx context.Context, wb twopc.WriteBatch) (result interface{}, err error) {
+	var h *types.TxRequestHeader
+	if h, err = txHeader(wb); err != nil {
+		return
+	}
+	var resp *types.Response
+	if resp, err = w.db.txs.commit(h.TxID); err != nil {
+		return
+	}
+	return resp, nil
+}
+
+// Rollback implements twopc.Worker.Rollback.
+func (w *TxWorker) Rollback(ctx context.Context, wb twopc.WriteBatch) (err error) {
+	var h *types.TxRequestHeader
+	if h, err = txHeader(wb); err != nil {
+		return
+	}
+	return w.db.txs.rollback(h.TxID)
+}
+
+// TxStatus returns the state of the multi-database transaction branch on the database, the
+// branch is marked as rolled back if it's never prepared.
+func (db *Database) TxStatus(txID hash.Hash) *types.TxResponse {
+	return db.txs.status(txID)
+}
+
+// checkTx verifies the write request of a transaction branch, and executes it on the current
+// state without changing it.
+func (db *Database) checkTx(req *types.Request) (err error) {
+	if err = db.verifyRequest(req); err != nil {
+		return
+	}
+	if used, limit := db.SpaceUsage(); limit > 0 && used >= limit {
+		return errors.Wrapf(ErrSpaceLimitExceeded, "database size %d reaches quota %d", used, limit)
+	}
+	return db.chain.Check(req)
+}
+
+// applyTx writes the request of a prepared transaction branch, the other write queries are
+// blocked meanwhile.
+func (db *Database) applyTx(req *types.Request) (resp *types.Response, err error) {
+	return db.query(req, nil)
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/CovenantSQL/sqlparser"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/utils/log"
+)
+
+// Attachment defines a database attached read-only to a read query.
+type Attachment struct {
+	Name string // schema name of the database referenced by the query
+	File string // storage file of the database
+	Key  string // encryption key of the storage file, if any
+}
+
+// ReferencedDatabases returns the schema names qualifying the tables in the query pattern, except
+// the main and temp schemas of the local database. The stored procedures only reference the
+// local database.
+func ReferencedDatabases(pattern string) (names []string, err error) {
+	if _, ok := CalledProcedure(pattern); ok || isTxControlQuery(pattern) {
+		return
+	}
+	var (
+		tokenizer  = sqlparser.NewStringTokenizer(pattern)
+		statements []sqlparser.Statement
+		walkNodes  []sqlparser.SQLNode
+		seen       = make(map[string]bool)
+	)
+	if _, statements, err = sqlparser.ParseMultiple(tokenizer); err != nil {
+		err = errors.Wrap(err, "parse sql failed")
+		return
+	}
+	for _, v := range statements {
+		walkNodes = append(walkNodes, v)
+	}
+	err = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		if n, ok := node.(sqlparser.TableName); ok && !n.Qualifier.IsEmpty() {
+			var name = n.Qualifier.String()
+			if lowered := strings.ToLower(name); lowered != "main" && lowered != "temp" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		return true, nil
+	}, walkNodes...)
+	return
+}
+
+// attach reserves a connection of db and attaches the databases to it. The returned detach
+// function detaches the databases and releases the connection.
+func attach(
+	ctx context.Context, db *sql.DB, attached []Attachment) (conn *sql.Conn, detach func(), err error,
+) {
+	var count int
+	if conn, err = db.Conn(ctx); err != nil {
+		return
+	}
+	detach = func() {
+		for _, v := range attached[:count] {
+			if _, err := conn.ExecContext(
+				context.Background(), `DETACH DATABASE "`+v.Name+`"`,
+			); err != nil {
+				log.WithError(err).WithField("name", v.Name).Error("failed to detach database")
+			}
+		}
+		_ = conn.Close()
+	}
+	for _, v := range attached {
+		if strings.ContainsRune(v.Name, '"') {
+			err = errors.Wrapf(ErrInvalidRequest, "invalid database name %s", v.Name)
+			break
+		}
+		if _, err = conn.ExecContext(
+			ctx, `ATTACH DATABASE ? AS "`+v.Name+`" KEY ?`, v.File, v.Key,
+		); err != nil {
+			err = errors.Wrapf(err, "attach database %s", v.Name)
+			break
+		}
+		count++
+	}
+	if err != nil {
+		detach()
+		conn, detach = nil, nil
+	}
+	return
+}
+
+// PinFunc pins the states of the databases read by a query, the states are not changed until
+// release is called.
+type PinFunc func() (release func(), err error)
+
+// pinSnapshots starts the read transaction on the main database and each attached database of
+// tx while they are pinned, the read transaction is started lazily by sqlite on the first read of
+// each database.
+func pinSnapshots(ctx context.Context, tx *sql.Tx, attached []Attachment, pin PinFunc) (err error) {
+	var count int
+	if pin != nil {
+		var release func()
+		if release, err = pin(); err != nil {
+			return
+		}
+		defer release()
+	}
+	if err = tx.QueryRowContext(ctx, `SELECT COUNT(1) FROM "main".sqlite_master`).Scan(&count); err != nil {
+		return
+	}
+	for _, v := range attached {
+		if err = tx.QueryRowContext(
+			ctx, `SELECT COUNT(1) FROM "`+v.Name+`".sqlite_master`,
+		).Scan(&count); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+// ChangeSink is the callback function which receives the row changes captured by a State. The
+// changes are delivered in order of their positions once the write requests are committed to the
+// storage. The changes are delivered again with the following ones if the sink returns an error.
+type ChangeSink func(changes []*types.RowChange) error
+
+type sqlContextHandler interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// withoutRowidRegexp matches the DDL creating a WITHOUT ROWID table, the row changes of which are
+// reported without rowid by the preupdate hook of sqlite.
+var withoutRowidRegexp = regexp.MustCompile(`(?i)\bWITHOUT\s+ROWID\b`)
+
+// changeCapture captures the row changes made by the write queries of a State.
+//
+// The row changes are reported with the old and new values by the preupdate hook of sqlite while
+// a statement is executed, and the column names and primary key of the tables are read after the
+// statement. The changes of the applied requests are queued until they are committed by the
+// ongoing transaction of the State.
+type changeCapture struct {
+	sync.Mutex
+	sink    ChangeSink
+	updates []*xi.RowUpdate    // reported by the hook on the ongoing statement
+	pending []*types.RowChange // captured on the ongoing request
+	queued  []*types.RowChange // captured on the applied requests, not committed yet
+
+	// tables caches the schemas of tables, it's guarded by the State lock.
+	tables map[string]*tableSchema
+}
+
+// tableSchema is the column names and primary key columns of a table.
+type tableSchema struct {
+	columns []string
+	pk      []string
+}
+
+func newChangeCapture(sink ChangeSink) *changeCapture {
+	return &changeCapture{
+		sink:   sink,
+		tables: make(map[string]*tableSchema),
+	}
+}
+
+func (c *changeCapture) onUpdate(u *xi.RowUpdate) {
+	c.Lock()
+	defer c.Unlock()
+	c.updates = append(c.updates, u)
+}
+
+func (c *changeCapture) takeUpdates() (updates []*xi.RowUpdate) {
+	c.Lock()
+	defer c.Unlock()
+	updates, c.updates = c.updates, nil
+	return
+}
+
+func quoteIdentifier(id string) string {
+	return `"` + strings.Replace(id, `"`, `""`, -1) + `"`
+}
+
+// qualifiedTable returns the table name of u, which is qualified by the database name if it's
+// not the main database.
+func qualifiedTable(u *xi.RowUpdate) string {
+	if u.Database != "main" {
+		return u.Database + "." + u.Table
+	}
+	return u.Table
+}
+
+// tableSchema returns the schema of the table.
+func (c *changeCapture) tableSchema(
+	ctx context.Context, h sqlContextHandler, database, table string) (ts *tableSchema, err error,
+) {
+	var (
+		key  = database + "." + table
+		ok   bool
+		rows *sql.Rows
+	)
+	if ts, ok = c.tables[key]; ok {
+		return
+	}
+	if rows, err = h.QueryContext(ctx, "PRAGMA "+quoteIdentifier(database)+
+		".table_info("+quoteIdentifier(table)+")"); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	var (
+		orders = make(map[string]int64)
+		schema = &tableSchema{}
+	)
+	for rows.Next() {
+		var (
+			cid, notNull, order int64
+			name, typ           string
+			dflt                interface{}
+		)
+		if err = rows.Scan(&cid, &name, &typ, &notNull, &dflt, &order); err != nil {
+			return
+		}
+		schema.columns = append(schema.columns, name)
+		if order > 0 {
+			orders[name] = order
+			schema.pk = append(schema.pk, name)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	sort.Slice(schema.pk, func(i, j int) bool { return orders[schema.pk[i]] < orders[schema.pk[j]] })
+	c.tables[key] = schema
+	ts = schema
+	return
+}
+
+// capture builds the row changes from the updates reported on the statement just executed on h,
+// the offset is the log offset of the statement in the state.
+func (c *changeCapture) capture(
+	ctx context.Context, h sqlContextHandler, offset uint64, updates []*xi.RowUpdate,
+) (err error) {
+	var changes = make([]*types.RowChange, 0, len(updates))
+	for i, u := range updates {
+		var (
+			change = &types.RowChange{
+				Position: types.ChangePosition{Offset: offset, Index: uint32(i)},
+				Table:    qualifiedTable(u),
+				RowID:    u.RowID,
+				Old:      u.Old,
+				New:      u.New,
+			}
+			ts *tableSchema
+		)
+		switch u.Op {
+		case xi.UpdateInsert:
+			change.Op = types.ChangeInsert
+		case xi.UpdateUpdate:
+			change.Op = types.ChangeUpdate
+		case xi.UpdateDelete:
+			change.Op = types.ChangeDelete
+		}
+		if ts, err = c.tableSchema(ctx, h, u.Database, u.Table); err != nil {
+			return
+		}
+		change.Columns = ts.columns
+		change.PrimaryKey = buildPrimaryKey(ts.pk, ts.columns, change, u.RowID)
+		changes = append(changes, change)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.pending = append(c.pending, changes...)
+	return
+}
+
+func buildPrimaryKey(pk, columns []string, change *types.RowChange, rowid int64) (values []interface{}) {
+	var row = change.New
+	if row == nil {
+		row = change.Old
+	}
+	if len(pk) == 0 || row == nil {
+		return []interface{}{rowid}
+	}
+	values = make([]interface{}, 0, len(pk))
+	for _, name := range pk {
+		for i, col := range columns {
+			if col == name && i < len(row) {
+				values = append(values, row[i])
+				break
+			}
+		}
+	}
+	return
+}
+
+// resetSchema drops the cached table schemas, it should be called on any schema change.
+func (c *changeCapture) resetSchema() {
+	c.tables = make(map[string]*tableSchema)
+}
+
+// apply queues the pending changes of the applied request until they are committed.
+func (c *changeCapture) apply() {
+	c.Lock()
+	defer c.Unlock()
+	c.queued = append(c.queued, c.pending...)
+	c.pending = nil
+}
+
+// discard drops the pending changes of the failed request.
+func (c *changeCapture) discard() {
+	c.Lock()
+	defer c.Unlock()
+	c.pending = nil
+}
+
+// publish delivers the queued changes committed to the storage to the sink, the changes are kept
+// in queue if the sink fails.
+func (c *changeCapture) publish() (err error) {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.queued) == 0 {
+		return
+	}
+	if err = c.sink(c.queued); err != nil {
+		return
+	}
+	c.queued = nil
+	return
+}
+
+// rollback drops the queued changes of the requests rolled back with the ongoing transaction.
+func (c *changeCapture) rollback() {
+	c.Lock()
+	defer c.Unlock()
+	c.pending = nil
+	c.queued = nil
+}
@@ -0,0 +1,204 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestChangeCapture(t *testing.T) {
+	if !xs.PreupdateHookSupported() {
+		Convey("Change capture should not be enabled without the preupdate hook", t, func() {
+			var filePath = path.Join(testingDataDir, t.Name())
+			strg, err := xs.NewSqlite(fmt.Sprint("file:", filePath))
+			So(err, ShouldBeNil)
+			var state = NewState(sql.LevelReadUncommitted, nodeID, strg)
+			defer func() {
+				_ = state.Close(true)
+				for _, suffix := range []string{"", "-shm", "-wal"} {
+					_ = os.Remove(filePath + suffix)
+				}
+			}()
+			err = state.EnableChangeCapture(func([]*types.RowChange) error { return nil })
+			So(errors.Cause(err), ShouldEqual, ErrChangeCaptureNotSupported)
+			err = state.EnableStateDigest()
+			So(errors.Cause(err), ShouldEqual, ErrStateDigestNotSupported)
+		})
+		return
+	}
+	for _, level := range []sql.IsolationLevel{sql.LevelDefault, sql.LevelReadUncommitted} {
+		Convey(fmt.Sprintf("Given a state with change capture at level %s", level), t, func() {
+			var (
+				filePath = path.Join(testingDataDir, t.Name())
+				state    *State
+				strg     xi.Storage
+				changes  []*types.RowChange
+				sinkErr  error
+				err      error
+			)
+			strg, err = xs.NewSqlite(fmt.Sprint("file:", filePath))
+			So(err, ShouldBeNil)
+			state = NewState(level, nodeID, strg)
+			So(state, ShouldNotBeNil)
+			Reset(func() {
+				err = state.Close(true)
+				So(err, ShouldBeNil)
+				err = os.Remove(filePath)
+				So(err, ShouldBeNil)
+				err = os.Remove(fmt.Sprint(filePath, "-shm"))
+				So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+				err = os.Remove(fmt.Sprint(filePath, "-wal"))
+				So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+			})
+			err = state.EnableChangeCapture(func(c []*types.RowChange) error {
+				if sinkErr != nil {
+					return sinkErr
+				}
+				changes = append(changes, c...)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`CREATE TABLE t2 (v TEXT)`),
+			}), true)
+			So(err, ShouldBeNil)
+			So(changes, ShouldBeEmpty)
+
+			Convey("Row changes should be captured with old and new values", func() {
+				var offset = state.getSeq()
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?), (?, ?)`, 1, "a", 2, "b"),
+					buildQuery(`UPDATE t1 SET v = ? WHERE k = ?`, "c", 1),
+					buildQuery(`DELETE FROM t1 WHERE k = ?`, 2),
+					buildQuery(`INSERT INTO t2 (v) VALUES (?)`, "d"),
+				}), true)
+				So(err, ShouldBeNil)
+				if level == sql.LevelReadUncommitted {
+					// The changes are published after they are committed
+					So(changes, ShouldBeEmpty)
+					err = state.commit()
+					So(err, ShouldBeNil)
+				}
+				So(changes, ShouldHaveLength, 5)
+
+				So(changes[0].Position, ShouldResemble, types.ChangePosition{Offset: offset, Index: 0})
+				So(changes[0].Table, ShouldEqual, "t1")
+				So(changes[0].Op, ShouldEqual, types.ChangeInsert)
+				So(changes[0].Columns, ShouldResemble, []string{"k", "v"})
+				So(changes[0].PrimaryKey, ShouldResemble, []interface{}{int64(1)})
+				So(changes[0].Old, ShouldBeNil)
+				So(changes[0].New, ShouldResemble, []interface{}{int64(1), "a"})
+				So(changes[1].Position, ShouldResemble, types.ChangePosition{Offset: offset, Index: 1})
+
+				So(changes[2].Position, ShouldResemble, types.ChangePosition{Offset: offset + 1, Index: 0})
+				So(changes[2].Op, ShouldEqual, types.ChangeUpdate)
+				So(changes[2].Old, ShouldResemble, []interface{}{int64(1), "a"})
+				So(changes[2].New, ShouldResemble, []interface{}{int64(1), "c"})
+
+				So(changes[3].Position, ShouldResemble, types.ChangePosition{Offset: offset + 2, Index: 0})
+				So(changes[3].Op, ShouldEqual, types.ChangeDelete)
+				So(changes[3].Columns, ShouldResemble, []string{"k", "v"})
+				So(changes[3].PrimaryKey, ShouldResemble, []interface{}{int64(2)})
+				So(changes[3].Old, ShouldResemble, []interface{}{int64(2), "b"})
+				So(changes[3].New, ShouldBeNil)
+
+				So(changes[4].Table, ShouldEqual, "t2")
+				So(changes[4].PrimaryKey, ShouldResemble, []interface{}{changes[4].RowID})
+
+				// Data should be the same as executing without capture
+				var resp *types.Response
+				_, resp, err = state.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT k, v FROM t1`),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Rows, ShouldHaveLength, 1)
+				So(resp.Payload.Rows[0].Values, ShouldResemble, []interface{}{int64(1), "c"})
+			})
+			Convey("Row changes of the deletions without condition and replacements should be captured", func() {
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?), (?, ?)`, 1, "a", 2, "b"),
+					buildQuery(`REPLACE INTO t1 (k, v) VALUES (?, ?)`, 1, "c"),
+					buildQuery(`DELETE FROM t1`),
+				}), true)
+				So(err, ShouldBeNil)
+				err = state.commit()
+				So(err, ShouldBeNil)
+				So(changes, ShouldHaveLength, 6)
+				So(changes[2].Op, ShouldEqual, types.ChangeDelete)
+				So(changes[2].Old, ShouldResemble, []interface{}{int64(1), "a"})
+				So(changes[3].Op, ShouldEqual, types.ChangeInsert)
+				So(changes[3].New, ShouldResemble, []interface{}{int64(1), "c"})
+				// The rows are deleted in rowid order, the replacement has a new rowid
+				So(changes[4].Op, ShouldEqual, types.ChangeDelete)
+				So(changes[4].Old, ShouldResemble, []interface{}{int64(2), "b"})
+				So(changes[5].Op, ShouldEqual, types.ChangeDelete)
+				So(changes[5].Old, ShouldResemble, []interface{}{int64(1), "c"})
+			})
+			Convey("The changes failed to publish should be published again in order", func() {
+				sinkErr = errors.New("sink failed")
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, 1, "a"),
+				}), true)
+				So(err, ShouldBeNil)
+				err = state.commit()
+				So(err, ShouldBeNil)
+				So(changes, ShouldBeEmpty)
+				sinkErr = nil
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, 2, "b"),
+				}), true)
+				So(err, ShouldBeNil)
+				err = state.commit()
+				So(err, ShouldBeNil)
+				So(changes, ShouldHaveLength, 2)
+				So(changes[0].New, ShouldResemble, []interface{}{int64(1), "a"})
+				So(changes[1].New, ShouldResemble, []interface{}{int64(2), "b"})
+			})
+			Convey("The WITHOUT ROWID tables should be rejected", func() {
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`CREATE TABLE t3 (k INT PRIMARY KEY, v TEXT) WITHOUT ROWID`),
+				}), true)
+				So(errors.Cause(err), ShouldEqual, ErrWithoutRowidNotSupported)
+			})
+			Convey("Row changes of failed requests should not be delivered", func() {
+				if level != sql.LevelReadUncommitted {
+					return
+				}
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, 1, "a"),
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, 1, "a"),
+				}), true)
+				So(err, ShouldNotBeNil)
+				err = state.commit()
+				So(err, ShouldBeNil)
+				So(changes, ShouldBeEmpty)
+			})
+		})
+	}
+}
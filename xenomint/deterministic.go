@@ -0,0 +1,55 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// querySource is the deterministic source of the stateful sql functions called by a write request.
+// The current time is the signed request timestamp, and the random values are generated by a
+// splitmix64 generator seeded by the request hash, so that the leader, the followers and the block
+// replays produce identical results. It's not safe for concurrent use.
+type querySource struct {
+	now   time.Time
+	state uint64
+}
+
+func newQuerySource(req *types.Request) *querySource {
+	var h = req.Header.Hash()
+	return &querySource{
+		now:   req.Header.Timestamp.UTC(),
+		state: binary.BigEndian.Uint64(h[:8]),
+	}
+}
+
+// Now implements Now method of the xenomint/interfaces.DeterministicSource interface.
+func (s *querySource) Now() time.Time {
+	return s.now
+}
+
+// Uint64 implements Uint64 method of the xenomint/interfaces.DeterministicSource interface.
+func (s *querySource) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	var z = s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestDeterministicQuery(t *testing.T) {
+	Convey("Given a leader state with change capture and two follower states", t, func() {
+		var (
+			states [3]*State
+			err    error
+		)
+		for i := range states {
+			var (
+				fl   = path.Join(testingDataDir, fmt.Sprint(t.Name(), i))
+				strg xi.Storage
+			)
+			strg, err = xs.NewSqlite(fmt.Sprint("file:", fl))
+			So(err, ShouldBeNil)
+			states[i] = NewState(sql.LevelReadUncommitted, nodeID, strg)
+			var st = states[i]
+			Reset(func() {
+				err = st.Close(true)
+				So(err, ShouldBeNil)
+				for _, suffix := range []string{"", "-shm", "-wal"} {
+					err = os.Remove(fl + suffix)
+					So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+				}
+			})
+		}
+		if xs.PreupdateHookSupported() {
+			err = states[0].EnableChangeCapture(func([]*types.RowChange) error { return nil })
+			So(err, ShouldBeNil)
+		}
+
+		var (
+			ts   = time.Date(2019, 6, 18, 9, 18, 3, 0, time.UTC)
+			reqs = []*types.Request{
+				buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`CREATE TABLE t1 (
+						k INTEGER PRIMARY KEY,
+						r INT,
+						b BLOB,
+						d TEXT,
+						c TEXT)`),
+				}),
+				buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (r, b, d, c)
+						VALUES (random(), randomblob(12), date('now', '+1 day'), CURRENT_TIMESTAMP)`),
+					buildQuery(`INSERT INTO t1 (r, b, d, c)
+						VALUES (random(), randomblob(12), strftime('%s', 'now'), CURRENT_TIMESTAMP)`),
+				}),
+				buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`UPDATE t1 SET r = random(), d = CURRENT_DATE`),
+				}),
+			}
+			resps = make([]*types.Response, len(reqs))
+			block = &types.Block{}
+		)
+		for i, req := range reqs {
+			req.Header.Timestamp = ts.Add(time.Duration(i) * time.Second)
+			err = req.Sign(testingPrivateKey)
+			So(err, ShouldBeNil)
+			_, resps[i], err = states[0].Query(req, true)
+			So(err, ShouldBeNil)
+			err = states[1].Replay(req, resps[i])
+			So(err, ShouldBeNil)
+			block.QueryTxs = append(block.QueryTxs, &types.QueryAsTx{
+				Request:  req,
+				Response: &resps[i].Header,
+			})
+		}
+		err = states[2].ReplayBlock(block)
+		So(err, ShouldBeNil)
+
+		Convey("All the states should produce identical results", func() {
+			var results [len(states)][]types.ResponseRow
+			for i, st := range states {
+				var resp *types.Response
+				_, resp, err = st.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT * FROM t1 ORDER BY k`),
+				}), true)
+				So(err, ShouldBeNil)
+				results[i] = resp.Payload.Rows
+			}
+			So(results[0], ShouldHaveLength, 2)
+			So(results[1], ShouldResemble, results[0])
+			So(results[2], ShouldResemble, results[0])
+
+			var first, second = results[0][0].Values, results[0][1].Values
+			So(first[1], ShouldNotEqual, second[1])
+			So(first[2], ShouldHaveLength, 12)
+			So(first[2], ShouldNotResemble, second[2])
+			So(first[3], ShouldEqual, "2019-06-18")
+			So(second[3], ShouldEqual, "2019-06-18")
+			So(first[4], ShouldEqual, "2019-06-18 09:18:04")
+		})
+	})
+}
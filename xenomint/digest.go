@@ -0,0 +1,152 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	"github.com/CovenantSQL/CovenantSQL/utils"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+// digestRow is the digested form of a changed row.
+type digestRow struct {
+	Op     types.ChangeOp
+	Table  string
+	RowID  int64         // always 0 in the WITHOUT ROWID tables
+	Values []interface{} // column values after the change, or before it if the row is removed
+}
+
+// stateDigest digests the row changes made by each write request of a State, the replicas which
+// apply the same write requests produce the same digests unless their storages diverge.
+//
+// The changed rows are reported with their values by the preupdate hook of sqlite, the digest of a
+// request covers the changed rows of all its statements in order. The rows of the WITHOUT ROWID
+// tables are identified by their column values, which include the primary key. The preupdate hook
+// doesn't report the schema changes, nor the rows removed along with a dropped table, so the schema
+// is digested after each DDL statement instead.
+//
+// Note that the digest covers the changes only: a row diverged on a replica is not detected until
+// it's changed by a later request, or read by a storage challenge, see State.StorageRecord.
+type stateDigest struct {
+	sync.Mutex
+	rows []*digestRow // reported by the hook on the ongoing statement
+
+	// buf holds the row hashes of the ongoing request, it's guarded by the State lock.
+	buf bytes.Buffer
+}
+
+func newStateDigest() *stateDigest {
+	return &stateDigest{}
+}
+
+func (d *stateDigest) onUpdate(u *xi.RowUpdate) {
+	var row = &digestRow{Table: qualifiedTable(u), RowID: u.RowID, Values: u.New}
+	switch u.Op {
+	case xi.UpdateInsert:
+		row.Op = types.ChangeInsert
+	case xi.UpdateUpdate:
+		row.Op = types.ChangeUpdate
+	case xi.UpdateDelete:
+		row.Op = types.ChangeDelete
+		row.Values = u.Old
+	default:
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.rows = append(d.rows, row)
+}
+
+func (d *stateDigest) takeRows() (rows []*digestRow) {
+	d.Lock()
+	defer d.Unlock()
+	rows, d.rows = d.rows, nil
+	return
+}
+
+func (d *stateDigest) add(row *digestRow) (err error) {
+	var buf, ierr = utils.EncodeMsgPack(row)
+	if ierr != nil {
+		return ierr
+	}
+	var h = hash.THashH(buf.Bytes())
+	_, err = d.buf.Write(h[:])
+	return
+}
+
+// digest digests the rows changed by the statement just executed.
+func (d *stateDigest) digest() (err error) {
+	for _, row := range d.takeRows() {
+		if err = d.add(row); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// digestSchema digests the schema of the main database after a DDL statement.
+func (d *stateDigest) digestSchema(ctx context.Context, h sqlContextHandler) (err error) {
+	var rows *sql.Rows
+	if rows, err = h.QueryContext(ctx, `SELECT "type", "name", "tbl_name", "sql"
+FROM "main"."sqlite_master" ORDER BY "type", "name"`); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	var row = &digestRow{Table: "main.sqlite_master"}
+	for rows.Next() {
+		var typ, name, table, stmt sql.NullString
+		if err = rows.Scan(&typ, &name, &table, &stmt); err != nil {
+			return
+		}
+		row.Values = append(row.Values, typ.String, name.String, table.String, stmt.String)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	return d.add(row)
+}
+
+// reset drops the digested rows of the ongoing request.
+func (d *stateDigest) reset() {
+	d.takeRows()
+	d.buf.Reset()
+}
+
+// take returns the digest of the ongoing request and resets it.
+func (d *stateDigest) take() (h hash.Hash) {
+	h = hash.THashH(d.buf.Bytes())
+	d.buf.Reset()
+	return
+}
+
+// NextStateHash returns the state hash after the write requests with digests are applied on the
+// state of parent, it's the incremental checksum of the changes made to the database state carried
+// by each block.
+func NextStateHash(parent hash.Hash, digests []hash.Hash) hash.Hash {
+	var buf = make([]byte, 0, hash.HashSize*(len(digests)+1))
+	buf = append(buf, parent[:]...)
+	for _, v := range digests {
+		buf = append(buf, v[:]...)
+	}
+	return hash.THashH(buf)
+}
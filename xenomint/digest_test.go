@@ -0,0 +1,199 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestStateDigest(t *testing.T) {
+	if !xs.PreupdateHookSupported() {
+		t.Skip(xs.ErrPreupdateHookNotSupported)
+	}
+	for _, level := range []sql.IsolationLevel{sql.LevelDefault, sql.LevelReadUncommitted} {
+		Convey(fmt.Sprintf("Given two states with state digest at level %s", level), t, func() {
+			var (
+				fl1  = path.Join(testingDataDir, fmt.Sprint(t.Name(), "x1"))
+				fl2  = path.Join(testingDataDir, fmt.Sprint(t.Name(), "x2"))
+				st1  *State
+				st2  *State
+				strg xi.Storage
+				err  error
+			)
+			strg, err = xs.NewSqlite(fmt.Sprint("file:", fl1))
+			So(err, ShouldBeNil)
+			st1 = NewState(level, nodeID, strg)
+			strg, err = xs.NewSqlite(fmt.Sprint("file:", fl2))
+			So(err, ShouldBeNil)
+			st2 = NewState(level, nodeID, strg)
+			Reset(func() {
+				for _, v := range []*State{st1, st2} {
+					err = v.Close(true)
+					So(err, ShouldBeNil)
+				}
+				for _, v := range []string{fl1, fl2} {
+					err = os.Remove(v)
+					So(err, ShouldBeNil)
+					for _, s := range []string{"-shm", "-wal"} {
+						err = os.Remove(fmt.Sprint(v, s))
+						So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+					}
+				}
+			})
+			err = st1.EnableStateDigest()
+			So(err, ShouldBeNil)
+			err = st2.EnableStateDigest()
+			So(err, ShouldBeNil)
+			// produces a block from the queries on st1
+			var produce = func(reqs ...*types.Request) (block *types.Block, digests []hash.Hash) {
+				for _, v := range reqs {
+					var qt, resp, err = st1.Query(v, true)
+					So(err, ShouldBeNil)
+					qt.UpdateResp(resp)
+				}
+				var _, qts, err = st1.CommitEx()
+				So(err, ShouldBeNil)
+				block = &types.Block{QueryTxs: make([]*types.QueryAsTx, len(qts))}
+				for i, v := range qts {
+					block.QueryTxs[i] = &types.QueryAsTx{Request: v.Req, Response: &v.Resp.Header}
+					if v.Req.Header.QueryType == types.WriteQuery {
+						So(v.Digest, ShouldNotResemble, hash.Hash{})
+						digests = append(digests, v.Digest)
+					}
+				}
+				return
+			}
+
+			var block, digests = produce(
+				buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				}),
+				buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?), (?, ?)`, 1, "a", 2, "b"),
+				}),
+			)
+			So(digests, ShouldHaveLength, 2)
+			replayed, err := st2.ReplayBlockEx(context.Background(), block)
+			So(err, ShouldBeNil)
+			So(replayed, ShouldResemble, digests)
+
+			Convey("The digests should match while the states are identical", func() {
+				block, digests = produce(
+					buildRequest(types.WriteQuery, []types.Query{
+						buildQuery(`UPDATE t1 SET v = ? WHERE k = ?`, "c", 1),
+						buildQuery(`DELETE FROM t1 WHERE k = ?`, 2),
+					}),
+					buildRequest(types.ReadQuery, []types.Query{
+						buildQuery(`SELECT v FROM t1`),
+					}),
+				)
+				So(digests, ShouldHaveLength, 1)
+				replayed, err = st2.ReplayBlockEx(context.Background(), block)
+				So(err, ShouldBeNil)
+				So(replayed, ShouldResemble, digests)
+				So(NextStateHash(hash.Hash{}, replayed), ShouldResemble,
+					NextStateHash(hash.Hash{}, digests))
+			})
+			Convey("The digests should mismatch after the states diverge", func() {
+				_, err = st2.strg.Writer().Exec(`UPDATE t1 SET v = 'x' WHERE k = 2`)
+				So(err, ShouldBeNil)
+				block, digests = produce(
+					buildRequest(types.WriteQuery, []types.Query{
+						buildQuery(`UPDATE t1 SET k = k + 10`),
+					}),
+				)
+				replayed, err = st2.ReplayBlockEx(context.Background(), block)
+				So(err, ShouldBeNil)
+				So(replayed, ShouldHaveLength, 1)
+				So(replayed, ShouldNotResemble, digests)
+			})
+			Convey("The digests should mismatch after the schemas diverge", func() {
+				_, err = st2.strg.Writer().Exec(`CREATE INDEX i1 ON t1 (v)`)
+				So(err, ShouldBeNil)
+				block, digests = produce(
+					buildRequest(types.WriteQuery, []types.Query{
+						buildQuery(`ALTER TABLE t1 ADD COLUMN c INT`),
+					}),
+				)
+				replayed, err = st2.ReplayBlockEx(context.Background(), block)
+				So(err, ShouldBeNil)
+				So(replayed, ShouldHaveLength, 1)
+				So(replayed, ShouldNotResemble, digests)
+			})
+			Convey("The digests should mismatch after the rows diverge and truncated", func() {
+				_, err = st2.strg.Writer().Exec(`INSERT INTO t1 (k, v) VALUES (3, 'x')`)
+				So(err, ShouldBeNil)
+				block, digests = produce(
+					buildRequest(types.WriteQuery, []types.Query{
+						buildQuery(`DELETE FROM t1`),
+					}),
+				)
+				replayed, err = st2.ReplayBlockEx(context.Background(), block)
+				So(err, ShouldBeNil)
+				So(replayed, ShouldHaveLength, 1)
+				So(replayed, ShouldNotResemble, digests)
+			})
+			Convey("The digests should cover the WITHOUT ROWID tables", func() {
+				block, digests = produce(
+					buildRequest(types.WriteQuery, []types.Query{
+						buildQuery(`CREATE TABLE t3 (k TEXT PRIMARY KEY, v INT) WITHOUT ROWID`),
+						buildQuery(`INSERT INTO t3 (k, v) VALUES (?, ?), (?, ?)`, "a", 1, "b", 2),
+					}),
+				)
+				replayed, err = st2.ReplayBlockEx(context.Background(), block)
+				So(err, ShouldBeNil)
+				So(replayed, ShouldResemble, digests)
+				_, err = st2.strg.Writer().Exec(`UPDATE t3 SET v = 3 WHERE k = 'b'`)
+				So(err, ShouldBeNil)
+				block, digests = produce(
+					buildRequest(types.WriteQuery, []types.Query{
+						buildQuery(`DELETE FROM t3 WHERE k = ?`, "b"),
+					}),
+				)
+				replayed, err = st2.ReplayBlockEx(context.Background(), block)
+				So(err, ShouldBeNil)
+				So(replayed, ShouldHaveLength, 1)
+				So(replayed, ShouldNotResemble, digests)
+			})
+			Convey("The digests should match with change capture enabled", func() {
+				err = st1.EnableChangeCapture(func([]*types.RowChange) error { return nil })
+				So(err, ShouldBeNil)
+				block, digests = produce(
+					buildRequest(types.WriteQuery, []types.Query{
+						buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, 3, "d"),
+						buildQuery(`UPDATE t1 SET v = v || 'e'`),
+					}),
+				)
+				replayed, err = st2.ReplayBlockEx(context.Background(), block)
+				So(err, ShouldBeNil)
+				So(replayed, ShouldResemble, digests)
+			})
+		})
+	}
+}
@@ -18,6 +18,8 @@ package xenomint
 
 import (
 	"errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
 )
 
 var (
@@ -33,4 +35,39 @@ var (
 	ErrStatefulQueryParts = errors.New("query contains stateful query parts")
 	// ErrInvalidTableName indicates query contains invalid table name in ddl statement.
 	ErrInvalidTableName = errors.New("invalid table name in ddl")
+	// ErrInvalidExplainQuery indicates the statement explained by EXPLAIN query is invalid.
+	ErrInvalidExplainQuery = errors.New("invalid statement to explain")
+	// ErrChangeCaptureNotSupported indicates the underlying storage can't report row changes.
+	ErrChangeCaptureNotSupported = errors.New("change capture not supported by storage")
+	// ErrStateDigestNotSupported indicates the underlying storage can't report row changes to
+	// digest.
+	ErrStateDigestNotSupported = errors.New("state digest not supported by storage")
+	// ErrWithoutRowidNotSupported indicates a WITHOUT ROWID table is created on a state with the row
+	// changes captured, as the changes of such tables are reported by sqlite without rowid.
+	ErrWithoutRowidNotSupported = errors.New("WITHOUT ROWID table not supported")
+	// ErrStorageRecordStale indicates the state has passed the log offset of the storage record
+	// requested.
+	ErrStorageRecordStale = errors.New("storage record stale")
+	// ErrStateClosed indicates the state is already closed.
+	ErrStateClosed = errors.New("state closed")
+	// ErrExtensionNotAllowed indicates query uses a sqlite extension not allowed in the database.
+	ErrExtensionNotAllowed = errors.New("sqlite extension not allowed")
+	// ErrExtensionNotSupported indicates a sqlite extension is not supported by the local sqlite
+	// library.
+	ErrExtensionNotSupported = errors.New("sqlite extension not supported")
+	// ErrQueryTimeout indicates the query is interrupted as it's not finished before its deadline.
+	ErrQueryTimeout = types.ErrQueryTimeout
+	// ErrLimitExceeded indicates the statement is interrupted as it exceeds a query limit, see
+	// LimitError for the limit exceeded.
+	ErrLimitExceeded = errors.New("query limit exceeded")
+	// ErrProcedureNotFound indicates the stored procedure called is not registered.
+	ErrProcedureNotFound = errors.New("stored procedure not found")
+	// ErrInvalidProcedure indicates the definition of a stored procedure is invalid.
+	ErrInvalidProcedure = errors.New("invalid stored procedure")
+	// ErrInvalidProcedureArgs indicates the arguments don't match the parameters of the stored
+	// procedure called.
+	ErrInvalidProcedureArgs = errors.New("invalid stored procedure arguments")
+	// ErrProcedureNotReadOnly indicates the stored procedure called by a read query contains
+	// statements other than SELECT, which should be called by a write query.
+	ErrProcedureNotReadOnly = errors.New("stored procedure not read-only")
 )
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+// extensionOptions maps the sqlite extensions to the compile options enabling them.
+var extensionOptions = []struct {
+	ext    types.SQLiteExtension
+	option string
+}{
+	{types.ExtensionFTS5, "ENABLE_FTS5"},
+	{types.ExtensionJSON1, "ENABLE_JSON1"},
+	{types.ExtensionRTree, "ENABLE_RTREE"},
+}
+
+// SupportedExtensions returns the sqlite extensions supported by the local sqlite library.
+func SupportedExtensions() (ext types.SQLiteExtension, err error) {
+	for _, v := range extensionOptions {
+		var used bool
+		if used, err = xs.CompileOptionUsed(v.option); err != nil {
+			return
+		}
+		if used {
+			ext |= v.ext
+		}
+	}
+	return
+}
+
+// checkExtensions reports an error if any of the sqlite extensions in ext is unknown or not
+// supported by the local sqlite library. The unrestricted extensions are always accepted.
+func checkExtensions(ext types.SQLiteExtension) (err error) {
+	if ext == types.UnrestrictedExtensions {
+		return
+	}
+	if !types.AllExtensions.Has(ext) {
+		return errors.Wrapf(ErrExtensionNotSupported, "unknown extensions %#x", uint32(ext))
+	}
+	if ext == 0 {
+		return
+	}
+	var supported types.SQLiteExtension
+	if supported, err = SupportedExtensions(); err != nil {
+		return
+	}
+	if missing := ext &^ supported; missing != 0 {
+		return errors.Wrapf(ErrExtensionNotSupported, "%s", missing)
+	}
+	return
+}
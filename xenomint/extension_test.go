@@ -0,0 +1,144 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestExtensions(t *testing.T) {
+	Convey("Given a set of queries using sqlite extensions", t, func() {
+		var cases = []struct {
+			query string
+			ext   types.SQLiteExtension
+		}{
+			{`CREATE VIRTUAL TABLE t1 USING fts5(a, b)`, types.ExtensionFTS5},
+			{`SELECT highlight(t1, 0, '[', ']') FROM t1 WHERE t1 MATCH 'x'`, types.ExtensionFTS5},
+			{`SELECT * FROM t1 ORDER BY bm25(t1)`, types.ExtensionFTS5},
+			{`SELECT json_extract('{"a":1}', '$.a')`, types.ExtensionJSON1},
+			{`SELECT JSON('{}')`, types.ExtensionJSON1},
+			{`CREATE VIRTUAL TABLE t2 USING rtree(id, x0, x1)`, types.ExtensionRTree},
+			{`CREATE VIRTUAL TABLE t3 USING rtree_i32(id, x0, x1)`, types.ExtensionRTree},
+		}
+		Convey("The queries should be rejected if the extension is not allowed", func() {
+			for _, c := range cases {
+				_, _, _, err := sanitizeQuery(c.query, types.AllExtensions&^c.ext)
+				So(errors.Cause(err), ShouldEqual, ErrExtensionNotAllowed)
+			}
+		})
+		Convey("The queries should be accepted if the extension is allowed", func() {
+			for _, c := range cases {
+				_, _, _, err := sanitizeQuery(c.query, c.ext)
+				So(err, ShouldBeNil)
+			}
+		})
+		Convey("The virtual table modules out of the whitelist should always be rejected", func() {
+			for _, q := range []string{
+				`CREATE VIRTUAL TABLE t1 USING fts4(a, b)`,
+				`CREATE VIRTUAL TABLE t1 USING dbstat`,
+			} {
+				_, _, _, err := sanitizeQuery(q, types.AllExtensions)
+				So(errors.Cause(err), ShouldEqual, ErrExtensionNotAllowed)
+			}
+		})
+		Convey("The queries should be accepted if the extensions are unrestricted", func() {
+			for _, c := range cases {
+				_, _, _, err := sanitizeQuery(c.query, types.UnrestrictedExtensions)
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+	Convey("Given a new state", t, func() {
+		var (
+			fl   = path.Join(testingDataDir, t.Name())
+			strg xi.Storage
+			st   *State
+			err  error
+		)
+		strg, err = xs.NewSqlite(fmt.Sprint("file:", fl))
+		So(err, ShouldBeNil)
+		st = NewState(sql.LevelReadUncommitted, nodeID, strg)
+		Reset(func() {
+			err = st.Close(true)
+			So(err, ShouldBeNil)
+			for _, suffix := range []string{"", "-shm", "-wal"} {
+				err = os.Remove(fl + suffix)
+				So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+			}
+		})
+
+		var req = buildRequest(types.WriteQuery, []types.Query{
+			buildQuery(`CREATE VIRTUAL TABLE t1 USING rtree(id, x0, x1)`),
+		})
+		Convey("The state should not restrict extensions by default", func() {
+			_, _, err = st.Query(req, true)
+			So(err, ShouldBeNil)
+			_, _, err = st.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE VIRTUAL TABLE t2 USING fts4(a, b)`),
+			}), true)
+			So(err, ShouldBeNil)
+		})
+		Convey("The state should reject the extensions not allowed", func() {
+			err = st.SetExtensions(types.ExtensionRTree)
+			So(err, ShouldBeNil)
+			_, _, err = st.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE VIRTUAL TABLE t2 USING fts4(a, b)`),
+			}), true)
+			So(errors.Cause(err), ShouldEqual, ErrExtensionNotAllowed)
+		})
+		Convey("The state should reject unknown extensions", func() {
+			err = st.SetExtensions(types.AllExtensions + 1)
+			So(errors.Cause(err), ShouldEqual, ErrExtensionNotSupported)
+		})
+		Convey("The state should accept the allowed extensions", func() {
+			err = st.SetExtensions(types.ExtensionRTree)
+			So(err, ShouldBeNil)
+			_, _, err = st.Query(req, true)
+			So(err, ShouldBeNil)
+			_, _, err = st.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`INSERT INTO t1 VALUES (1, 0.5, 1.5)`),
+			}), true)
+			So(err, ShouldBeNil)
+			var resp *types.Response
+			_, resp, err = st.Query(buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT id FROM t1 WHERE x0 < 1 AND x1 > 1`),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+		})
+		Convey("The supported extensions should match the sqlite compile options", func() {
+			supported, err := SupportedExtensions()
+			So(err, ShouldBeNil)
+			So(supported.Has(types.ExtensionRTree), ShouldBeTrue)
+			if !supported.Has(types.ExtensionFTS5) {
+				err = st.SetExtensions(types.ExtensionFTS5)
+				So(errors.Cause(err), ShouldEqual, ErrExtensionNotSupported)
+			}
+		})
+	})
+}
@@ -18,6 +18,7 @@ package interfaces
 
 import (
 	"database/sql"
+	"time"
 )
 
 // Storage is the interface implemented by an object that returns standard *sql.DB as DirtyReader,
@@ -28,3 +29,85 @@ type Storage interface {
 	Writer() *sql.DB
 	Close() error
 }
+
+// UpdateOp enumerates the row change operations reported by an UpdateHook.
+type UpdateOp int
+
+const (
+	// UpdateInsert defines a row insertion.
+	UpdateInsert UpdateOp = iota
+	// UpdateUpdate defines a row update.
+	UpdateUpdate
+	// UpdateDelete defines a row deletion.
+	UpdateDelete
+)
+
+// RowUpdate is a row change made by a Storage writer with the column values of the row.
+type RowUpdate struct {
+	Op       UpdateOp
+	Database string
+	Table    string
+	RowID    int64         // rowid of the row after the change, or the deleted one
+	Old      []interface{} // column values before the change, nil on insertion
+	New      []interface{} // column values after the change, nil on deletion
+}
+
+// UpdateHook is the callback function invoked on each row change made by a Storage writer, it's
+// called before the change is made and must not access the storage.
+type UpdateHook func(u *RowUpdate)
+
+// UpdateNotifier is the interface optionally implemented by a Storage to report the row changes
+// made by its writer.
+type UpdateNotifier interface {
+	// SetUpdateHook sets the hook of the row changes, it returns an error if the row changes
+	// couldn't be reported by the Storage.
+	SetUpdateHook(hook UpdateHook) error
+}
+
+// DeterministicSource provides the current time and random values of the deterministic sql
+// functions, which replace the stateful ones to keep the replicas consistent.
+type DeterministicSource interface {
+	Now() time.Time
+	Uint64() uint64
+}
+
+// Deterministic is the interface optionally implemented by a Storage to evaluate the time and
+// random functions of its writer with a DeterministicSource. The system clock and random
+// generator are used if the source is nil.
+type Deterministic interface {
+	SetDeterministicSource(src DeterministicSource)
+}
+
+// StepBudget limits the sqlite VM instructions executed and the memory used by each statement on
+// a connection.
+type StepBudget interface {
+	// Reset resets the instructions executed and the memory used, and sets the max instructions
+	// and the max bytes of memory of the next statements, 0 means unlimited. The memory is the
+	// growth of the page caches of the connection, including the temp database, and the length
+	// of each value computed; the sorters and the ephemeral tables are only limited by the
+	// instructions.
+	Reset(maxSteps, maxMemory uint64)
+	// Exceeded reports whether a statement is interrupted by the budget since the last reset.
+	Exceeded() bool
+	// MemoryExceeded reports whether a statement is interrupted by the max memory of the budget
+	// since the last reset.
+	MemoryExceeded() bool
+	// Usage returns the rows stepped by the full table scans and the instructions of the
+	// statements finished on the connection since the budget is installed, which are reported
+	// by the statement status of sqlite.
+	Usage() (scanned, steps uint64)
+}
+
+// StepLimiter is the interface optionally implemented by a Storage to interrupt the statements
+// executing too many sqlite VM instructions or using too much memory.
+type StepLimiter interface {
+	// WriterBudget returns the budget shared by the writer connections, which should only be
+	// used by the serialized writes.
+	WriterBudget() StepBudget
+	// InstallBudget returns the budget of the driver connection dc reset to unlimited, which is
+	// obtained by sql.Conn.Raw from a Reader or DirtyReader connection. The budget is owned by
+	// the connection and released when the connection is closed.
+	InstallBudget(dc interface{}) (StepBudget, error)
+	// UninstallBudget resets the budget of the driver connection dc to unlimited.
+	UninstallBudget(dc interface{}, b StepBudget)
+}
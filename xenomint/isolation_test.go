@@ -0,0 +1,172 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestReadIsolation(t *testing.T) {
+	Convey("Given states at different isolation levels", t, func() {
+		var (
+			filePath = path.Join(testingDataDir, t.Name())
+			strg     xi.Storage
+			err      error
+
+			open = func(level sql.IsolationLevel) (st *State) {
+				st = NewState(level, nodeID, strg)
+				So(st, ShouldNotBeNil)
+				_, _, err = st.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`CREATE TABLE IF NOT EXISTS t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+					buildQuery(`INSERT OR REPLACE INTO t1 (k, v) VALUES (1, 'v1')`),
+				}), true)
+				So(err, ShouldBeNil)
+				So(st.commit(), ShouldBeNil)
+				return
+			}
+			write = func(st *State, pattern string) {
+				_, _, err = st.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(pattern),
+				}), true)
+				So(err, ShouldBeNil)
+			}
+			read = func(st *State) (resp *types.Response) {
+				_, resp, err = st.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT v FROM t1 WHERE k=1`),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Rows, ShouldHaveLength, 1)
+				return
+			}
+			readIn = func(v *readView, st *State, pattern string) interface{} {
+				var (
+					q    = buildQuery(pattern)
+					qer  sqlQuerier
+					end  func()
+					data [][]interface{}
+				)
+				qer, end, err = v.begin(context.Background())
+				So(err, ShouldBeNil)
+				defer end()
+				_, _, data, err = readSingle(context.Background(), qer, st.readStmts, &q, 0, nil)
+				So(err, ShouldBeNil)
+				So(data, ShouldHaveLength, 1)
+				return data[0][0]
+			}
+		)
+		strg, err = xs.NewSqlite(fmt.Sprint("file:", filePath))
+		So(err, ShouldBeNil)
+		Reset(func() {
+			err = strg.Close()
+			So(err, ShouldBeNil)
+			err = os.Remove(filePath)
+			So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+			err = os.Remove(fmt.Sprint(filePath, "-shm"))
+			So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+			err = os.Remove(fmt.Sprint(filePath, "-wal"))
+			So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("The read levels should be mapped from the state levels", func() {
+			for _, v := range []struct {
+				state, read sql.IsolationLevel
+			}{
+				{sql.LevelDefault, sql.LevelSnapshot},
+				{sql.LevelReadUncommitted, sql.LevelReadUncommitted},
+				{sql.LevelReadCommitted, sql.LevelReadCommitted},
+				{sql.LevelWriteCommitted, sql.LevelSnapshot},
+				{sql.LevelRepeatableRead, sql.LevelSnapshot},
+				{sql.LevelSnapshot, sql.LevelSnapshot},
+				{sql.LevelSerializable, sql.LevelSerializable},
+				{sql.LevelLinearizable, sql.LevelSerializable},
+			} {
+				So((&State{level: v.state}).readLevel(), ShouldEqual, v.read)
+			}
+		})
+		Convey("The uncommitted changes should only be read at read uncommitted", func() {
+			var st = open(sql.LevelReadUncommitted)
+			defer func() { So(st.Close(false), ShouldBeNil) }()
+			write(st, `UPDATE t1 SET v='dirty' WHERE k=1`)
+			var resp = read(st)
+			So(resp.Header.IsolationLevel, ShouldEqual, int(sql.LevelReadUncommitted))
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, "dirty")
+
+			var rc = NewState(sql.LevelReadCommitted, nodeID, strg)
+			resp = read(rc)
+			So(resp.Header.IsolationLevel, ShouldEqual, int(sql.LevelReadCommitted))
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, "v1")
+			So(st.commit(), ShouldBeNil)
+			resp = read(rc)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, "dirty")
+		})
+		Convey("The changes of an ongoing transaction should not be read at read committed", func() {
+			var st = open(sql.LevelReadCommitted)
+			defer func() { So(st.Close(false), ShouldBeNil) }()
+			var tx *sql.Tx
+			tx, err = strg.Writer().Begin()
+			So(err, ShouldBeNil)
+			_, err = tx.Exec(`UPDATE t1 SET v='dirty' WHERE k=1`)
+			So(err, ShouldBeNil)
+			var resp = read(st)
+			So(resp.Header.IsolationLevel, ShouldEqual, int(sql.LevelReadCommitted))
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, "v1")
+			So(tx.Rollback(), ShouldBeNil)
+		})
+		Convey("The changes committed during a read should be seen at read committed", func() {
+			var st = open(sql.LevelReadCommitted)
+			defer func() { So(st.Close(false), ShouldBeNil) }()
+			var view *readView
+			view, err = st.openReadView(context.Background())
+			So(err, ShouldBeNil)
+			defer view.close()
+			So(view.level, ShouldEqual, sql.LevelReadCommitted)
+			So(readIn(view, st, `SELECT v FROM t1 WHERE k=1`), ShouldEqual, "v1")
+			write(st, `UPDATE t1 SET v='v2' WHERE k=1`)
+			So(readIn(view, st, `SELECT v FROM t1 WHERE k=1`), ShouldEqual, "v2")
+		})
+		Convey("The non-repeatable reads and phantoms should be prevented at snapshot", func() {
+			var st = open(sql.LevelSnapshot)
+			defer func() { So(st.Close(false), ShouldBeNil) }()
+			var view *readView
+			view, err = st.openReadView(context.Background())
+			So(err, ShouldBeNil)
+			So(view.level, ShouldEqual, sql.LevelSnapshot)
+			So(readIn(view, st, `SELECT v FROM t1 WHERE k=1`), ShouldEqual, "v1")
+			So(readIn(view, st, `SELECT COUNT(1) FROM t1`), ShouldEqual, 1)
+			write(st, `UPDATE t1 SET v='v2' WHERE k=1`)
+			write(st, `INSERT INTO t1 (k, v) VALUES (2, 'v2')`)
+			So(readIn(view, st, `SELECT v FROM t1 WHERE k=1`), ShouldEqual, "v1")
+			So(readIn(view, st, `SELECT COUNT(1) FROM t1`), ShouldEqual, 1)
+			view.close()
+
+			var resp = read(st)
+			So(resp.Header.IsolationLevel, ShouldEqual, int(sql.LevelSnapshot))
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, "v2")
+		})
+	})
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+// Limit names of the query limits.
+const (
+	// LimitRows defines the limit of the rows returned by a read statement.
+	LimitRows = "rows"
+	// LimitSteps defines the limit of the sqlite VM instructions executed by a statement.
+	LimitSteps = "steps"
+	// LimitMemory defines the limit of the memory used by a read statement, see
+	// xenomint/interfaces.StepBudget, and the bytes of the rows returned by it.
+	LimitMemory = "memory"
+)
+
+var limitNames = [...]string{LimitRows, LimitSteps, LimitMemory}
+
+// LimitError indicates that a statement is interrupted as it exceeds one of the query limits.
+type LimitError struct {
+	Limit string // name of the limit, e.g., LimitRows
+	Max   uint64 // max value of the limit
+}
+
+// Error implements error.Error.
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: max %s %d", ErrLimitExceeded.Error(), e.Limit, e.Max)
+}
+
+type limitsKey struct{}
+
+// WithLimits returns a copy of ctx carrying the query limits of the requester, which override the
+// limits of the database in a read request. The write requests are always executed with the limits
+// of the database, as they are applied by the replicas without the requester context.
+func WithLimits(ctx context.Context, limits types.QueryLimits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, limits)
+}
+
+// sandbox enforces the query limits on the statements of a request.
+type sandbox struct {
+	limits types.QueryLimits
+	budget xi.StepBudget // nil if the steps are not limited by the storage
+	st     *State
+}
+
+// beginStmt resets the step budget before a statement is executed.
+func (sb *sandbox) beginStmt() {
+	if sb != nil && sb.budget != nil {
+		sb.budget.Reset(sb.limits.MaxSteps, sb.limits.MaxMemory)
+	}
+}
+
+// endStmt checks the step budget after a statement is executed, and replaces err with a
+// LimitError if the statement is interrupted by the budget.
+func (sb *sandbox) endStmt(err error) error {
+	if sb == nil || sb.budget == nil {
+		return err
+	}
+	if err != nil && sb.budget.MemoryExceeded() {
+		err = sb.violate(LimitMemory, sb.limits.MaxMemory)
+	} else if err != nil && sb.budget.Exceeded() {
+		err = sb.violate(LimitSteps, sb.limits.MaxSteps)
+	}
+	sb.budget.Reset(0, 0)
+	return err
+}
+
+// checkRows checks the number and size of the rows returned by a read statement.
+func (sb *sandbox) checkRows(rows, size uint64) error {
+	if sb == nil {
+		return nil
+	}
+	if max := sb.limits.MaxRows; max > 0 && rows > max {
+		return sb.violate(LimitRows, max)
+	}
+	if max := sb.limits.MaxMemory; max > 0 && size > max {
+		return sb.violate(LimitMemory, max)
+	}
+	return nil
+}
+
+func (sb *sandbox) violate(limit string, max uint64) error {
+	for i, v := range limitNames {
+		if v == limit {
+			atomic.AddUint64(&sb.st.violations[i], 1)
+		}
+	}
+	return &LimitError{Limit: limit, Max: max}
+}
+
+// SetLimits sets the query limits of the database. The write requests are only limited by the
+// steps, as they return no rows and the memory allocated may differ on the replicas.
+func (s *State) SetLimits(limits types.QueryLimits) {
+	s.limits.Store(limits)
+}
+
+func (s *State) getLimits() (limits types.QueryLimits) {
+	limits, _ = s.limits.Load().(types.QueryLimits)
+	return
+}
+
+// LimitViolations returns the number of statements interrupted by the query limit.
+func (s *State) LimitViolations(limit string) uint64 {
+	for i, v := range limitNames {
+		if v == limit {
+			return atomic.LoadUint64(&s.violations[i])
+		}
+	}
+	return 0
+}
+
+// readSandbox returns the sandbox of a read request with the limits of the requester in ctx, and
+// the step budget b installed on the connection executing the request.
+func (s *State) readSandbox(ctx context.Context, b xi.StepBudget) *sandbox {
+	var limits = s.getLimits()
+	if v, ok := ctx.Value(limitsKey{}).(types.QueryLimits); ok {
+		limits = limits.Override(v)
+	}
+	return &sandbox{limits: limits, budget: b, st: s}
+}
+
+// writeSandbox returns the sandbox of the writes, it must be called with the State lock held.
+func (s *State) writeSandbox() *sandbox {
+	var sb = &sandbox{limits: types.QueryLimits{MaxSteps: s.getLimits().MaxSteps}, st: s}
+	if l, ok := s.strg.(xi.StepLimiter); ok {
+		sb.budget = l.WriterBudget()
+	}
+	return sb
+}
+
+// isolateLimitedWrite commits the ongoing transaction of the read uncommitted state before a write
+// request limited by the steps, as sqlite rolls back the whole transaction on an interrupted
+// write. It must be called with the State lock held.
+func (s *State) isolateLimitedWrite() {
+	if s.level == sql.LevelReadUncommitted && s.getLimits().MaxSteps > 0 &&
+		s.getSeq() != s.getLastCommitPoint() {
+		s.flushHandler()
+	}
+}
+
+// recoverLimitedWrite reopens the transaction of the read uncommitted state if it's rolled back by
+// the write request interrupted by the step budget. It must be called with the State lock held.
+func (s *State) recoverLimitedWrite(err error) {
+	if le, ok := errors.Cause(err).(*LimitError); !ok || le.Limit != LimitSteps {
+		return
+	}
+	if tx, ok := s.handler.(sqlTransaction); ok {
+		// the transaction is already rolled back by sqlite
+		_ = tx.Rollback()
+		if s.capture != nil {
+			s.capture.rollback()
+		}
+		atomic.StoreUint32(&s.hasSchemaChange, 0)
+		s.openHandler()
+	}
+}
+
+// installBudget installs a step budget on the reader connection conn if it's supported by the
+// storage, and returns the function to uninstall it.
+func (s *State) installBudget(conn *sql.Conn) (b xi.StepBudget, uninstall func(), err error) {
+	var l, ok = s.strg.(xi.StepLimiter)
+	if !ok {
+		return nil, func() {}, nil
+	}
+	if err = conn.Raw(func(dc interface{}) (err error) {
+		b, err = l.InstallBudget(dc)
+		return
+	}); err != nil {
+		return
+	}
+	uninstall = func() {
+		// the budget is released with the connection if it's already closed
+		_ = conn.Raw(func(dc interface{}) error {
+			l.UninstallBudget(dc, b)
+			return nil
+		})
+	}
+	return
+}
+
+// rowSize returns the approximate bytes of a row buffered in memory.
+func rowSize(row []interface{}) (size uint64) {
+	for _, v := range row {
+		switch v := v.(type) {
+		case []byte:
+			size += uint64(len(v))
+		case string:
+			size += uint64(len(v))
+		default:
+			size += 8
+		}
+	}
+	return
+}
@@ -29,6 +29,9 @@ type QueryTracker struct {
 	sync.RWMutex
 	Req  *types.Request
 	Resp *types.Response
+	// Digest is the digest of the row changes made by the write query, it's set only if the
+	// state digest is enabled.
+	Digest hash.Hash
 }
 
 // UpdateResp updates response of the QueryTracker within locking scope.
@@ -112,6 +115,17 @@ func (p *pool) match(sp uint64, req *types.Request) bool {
 	return true
 }
 
+// digestOf returns the digest of the pooled query with sequence sp.
+func (p *pool) digestOf(sp uint64) (h hash.Hash, ok bool) {
+	var pos int
+	if pos, ok = p.index[sp]; !ok {
+		return
+	}
+	h = p.queries[pos].Digest
+	ok = !h.IsEqual(&hash.Hash{})
+	return
+}
+
 func (p *pool) matchLast(sp uint64) bool {
 	var (
 		pos int
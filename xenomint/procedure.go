@@ -0,0 +1,328 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/CovenantSQL/sqlparser"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+// procedureTable is the table storing the stored procedures registered on a database, so that
+// they're replicated and snapshotted as part of the database state.
+const procedureTable = "__cql_procedures"
+
+var (
+	// callRegexp matches the query calling a stored procedure, i.e., "CALL name".
+	callRegexp = regexp.MustCompile(`(?is)^\s*call\s+([a-z_][a-z0-9_]*)\s*;?\s*$`)
+	// procedureNameRegexp matches the valid names of the stored procedures and their parameters.
+	procedureNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// txControlWords defines the leading words of the transaction control statements, which are
+	// not allowed in a stored procedure as it's executed in a savepoint.
+	txControlWords = map[string]bool{
+		"begin":     true,
+		"commit":    true,
+		"end":       true,
+		"rollback":  true,
+		"savepoint": true,
+		"release":   true,
+	}
+)
+
+// procedureQuerier loads the stored procedures from the database.
+type procedureQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// CalledProcedure returns the name of the stored procedure called by the query pattern, which is
+// in the form of "CALL name" with the arguments passed as the query arguments.
+func CalledProcedure(pattern string) (name string, ok bool) {
+	var m = callRegexp.FindStringSubmatch(pattern)
+	if m == nil {
+		return
+	}
+	return m[1], true
+}
+
+// ReferencesProcedures reports whether the query pattern may access the table storing the stored
+// procedures. The table should only be written by the procedure registration.
+func ReferencesProcedures(pattern string) bool {
+	return strings.Contains(strings.ToLower(pattern), procedureTable)
+}
+
+// ProcedureQueries returns the write queries registering the stored procedure p, which replaces
+// the existing one with the same name.
+func ProcedureQueries(p *types.Procedure) (queries []types.Query, err error) {
+	var def []byte
+	if _, err = procedureParams(p); err != nil {
+		return
+	}
+	if def, err = json.Marshal(p); err != nil {
+		err = errors.Wrap(err, "encode procedure failed")
+		return
+	}
+	queries = []types.Query{
+		{Pattern: createProcedureTable},
+		{
+			Pattern: `INSERT OR REPLACE INTO ` + procedureTable + ` (name, definition) VALUES (?, ?)`,
+			Args:    []types.NamedArg{{Value: p.Name}, {Value: string(def)}},
+		},
+	}
+	return
+}
+
+// DropProcedureQueries returns the write queries dropping the stored procedure.
+func DropProcedureQueries(name string) []types.Query {
+	return []types.Query{
+		{Pattern: createProcedureTable},
+		{
+			Pattern: `DELETE FROM ` + procedureTable + ` WHERE name = ?`,
+			Args:    []types.NamedArg{{Value: name}},
+		},
+	}
+}
+
+const createProcedureTable = `CREATE TABLE IF NOT EXISTS ` + procedureTable +
+	` (name TEXT PRIMARY KEY, definition TEXT NOT NULL)`
+
+// procedureParams validates the stored procedure p, and returns the parameters referenced by each
+// of its statements.
+func procedureParams(p *types.Procedure) (params [][]string, err error) {
+	if !procedureNameRegexp.MatchString(p.Name) {
+		err = errors.Wrapf(ErrInvalidProcedure, "invalid name %q", p.Name)
+		return
+	}
+	var declared = make(map[string]bool, len(p.Params))
+	for _, v := range p.Params {
+		if !procedureNameRegexp.MatchString(v) || declared[v] {
+			err = errors.Wrapf(ErrInvalidProcedure, "invalid or duplicate parameter %q", v)
+			return
+		}
+		declared[v] = true
+	}
+	if len(p.Statements) == 0 {
+		err = errors.Wrap(ErrInvalidProcedure, "no statement")
+		return
+	}
+	params = make([][]string, len(p.Statements))
+	for i, v := range p.Statements {
+		if _, ok := CalledProcedure(v); ok || ReferencesProcedures(v) {
+			err = errors.Wrapf(ErrInvalidProcedure, "statement #%d accesses stored procedures", i)
+			return
+		}
+		if params[i], err = statementParams(v); err != nil {
+			err = errors.Wrapf(err, "statement #%d", i)
+			return
+		}
+		for _, name := range params[i] {
+			if !declared[name] {
+				err = errors.Wrapf(ErrInvalidProcedure,
+					"statement #%d references undeclared parameter %s", i, name)
+				return
+			}
+		}
+	}
+	return
+}
+
+// statementParams returns the named parameters referenced by the statement of a stored
+// procedure, positional parameters and transaction control statements are not allowed.
+func statementParams(stmt string) (names []string, err error) {
+	var (
+		tokenizer = sqlparser.NewStringTokenizer(stmt)
+		seen      = make(map[string]bool)
+		leading   = true
+	)
+	tokenizer.SeparatePositionalArgs = true
+	for {
+		var typ, val = tokenizer.Scan()
+		switch typ {
+		case 0:
+			return
+		case sqlparser.LEX_ERROR:
+			err = errors.Wrapf(ErrInvalidProcedure, "syntax error at position %d", tokenizer.Position)
+			return
+		case sqlparser.COMMENT:
+			continue
+		case sqlparser.POS_ARG, sqlparser.LIST_ARG:
+			err = errors.Wrap(ErrInvalidProcedure, "only named parameters are allowed")
+			return
+		case sqlparser.VALUE_ARG:
+			if name := string(val[1:]); !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		case ';':
+			leading = true
+			continue
+		}
+		if leading && txControlWords[strings.ToLower(string(val))] {
+			err = errors.Wrapf(ErrInvalidProcedure, "transaction control statement %s", val)
+			return
+		}
+		leading = false
+	}
+}
+
+// loadProcedure loads the stored procedure from the database with qer.
+func loadProcedure(ctx context.Context, qer procedureQuerier, name string) (p *types.Procedure, err error) {
+	var (
+		count int
+		def   string
+	)
+	if err = queryValue(ctx, qer, &count,
+		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, procedureTable,
+	); err != nil {
+		return
+	}
+	if count == 0 {
+		err = errors.Wrapf(ErrProcedureNotFound, "procedure %s", name)
+		return
+	}
+	if err = queryValue(ctx, qer, &def,
+		`SELECT definition FROM `+procedureTable+` WHERE name = ?`, name,
+	); err == sql.ErrNoRows {
+		err = errors.Wrapf(ErrProcedureNotFound, "procedure %s", name)
+		return
+	} else if err != nil {
+		return
+	}
+	p = &types.Procedure{}
+	if err = json.Unmarshal([]byte(def), p); err != nil {
+		err = errors.Wrapf(ErrInvalidProcedure, "decode procedure %s failed: %v", name, err)
+	}
+	return
+}
+
+// queryValue scans the first column of the first row returned by the query into dest.
+func queryValue(
+	ctx context.Context, qer procedureQuerier, dest interface{}, query string, args ...interface{},
+) (err error) {
+	var rows *sql.Rows
+	if rows, err = qer.QueryContext(ctx, query, args...); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return
+	}
+	return rows.Scan(dest)
+}
+
+// bindProcedure returns the statements of the stored procedure p with the arguments bound to the
+// parameters they reference. The unnamed arguments are bound to the parameters in order.
+func bindProcedure(p *types.Procedure, args []types.NamedArg) (stmts []types.Query, err error) {
+	var (
+		params [][]string
+		values = make(map[string]interface{}, len(args))
+	)
+	if params, err = procedureParams(p); err != nil {
+		return
+	}
+	for i, v := range args {
+		var name = v.Name
+		if name == "" {
+			if i >= len(p.Params) {
+				err = errors.Wrapf(ErrInvalidProcedureArgs, "too many arguments to %s", p.Name)
+				return
+			}
+			name = p.Params[i]
+		}
+		if _, ok := values[name]; ok {
+			err = errors.Wrapf(ErrInvalidProcedureArgs, "duplicate argument %s to %s", name, p.Name)
+			return
+		}
+		values[name] = v.Value
+	}
+	if len(values) > len(p.Params) {
+		err = errors.Wrapf(ErrInvalidProcedureArgs, "unknown argument to %s", p.Name)
+		return
+	}
+	for _, v := range p.Params {
+		if _, ok := values[v]; !ok {
+			err = errors.Wrapf(ErrInvalidProcedureArgs, "missing argument %s to %s", v, p.Name)
+			return
+		}
+	}
+	stmts = make([]types.Query, len(p.Statements))
+	for i, v := range p.Statements {
+		stmts[i].Pattern = v
+		for _, name := range params[i] {
+			stmts[i].Args = append(stmts[i].Args, types.NamedArg{Name: name, Value: values[name]})
+		}
+	}
+	return
+}
+
+// expandCall returns the statements executed for the query q, which are the ones of the stored
+// procedure loaded by qer if q calls one, or q itself otherwise.
+func expandCall(ctx context.Context, qer procedureQuerier, q *types.Query) (stmts []types.Query, err error) {
+	var (
+		name string
+		ok   bool
+		p    *types.Procedure
+	)
+	if name, ok = CalledProcedure(q.Pattern); !ok {
+		return []types.Query{*q}, nil
+	}
+	if p, err = loadProcedure(ctx, qer, name); err != nil {
+		return
+	}
+	return bindProcedure(p, q.Args)
+}
+
+// isReadOnlyQuery reports whether the query pattern only consists of SELECT statements, the
+// pattern failed to parse is regarded as a write.
+func isReadOnlyQuery(pattern string) bool {
+	var (
+		tokenizer  = sqlparser.NewStringTokenizer(pattern)
+		statements []sqlparser.Statement
+		err        error
+	)
+	if _, statements, err = sqlparser.ParseMultiple(tokenizer); err != nil || len(statements) == 0 {
+		return false
+	}
+	for _, v := range statements {
+		switch v.(type) {
+		case *sqlparser.Select, *sqlparser.Union:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// callsProcedure reports whether any of the queries calls a stored procedure.
+func callsProcedure(queries []types.Query) bool {
+	for _, v := range queries {
+		if _, ok := CalledProcedure(v.Pattern); ok {
+			return true
+		}
+	}
+	return false
+}
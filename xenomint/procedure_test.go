@@ -0,0 +1,221 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestProcedure(t *testing.T) {
+	Convey("The called procedure should be parsed from the query", t, func() {
+		for pattern, expected := range map[string]string{
+			`CALL transfer`:       "transfer",
+			` call _p1 ; `:        "_p1",
+			"CALL\n\tTransfer":    "Transfer",
+			`CALL transfer(1, 2)`: "",
+			`SELECT 'CALL p'`:     "",
+			`CALL 1p`:             "",
+		} {
+			var name, ok = CalledProcedure(pattern)
+			So(ok, ShouldEqual, expected != "")
+			So(name, ShouldEqual, expected)
+		}
+		So(ReferencesProcedures(`SELECT * FROM __CQL_Procedures`), ShouldBeTrue)
+		So(ReferencesProcedures(`SELECT * FROM t1`), ShouldBeFalse)
+	})
+	Convey("The procedure definition should be validated", t, func() {
+		for _, v := range []*types.Procedure{
+			{Name: "1p", Statements: []string{`SELECT 1`}},
+			{Name: "p", Params: []string{"a", "a"}, Statements: []string{`SELECT :a`}},
+			{Name: "p", Params: []string{"a b"}, Statements: []string{`SELECT 1`}},
+			{Name: "p"},
+			{Name: "p", Statements: []string{`SELECT :a`}},
+			{Name: "p", Statements: []string{`SELECT ?`}},
+			{Name: "p", Statements: []string{`CALL q`}},
+			{Name: "p", Statements: []string{`DELETE FROM __cql_procedures`}},
+			{Name: "p", Statements: []string{`COMMIT`}},
+			{Name: "p", Statements: []string{`INSERT INTO t1 VALUES (1); /* */ ROLLBACK`}},
+		} {
+			var _, err = ProcedureQueries(v)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidProcedure)
+		}
+		var params, err = procedureParams(&types.Procedure{
+			Name:   "p",
+			Params: []string{"a", "b", "c"},
+			Statements: []string{
+				`INSERT INTO t1 VALUES (:a, ':b') -- :c`,
+				`UPDATE t1 SET v = :b WHERE k = :a OR k = :b`,
+				`SELECT 'begin'`,
+			},
+		})
+		So(err, ShouldBeNil)
+		So(params, ShouldResemble, [][]string{{"a"}, {"b", "a"}, nil})
+	})
+	Convey("The arguments should be bound to the procedure parameters", t, func() {
+		var p = &types.Procedure{
+			Name:   "p",
+			Params: []string{"a", "b"},
+			Statements: []string{
+				`INSERT INTO t1 VALUES (:a, :b)`,
+				`DELETE FROM t1 WHERE k = :b`,
+			},
+		}
+		var stmts, err = bindProcedure(p, []types.NamedArg{{Value: 1}, {Name: "b", Value: 2}})
+		So(err, ShouldBeNil)
+		So(stmts, ShouldResemble, []types.Query{
+			{
+				Pattern: `INSERT INTO t1 VALUES (:a, :b)`,
+				Args:    []types.NamedArg{{Name: "a", Value: 1}, {Name: "b", Value: 2}},
+			}, {
+				Pattern: `DELETE FROM t1 WHERE k = :b`,
+				Args:    []types.NamedArg{{Name: "b", Value: 2}},
+			},
+		})
+		for _, v := range [][]types.NamedArg{
+			{{Value: 1}},
+			{{Value: 1}, {Value: 2}, {Value: 3}},
+			{{Value: 1}, {Name: "a", Value: 2}},
+			{{Value: 1}, {Name: "b", Value: 2}, {Name: "c", Value: 3}},
+		} {
+			_, err = bindProcedure(p, v)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidProcedureArgs)
+		}
+	})
+	Convey("Given a chain state object", t, func() {
+		var (
+			fl  = path.Join(testingDataDir, t.Name())
+			st  *State
+			err error
+		)
+		strg, err := xs.NewSqlite(fmt.Sprint("file:", fl))
+		So(err, ShouldBeNil)
+		st = NewState(sql.LevelReadUncommitted, nodeID, strg)
+		Reset(func() {
+			err = st.Close(true)
+			So(err, ShouldBeNil)
+			for _, v := range []string{"", "-shm", "-wal"} {
+				err = os.Remove(fl + v)
+				So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+			}
+		})
+		var (
+			resp  *types.Response
+			write = func(queries ...types.Query) (err error) {
+				_, resp, err = st.Query(buildRequest(types.WriteQuery, queries), true)
+				return
+			}
+			read = func(queries ...types.Query) (err error) {
+				_, resp, err = st.Query(buildRequest(types.ReadQuery, queries), true)
+				return
+			}
+			register = func(p *types.Procedure) (err error) {
+				var queries []types.Query
+				if queries, err = ProcedureQueries(p); err != nil {
+					return
+				}
+				return write(queries...)
+			}
+		)
+		err = write(
+			buildQuery(`CREATE TABLE accounts (id INT, balance INT CHECK (balance >= 0), PRIMARY KEY(id))`),
+			buildQuery(`INSERT INTO accounts VALUES (1, 100), (2, 0)`),
+		)
+		So(err, ShouldBeNil)
+		err = register(&types.Procedure{
+			Name:   "transfer",
+			Params: []string{"from", "to", "amount"},
+			Statements: []string{
+				`UPDATE accounts SET balance = balance + :amount WHERE id = :to`,
+				`UPDATE accounts SET balance = balance - :amount WHERE id = :from`,
+			},
+		})
+		So(err, ShouldBeNil)
+		err = register(&types.Procedure{
+			Name:       "balance",
+			Params:     []string{"id"},
+			Statements: []string{`SELECT balance FROM accounts WHERE id = :id`},
+		})
+		So(err, ShouldBeNil)
+		Convey("The procedures should be called by name with arguments", func() {
+			err = write(buildQuery(`CALL transfer`, 1, 2, 30))
+			So(err, ShouldBeNil)
+			So(resp.Header.AffectedRows, ShouldEqual, 2)
+			err = read(buildQuery(`CALL balance`, 2))
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 30)
+			err = st.commit()
+			So(err, ShouldBeNil)
+			err = read(types.Query{
+				Pattern: `CALL balance`,
+				Args:    []types.NamedArg{{Name: "id", Value: 1}},
+			})
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 70)
+		})
+		Convey("The procedure statements should be applied atomically", func() {
+			err = write(buildQuery(`CALL transfer`, 1, 2, 300))
+			So(err, ShouldNotBeNil)
+			err = read(buildQuery(`SELECT balance FROM accounts ORDER BY id`))
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 100)
+			So(resp.Payload.Rows[1].Values[0], ShouldEqual, 0)
+		})
+		Convey("The procedures should be replaced and dropped", func() {
+			err = register(&types.Procedure{
+				Name:       "balance",
+				Params:     []string{"id"},
+				Statements: []string{`SELECT balance * 2 FROM accounts WHERE id = :id`},
+			})
+			So(err, ShouldBeNil)
+			err = read(buildQuery(`CALL balance`, 1))
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 200)
+			err = write(DropProcedureQueries("balance")...)
+			So(err, ShouldBeNil)
+			err = read(buildQuery(`CALL balance`, 1))
+			So(errors.Cause(err), ShouldEqual, ErrProcedureNotFound)
+		})
+		Convey("The invalid calls should be rejected", func() {
+			err = write(buildQuery(`CALL unknown`))
+			So(errors.Cause(err), ShouldEqual, ErrProcedureNotFound)
+			err = write(buildQuery(`CALL transfer`, 1, 2))
+			So(errors.Cause(err), ShouldEqual, ErrInvalidProcedureArgs)
+			// the procedures with writes are rejected in the read calls, even if the reads are
+			// served by the writer handler on an uncommitted schema change
+			err = read(buildQuery(`CALL transfer`, 1, 2, 30))
+			So(errors.Cause(err), ShouldEqual, ErrProcedureNotReadOnly)
+			err = write(buildQuery(`CREATE TABLE t2 (k INT)`))
+			So(err, ShouldBeNil)
+			err = read(buildQuery(`CALL transfer`, 1, 2, 30))
+			So(errors.Cause(err), ShouldEqual, ErrProcedureNotReadOnly)
+			err = read(buildQuery(`CALL balance`, 2))
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 0)
+		})
+	})
+}
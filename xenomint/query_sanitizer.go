@@ -17,9 +17,9 @@
 package xenomint
 
 import (
-	"bytes"
 	"database/sql"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/CovenantSQL/sqlparser"
@@ -27,37 +27,37 @@ import (
 
 	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
 )
 
 var (
+	// explainRegexp matches the EXPLAIN and EXPLAIN QUERY PLAN prefix of a query.
+	explainRegexp = regexp.MustCompile(`(?is)^\s*explain(\s+query\s+plan)?\s+(.*)$`)
+
 	sanitizeFunctionMap = map[string]map[string]bool{
 		"load_extension": nil,
+		// the connection state of the storage, see xenomint/sqlite
+		"cql_claim_conn": nil,
 		"unlikely":       nil,
 		"likelihood":     nil,
 		"likely":         nil,
 		"affinity":       nil,
 		"typeof":         nil,
-		"random":         nil,
-		"randomblob":     nil,
 		"unknown":        nil,
+		// the 'now' time value is rewritten by rewriteStatefulCalls
 		"date": {
-			"now":       true,
 			"localtime": true,
 		},
 		"time": {
-			"now":       true,
 			"localtime": true,
 		},
 		"datetime": {
-			"now":       true,
 			"localtime": true,
 		},
 		"julianday": {
-			"now":       true,
 			"localtime": true,
 		},
 		"strftime": {
-			"now":       true,
 			"localtime": true,
 		},
 
@@ -72,12 +72,69 @@ var (
 		//"sqlite_rename_parent":      nil,
 		//"sqlite_record":             nil,
 	}
+
+	// extensionModuleMap maps the allowed virtual table modules to the sqlite extensions.
+	extensionModuleMap = map[string]types.SQLiteExtension{
+		"fts5":      types.ExtensionFTS5,
+		"rtree":     types.ExtensionRTree,
+		"rtree_i32": types.ExtensionRTree,
+	}
+
+	// extensionFuncMap maps the functions to the sqlite extensions, the json functions are
+	// matched by prefix in extensionFunc.
+	extensionFuncMap = map[string]types.SQLiteExtension{
+		"fts5":       types.ExtensionFTS5,
+		"bm25":       types.ExtensionFTS5,
+		"highlight":  types.ExtensionFTS5,
+		"snippet":    types.ExtensionFTS5,
+		"rtreenode":  types.ExtensionRTree,
+		"rtreedepth": types.ExtensionRTree,
+		"rtreecheck": types.ExtensionRTree,
+	}
+
+	// deterministicFuncMap maps the random functions to their deterministic replacements.
+	deterministicFuncMap = map[string]string{
+		"random":     xs.RandomFunc,
+		"randomblob": xs.RandomBlobFunc,
+	}
+
+	// timeFuncMap defines the date and time functions accepting the 'now' time value, and the
+	// position of the time value argument.
+	timeFuncMap = map[string]int{
+		"date":      0,
+		"time":      0,
+		"datetime":  0,
+		"julianday": 0,
+		"strftime":  1,
+	}
+
+	// timeLiteralMap maps the current time literals to their deterministic replacements.
+	timeLiteralMap = map[int]string{
+		sqlparser.CURRENT_TIMESTAMP: "(datetime(" + xs.NowFunc + "()))",
+		sqlparser.CURRENT_DATE:      "(date(" + xs.NowFunc + "()))",
+		sqlparser.CURRENT_TIME:      "(time(" + xs.NowFunc + "()))",
+	}
 )
 
 func convertQueryAndBuildArgs(pattern string, args []types.NamedArg) (containsDDL bool, p string, ifs []interface{}, err error) {
-	if lower := strings.ToLower(pattern); strings.Contains(lower, "begin") ||
-		strings.Contains(lower, "rollback") || strings.Contains(lower, "commit") {
-		return false, pattern, nil, nil
+	var count int
+	if containsDDL, p, count, err = sanitizeQuery(
+		pattern, types.UnrestrictedExtensions); err != nil || count == 0 {
+		return
+	}
+	ifs = buildArgs(args)
+	return
+}
+
+// sanitizeQuery parses and sanitizes the query pattern, it returns the translated pattern and the
+// count of statements in it. Transaction control queries are passed through as is with a zero
+// statement count. The virtual tables and functions of the sqlite extensions are only allowed if
+// they are included in ext.
+func sanitizeQuery(
+	pattern string, ext types.SQLiteExtension) (containsDDL bool, p string, count int, err error,
+) {
+	if isTxControlQuery(pattern) {
+		return false, pattern, 0, nil
 	}
 	var (
 		tokenizer  = sqlparser.NewStringTokenizer(pattern)
@@ -97,6 +154,14 @@ func convertQueryAndBuildArgs(pattern string, args []types.NamedArg) (containsDD
 		walkNodes := []sqlparser.SQLNode{statements[i]}
 
 		switch stmt := statements[i].(type) {
+		case *sqlparser.Explain:
+			// The explained statement is discarded by the parser, parse it again for sanitizing.
+			// It is never executed by sqlite, so it is not counted as a DDL.
+			var explained sqlparser.Statement
+			if explained, err = parseExplained(queryParts[i]); err != nil {
+				return
+			}
+			walkNodes = []sqlparser.SQLNode{explained}
 		case *sqlparser.Show:
 			origQuery = queryParts[i]
 
@@ -128,6 +193,15 @@ WHERE type = "index" AND tbl_name = "%s"
 			queryParts[i] = query
 		case *sqlparser.DDL:
 			containsDDL = true
+			if stmt.Action == sqlparser.CreateVirtualTableStr {
+				// the module name is parsed as the new table name
+				var module = strings.ToLower(stmt.NewName.Name.String())
+				if required, ok := extensionModuleMap[module]; ext != types.UnrestrictedExtensions &&
+					(!ok || !ext.Has(required)) {
+					err = errors.Wrapf(ErrExtensionNotAllowed, "virtual table module %s", module)
+					return
+				}
+			}
 			if stmt.TableSpec != nil {
 				// walk table default values for invalid stateful expressions
 				for _, c := range stmt.TableSpec.Columns {
@@ -152,21 +226,17 @@ WHERE type = "index" AND tbl_name = "%s"
 			}
 		}
 
-		// scan query and test if there is any stateful query logic like time expression or random function
+		// scan query and test if there is any stateful query logic which can't be rewritten, the
+		// time expressions, the 'now' time values and the random functions are rewritten later
 		err = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
 			switch n := node.(type) {
-			case *sqlparser.SQLVal:
-				if n.Type == sqlparser.ValArg && bytes.EqualFold([]byte("CURRENT_TIMESTAMP"), n.Val) {
-					// current_timestamp literal in default expression
-					err = errors.Wrap(ErrStatefulQueryParts, "DEFAULT CURRENT_TIMESTAMP not supported")
+			case *sqlparser.FuncExpr:
+				if required, ok := extensionFunc(n.Name.Lowered()); ok && !ext.Has(required) {
+					tb := sqlparser.NewTrackedBuffer(nil)
+					err = errors.Wrapf(ErrExtensionNotAllowed, "function call %s",
+						tb.WriteNode(n).String())
 					return
 				}
-			case *sqlparser.TimeExpr:
-				tb := sqlparser.NewTrackedBuffer(nil)
-				err = errors.Wrapf(ErrStatefulQueryParts, "time expression %s not supported",
-					tb.WriteNode(n).String())
-				return
-			case *sqlparser.FuncExpr:
 				if strings.HasPrefix(n.Name.Lowered(), "sqlite") {
 					tb := sqlparser.NewTrackedBuffer(nil)
 					err = errors.Wrapf(ErrStatefulQueryParts, "function call %s not supported",
@@ -196,7 +266,7 @@ WHERE type = "index" AND tbl_name = "%s"
 							}
 						}
 						return true, nil
-					})
+					}, n.Exprs)
 
 					return
 				}
@@ -207,10 +277,176 @@ WHERE type = "index" AND tbl_name = "%s"
 			err = errors.Wrap(err, "parse sql failed")
 			return
 		}
+		if _, ok := statements[i].(*sqlparser.Show); !ok {
+			_, ddl := statements[i].(*sqlparser.DDL)
+			if queryParts[i], err = rewriteStatefulCalls(queryParts[i], ddl); err != nil {
+				err = errors.Wrap(err, "parse sql failed")
+				return
+			}
+		}
 	}
 
 	p = strings.Join(queryParts, "; ")
+	count = len(queryParts)
+	return
+}
+
+// extensionFunc returns the sqlite extension providing the function name, if any.
+func extensionFunc(name string) (ext types.SQLiteExtension, ok bool) {
+	if name == "json" || strings.HasPrefix(name, "json_") {
+		return types.ExtensionJSON1, true
+	}
+	ext, ok = extensionFuncMap[name]
+	return
+}
+
+// rewriteStatefulCalls rewrites the 'now' time values, the current time literals and the random
+// function calls in query to the deterministic functions, which are evaluated with the signed
+// timestamp and hash of the write request. The stateful parts of a DDL statement are rejected
+// instead, as the deterministic functions would be kept in the schema and fail the writes of the
+// sqlite instances without them, e.g. the database mirror and the restored backups.
+func rewriteStatefulCalls(query string, ddl bool) (p string, err error) {
+	type frame struct {
+		timeArg int // position of the time value argument, or -1 if not a time function call
+		arg     int // position of the current argument
+	}
+	var (
+		tkn    = sqlparser.NewStringTokenizer(query)
+		buf    strings.Builder
+		copied int
+		frames []frame
+		inTime int // count of the enclosing time function calls
+
+		prevTyp        int
+		prevVal        string
+		prevStart, end int
+
+		unsupported = func(start, end int) error {
+			return errors.Wrapf(ErrStatefulQueryParts, "stateful query part %s not supported",
+				query[start:end])
+		}
+		replace = func(start, end int, to string) error {
+			if ddl {
+				return errors.Wrapf(ErrStatefulQueryParts,
+					"stateful query part %s not supported in schema", query[start:end])
+			}
+			buf.WriteString(query[copied:start])
+			buf.WriteString(to)
+			copied = end
+			return nil
+		}
+	)
+	for {
+		typ, val := tkn.Scan()
+		if typ == 0 {
+			break
+		}
+		if typ == sqlparser.LEX_ERROR {
+			err = errors.Wrapf(ErrStatefulQueryParts, "unexpected token %s", val)
+			return
+		}
+		// The lookahead char is read by the tokenizer
+		end = tkn.Position - 1
+		var start = end - len(val)
+
+		switch {
+		case typ == '(':
+			var (
+				f    = frame{timeArg: -1}
+				name = strings.ToLower(prevVal)
+			)
+			if prevTyp != sqlparser.STRING {
+				if pos, ok := timeFuncMap[name]; ok {
+					f.timeArg = pos
+					inTime++
+				}
+				if to, ok := deterministicFuncMap[name]; ok {
+					if !strings.EqualFold(query[prevStart:prevStart+len(prevVal)], prevVal) {
+						// quoted function name
+						err = unsupported(prevStart, end)
+						return
+					}
+					if err = replace(prevStart, prevStart+len(prevVal), to); err != nil {
+						return
+					}
+				}
+			}
+			frames = append(frames, f)
+		case typ == ')' && len(frames) > 0:
+			if frames[len(frames)-1].timeArg >= 0 {
+				inTime--
+			}
+			frames = frames[:len(frames)-1]
+		case typ == ',' && len(frames) > 0:
+			frames[len(frames)-1].arg++
+		case typ == sqlparser.STRING && inTime > 0 && strings.EqualFold(string(val), "now"):
+			// The quoted raw string is 2 bytes longer than its value
+			start -= 2
+			var f = frames[len(frames)-1]
+			if start < 0 || !strings.EqualFold(query[start+1:end-1], "now") {
+				err = unsupported(start, end)
+				return
+			}
+			if f.timeArg < 0 {
+				// 'now' in a nested expression of a time function call
+				err = unsupported(start, end)
+				return
+			}
+			if f.arg == f.timeArg {
+				if err = replace(start, end, xs.NowFunc+"()"); err != nil {
+					return
+				}
+			}
+		default:
+			if to, ok := timeLiteralMap[typ]; ok {
+				if err = replace(start, end, to); err != nil {
+					return
+				}
+			}
+		}
+		prevTyp, prevVal, prevStart = typ, string(val), start
+	}
+	if copied == 0 {
+		return query, nil
+	}
+	buf.WriteString(query[copied:])
+	p = buf.String()
+	return
+}
+
+// isTxControlQuery reports whether the query pattern may contain transaction control statements,
+// which are passed through to sqlite without sanitizing.
+func isTxControlQuery(pattern string) bool {
+	var lower = strings.ToLower(pattern)
+	return strings.Contains(lower, "begin") ||
+		strings.Contains(lower, "rollback") || strings.Contains(lower, "commit")
+}
+
+// parseExplained parses the statement explained by an EXPLAIN or EXPLAIN QUERY PLAN query.
+func parseExplained(query string) (stmt sqlparser.Statement, err error) {
+	var m = explainRegexp.FindStringSubmatch(query)
+	if m == nil {
+		err = errors.Wrap(ErrInvalidExplainQuery, "parse sql failed")
+		return
+	}
+	if stmt, err = sqlparser.Parse(m[2]); err != nil {
+		err = errors.Wrap(err, "parse sql failed")
+		return
+	}
+	switch stmt.(type) {
+	case *sqlparser.Explain, *sqlparser.Show:
+		err = errors.Wrap(ErrInvalidExplainQuery, "parse sql failed")
+	}
+	return
+}
+
+// isExplainQuery reports whether the query pattern is an EXPLAIN or EXPLAIN QUERY PLAN query, which
+// only reports how sqlite would run the statement instead of executing it.
+func isExplainQuery(pattern string) bool {
+	return explainRegexp.MatchString(pattern)
+}
 
+func buildArgs(args []types.NamedArg) (ifs []interface{}) {
 	ifs = make([]interface{}, len(args))
 	for i, v := range args {
 		ifs[i] = sql.NamedArg{
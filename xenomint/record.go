@@ -0,0 +1,230 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/utils"
+)
+
+// storageRecord is a row of the database selected as a storage record.
+type storageRecord struct {
+	Seq    uint64
+	Table  string
+	Values []interface{} // rowid and the column values, nil if there is no row to select
+}
+
+// recordProbe is a pending storage record request, which is answered when the state reaches the
+// requested log offset.
+type recordProbe struct {
+	seq    uint64
+	n      int32
+	record []byte
+	err    error
+	done   chan struct{}
+}
+
+// CurrentStorageRecord returns the nth storage record of the current state and the log offset it is
+// read at, see StorageRecord.
+func (s *State) CurrentStorageRecord(n int32) (seq uint64, record []byte, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		err = ErrStateClosed
+		return
+	}
+	seq = s.getSeq()
+	record, err = s.storageRecord(context.Background(), seq, n)
+	return
+}
+
+// StorageRecord returns the nth storage record of the state at log offset seq, which is a row of a
+// user table selected by n. It waits until the state reaches seq between two write requests, and
+// returns ErrStorageRecordStale if the state has passed seq already.
+//
+// The replicas read the same record from the same state, so the record proves that the replica
+// does store the database. Note that the tables WITHOUT ROWID and the virtual tables are not
+// selected.
+func (s *State) StorageRecord(ctx context.Context, seq uint64, n int32) (record []byte, err error) {
+	var p = &recordProbe{seq: seq, n: n, done: make(chan struct{})}
+	if err = func() (err error) {
+		s.Lock()
+		defer s.Unlock()
+		if s.closed {
+			return ErrStateClosed
+		}
+		s.probes = append(s.probes, p)
+		s.fireProbes()
+		return
+	}(); err != nil {
+		return
+	}
+	select {
+	case <-p.done:
+		return p.record, p.err
+	case <-ctx.Done():
+		s.Lock()
+		defer s.Unlock()
+		for i, v := range s.probes {
+			if v == p {
+				s.probes = append(s.probes[:i], s.probes[i+1:]...)
+				return nil, ctx.Err()
+			}
+		}
+		// fired while waiting for the lock
+		return p.record, p.err
+	}
+}
+
+// fireProbes answers the pending storage record requests at the current log offset. It must be
+// called with the State lock held and between two write requests.
+func (s *State) fireProbes() {
+	if len(s.probes) == 0 {
+		return
+	}
+	var (
+		seq     = s.getSeq()
+		pending = s.probes[:0]
+	)
+	for _, v := range s.probes {
+		switch {
+		case v.seq == seq:
+			v.record, v.err = s.storageRecord(context.Background(), seq, v.n)
+		case v.seq < seq:
+			v.err = errors.Wrapf(ErrStorageRecordStale, "log offset %d vs %d", v.seq, seq)
+		default:
+			pending = append(pending, v)
+			continue
+		}
+		close(v.done)
+	}
+	for i := len(pending); i < len(s.probes); i++ {
+		s.probes[i] = nil
+	}
+	s.probes = pending
+}
+
+// storageRecord reads the nth storage record with the handler of the ongoing write queries. It must
+// be called with the State lock held.
+func (s *State) storageRecord(ctx context.Context, seq uint64, n int32) (record []byte, err error) {
+	var (
+		h      = s.contextHandler()
+		tables []string
+		row    = &storageRecord{Seq: seq}
+	)
+	if tables, err = recordTables(ctx, h); err != nil {
+		return
+	}
+	if len(tables) > 0 && n >= 0 {
+		row.Table = tables[int(n)%len(tables)]
+		if row.Values, err = selectRow(ctx, h, row.Table, int64(n)/int64(len(tables))); err != nil {
+			return
+		}
+	}
+	var buf, ierr = utils.EncodeMsgPack(row)
+	if ierr != nil {
+		err = ierr
+		return
+	}
+	return buf.Bytes(), nil
+}
+
+// recordTables returns the names of the user tables which the storage records are selected from.
+func recordTables(ctx context.Context, h sqlContextHandler) (tables []string, err error) {
+	var rows *sql.Rows
+	if rows, err = h.QueryContext(ctx, `SELECT "name", "sql" FROM "main"."sqlite_master"
+WHERE "type" = 'table' AND "name" NOT LIKE 'sqlite\_%' ESCAPE '\' ORDER BY "name"`); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var name, stmt sql.NullString
+		if err = rows.Scan(&name, &stmt); err != nil {
+			return
+		}
+		if withoutRowidRegexp.MatchString(stmt.String) ||
+			strings.HasPrefix(strings.ToUpper(strings.TrimSpace(stmt.String)), "CREATE VIRTUAL") {
+			continue
+		}
+		tables = append(tables, name.String)
+	}
+	err = rows.Err()
+	return
+}
+
+// selectRow returns the rowid and the column values of the row selected by k in table, or nil if
+// the table is empty. The kth rowid in the range of the table rowids is selected, or the first row
+// following it if the rowid is not used.
+func selectRow(
+	ctx context.Context, h sqlContextHandler, table string, k int64) (values []interface{}, err error,
+) {
+	var (
+		name     = `"main".` + quoteIdentifier(table)
+		min, max sql.NullInt64
+	)
+	if err = func() (err error) {
+		var rows *sql.Rows
+		if rows, err = h.QueryContext(ctx,
+			`SELECT min("rowid"), max("rowid") FROM `+name); err != nil {
+			return
+		}
+		defer func() { _ = rows.Close() }()
+		if rows.Next() {
+			if err = rows.Scan(&min, &max); err != nil {
+				return
+			}
+		}
+		return rows.Err()
+	}(); err != nil || !min.Valid {
+		return
+	}
+	var (
+		rows    *sql.Rows
+		columns []string
+		span    = uint64(max.Int64-min.Int64) + 1
+		rowid   = min.Int64 + int64(uint64(k)%span)
+	)
+	if span == 0 {
+		// the rowids span the full int64 range
+		rowid = min.Int64 + k
+	}
+	if rows, err = h.QueryContext(ctx, `SELECT "rowid", * FROM `+name+
+		` WHERE "rowid" >= ? ORDER BY "rowid" LIMIT 1`, rowid); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	if columns, err = rows.Columns(); err != nil {
+		return
+	}
+	if rows.Next() {
+		values = make([]interface{}, len(columns))
+		var dest = make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return
+		}
+	}
+	err = rows.Err()
+	return
+}
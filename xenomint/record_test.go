@@ -0,0 +1,147 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestStorageRecord(t *testing.T) {
+	Convey("Given two states replicating the same database", t, func() {
+		var (
+			fl1  = path.Join(testingDataDir, fmt.Sprint(t.Name(), "x1"))
+			fl2  = path.Join(testingDataDir, fmt.Sprint(t.Name(), "x2"))
+			st1  *State
+			st2  *State
+			strg xi.Storage
+			err  error
+		)
+		strg, err = xs.NewSqlite(fmt.Sprint("file:", fl1))
+		So(err, ShouldBeNil)
+		st1 = NewState(sql.LevelReadUncommitted, nodeID, strg)
+		strg, err = xs.NewSqlite(fmt.Sprint("file:", fl2))
+		So(err, ShouldBeNil)
+		st2 = NewState(sql.LevelReadUncommitted, nodeID, strg)
+		Reset(func() {
+			for _, v := range []*State{st1, st2} {
+				err = v.Close(true)
+				So(err, ShouldBeNil)
+			}
+			for _, v := range []string{fl1, fl2} {
+				err = os.Remove(v)
+				So(err, ShouldBeNil)
+				for _, s := range []string{"-shm", "-wal"} {
+					err = os.Remove(fmt.Sprint(v, s))
+					So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+				}
+			}
+		})
+		// produces a block from the queries on st1
+		var produce = func(reqs ...*types.Request) (block *types.Block) {
+			for _, v := range reqs {
+				var qt, resp, err = st1.Query(v, true)
+				So(err, ShouldBeNil)
+				qt.UpdateResp(resp)
+			}
+			var _, qts, err = st1.CommitEx()
+			So(err, ShouldBeNil)
+			block = &types.Block{QueryTxs: make([]*types.QueryAsTx, len(qts))}
+			for i, v := range qts {
+				block.QueryTxs[i] = &types.QueryAsTx{Request: v.Req, Response: &v.Resp.Header}
+			}
+			return
+		}
+
+		var block = produce(
+			buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`CREATE TABLE t2 (k TEXT PRIMARY KEY, v BLOB) WITHOUT ROWID`),
+				buildQuery(`CREATE TABLE t3 (v TEXT)`),
+			}),
+			buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?), (?, ?), (?, ?)`,
+					1, "a", 5, "b", 9, "c"),
+			}),
+		)
+		seq, record, err := st1.CurrentStorageRecord(0)
+		So(err, ShouldBeNil)
+		So(record, ShouldNotBeEmpty)
+
+		Convey("The replicas should read the same records at the same log offset", func() {
+			var (
+				ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+				records     = make(chan []byte, 1)
+				errs        = make(chan error, 1)
+			)
+			defer cancel()
+			go func() {
+				var record, err = st2.StorageRecord(ctx, seq, 0)
+				records <- record
+				errs <- err
+			}()
+			_, err = st2.ReplayBlockEx(context.Background(), block)
+			So(err, ShouldBeNil)
+			So(<-errs, ShouldBeNil)
+			So(<-records, ShouldResemble, record)
+
+			for n := int32(1); n < 8; n++ {
+				_, expected, err := st1.CurrentStorageRecord(n)
+				So(err, ShouldBeNil)
+				replica, err := st2.StorageRecord(ctx, seq, n)
+				So(err, ShouldBeNil)
+				So(replica, ShouldResemble, expected)
+			}
+		})
+		Convey("The record should differ if the row is changed", func() {
+			_, err = st2.ReplayBlockEx(context.Background(), block)
+			So(err, ShouldBeNil)
+			var _, resp, err = st2.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`UPDATE t1 SET v = ? WHERE k = ?`, "x", 1),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Header.AffectedRows, ShouldEqual, 1)
+			_, err = st2.StorageRecord(context.Background(), seq, 0)
+			So(errors.Cause(err), ShouldEqual, ErrStorageRecordStale)
+			nseq, forged, err := st2.CurrentStorageRecord(0)
+			So(err, ShouldBeNil)
+			So(nseq, ShouldEqual, seq+1)
+			So(forged, ShouldNotResemble, record)
+		})
+		Convey("The request should be canceled if the log offset is not reached", func() {
+			var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err = st2.StorageRecord(ctx, seq, 0)
+			So(err == context.DeadlineExceeded, ShouldBeTrue)
+			st2.Lock()
+			So(st2.probes, ShouldBeEmpty)
+			st2.Unlock()
+		})
+	})
+}
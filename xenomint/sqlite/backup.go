@@ -0,0 +1,175 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlite
+
+/*
+#include <stdlib.h>
+#include <string.h>
+
+// The sqlite library is linked by the sqlite3 driver package, which is built with the sqlcipher
+// codec.
+typedef struct sqlite3 sqlite3;
+typedef struct sqlite3_context sqlite3_context;
+typedef struct sqlite3_value sqlite3_value;
+extern int sqlite3_open_v2(const char *, sqlite3 **, int, const char *);
+extern int sqlite3_close(sqlite3 *);
+extern int sqlite3_key(sqlite3 *, const void *, int);
+extern int sqlite3_busy_timeout(sqlite3 *, int);
+extern int sqlite3_exec(sqlite3 *, const char *, int (*)(void *, int, char **, char **), void *,
+	char **);
+extern int sqlite3_create_function(sqlite3 *, const char *, int, int, void *,
+	void (*)(sqlite3_context *, int, sqlite3_value **),
+	void (*)(sqlite3_context *, int, sqlite3_value **), void (*)(sqlite3_context *));
+extern const char *sqlite3_errmsg(sqlite3 *);
+extern char *sqlite3_mprintf(const char *, ...);
+extern void sqlite3_free(void *);
+extern void sqlcipher_exportFunc(sqlite3_context *, int, sqlite3_value **);
+
+#define SQLITE_OPEN_READWRITE 0x00000002
+#define SQLITE_OPEN_CREATE 0x00000004
+#define SQLITE_UTF8 1
+
+// exportDatabase copies the database file src decrypted with srcKey into the database file dst
+// encrypted with dstKey by sqlcipher_export, which is only registered on this connection. The
+// connection is opened with SQLITE_OPEN_CREATE for the attached target, the source database
+// file should exist. The error message is returned in errmsg, which should be freed by the
+// caller.
+static int exportDatabase(const char *src, const char *srcKey, const char *dst,
+	const char *dstKey, char **errmsg)
+{
+	sqlite3 *db = NULL;
+	char *sql = NULL;
+	int rc = sqlite3_open_v2(src, &db, SQLITE_OPEN_READWRITE | SQLITE_OPEN_CREATE, NULL);
+	if (rc == 0 && srcKey[0] != '\0') {
+		rc = sqlite3_key(db, srcKey, (int)strlen(srcKey));
+	}
+	if (rc == 0) {
+		rc = sqlite3_busy_timeout(db, 5000);
+	}
+	if (rc == 0) {
+		rc = sqlite3_create_function(db, "sqlcipher_export", 1, SQLITE_UTF8, NULL,
+			sqlcipher_exportFunc, NULL, NULL);
+	}
+	if (rc == 0) {
+		sql = sqlite3_mprintf("ATTACH DATABASE %Q AS \"export\" KEY %Q;"
+			"SELECT sqlcipher_export('export');"
+			"DETACH DATABASE \"export\";", dst, dstKey);
+		rc = sqlite3_exec(db, sql, NULL, NULL, errmsg);
+		sqlite3_free(sql);
+	} else if (db != NULL) {
+		*errmsg = sqlite3_mprintf("%s", sqlite3_errmsg(db));
+	}
+	sqlite3_close(db);
+	return rc;
+}
+*/
+import "C"
+
+import (
+	"os"
+	"unsafe"
+
+	sqlite3 "github.com/CovenantSQL/go-sqlite3-encrypt"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/storage"
+)
+
+// Backup copies the database attached to src into a new database file attached to dst by the
+// online backup API. The database files are decrypted and encrypted with the keys specified in
+// the DSNs respectively, and any existing file of dst is replaced.
+func Backup(src, dst string) (err error) {
+	var (
+		drv              = &sqlite3.SQLiteDriver{}
+		srcDSN, dstDSN   *storage.DSN
+		srcConn, dstConn *sqlite3.SQLiteConn
+		backup           *sqlite3.SQLiteBackup
+		conn             interface{}
+	)
+	if srcDSN, err = storage.NewDSN(src); err != nil {
+		return
+	}
+	if dstDSN, err = storage.NewDSN(dst); err != nil {
+		return
+	}
+	if err = removeFiles(dstDSN.GetFileName()); err != nil {
+		return
+	}
+	srcDSN.AddParam("_journal_mode", "WAL")
+	srcDSN.AddParam("_query_only", "on")
+	if conn, err = drv.Open(srcDSN.Format()); err != nil {
+		return errors.Wrap(err, "open source database")
+	}
+	srcConn = conn.(*sqlite3.SQLiteConn)
+	defer func() { _ = srcConn.Close() }()
+	if conn, err = drv.Open(dstDSN.Format()); err != nil {
+		return errors.Wrap(err, "open target database")
+	}
+	dstConn = conn.(*sqlite3.SQLiteConn)
+	defer func() { _ = dstConn.Close() }()
+	if backup, err = dstConn.Backup("main", srcConn, "main"); err != nil {
+		return errors.Wrap(err, "init backup")
+	}
+	if _, err = backup.Step(-1); err != nil {
+		_ = backup.Finish()
+		return errors.Wrap(err, "copy database pages")
+	}
+	if err = backup.Finish(); err != nil {
+		return errors.Wrap(err, "finish backup")
+	}
+	return
+}
+
+// Export copies the database attached to src into a new database file attached to dst by
+// sqlcipher_export. Unlike Backup, which copies the raw pages, it also works on a plaintext
+// source database with an encrypted target. The database files are decrypted and encrypted with
+// the keys specified in the DSNs respectively, and any existing file of dst is replaced.
+func Export(src, dst string) (err error) {
+	var srcDSN, dstDSN *storage.DSN
+	if srcDSN, err = storage.NewDSN(src); err != nil {
+		return
+	}
+	if dstDSN, err = storage.NewDSN(dst); err != nil {
+		return
+	}
+	if _, err = os.Stat(srcDSN.GetFileName()); err != nil {
+		return errors.Wrap(err, "open source database")
+	}
+	if err = removeFiles(dstDSN.GetFileName()); err != nil {
+		return
+	}
+	var (
+		srcKey, _ = srcDSN.GetParam("_crypto_key")
+		dstKey, _ = dstDSN.GetParam("_crypto_key")
+		args      = []*C.char{
+			C.CString(srcDSN.GetFileName()), C.CString(srcKey),
+			C.CString(dstDSN.GetFileName()), C.CString(dstKey),
+		}
+		errmsg *C.char
+	)
+	defer func() {
+		for _, v := range args {
+			C.free(unsafe.Pointer(v))
+		}
+	}()
+	if rc := C.exportDatabase(args[0], args[1], args[2], args[3], &errmsg); rc != 0 {
+		err = errors.Errorf("export database: %s (%d)", C.GoString(errmsg), int(rc))
+		C.sqlite3_free(unsafe.Pointer(errmsg))
+		_ = removeFiles(dstDSN.GetFileName())
+	}
+	return
+}
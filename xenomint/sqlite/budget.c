@@ -0,0 +1,268 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#include <stdint.h>
+#include <stdlib.h>
+#include "budget.h"
+
+// The sqlite library is linked by the sqlite3 driver package.
+typedef struct sqlite3 sqlite3;
+typedef struct sqlite3_stmt sqlite3_stmt;
+typedef struct sqlite3_context sqlite3_context;
+typedef struct sqlite3_value sqlite3_value;
+typedef struct sqlite3_api_routines sqlite3_api_routines;
+typedef long long sqlite3_int64;
+
+extern int sqlite3_auto_extension(void (*)(void));
+extern int sqlite3_create_function_v2(sqlite3 *, const char *, int, int, void *,
+	void (*)(sqlite3_context *, int, sqlite3_value **),
+	void (*)(sqlite3_context *, int, sqlite3_value **),
+	void (*)(sqlite3_context *),
+	void (*)(void *));
+extern void *sqlite3_user_data(sqlite3_context *);
+extern sqlite3_int64 sqlite3_value_int64(sqlite3_value *);
+extern void sqlite3_result_int64(sqlite3_context *, sqlite3_int64);
+extern void sqlite3_result_null(sqlite3_context *);
+extern void sqlite3_progress_handler(sqlite3 *, int, int (*)(void *), void *);
+extern int sqlite3_trace_v2(sqlite3 *, unsigned, int (*)(unsigned, void *, void *, void *), void *);
+extern int sqlite3_stmt_status(sqlite3_stmt *, int, int);
+extern int sqlite3_db_status(sqlite3 *, int, int *, int *, int);
+extern int sqlite3_limit(sqlite3 *, int, int);
+extern int sqlite3_errcode(sqlite3 *);
+
+#define SQLITE_OK 0
+#define SQLITE_NOMEM 7
+#define SQLITE_TOOBIG 18
+#define SQLITE_UTF8 1
+#define SQLITE_TRACE_PROFILE 0x02
+#define SQLITE_STMTSTATUS_FULLSCAN_STEP 1
+#define SQLITE_STMTSTATUS_VM_STEP 4
+#define SQLITE_DBSTATUS_CACHE_USED 1
+#define SQLITE_LIMIT_LENGTH 0
+
+// progressOps is the number of VM instructions between the progress handler calls, which is
+// also the granularity of the budget.
+#define progressOps 100
+
+#define load(p) __atomic_load_n((p), __ATOMIC_ACQUIRE)
+#define store(p, v) __atomic_store_n((p), (v), __ATOMIC_RELEASE)
+#define add(p, v) __atomic_add_fetch((p), (v), __ATOMIC_ACQ_REL)
+
+struct stepBudget {
+	unsigned long long steps;
+	unsigned long long max;
+	unsigned long long maxMem;
+	int exceeded;
+	// statement status counters accumulated by the profile callback, which are not reset with
+	// the budget
+	unsigned long long scanned;
+	unsigned long long vmSteps;
+	// db is the connection owning the budget, the memory of which is metered, or NULL if the
+	// budget is shared by connections. It's cleared when the connection is closed.
+	sqlite3 *db;
+	int defaultLength;  // max length of the values of db by default
+	long long baseMem;  // page cache memory of db when the budget is reset
+	int refs;
+};
+
+struct connState {
+	sqlite3 *db;
+	int claimed;
+	stepBudget *budget; // budget of the connection, acquired when it's claimed
+	stepBudget *shared; // budget shared with the other connections which replaces budget if set
+};
+
+// claimToken is the argument to claim a connection state, which is unknown to the queries.
+static long long claimToken;
+static int inUse;
+
+// allocBudget allocates a budget referenced once, which meters the memory of db if it's set.
+static stepBudget *allocBudget(sqlite3 *db) {
+	stepBudget *b = (stepBudget *)calloc(1, sizeof(stepBudget));
+	if (b == NULL) {
+		return NULL;
+	}
+	b->refs = 1;
+	if ((b->db = db) != NULL) {
+		b->defaultLength = sqlite3_limit(db, SQLITE_LIMIT_LENGTH, -1);
+	}
+	add(&inUse, 1);
+	return b;
+}
+
+stepBudget *newBudget(void) {
+	return allocBudget(NULL);
+}
+
+static void retainBudget(stepBudget *b) {
+	if (b != NULL) {
+		add(&b->refs, 1);
+	}
+}
+
+void releaseBudget(stepBudget *b) {
+	if (b != NULL && add(&b->refs, -1) == 0) {
+		free(b);
+		add(&inUse, -1);
+	}
+}
+
+int budgetsInUse(void) {
+	return load(&inUse);
+}
+
+// cacheUsed returns the heap memory used by the page caches of the connection, including the
+// caches of the temp database and the attached ones.
+static long long cacheUsed(sqlite3 *db) {
+	int cur = 0, hi = 0;
+	if (sqlite3_db_status(db, SQLITE_DBSTATUS_CACHE_USED, &cur, &hi, 0) != SQLITE_OK) {
+		return 0;
+	}
+	return cur;
+}
+
+// resetBudget resets the budget before or after a statement. It's called by the goroutine using
+// the connection of the budget, so no statement of the connection is stepping; the budget shared
+// by connections may still be read by their callbacks.
+void resetBudget(stepBudget *b, unsigned long long max, unsigned long long maxMem) {
+	sqlite3 *db = load(&b->db);
+	store(&b->exceeded, 0);
+	store(&b->steps, 0);
+	store(&b->max, max);
+	if (db != NULL) {
+		// the values of the statements, e.g., the strings concatenated, can't exceed the memory
+		int length = b->defaultLength;
+		if (maxMem > 0 && maxMem < (unsigned long long)length) {
+			length = (int)maxMem;
+		}
+		sqlite3_limit(db, SQLITE_LIMIT_LENGTH, length);
+		store(&b->baseMem, cacheUsed(db));
+		store(&b->maxMem, maxMem);
+	}
+}
+
+int budgetExceeded(stepBudget *b) {
+	int exceeded = load(&b->exceeded);
+	sqlite3 *db = load(&b->db);
+	if (exceeded == 0 && db != NULL && load(&b->maxMem) > 0 &&
+		sqlite3_errcode(db) == SQLITE_TOOBIG) {
+		// the last statement failed on a value longer than the memory
+		exceeded = budgetExceededMemory;
+	}
+	return exceeded;
+}
+
+void budgetUsage(stepBudget *b, unsigned long long *scanned, unsigned long long *vmSteps) {
+	*scanned = load(&b->scanned);
+	*vmSteps = load(&b->vmSteps);
+}
+
+static stepBudget *currentBudget(connState *s) {
+	return s->shared != NULL ? s->shared : s->budget;
+}
+
+static int budgetProgress(void *p) {
+	stepBudget *b = currentBudget((connState *)p);
+	unsigned long long steps = add(&b->steps, progressOps), max = load(&b->max);
+	unsigned long long maxMem = load(&b->maxMem);
+	sqlite3 *db;
+	if (max > 0 && steps > max) {
+		store(&b->exceeded, budgetExceededSteps);
+		return 1;
+	}
+	if (maxMem > 0 && (db = load(&b->db)) != NULL &&
+		cacheUsed(db) - load(&b->baseMem) > (long long)maxMem) {
+		store(&b->exceeded, budgetExceededMemory);
+		return 1;
+	}
+	return 0;
+}
+
+// budgetTrace is called when a statement finishes, it collects and resets the status counters of
+// the statement.
+static int budgetTrace(unsigned event, void *p, void *stmt, void *x) {
+	stepBudget *b = currentBudget((connState *)p);
+	add(&b->scanned,
+		sqlite3_stmt_status((sqlite3_stmt *)stmt, SQLITE_STMTSTATUS_FULLSCAN_STEP, 1));
+	add(&b->vmSteps, sqlite3_stmt_status((sqlite3_stmt *)stmt, SQLITE_STMTSTATUS_VM_STEP, 1));
+	return 0;
+}
+
+// claimConn returns the connection state on its first call with the claim token, and installs the
+// budget of the connection. It returns NULL otherwise, so that the state is never exposed to the
+// queries of the connection.
+static void claimConn(sqlite3_context *ctx, int argc, sqlite3_value **argv) {
+	connState *s = (connState *)sqlite3_user_data(ctx);
+	if (s->claimed || argc != 1 || sqlite3_value_int64(argv[0]) != claimToken) {
+		sqlite3_result_null(ctx);
+		return;
+	}
+	if ((s->budget = allocBudget(s->db)) == NULL) {
+		sqlite3_result_null(ctx);
+		return;
+	}
+	s->claimed = 1;
+	sqlite3_progress_handler(s->db, progressOps, budgetProgress, s);
+	sqlite3_trace_v2(s->db, SQLITE_TRACE_PROFILE, budgetTrace, s);
+	sqlite3_result_int64(ctx, (sqlite3_int64)(intptr_t)s);
+}
+
+// destroyConnState is called by sqlite when the connection is closed.
+static void destroyConnState(void *p) {
+	connState *s = (connState *)p;
+	if (s->budget != NULL) {
+		store(&s->budget->db, (sqlite3 *)NULL);
+		releaseBudget(s->budget);
+	}
+	releaseBudget(s->shared);
+	free(s);
+}
+
+static int registerConnState(sqlite3 *db, char **errMsg, const sqlite3_api_routines *api) {
+	connState *s = (connState *)calloc(1, sizeof(connState));
+	if (s == NULL) {
+		return SQLITE_NOMEM;
+	}
+	s->db = db;
+	// s is destroyed by sqlite if the registration fails
+	return sqlite3_create_function_v2(
+		db, claimConnFunc, 1, SQLITE_UTF8, s, claimConn, NULL, NULL, destroyConnState);
+}
+
+int initBudgets(long long token) {
+	claimToken = token;
+	return sqlite3_auto_extension((void (*)(void))registerConnState);
+}
+
+connState *connStateOf(long long v) {
+	return (connState *)(intptr_t)v;
+}
+
+void *connHandle(connState *s) {
+	return s->db;
+}
+
+stepBudget *retainConnBudget(connState *s) {
+	retainBudget(s->budget);
+	return s->budget;
+}
+
+void shareBudget(connState *s, stepBudget *b) {
+	stepBudget *old = s->shared;
+	retainBudget(b);
+	s->shared = b;
+	releaseBudget(old);
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlite
+
+/*
+#include "budget.h"
+*/
+import "C"
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"sync"
+	"unsafe"
+
+	sqlite3 "github.com/CovenantSQL/go-sqlite3-encrypt"
+	"github.com/pkg/errors"
+
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+// claimConnFunc is the name of the sql function claiming the connection state, see budget.h.
+const claimConnFunc = "cql_claim_conn"
+
+var (
+	budgetsOnce sync.Once
+	budgetsErr  error
+	claimToken  int64
+)
+
+// initBudgets registers the connection state on each sqlite connection opened afterwards, so that
+// it could be claimed by the storage without the handle of the sqlite3 driver. The state is only
+// claimed with a random token, the connections not opened by the storages are left unclaimed.
+func initBudgets() error {
+	budgetsOnce.Do(func() {
+		var buf [8]byte
+		if _, budgetsErr = rand.Read(buf[:]); budgetsErr != nil {
+			budgetsErr = errors.Wrap(budgetsErr, "generate claim token failed")
+			return
+		}
+		// a zero token is never claimed, see claimConn
+		claimToken = int64(binary.LittleEndian.Uint64(buf[:]) | 1)
+		if rc := C.initBudgets(C.longlong(claimToken)); rc != 0 {
+			budgetsErr = errors.Errorf("register sqlite connection state failed: %d", int(rc))
+		}
+	})
+	return budgetsErr
+}
+
+// budgetsInUse returns the number of budgets referenced by the connections and the storages.
+func budgetsInUse() int {
+	return int(C.budgetsInUse())
+}
+
+// budget is the xi.StepBudget backed by a sqlite progress handler. It's allocated in C memory as
+// it's referenced by the sqlite connections, and freed in C when it's no longer referenced. The
+// budget of a reader connection also meters the memory used by the statements of the connection,
+// which is the growth of its page caches since the reset, and the length of each value.
+type budget struct {
+	b *C.stepBudget
+}
+
+func newBudget() *budget {
+	return &budget{b: C.newBudget()}
+}
+
+// Reset implements Reset method of the xenomint/interfaces.StepBudget interface.
+func (b *budget) Reset(maxSteps, maxMemory uint64) {
+	if b.b == nil {
+		// the budget is released
+		return
+	}
+	C.resetBudget(b.b, C.ulonglong(maxSteps), C.ulonglong(maxMemory))
+}
+
+// Exceeded implements Exceeded method of the xenomint/interfaces.StepBudget interface.
+func (b *budget) Exceeded() bool {
+	return b.b != nil && C.budgetExceeded(b.b) != 0
+}
+
+// MemoryExceeded implements MemoryExceeded method of the xenomint/interfaces.StepBudget interface.
+func (b *budget) MemoryExceeded() bool {
+	return b.b != nil && C.budgetExceeded(b.b) == C.budgetExceededMemory
+}
+
+// Usage implements Usage method of the xenomint/interfaces.StepBudget interface.
+func (b *budget) Usage() (scanned, steps uint64) {
+	if b.b == nil {
+		return
+	}
+	var cs, cv C.ulonglong
+	C.budgetUsage(b.b, &cs, &cv)
+	return uint64(cs), uint64(cv)
+}
+
+func (b *budget) free() {
+	if b.b != nil {
+		C.releaseBudget(b.b)
+		b.b = nil
+	}
+}
+
+// conn is the driver connection of the storage. Its sqlite handle and budget are claimed from the
+// connection state registered on connect, which is released by sqlite with the connection.
+type conn struct {
+	*sqlite3.SQLiteConn
+	state *C.connState
+}
+
+// claimConn claims the connection state of the driver connection dc, it fails if the state is
+// already claimed.
+func claimConn(dc driver.Conn) (c *conn, err error) {
+	var sc, ok = dc.(*sqlite3.SQLiteConn)
+	if !ok {
+		err = errors.Errorf("unexpected driver connection type %T", dc)
+		return
+	}
+	var rows driver.Rows
+	if rows, err = sc.Query(
+		"SELECT "+claimConnFunc+"(?)", []driver.Value{claimToken}); err != nil {
+		err = errors.Wrap(err, "claim sqlite connection failed")
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	var dest = make([]driver.Value, 1)
+	if err = rows.Next(dest); err != nil {
+		err = errors.Wrap(err, "claim sqlite connection failed")
+		return
+	}
+	var v int64
+	if v, ok = dest[0].(int64); !ok {
+		err = errors.New("sqlite connection already claimed")
+		return
+	}
+	c = &conn{SQLiteConn: sc, state: C.connStateOf(C.longlong(v))}
+	return
+}
+
+// handle returns the sqlite3 handle of the connection.
+func (c *conn) handle() unsafe.Pointer {
+	return C.connHandle(c.state)
+}
+
+// budget returns the budget of the connection, which must be freed by the caller.
+func (c *conn) budget() *budget {
+	return &budget{b: C.retainConnBudget(c.state)}
+}
+
+// shareBudget makes the connection use b instead of its own budget.
+func (c *conn) shareBudget(b *budget) {
+	C.shareBudget(c.state, b.b)
+}
+
+// WriterBudget implements WriterBudget method of the xenomint/interfaces.StepLimiter interface.
+func (s *SQLite3) WriterBudget() xi.StepBudget {
+	return s.writerBudget
+}
+
+// InstallBudget implements InstallBudget method of the xenomint/interfaces.StepLimiter interface.
+func (s *SQLite3) InstallBudget(dc interface{}) (b xi.StepBudget, err error) {
+	var c, ok = dc.(*conn)
+	if !ok {
+		err = errors.Errorf("unexpected driver connection type %T", dc)
+		return
+	}
+	var nb = c.budget()
+	nb.Reset(0, 0)
+	b = nb
+	return
+}
+
+// UninstallBudget implements UninstallBudget method of the xenomint/interfaces.StepLimiter
+// interface.
+func (s *SQLite3) UninstallBudget(dc interface{}, b xi.StepBudget) {
+	if nb, ok := b.(*budget); ok {
+		nb.Reset(0, 0)
+		nb.free()
+	}
+}
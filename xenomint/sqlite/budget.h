@@ -0,0 +1,46 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#ifndef XENOMINT_SQLITE_BUDGET_H
+#define XENOMINT_SQLITE_BUDGET_H
+
+// Reasons of the statement interrupted by a budget.
+#define budgetExceededSteps 1
+#define budgetExceededMemory 2
+
+// claimConnFunc is the name of the sql function registered on each sqlite connection, which
+// returns the connection state on its first call with the claim token only.
+#define claimConnFunc "cql_claim_conn"
+
+// stepBudget is shared by the sqlite callbacks and the Go code, and it may be shared by the
+// callbacks of several connections, so its fields are only accessed atomically by the functions
+// below.
+typedef struct stepBudget stepBudget;
+typedef struct connState connState;
+
+extern int initBudgets(long long token);
+extern stepBudget *newBudget(void);
+extern void releaseBudget(stepBudget *b);
+extern void resetBudget(stepBudget *b, unsigned long long max, unsigned long long maxMem);
+extern int budgetExceeded(stepBudget *b);
+extern void budgetUsage(stepBudget *b, unsigned long long *scanned, unsigned long long *vmSteps);
+extern int budgetsInUse(void);
+extern connState *connStateOf(long long v);
+extern void *connHandle(connState *s);
+extern stepBudget *retainConnBudget(connState *s);
+extern void shareBudget(connState *s, stepBudget *b);
+
+#endif
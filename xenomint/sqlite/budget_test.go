@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+func TestBudget(t *testing.T) {
+	Convey("Given a sqlite storage implementation", t, func() {
+		var (
+			fl     = path.Join(testingDataDir, t.Name())
+			inUse  = budgetsInUse()
+			st     *SQLite3
+			conn   *sql.Conn
+			b      xi.StepBudget
+			result sql.NullInt64
+			err    error
+		)
+		st, err = NewSqlite(fmt.Sprint("file:", fl))
+		So(err, ShouldBeNil)
+		defer func() {
+			for _, suffix := range []string{"", "-shm", "-wal"} {
+				_ = os.Remove(fl + suffix)
+			}
+		}()
+		_, err = st.Writer().Exec(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`)
+		So(err, ShouldBeNil)
+		_, err = st.Writer().Exec(`WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c
+			WHERE x < 1000) INSERT INTO t1 SELECT x, hex(zeroblob(1000)) FROM c`)
+		So(err, ShouldBeNil)
+
+		for _, db := range []*sql.DB{st.Reader(), st.DirtyReader()} {
+			conn, err = db.Conn(context.Background())
+			So(err, ShouldBeNil)
+			err = conn.Raw(func(dc interface{}) (err error) {
+				b, err = st.InstallBudget(dc)
+				return
+			})
+			So(err, ShouldBeNil)
+			So(b, ShouldNotBeNil)
+
+			// the connection state is only claimed by the storage
+			for _, token := range []int64{0, claimToken} {
+				err = conn.QueryRowContext(context.Background(),
+					"SELECT "+claimConnFunc+"(?)", token).Scan(&result)
+				So(err, ShouldBeNil)
+				So(result.Valid, ShouldBeFalse)
+			}
+
+			b.Reset(1000, 0)
+			err = conn.QueryRowContext(context.Background(),
+				`WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT count(x) FROM c`,
+			).Scan(&result)
+			So(err, ShouldNotBeNil)
+			So(b.Exceeded(), ShouldBeTrue)
+			So(b.MemoryExceeded(), ShouldBeFalse)
+			b.Reset(0, 1<<20)
+			err = conn.QueryRowContext(context.Background(),
+				`WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x < 1000)
+				SELECT length(group_concat(hex(zeroblob(2000)))) FROM c`,
+			).Scan(&result)
+			So(err, ShouldNotBeNil)
+			So(b.MemoryExceeded(), ShouldBeTrue)
+			b.Reset(0, 1<<20)
+			err = conn.QueryRowContext(context.Background(),
+				`WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x < 100000)
+				SELECT count(*) FROM (SELECT hex(zeroblob(100)) || x FROM c ORDER BY 1)`,
+			).Scan(&result)
+			So(err, ShouldBeNil)
+			So(b.MemoryExceeded(), ShouldBeFalse)
+			if db == st.Reader() {
+				// the pages read into the cache are metered, the dirty reader shares the cache
+				// with the writer which holds the pages already
+				b.Reset(0, 1<<18)
+				err = conn.QueryRowContext(context.Background(),
+					`SELECT count(v) FROM t1`).Scan(&result)
+				So(err, ShouldNotBeNil)
+				So(b.MemoryExceeded(), ShouldBeTrue)
+			}
+			err = conn.Raw(func(dc interface{}) error {
+				st.UninstallBudget(dc, b)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(b.Exceeded(), ShouldBeFalse)
+			err = conn.Close()
+			So(err, ShouldBeNil)
+		}
+		So(budgetsInUse(), ShouldBeGreaterThan, inUse)
+
+		// the budgets are released with the connections
+		err = st.Close()
+		So(err, ShouldBeNil)
+		So(budgetsInUse(), ShouldEqual, inUse)
+	})
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlite
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"time"
+
+	sqlite3 "github.com/CovenantSQL/go-sqlite3-encrypt"
+
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+const (
+	// NowFunc is the name of the sql function returning the current time of the deterministic
+	// source, which replaces the 'now' time value.
+	NowFunc = "cql_now"
+	// RandomFunc is the name of the sql function returning a random integer from the
+	// deterministic source, which replaces random().
+	RandomFunc = "cql_random"
+	// RandomBlobFunc is the name of the sql function returning a random blob from the
+	// deterministic source, which replaces randomblob(N).
+	RandomBlobFunc = "cql_randomblob"
+
+	// nowFormat is the time value format returned by NowFunc, which is accepted by all the
+	// sqlite date and time functions.
+	nowFormat = "2006-01-02 15:04:05.000"
+)
+
+// systemSource is the xi.DeterministicSource backed by the system clock and random generator.
+type systemSource struct{}
+
+func (systemSource) Now() time.Time { return time.Now().UTC() }
+func (systemSource) Uint64() uint64 { return rand.Uint64() }
+
+// regDeterministicFunc registers the deterministic functions to c, which evaluate with the source
+// returned by src.
+func regDeterministicFunc(c *sqlite3.SQLiteConn, src func() xi.DeterministicSource) (err error) {
+	nowFunc := func() string {
+		return src().Now().UTC().Format(nowFormat)
+	}
+
+	randomFunc := func() int64 {
+		return int64(src().Uint64())
+	}
+
+	randomBlobFunc := func(n int64) []byte {
+		// same as randomblob(N) of sqlite: N less than 1 is treated as 1
+		if n < 1 {
+			n = 1
+		}
+		var (
+			s   = src()
+			out = make([]byte, (n+7)/8*8)
+		)
+		for i := 0; i < len(out); i += 8 {
+			binary.BigEndian.PutUint64(out[i:], s.Uint64())
+		}
+		return out[:n]
+	}
+
+	if err = c.RegisterFunc(NowFunc, nowFunc, false); err != nil {
+		return
+	}
+	if err = c.RegisterFunc(RandomFunc, randomFunc, false); err != nil {
+		return
+	}
+	if err = c.RegisterFunc(RandomBlobFunc, randomBlobFunc, false); err != nil {
+		return
+	}
+	return
+}
+
+func systemSourceFunc() xi.DeterministicSource {
+	return systemSource{}
+}
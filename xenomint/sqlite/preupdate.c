@@ -0,0 +1,62 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+#include <stddef.h>
+#include "_cgo_export.h"
+
+// The preupdate hook functions are only compiled in the sqlite library of the sqlite3 driver
+// package with SQLITE_ENABLE_PREUPDATE_HOOK, they are weakly referenced so that they are NULL
+// otherwise.
+extern void *sqlite3_preupdate_hook(sqlite3 *,
+	void (*)(void *, sqlite3 *, int, const char *, const char *, sqlite3_int64, sqlite3_int64),
+	void *) __attribute__((weak));
+extern int sqlite3_preupdate_old(sqlite3 *, int, sqlite3_value **) __attribute__((weak));
+extern int sqlite3_preupdate_new(sqlite3 *, int, sqlite3_value **) __attribute__((weak));
+extern int sqlite3_preupdate_count(sqlite3 *) __attribute__((weak));
+
+static void preupdateCallback(void *arg, sqlite3 *db, int op, const char *zDb, const char *zTbl,
+	sqlite3_int64 iKey1, sqlite3_int64 iKey2)
+{
+	goPreupdate((uintptr_t)arg, db, op, (char *)zDb, (char *)zTbl, iKey1, iKey2);
+}
+
+int preupdateSupported(void) {
+	return sqlite3_preupdate_hook != NULL && sqlite3_preupdate_old != NULL &&
+		sqlite3_preupdate_new != NULL && sqlite3_preupdate_count != NULL;
+}
+
+void installPreupdateHook(void *db, uintptr_t id) {
+	if (!preupdateSupported()) {
+		return;
+	}
+	if (id == 0) {
+		sqlite3_preupdate_hook((sqlite3 *)db, NULL, NULL);
+		return;
+	}
+	sqlite3_preupdate_hook((sqlite3 *)db, preupdateCallback, (void *)id);
+}
+
+int preupdateCount(sqlite3 *db) {
+	return sqlite3_preupdate_count(db);
+}
+
+sqlite3_value *preupdateValue(sqlite3 *db, int old, int i) {
+	sqlite3_value *v = NULL;
+	if ((old ? sqlite3_preupdate_old(db, i, &v) : sqlite3_preupdate_new(db, i, &v)) != 0) {
+		return NULL;
+	}
+	return v;
+}
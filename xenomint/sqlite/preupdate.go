@@ -0,0 +1,164 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlite
+
+/*
+#include <stdint.h>
+
+typedef struct sqlite3 sqlite3;
+typedef struct sqlite3_value sqlite3_value;
+typedef long long sqlite3_int64;
+
+extern int sqlite3_value_type(sqlite3_value *);
+extern sqlite3_int64 sqlite3_value_int64(sqlite3_value *);
+extern double sqlite3_value_double(sqlite3_value *);
+extern const void *sqlite3_value_blob(sqlite3_value *);
+extern const unsigned char *sqlite3_value_text(sqlite3_value *);
+extern int sqlite3_value_bytes(sqlite3_value *);
+
+extern int preupdateSupported(void);
+extern void installPreupdateHook(void *db, uintptr_t id);
+extern int preupdateCount(sqlite3 *db);
+extern sqlite3_value *preupdateValue(sqlite3 *db, int old, int i);
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+
+	sqlite3 "github.com/CovenantSQL/go-sqlite3-encrypt"
+	"github.com/pkg/errors"
+
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+// Fundamental datatypes of sqlite values.
+const (
+	sqliteInteger = 1
+	sqliteFloat   = 2
+	sqliteText    = 3
+	sqliteBlob    = 4
+)
+
+var (
+	// ErrPreupdateHookNotSupported indicates that the sqlite library is not compiled with
+	// SQLITE_ENABLE_PREUPDATE_HOOK, which is required to report the row changes with values.
+	ErrPreupdateHookNotSupported = errors.New(
+		"sqlite preupdate hook not supported, build with CGO_CFLAGS=-DSQLITE_ENABLE_PREUPDATE_HOOK")
+
+	// hookedStorages maps the ids passed to the preupdate hooks to the storages, as the Go pointers
+	// couldn't be referenced by C.
+	hookedStorages = struct {
+		sync.RWMutex
+		m    map[uintptr]*SQLite3
+		next uintptr
+	}{m: make(map[uintptr]*SQLite3)}
+)
+
+// PreupdateHookSupported reports whether the row changes could be reported by the preupdate
+// hook of the sqlite library.
+func PreupdateHookSupported() bool {
+	return C.preupdateSupported() != 0
+}
+
+func registerHookedStorage(s *SQLite3) (id uintptr) {
+	hookedStorages.Lock()
+	defer hookedStorages.Unlock()
+	hookedStorages.next++
+	id = hookedStorages.next
+	hookedStorages.m[id] = s
+	return
+}
+
+func unregisterHookedStorage(id uintptr) {
+	hookedStorages.Lock()
+	defer hookedStorages.Unlock()
+	delete(hookedStorages.m, id)
+}
+
+// installPreupdateHook reports the row changes of the driver connection dc to the storage
+// registered with id, or uninstalls the hook if id is 0.
+func installPreupdateHook(dc *conn, id uintptr) (err error) {
+	if !PreupdateHookSupported() {
+		return
+	}
+	C.installPreupdateHook(dc.handle(), C.uintptr_t(id))
+	return
+}
+
+//export goPreupdate
+func goPreupdate(
+	id C.uintptr_t, db *C.sqlite3, op C.int, zDb, zTbl *C.char, iKey1, iKey2 C.sqlite3_int64,
+) {
+	hookedStorages.RLock()
+	var s = hookedStorages.m[uintptr(id)]
+	hookedStorages.RUnlock()
+	if s == nil {
+		return
+	}
+	var u = &xi.RowUpdate{
+		Database: C.GoString(zDb),
+		Table:    C.GoString(zTbl),
+		RowID:    int64(iKey2),
+	}
+	switch int(op) {
+	case sqlite3.SQLITE_INSERT:
+		u.Op = xi.UpdateInsert
+		u.New = preupdateValues(db, false)
+	case sqlite3.SQLITE_UPDATE:
+		u.Op = xi.UpdateUpdate
+		u.Old = preupdateValues(db, true)
+		u.New = preupdateValues(db, false)
+	case sqlite3.SQLITE_DELETE:
+		u.Op = xi.UpdateDelete
+		u.RowID = int64(iKey1)
+		u.Old = preupdateValues(db, true)
+	default:
+		return
+	}
+	s.onUpdate(u)
+}
+
+// preupdateValues returns the column values of the row changed before or after the change, it
+// must be called in the preupdate hook.
+func preupdateValues(db *C.sqlite3, old bool) (values []interface{}) {
+	var o C.int
+	if old {
+		o = 1
+	}
+	values = make([]interface{}, int(C.preupdateCount(db)))
+	for i := range values {
+		var v = C.preupdateValue(db, o, C.int(i))
+		if v == nil {
+			continue
+		}
+		switch C.sqlite3_value_type(v) {
+		case sqliteInteger:
+			values[i] = int64(C.sqlite3_value_int64(v))
+		case sqliteFloat:
+			values[i] = float64(C.sqlite3_value_double(v))
+		case sqliteText:
+			var p = unsafe.Pointer(C.sqlite3_value_text(v))
+			values[i] = C.GoStringN((*C.char)(p), C.sqlite3_value_bytes(v))
+		case sqliteBlob:
+			var p = C.sqlite3_value_blob(v)
+			values[i] = C.GoBytes(p, C.sqlite3_value_bytes(v))
+		}
+	}
+	return
+}
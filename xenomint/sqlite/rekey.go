@@ -0,0 +1,195 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlite
+
+import (
+	"os"
+
+	sqlite3 "github.com/CovenantSQL/go-sqlite3-encrypt"
+	"github.com/pkg/errors"
+
+	"github.com/CovenantSQL/CovenantSQL/storage"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+// RekeyFileSuffix defines the file name suffix of the database file re-encrypted by a Rekeyer.
+const RekeyFileSuffix = ".rekey"
+
+// Rekeyer re-encrypts a SQLite3 database with a new key online.
+//
+// The database pages are copied into a new file encrypted with the new key by the online backup
+// API step by step, which restarts automatically if the source database is modified during the
+// copy. Once the copy is done, Switch finishes the last pages and replaces the database file with
+// the new one, it should be called while the database is not being modified.
+type Rekeyer struct {
+	src, dst   *storage.DSN
+	srcConn    *sqlite3.SQLiteConn
+	dstConn    *sqlite3.SQLiteConn
+	backup     *sqlite3.SQLiteBackup
+	copied     int
+	total      int
+	isFinished bool
+}
+
+// NewRekeyer returns a new Rekeyer to re-encrypt the database attached to filename with key, the
+// database file is decrypted with the key specified in filename if any.
+func NewRekeyer(filename, key string) (r *Rekeyer, err error) {
+	var (
+		drv  = &sqlite3.SQLiteDriver{}
+		conn interface{}
+	)
+	r = &Rekeyer{}
+	if r.src, err = storage.NewDSN(filename); err != nil {
+		return
+	}
+	r.dst = r.src.Clone()
+	r.dst.SetFileName(r.src.GetFileName() + RekeyFileSuffix)
+	r.dst.AddParam("_crypto_key", key)
+	// Remove any stale file left by an interrupted rekey
+	if err = removeFiles(r.dst.GetFileName()); err != nil {
+		return
+	}
+
+	var srcDSN = r.src.Clone()
+	srcDSN.AddParam("_journal_mode", "WAL")
+	srcDSN.AddParam("_query_only", "on")
+	if conn, err = drv.Open(srcDSN.Format()); err != nil {
+		err = errors.Wrap(err, "open source database")
+		return
+	}
+	r.srcConn = conn.(*sqlite3.SQLiteConn)
+	if conn, err = drv.Open(r.dst.Format()); err != nil {
+		err = errors.Wrap(err, "open target database")
+		_ = r.srcConn.Close()
+		return
+	}
+	r.dstConn = conn.(*sqlite3.SQLiteConn)
+	if r.backup, err = r.dstConn.Backup("main", r.srcConn, "main"); err != nil {
+		err = errors.Wrap(err, "init backup")
+		_ = r.Close()
+		return
+	}
+	return
+}
+
+// Step copies up to n pages of the database, or all the remaining pages if n is negative. It
+// returns true if all the pages are copied.
+func (r *Rekeyer) Step(n int) (done bool, err error) {
+	if done, err = r.backup.Step(n); err != nil {
+		err = errors.Wrap(err, "copy database pages")
+		return
+	}
+	r.total = r.backup.PageCount()
+	r.copied = r.total - r.backup.Remaining()
+	return
+}
+
+// Progress returns the count of copied pages and the total page count of the database, which are
+// updated by each Step.
+func (r *Rekeyer) Progress() (copied, total int) {
+	return r.copied, r.total
+}
+
+// Switch copies the remaining pages and switches the old storage to the re-encrypted database. The
+// old storage is kept usable if it returns any error before the old storage is closed.
+func (r *Rekeyer) Switch(old xi.Storage) (s xi.Storage, err error) {
+	var done bool
+	if done, err = r.Step(-1); err != nil {
+		return
+	}
+	if !done {
+		err = errors.New("database pages are not fully copied")
+		return
+	}
+	if err = r.finish(); err != nil {
+		return
+	}
+	if err = old.Close(); err != nil {
+		err = errors.Wrap(err, "close old storage")
+		return
+	}
+	// The WAL of the old database file is copied and encrypted with the old key, remove it before
+	// the new database file takes place
+	var filename = r.src.GetFileName()
+	if err = removeJournals(filename); err != nil {
+		return
+	}
+	if err = os.Rename(r.dst.GetFileName(), filename); err != nil {
+		err = errors.Wrap(err, "replace database file")
+		return
+	}
+	var dsn = r.dst.Clone()
+	dsn.SetFileName(filename)
+	return NewSqlite(dsn.Format())
+}
+
+func (r *Rekeyer) finish() (err error) {
+	if r.isFinished {
+		return
+	}
+	r.isFinished = true
+	if r.backup != nil {
+		if err = r.backup.Finish(); err != nil {
+			err = errors.Wrap(err, "finish backup")
+		}
+	}
+	if r.dstConn != nil {
+		if ierr := r.dstConn.Close(); ierr != nil && err == nil {
+			err = errors.Wrap(ierr, "close target database")
+		}
+	}
+	if r.srcConn != nil {
+		if ierr := r.srcConn.Close(); ierr != nil && err == nil {
+			err = errors.Wrap(ierr, "close source database")
+		}
+	}
+	return
+}
+
+// Close aborts the rekey if it's not switched, and removes the re-encrypted database file.
+func (r *Rekeyer) Close() (err error) {
+	var filename = r.dst.GetFileName()
+	if _, ierr := os.Stat(filename); ierr != nil {
+		// Already switched
+		return r.finish()
+	}
+	if err = r.finish(); err != nil {
+		return
+	}
+	return removeFiles(filename)
+}
+
+// removeJournals removes the journal files of the database file.
+func removeJournals(filename string) (err error) {
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err = os.Remove(filename + suffix); err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+	return nil
+}
+
+// removeFiles removes the database file and its journal files.
+func removeFiles(filename string) (err error) {
+	if err = removeJournals(filename); err != nil {
+		return
+	}
+	if err = os.Remove(filename); err != nil && os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sqlite
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+func TestRekeyer(t *testing.T) {
+	Convey("Given an encrypted sqlite storage", t, func() {
+		const rows = 1000
+		var (
+			fl    = path.Join(testingDataDir, t.Name())
+			st    xi.Storage
+			r     *Rekeyer
+			count int
+			err   error
+		)
+		st, err = NewSqlite(fmt.Sprint("file:", fl, "?_crypto_key=old"))
+		So(err, ShouldBeNil)
+		Reset(func() {
+			err = st.Close()
+			So(err, ShouldBeNil)
+			err = removeFiles(fl)
+			So(err, ShouldBeNil)
+		})
+		_, err = st.Writer().Exec(`CREATE TABLE "t1" ("k" INT, "v" TEXT, PRIMARY KEY("k"))`)
+		So(err, ShouldBeNil)
+		for i := 0; i < rows; i++ {
+			_, err = st.Writer().Exec(`INSERT INTO "t1" VALUES (?, ?)`, i, fmt.Sprintf("v%d", i))
+			So(err, ShouldBeNil)
+		}
+		r, err = NewRekeyer(fmt.Sprint("file:", fl, "?_crypto_key=old"), "new")
+		So(err, ShouldBeNil)
+
+		Convey("The storage should be re-encrypted with the new key", func() {
+			var done bool
+			done, err = r.Step(1)
+			So(err, ShouldBeNil)
+			So(done, ShouldBeFalse)
+			copied, total := r.Progress()
+			So(copied, ShouldEqual, 1)
+			So(total, ShouldBeGreaterThan, 1)
+			// Modify the database during copy
+			_, err = st.Writer().Exec(`INSERT INTO "t1" VALUES (?, ?)`, rows, "last")
+			So(err, ShouldBeNil)
+			done, err = r.Step(1)
+			So(err, ShouldBeNil)
+			So(done, ShouldBeFalse)
+
+			st, err = r.Switch(st)
+			So(err, ShouldBeNil)
+			err = r.Close()
+			So(err, ShouldBeNil)
+			err = st.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, rows+1)
+			_, err = os.Stat(fl + RekeyFileSuffix)
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			// The old key should not work any more
+			var old *SQLite3
+			old, err = NewSqlite(fmt.Sprint("file:", fl, "?_crypto_key=old"))
+			So(err, ShouldBeNil)
+			err = old.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+			So(err, ShouldNotBeNil)
+			err = old.Close()
+			So(err, ShouldBeNil)
+		})
+		Convey("The storage should be kept on abort", func() {
+			_, err = r.Step(1)
+			So(err, ShouldBeNil)
+			err = r.Close()
+			So(err, ShouldBeNil)
+			_, err = os.Stat(fl + RekeyFileSuffix)
+			So(os.IsNotExist(err), ShouldBeTrue)
+			err = st.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, rows)
+		})
+	})
+}
+
+func TestBackup(t *testing.T) {
+	Convey("Given an encrypted sqlite storage", t, func() {
+		const rows = 100
+		var (
+			fl    = path.Join(testingDataDir, t.Name())
+			dst   = fl + ".backup"
+			st    xi.Storage
+			count int
+			err   error
+		)
+		st, err = NewSqlite(fmt.Sprint("file:", fl, "?_crypto_key=src"))
+		So(err, ShouldBeNil)
+		Reset(func() {
+			err = st.Close()
+			So(err, ShouldBeNil)
+			err = removeFiles(fl)
+			So(err, ShouldBeNil)
+			err = removeFiles(dst)
+			So(err, ShouldBeNil)
+		})
+		_, err = st.Writer().Exec(`CREATE TABLE "t1" ("k" INT, "v" TEXT, PRIMARY KEY("k"))`)
+		So(err, ShouldBeNil)
+		for i := 0; i < rows; i++ {
+			_, err = st.Writer().Exec(`INSERT INTO "t1" VALUES (?, ?)`, i, fmt.Sprintf("v%d", i))
+			So(err, ShouldBeNil)
+		}
+
+		Convey("The backup should be encrypted with the target key", func() {
+			err = Backup(fmt.Sprint("file:", fl, "?_crypto_key=src"), fmt.Sprint("file:", dst))
+			So(err, ShouldBeNil)
+			var plain *SQLite3
+			plain, err = NewSqlite(fmt.Sprint("file:", dst))
+			So(err, ShouldBeNil)
+			err = plain.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, rows)
+			err = plain.Close()
+			So(err, ShouldBeNil)
+
+			err = Backup(fmt.Sprint("file:", dst), fmt.Sprint("file:", dst, ".enc?_crypto_key=dst"))
+			So(err, ShouldBeNil)
+			defer func() { _ = removeFiles(dst + ".enc") }()
+			var enc *SQLite3
+			enc, err = NewSqlite(fmt.Sprint("file:", dst, ".enc?_crypto_key=dst"))
+			So(err, ShouldBeNil)
+			err = enc.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, rows)
+			err = enc.Close()
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestExport(t *testing.T) {
+	Convey("Given a plaintext sqlite storage", t, func() {
+		const rows = 100
+		var (
+			fl    = path.Join(testingDataDir, t.Name())
+			dst   = fl + ".export"
+			st    xi.Storage
+			count int
+			err   error
+		)
+		st, err = NewSqlite(fmt.Sprint("file:", fl))
+		So(err, ShouldBeNil)
+		Reset(func() {
+			err = st.Close()
+			So(err, ShouldBeNil)
+			err = removeFiles(fl)
+			So(err, ShouldBeNil)
+			err = removeFiles(dst)
+			So(err, ShouldBeNil)
+		})
+		_, err = st.Writer().Exec(`CREATE TABLE "t1" ("k" INT, "v" TEXT, PRIMARY KEY("k"))`)
+		So(err, ShouldBeNil)
+		for i := 0; i < rows; i++ {
+			_, err = st.Writer().Exec(`INSERT INTO "t1" VALUES (?, ?)`, i, fmt.Sprintf("v%d", i))
+			So(err, ShouldBeNil)
+		}
+
+		Convey("The export should be encrypted with the target key", func() {
+			err = Export(fmt.Sprint("file:", fl), fmt.Sprint("file:", dst, "?_crypto_key=dst"))
+			So(err, ShouldBeNil)
+			var enc *SQLite3
+			enc, err = NewSqlite(fmt.Sprint("file:", dst, "?_crypto_key=dst"))
+			So(err, ShouldBeNil)
+			err = enc.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, rows)
+			err = enc.Close()
+			So(err, ShouldBeNil)
+
+			var plain *SQLite3
+			plain, err = NewSqlite(fmt.Sprint("file:", dst))
+			So(err, ShouldBeNil)
+			err = plain.Reader().QueryRow(`SELECT COUNT(1) FROM "t1"`).Scan(&count)
+			So(err, ShouldNotBeNil)
+			err = plain.Close()
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -17,7 +17,10 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"sync"
 	"time"
 
 	sqlite3 "github.com/CovenantSQL/go-sqlite3-encrypt"
@@ -25,6 +28,7 @@ import (
 	"github.com/CovenantSQL/CovenantSQL/crypto/symmetric"
 	"github.com/CovenantSQL/CovenantSQL/storage"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
 )
 
 const (
@@ -32,7 +36,7 @@ const (
 	dirtyReadDriver    = "sqlite3-dirty-reader"
 )
 
-func init() {
+func regCustomFunc(c *sqlite3.SQLiteConn) (err error) {
 	encryptFunc := func(in, pass, salt []byte) (out []byte, err error) {
 		out, err = symmetric.EncryptWithPassword(in, pass, salt)
 		return
@@ -50,20 +54,21 @@ func init() {
 		return t
 	}
 
-	regCustomFunc := func(c *sqlite3.SQLiteConn) (err error) {
-		if err = c.RegisterFunc("sleep", sleepFunc, true); err != nil {
-			return
-		}
-		if err = c.RegisterFunc("encrypt", encryptFunc, true); err != nil {
-			return
-		}
-		if err = c.RegisterFunc("decrypt", decryptFunc, true); err != nil {
-			return
-		}
+	if err = c.RegisterFunc("sleep", sleepFunc, true); err != nil {
 		return
 	}
+	if err = c.RegisterFunc("encrypt", encryptFunc, true); err != nil {
+		return
+	}
+	if err = c.RegisterFunc("decrypt", decryptFunc, true); err != nil {
+		return
+	}
+	return
+}
 
-	sql.Register(dirtyReadDriver, &sqlite3.SQLiteDriver{
+var (
+	// dirtyReadDrv opens the connections of the dirty reader.
+	dirtyReadDrv = &sqlite3.SQLiteDriver{
 		ConnectHook: func(c *sqlite3.SQLiteConn) (err error) {
 			if _, err = c.Exec("PRAGMA read_uncommitted=1", nil); err != nil {
 				return
@@ -71,17 +76,58 @@ func init() {
 			if err = regCustomFunc(c); err != nil {
 				return
 			}
+			if err = regDeterministicFunc(c, systemSourceFunc); err != nil {
+				return
+			}
 			return
 		},
-	})
-	sql.Register(serializableDriver, &sqlite3.SQLiteDriver{
+	}
+	// serializableDrv opens the connections of the reader.
+	serializableDrv = &sqlite3.SQLiteDriver{
 		ConnectHook: func(c *sqlite3.SQLiteConn) (err error) {
 			if err = regCustomFunc(c); err != nil {
 				return
 			}
+			if err = regDeterministicFunc(c, systemSourceFunc); err != nil {
+				return
+			}
 			return
 		},
-	})
+	}
+)
+
+func init() {
+	sql.Register(dirtyReadDriver, dirtyReadDrv)
+	sql.Register(serializableDriver, serializableDrv)
+}
+
+// connector opens connections of a sqlite3 driver with a specified dsn, and claims the connection
+// state of each connection.
+type connector struct {
+	dsn       string
+	drv       driver.Driver
+	onConnect func(c *conn) error // optional
+}
+
+// Connect implements driver.Connector.Connect.
+func (c *connector) Connect(context.Context) (dc driver.Conn, err error) {
+	if dc, err = c.drv.Open(c.dsn); err != nil {
+		return
+	}
+	var cc *conn
+	if cc, err = claimConn(dc); err == nil && c.onConnect != nil {
+		err = c.onConnect(cc)
+	}
+	if err != nil {
+		_ = dc.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// Driver implements driver.Connector.Driver.
+func (c *connector) Driver() driver.Driver {
+	return c.drv
 }
 
 // SQLite3 is the sqlite3 implementation of the xenomint/interfaces.Storage interface.
@@ -90,6 +136,15 @@ type SQLite3 struct {
 	dirtyReader *sql.DB
 	reader      *sql.DB
 	writer      *sql.DB
+
+	hookLock   sync.RWMutex
+	hookID     uintptr // id of the storage in the preupdate hooks of the writer connections
+	updateHook xi.UpdateHook
+
+	srcLock sync.RWMutex
+	src     xi.DeterministicSource
+
+	writerBudget *budget
 }
 
 // NewSqlite returns a new SQLite3 instance attached to filename.
@@ -102,6 +157,11 @@ func NewSqlite(filename string) (s *SQLite3, err error) {
 		dsn       *storage.DSN
 	)
 
+	if err = initBudgets(); err != nil {
+		return
+	}
+	instance.writerBudget = newBudget()
+
 	if dsn, err = storage.NewDSN(filename); err != nil {
 		return
 	}
@@ -122,15 +182,29 @@ func NewSqlite(filename string) (s *SQLite3, err error) {
 	dsnSHMRW.AddParam("cache", "shared")
 	shmRWDSN = dsnSHMRW.Format()
 
-	if instance.dirtyReader, err = sql.Open(dirtyReadDriver, shmRODSN); err != nil {
-		return
-	}
-	if instance.reader, err = sql.Open(serializableDriver, privRODSN); err != nil {
-		return
-	}
-	if instance.writer, err = sql.Open(serializableDriver, shmRWDSN); err != nil {
-		return
-	}
+	instance.dirtyReader = sql.OpenDB(&connector{dsn: shmRODSN, drv: dirtyReadDrv})
+	instance.reader = sql.OpenDB(&connector{dsn: privRODSN, drv: serializableDrv})
+	// NOTE(leventeliu): the writer connections are opened by a dedicated driver instance, so that
+	// the row changes are reported to the update hook of this storage only.
+	instance.hookID = registerHookedStorage(instance)
+	instance.writer = sql.OpenDB(&connector{
+		dsn: shmRWDSN,
+		drv: &sqlite3.SQLiteDriver{
+			ConnectHook: func(c *sqlite3.SQLiteConn) (err error) {
+				if err = regCustomFunc(c); err != nil {
+					return
+				}
+				return regDeterministicFunc(c, instance.source)
+			},
+		},
+		onConnect: func(c *conn) (err error) {
+			if err = installPreupdateHook(c, instance.hookID); err != nil {
+				return
+			}
+			c.shareBudget(instance.writerBudget)
+			return
+		},
+	})
 	s = instance
 	return
 }
@@ -150,6 +224,44 @@ func (s *SQLite3) Writer() *sql.DB {
 	return s.writer
 }
 
+// SetUpdateHook implements SetUpdateHook method of the xenomint/interfaces.UpdateNotifier interface.
+// The row changes are reported by the preupdate hook of sqlite, see PreupdateHookSupported.
+func (s *SQLite3) SetUpdateHook(hook xi.UpdateHook) (err error) {
+	if !PreupdateHookSupported() {
+		return ErrPreupdateHookNotSupported
+	}
+	s.hookLock.Lock()
+	defer s.hookLock.Unlock()
+	s.updateHook = hook
+	return
+}
+
+// SetDeterministicSource implements SetDeterministicSource method of the
+// xenomint/interfaces.Deterministic interface.
+func (s *SQLite3) SetDeterministicSource(src xi.DeterministicSource) {
+	s.srcLock.Lock()
+	defer s.srcLock.Unlock()
+	s.src = src
+}
+
+func (s *SQLite3) source() xi.DeterministicSource {
+	s.srcLock.RLock()
+	defer s.srcLock.RUnlock()
+	if s.src == nil {
+		return systemSource{}
+	}
+	return s.src
+}
+
+func (s *SQLite3) onUpdate(u *xi.RowUpdate) {
+	s.hookLock.RLock()
+	var hook = s.updateHook
+	s.hookLock.RUnlock()
+	if hook != nil {
+		hook(u)
+	}
+}
+
 // Close implements Close method of the xenomint/interfaces.Storage interface.
 func (s *SQLite3) Close() (err error) {
 	if err = s.dirtyReader.Close(); err != nil {
@@ -161,5 +273,19 @@ func (s *SQLite3) Close() (err error) {
 	if err = s.writer.Close(); err != nil {
 		return
 	}
+	unregisterHookedStorage(s.hookID)
+	s.writerBudget.free()
+	return
+}
+
+// CompileOptionUsed reports whether the sqlite library is compiled with the option, e.g.,
+// "ENABLE_FTS5".
+func CompileOptionUsed(option string) (used bool, err error) {
+	var db *sql.DB
+	if db, err = sql.Open(serializableDriver, ":memory:"); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	err = db.QueryRow(`SELECT sqlite_compileoption_used(?)`, option).Scan(&used)
 	return
 }
@@ -25,6 +25,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/CovenantSQL/CovenantSQL/crypto/hash"
 	"github.com/CovenantSQL/CovenantSQL/proto"
 	"github.com/CovenantSQL/CovenantSQL/types"
 	"github.com/CovenantSQL/CovenantSQL/utils/log"
@@ -56,16 +57,29 @@ type State struct {
 	level sql.IsolationLevel
 
 	sync.RWMutex
-	strg   xi.Storage
-	pool   *pool
-	closed bool
-	nodeID proto.NodeID
+	// strgLock pauses the reads, which are not guarded by the State lock, while the underlying
+	// storage is switching. It must be acquired before the State lock.
+	strgLock sync.RWMutex
+	strg     xi.Storage
+	pool     *pool
+	closed   bool
+	nodeID   proto.NodeID
 
 	handler         sqlHandler
+	readStmts       *stmtCache
+	writeStmts      *stmtCache
+	capture         *changeCapture
+	digest          *stateDigest
+	src             *querySource // deterministic source of the ongoing write request
+	probes          []*recordProbe
 	maxTx           uint64
 	lastCommitPoint uint64
 	current         uint64 // current is the current lastSeq of the current transaction
 	hasSchemaChange uint32 // indicates schema change happens in this uncommitted transaction
+	ext             uint32 // allowed sqlite extensions, see types.SQLiteExtension
+	maxQueryTime    int64  // max execution time of a read request, 0 for unlimited
+	limits          atomic.Value
+	violations      [len(limitNames)]uint64
 }
 
 // NewState returns a new State bound to strg.
@@ -76,11 +90,229 @@ func NewState(level sql.IsolationLevel, nodeID proto.NodeID, strg xi.Storage) (s
 		strg:   strg,
 		pool:   newPool(),
 		maxTx:  100,
+		ext:    uint32(types.UnrestrictedExtensions),
 	}
 	s.openHandler()
+	s.readStmts = newStmtCache(s.reader(), DefaultStmtCacheSize)
+	if s.level == sql.LevelReadUncommitted {
+		// NOTE(leventeliu): statements are only cached for the transactional write handler, the
+		// non-transactional one may dispatch a cached statement to another connection other than
+		// the one holding the ongoing transaction.
+		s.writeStmts = newStmtCache(s.strg.Writer(), DefaultStmtCacheSize)
+	}
+	return
+}
+
+// SetExtensions sets the sqlite extensions allowed in the queries, the extensions are unrestricted
+// by default. It reports an error if any of them is not supported by the local sqlite library, so
+// that all the replicas of a database support the same extensions.
+func (s *State) SetExtensions(ext types.SQLiteExtension) (err error) {
+	if err = checkExtensions(ext); err != nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	atomic.StoreUint32(&s.ext, uint32(ext))
+	// Statements are sanitized with the previous extensions
+	s.readStmts.purge()
+	s.writeStmts.purge()
 	return
 }
 
+func (s *State) extensions() types.SQLiteExtension {
+	return types.SQLiteExtension(atomic.LoadUint32(&s.ext))
+}
+
+// SetMaxQueryTime sets the max execution time of the read requests, a request is interrupted if
+// it's not finished in time or before its own deadline, 0 means unlimited. The write requests are
+// never interrupted once they are logged, otherwise the replicas may diverge.
+func (s *State) SetMaxQueryTime(d time.Duration) {
+	atomic.StoreInt64(&s.maxQueryTime, int64(d))
+}
+
+// queryContext returns the context to execute the statements of req, which is canceled on the
+// deadline of the request or the max execution time, whichever comes first. The running statement
+// is interrupted by sqlite once the context is canceled.
+func (s *State) queryContext(
+	ctx context.Context, req *types.Request) (context.Context, context.CancelFunc,
+) {
+	var deadline = req.Header.Deadline
+	if d := time.Duration(atomic.LoadInt64(&s.maxQueryTime)); d > 0 {
+		if max := time.Now().Add(d); deadline.IsZero() || max.Before(deadline) {
+			deadline = max
+		}
+	}
+	if deadline.IsZero() {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// EnableChangeCapture enables the row change capture of write queries, the captured changes are
+// delivered to sink once the write requests are committed to the storage.
+func (s *State) EnableChangeCapture(sink ChangeSink) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	var notifier, ok = s.strg.(xi.UpdateNotifier)
+	if !ok {
+		err = ErrChangeCaptureNotSupported
+		return
+	}
+	if err = notifier.SetUpdateHook(s.onUpdate); err != nil {
+		err = errors.Wrap(ErrChangeCaptureNotSupported, err.Error())
+		return
+	}
+	s.capture = newChangeCapture(sink)
+	return
+}
+
+// EnableStateDigest enables the digest of the row changes made by each write request, which is
+// set to the Digest of its QueryTracker.
+func (s *State) EnableStateDigest() (err error) {
+	s.Lock()
+	defer s.Unlock()
+	var notifier, ok = s.strg.(xi.UpdateNotifier)
+	if !ok {
+		err = ErrStateDigestNotSupported
+		return
+	}
+	if err = notifier.SetUpdateHook(s.onUpdate); err != nil {
+		err = errors.Wrap(ErrStateDigestNotSupported, err.Error())
+		return
+	}
+	s.digest = newStateDigest()
+	return
+}
+
+// onUpdate dispatches the row changes reported by the storage.
+func (s *State) onUpdate(u *xi.RowUpdate) {
+	if s.capture != nil {
+		s.capture.onUpdate(u)
+	}
+	if s.digest != nil {
+		s.digest.onUpdate(u)
+	}
+}
+
+// SwitchStorage switches the underlying storage of the state to the one returned by fn, which is
+// called with the current storage while all the queries are paused. The ongoing transaction is
+// committed before fn is called, and fn should keep the current storage usable if it fails.
+func (s *State) SwitchStorage(fn func(old xi.Storage) (xi.Storage, error)) (err error) {
+	s.strgLock.Lock()
+	defer s.strgLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		err = ErrStateClosed
+		return
+	}
+	var strg xi.Storage
+	s.commitHandler()
+	s.handler = nil
+	defer func() {
+		s.openHandler()
+		s.readStmts.rebind(s.reader())
+		s.writeStmts.rebind(s.strg.Writer())
+	}()
+	if strg, err = fn(s.strg); err != nil {
+		return
+	}
+	s.strg = strg
+	if s.capture != nil || s.digest != nil {
+		var notifier, ok = s.strg.(xi.UpdateNotifier)
+		if !ok {
+			log.WithError(ErrChangeCaptureNotSupported).Error("row changes are not captured on new storage")
+		} else if err = notifier.SetUpdateHook(s.onUpdate); err != nil {
+			log.WithError(err).Error("row changes are not captured on new storage")
+			err = nil
+		}
+	}
+	return
+}
+
+// Snapshot calls fn with the queries paused and the ongoing transaction committed, so that the
+// underlying storage can be copied consistently. The seq passed to fn is the log offset of the
+// next write query, which is not applied to the storage yet.
+func (s *State) Snapshot(fn func(seq uint64) error) (err error) {
+	s.strgLock.Lock()
+	defer s.strgLock.Unlock()
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		err = ErrStateClosed
+		return
+	}
+	s.commitHandler()
+	s.handler = nil
+	defer s.openHandler()
+	return fn(s.getSeq())
+}
+
+// StorageSize returns the size of the underlying sqlite database in bytes, which is counted by
+// page_count * page_size and includes the pages written by the ongoing transaction.
+func (s *State) StorageSize() (size uint64, err error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		err = ErrStateClosed
+		return
+	}
+	var count, pageSize uint64
+	if count, err = s.pragmaUint64("page_count"); err != nil {
+		return
+	}
+	if pageSize, err = s.pragmaUint64("page_size"); err != nil {
+		return
+	}
+	size = count * pageSize
+	return
+}
+
+func (s *State) pragmaUint64(name string) (value uint64, err error) {
+	var rows *sql.Rows
+	if rows, err = s.handler.Query("PRAGMA " + name); err != nil {
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err == nil {
+			err = sql.ErrNoRows
+		}
+		return
+	}
+	err = rows.Scan(&value)
+	return
+}
+
+// commitChanges queues or drops the captured changes of a write request, the queued changes are
+// published once they are committed to the storage.
+func (s *State) commitChanges(failed bool) {
+	if s.capture == nil {
+		return
+	}
+	if failed && s.level == sql.LevelReadUncommitted {
+		// The whole request is rolled back
+		s.capture.discard()
+		return
+	}
+	s.capture.apply()
+	if s.level != sql.LevelReadUncommitted {
+		// The statements are committed on execution
+		s.publishChanges()
+	}
+}
+
+// publishChanges delivers the captured changes committed to the storage, the failed ones are
+// delivered again on the next commit.
+func (s *State) publishChanges() {
+	if s.capture == nil {
+		return
+	}
+	if err := s.capture.publish(); err != nil {
+		log.WithError(err).Error("failed to publish row changes")
+	}
+}
+
 func (s *State) openHandler() {
 	if s.level == sql.LevelReadUncommitted {
 		var err error
@@ -99,6 +331,85 @@ func (s *State) reader() *sql.DB {
 	return s.strg.Reader()
 }
 
+// readLevel returns the isolation level the read queries are served at, which is the strongest
+// one provided by the reader connections not exceeding the level of the state.
+func (s *State) readLevel() sql.IsolationLevel {
+	switch s.level {
+	case sql.LevelReadUncommitted, sql.LevelReadCommitted:
+		return s.level
+	case sql.LevelSerializable, sql.LevelLinearizable:
+		// A read-only transaction on a consistent snapshot is serializable as the writes are
+		// serialized by the single writer, but it may still miss the latest writes not committed
+		// on this replica.
+		return sql.LevelSerializable
+	default:
+		return sql.LevelSnapshot
+	}
+}
+
+// readView is the view of the state which the queries of a read request are served on.
+type readView struct {
+	conn      *sql.Conn
+	level     sql.IsolationLevel
+	tx        *sql.Tx
+	budget    xi.StepBudget
+	uninstall func()
+}
+
+// openReadView opens a view to serve the queries of a read request at the read level:
+//
+//   - read uncommitted: the queries are read by the dirty reader sharing cache with the writer,
+//     which sees the changes of the ongoing write transaction;
+//   - read committed: the queries are read by the private cache reader one by one in their own
+//     transactions, each of them sees the changes committed before it starts;
+//   - snapshot and above: the queries are read in a single transaction of the private cache
+//     reader, which sees the WAL snapshot taken by its first query, i.e., the changes committed
+//     after that are invisible to all the queries of the request.
+//
+// The queries are read on a single connection, which the step budget of the request is installed
+// on.
+func (s *State) openReadView(ctx context.Context) (v *readView, err error) {
+	var conn *sql.Conn
+	if conn, err = s.reader().Conn(ctx); err != nil {
+		return
+	}
+	v = &readView{conn: conn, level: s.readLevel()}
+	if v.budget, v.uninstall, err = s.installBudget(conn); err != nil {
+		_ = conn.Close()
+		v = nil
+		return
+	}
+	if v.level == sql.LevelReadCommitted {
+		return
+	}
+	if v.tx, err = conn.BeginTx(ctx, nil); err != nil {
+		v.close()
+		v = nil
+		return
+	}
+	return
+}
+
+// begin returns the querier of the next query in the view, and a function to end the query.
+func (v *readView) begin(ctx context.Context) (qer sqlQuerier, end func(), err error) {
+	if v.tx != nil {
+		return v.tx, func() {}, nil
+	}
+	var tx *sql.Tx
+	if tx, err = v.conn.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	return tx, func() { _ = tx.Rollback() }, nil
+}
+
+func (v *readView) close() {
+	if v.tx != nil {
+		_ = v.tx.Rollback()
+	}
+	v.uninstall()
+	_ = v.conn.Close()
+}
+
 func (s *State) incSeq() {
 	atomic.AddUint64(&s.current, 1)
 }
@@ -130,6 +441,8 @@ func (s *State) Close(commit bool) (err error) {
 			s.rollbackHandler()
 		}
 	}
+	s.readStmts.purge()
+	s.writeStmts.purge()
 	if err = s.strg.Close(); err != nil {
 		return
 	}
@@ -146,7 +459,8 @@ func buildTypeNamesFromSQLColumnTypes(types []*sql.ColumnType) (names []string)
 }
 
 func readSingle(
-	ctx context.Context, qer sqlQuerier, q *types.Query,
+	ctx context.Context, qer sqlQuerier, cache *stmtCache, q *types.Query, ext types.SQLiteExtension,
+	sb *sandbox,
 ) (
 	names []string, types []string, data [][]interface{}, err error,
 ) {
@@ -154,13 +468,30 @@ func readSingle(
 		rows    *sql.Rows
 		cols    []*sql.ColumnType
 		pattern string
-		args    []interface{}
+		cs      *cachedStmt
+		size    uint64
 	)
 
-	if _, pattern, args, err = convertQueryAndBuildArgs(q.Pattern, q.Args); err != nil {
+	if _, pattern, cs, err = cache.acquire(ctx, q.Pattern, ext); err != nil {
 		return
 	}
-	if rows, err = qer.QueryContext(ctx, pattern, args...); err != nil {
+	defer cache.release(cs)
+	sb.beginStmt()
+	defer func() { err = sb.endStmt(err) }()
+	var (
+		args   = buildArgs(q.Args)
+		direct = cs == nil || !cs.accepts(len(args))
+	)
+	if !direct {
+		var stmt = bindStmt(ctx, qer, cs.stmt)
+		if stmt != cs.stmt {
+			defer func() { _ = stmt.Close() }()
+		}
+		rows, err = stmt.QueryContext(ctx, args...)
+	} else {
+		rows, err = qer.QueryContext(ctx, pattern, args...)
+	}
+	if err != nil {
 		return
 	}
 	defer func() {
@@ -187,8 +518,52 @@ func readSingle(
 		if err = rows.Scan(dest...); err != nil {
 			return
 		}
+		size += rowSize(row)
+		if err = sb.checkRows(uint64(len(data)+1), size); err != nil {
+			return
+		}
 		data = append(data, row)
 	}
+	if ctx.Err() != nil {
+		// the rows are truncated as the statement is interrupted
+		err = ctx.Err()
+	} else if sb != nil && sb.budget != nil && sb.budget.Exceeded() {
+		// the rows are truncated as the statement is interrupted by the step budget
+		err = rows.Err()
+	}
+	return
+}
+
+// readQuery executes the read query q, or the statements of the stored procedure called by q,
+// and returns the result of the last statement executed.
+func readQuery(
+	ctx context.Context, qer sqlQuerier, cache *stmtCache, q *types.Query, ext types.SQLiteExtension,
+	sb *sandbox, meter *usageMeter,
+) (
+	cnames, ctypes []string, data [][]interface{}, err error,
+) {
+	var stmts []types.Query
+	if stmts, err = expandCall(ctx, qer, q); err != nil {
+		return
+	}
+	if name, ok := CalledProcedure(q.Pattern); ok {
+		// the querier may be the writer handler, the writes must not bypass the replication
+		for _, v := range stmts {
+			if !isReadOnlyQuery(v.Pattern) {
+				err = errors.Wrapf(ErrProcedureNotReadOnly, "procedure %s", name)
+				return
+			}
+		}
+	}
+	for i := range stmts {
+		if cnames, ctypes, data, err = readSingle(ctx, qer, cache, &stmts[i], ext, sb); err != nil {
+			return
+		}
+		if !isExplainQuery(stmts[i].Pattern) {
+			// Query plans are not billed as results
+			meter.read(data)
+		}
+	}
 	return
 }
 
@@ -207,14 +582,24 @@ func (s *State) read(req *types.Request) (ref *QueryTracker, resp *types.Respons
 func (s *State) readWithContext(
 	ctx context.Context, req *types.Request) (ref *QueryTracker, resp *types.Response, err error,
 ) {
+	s.strgLock.RLock()
+	defer s.strgLock.RUnlock()
 	var (
 		ierr           error
 		cnames, ctypes []string
 		data           [][]interface{}
+		meter          = newUsageMeter(nil)
+		level          = sql.LevelReadCommitted
 	)
+	if s.level == sql.LevelReadUncommitted {
+		level = sql.LevelReadUncommitted
+	}
 	// TODO(leventeliu): no need to run every read query here.
+	var sb = s.readSandbox(ctx, nil)
 	for i, v := range req.Payload.Queries {
-		if cnames, ctypes, data, ierr = readSingle(ctx, s.reader(), &v); ierr != nil {
+		if cnames, ctypes, data, ierr = readQuery(
+			ctx, s.reader(), s.readStmts, &v, s.extensions(), sb, meter,
+		); ierr != nil {
 			err = errors.Wrapf(ierr, "query at #%d failed", i)
 			// Add to failed pool list
 			s.pool.setFailed(req)
@@ -229,12 +614,14 @@ func (s *State) readWithContext(
 	resp = &types.Response{
 		Header: types.SignedResponseHeader{
 			ResponseHeader: types.ResponseHeader{
-				Request:     req.Header.RequestHeader,
-				RequestHash: req.Header.Hash(),
-				NodeID:      s.nodeID,
-				Timestamp:   s.getLocalTime(),
-				RowCount:    uint64(len(data)),
-				LogOffset:   s.getSeq(),
+				Request:        req.Header.RequestHeader,
+				RequestHash:    req.Header.Hash(),
+				NodeID:         s.nodeID,
+				Timestamp:      s.getLocalTime(),
+				RowCount:       uint64(len(data)),
+				LogOffset:      s.getSeq(),
+				Usage:          meter.done(),
+				IsolationLevel: int(level),
 			},
 		},
 		Payload: types.ResponsePayload{
@@ -247,31 +634,74 @@ func (s *State) readWithContext(
 }
 
 func (s *State) readTx(
-	ctx context.Context, req *types.Request) (ref *QueryTracker, resp *types.Response, err error,
+	ctx context.Context, req *types.Request, attached []Attachment, pin PinFunc,
+) (
+	ref *QueryTracker, resp *types.Response, err error,
 ) {
+	s.strgLock.RLock()
+	defer s.strgLock.RUnlock()
 	var (
 		id             = s.getSeq()
 		ierr           error
 		cnames, ctypes []string
 		data           [][]interface{}
 		querier        sqlQuerier
+		view           *readView
+		cache          *stmtCache
+		level          sql.IsolationLevel
+		sb             *sandbox
+		meter          *usageMeter
 	)
-	if s.level == sql.LevelReadUncommitted && atomic.LoadUint32(&s.hasSchemaChange) == 1 {
+	if len(attached) > 0 {
+		// the statements are not cached as they may reference the attached databases, and the
+		// committed state is read so that it's consistent with the attached ones
+		var (
+			conn   *sql.Conn
+			detach func()
+			tx     *sql.Tx
+		)
+		if conn, detach, ierr = attach(ctx, s.strg.Reader(), attached); ierr != nil {
+			err = errors.Wrap(ierr, "attach databases failed")
+			return
+		}
+		defer detach()
+		var (
+			budget    xi.StepBudget
+			uninstall func()
+		)
+		if budget, uninstall, ierr = s.installBudget(conn); ierr != nil {
+			err = errors.Wrap(ierr, "install step budget failed")
+			return
+		}
+		defer uninstall()
+		if tx, ierr = conn.BeginTx(ctx, nil); ierr != nil {
+			err = errors.Wrap(ierr, "open tx failed")
+			return
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		if ierr = pinSnapshots(ctx, tx, attached, pin); ierr != nil {
+			err = errors.Wrap(ierr, "read attached databases failed")
+			return
+		}
+		querier, level, sb = tx, sql.LevelSnapshot, s.readSandbox(ctx, budget)
+	} else if s.level == sql.LevelReadUncommitted && atomic.LoadUint32(&s.hasSchemaChange) == 1 {
 		// lock transaction
 		s.Lock()
 		defer s.Unlock()
-		querier = s.handler
+		querier, level = s.handler, sql.LevelReadUncommitted
+		// the writer budget is free while the State lock is held
+		sb = s.readSandbox(ctx, s.writeSandbox().budget)
 	} else {
-		var tx *sql.Tx
-		if tx, ierr = s.reader().Begin(); ierr != nil {
+		if view, ierr = s.openReadView(ctx); ierr != nil {
 			err = errors.Wrap(ierr, "open tx failed")
 			return
 		}
-		querier = tx
-		defer func() {
-			_ = tx.Rollback()
-		}()
+		defer view.close()
+		level, cache, sb = view.level, s.readStmts, s.readSandbox(ctx, view.budget)
 	}
+	meter = newUsageMeter(sb.budget)
 
 	defer func() {
 		if ctx.Err() != nil {
@@ -283,8 +713,24 @@ func (s *State) readTx(
 		}
 	}()
 
+	// the statements are executed with a separate context, as the read transaction discards its
+	// connection if it's canceled with its context
+	var qctx, cancel = s.queryContext(ctx, req)
+	defer cancel()
 	for i, v := range req.Payload.Queries {
-		if cnames, ctypes, data, ierr = readSingle(ctx, querier, &v); ierr != nil {
+		var end = func() {}
+		if view != nil {
+			if querier, end, ierr = view.begin(ctx); ierr != nil {
+				err = errors.Wrapf(ierr, "open tx of query at #%d failed", i)
+				return
+			}
+		}
+		cnames, ctypes, data, ierr = readQuery(qctx, querier, cache, &v, s.extensions(), sb, meter)
+		end()
+		if ierr != nil {
+			if qctx.Err() == context.DeadlineExceeded {
+				ierr = ErrQueryTimeout
+			}
 			err = errors.Wrapf(ierr, "query at #%d failed", i)
 			// Add to failed pool list
 			s.Lock()
@@ -301,12 +747,14 @@ func (s *State) readTx(
 	resp = &types.Response{
 		Header: types.SignedResponseHeader{
 			ResponseHeader: types.ResponseHeader{
-				Request:     req.Header.RequestHeader,
-				RequestHash: req.Header.Hash(),
-				NodeID:      s.nodeID,
-				Timestamp:   s.getLocalTime(),
-				RowCount:    uint64(len(data)),
-				LogOffset:   id,
+				Request:        req.Header.RequestHeader,
+				RequestHash:    req.Header.Hash(),
+				NodeID:         s.nodeID,
+				Timestamp:      s.getLocalTime(),
+				RowCount:       uint64(len(data)),
+				LogOffset:      id,
+				Usage:          meter.done(),
+				IsolationLevel: int(level),
 			},
 		},
 		Payload: types.ResponsePayload{
@@ -324,7 +772,9 @@ func (s *State) writeSingle(
 	var (
 		containsDDL bool
 		pattern     string
-		args        []interface{}
+		cache       *stmtCache
+		cs          *cachedStmt
+		sb          = s.writeSandbox()
 		//start       = time.Now()
 
 		//parsed, executed time.Duration
@@ -341,13 +791,58 @@ func (s *State) writeSingle(
 	//	}
 	//	log.WithFields(fields).Debug("writeSingle duration stat (us)")
 	//}()
-	if containsDDL, pattern, args, err = convertQueryAndBuildArgs(q.Pattern, q.Args); err != nil {
+	if atomic.LoadUint32(&s.hasSchemaChange) == 0 {
+		// Statements are not prepared on an uncommitted schema
+		cache = s.writeStmts
+	}
+	if containsDDL, pattern, cs, err = cache.acquire(ctx, q.Pattern, s.extensions()); err != nil {
 		return
 	}
+	defer cache.release(cs)
 	//parsed = time.Since(start)
-	if res, err = s.handler.Exec(pattern, args...); err == nil {
+	var hooked = s.capture != nil || s.digest != nil
+	if hooked {
+		if containsDDL && s.capture != nil && withoutRowidRegexp.MatchString(pattern) {
+			err = errors.Wrap(ErrWithoutRowidNotSupported, "row changes are captured")
+			return
+		}
+		// drop the rows changed out of any write request, e.g., by the checks
+		s.dropUpdates()
+	}
+	var (
+		args   = buildArgs(q.Args)
+		direct = cs == nil || !cs.accepts(len(args))
+	)
+	sb.beginStmt()
+	if !direct {
+		var stmt = bindStmt(ctx, s.handler, cs.stmt)
+		if stmt != cs.stmt {
+			defer func() { _ = stmt.Close() }()
+		}
+		res, err = stmt.Exec(args...)
+	} else {
+		res, err = s.handler.Exec(pattern, args...)
+	}
+	err = sb.endStmt(err)
+	if hooked {
+		if err != nil || containsDDL {
+			// the row changes of a failed statement are rolled back
+			s.dropUpdates()
+		} else {
+			err = s.collectUpdates(ctx)
+		}
+		if err == nil && containsDDL && s.digest != nil {
+			err = s.digest.digestSchema(ctx, s.contextHandler())
+		}
+	}
+	if err == nil {
 		if containsDDL {
 			atomic.StoreUint32(&s.hasSchemaChange, 1)
+			s.readStmts.purge()
+			s.writeStmts.purge()
+			if s.capture != nil {
+				s.capture.resetSchema()
+			}
 		}
 		s.incSeq()
 	}
@@ -355,6 +850,70 @@ func (s *State) writeSingle(
 	return
 }
 
+// writeQuery executes the write query q, or the statements of the stored procedure called by q,
+// and returns the results of the statements executed.
+func (s *State) writeQuery(ctx context.Context, q *types.Query) (res []sql.Result, err error) {
+	var stmts []types.Query
+	if stmts, err = expandCall(ctx, s.handler, q); err != nil {
+		return
+	}
+	res = make([]sql.Result, len(stmts))
+	for i := range stmts {
+		if res[i], err = s.writeSingle(ctx, &stmts[i]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// dropUpdates drops the row changes reported by the storage since the last statement.
+func (s *State) dropUpdates() {
+	if s.capture != nil {
+		s.capture.takeUpdates()
+	}
+	if s.digest != nil {
+		s.digest.takeRows()
+	}
+}
+
+// collectUpdates captures and digests the row changes made by the statement just executed.
+func (s *State) collectUpdates(ctx context.Context) (err error) {
+	if s.capture != nil {
+		if err = s.capture.capture(
+			ctx, s.contextHandler(), s.getSeq(), s.capture.takeUpdates(),
+		); err != nil {
+			return
+		}
+	}
+	if s.digest != nil {
+		err = s.digest.digest()
+	}
+	return
+}
+
+// contextHandler returns the handler of the ongoing write queries to read the changes made by them.
+func (s *State) contextHandler() sqlContextHandler {
+	if h, ok := s.handler.(sqlContextHandler); ok {
+		return h
+	}
+	return s.strg.Writer()
+}
+
+// useSource sets the deterministic source of the storage writer to the one of req, and returns
+// a function to reset it. It must be called with the State lock held.
+func (s *State) useSource(req *types.Request) (reset func()) {
+	var d, ok = s.strg.(xi.Deterministic)
+	if !ok {
+		return func() {}
+	}
+	s.src = newQuerySource(req)
+	d.SetDeterministicSource(s.src)
+	return func() {
+		s.src = nil
+		d.SetDeterministicSource(nil)
+	}
+}
+
 func (s *State) write(
 	ctx context.Context, req *types.Request, isLeader bool) (ref *QueryTracker, resp *types.Response, err error,
 ) {
@@ -364,6 +923,7 @@ func (s *State) write(
 		totalAffectedRows int64
 		curAffectedRows   int64
 		lastInsertID      int64
+		usage             types.ResourceUsage
 		start             = time.Now()
 
 		lockAcquired, writeDone, enqueued, lockReleased, respBuilt time.Duration
@@ -394,7 +954,8 @@ func (s *State) write(
 	if err = func() (err error) {
 		var (
 			ierr error
-			qcnt = len(req.Payload.Queries)
+			// the statements of a stored procedure are also applied atomically
+			savepoint = len(req.Payload.Queries) > 1 || callsProcedure(req.Payload.Queries)
 		)
 		s.Lock()
 		lockAcquired = time.Since(start)
@@ -402,8 +963,14 @@ func (s *State) write(
 			s.Unlock()
 			lockReleased = time.Since(start)
 		}()
+		s.isolateLimitedWrite()
+		defer func() { s.recoverLimitedWrite(err) }()
 		lastSeq = s.getSeq()
-		if qcnt > 1 && s.level == sql.LevelReadUncommitted {
+		defer s.useSource(req)()
+		defer func() { s.commitChanges(err != nil) }()
+		s.resetDigest()
+		var meter = newUsageMeter(s.writeSandbox().budget)
+		if savepoint && s.level == sql.LevelReadUncommitted {
 			// Set savepoint
 			if _, ierr = s.handler.Exec(`SAVEPOINT "?"`, lastSeq); ierr != nil {
 				err = errors.Wrapf(ierr, "failed to create savepoint %d", lastSeq)
@@ -421,8 +988,8 @@ func (s *State) write(
 			}()
 		}
 		for i, v := range req.Payload.Queries {
-			var res sql.Result
-			if res, ierr = s.writeSingle(ctx, &v); ierr != nil {
+			var res []sql.Result
+			if res, ierr = s.writeQuery(ctx, &v); ierr != nil {
 				err = errors.Wrapf(ierr, "execute at #%d failed", i)
 				// TODO(leventeliu): request may actually be partial succeed without
 				// rolling back.
@@ -430,12 +997,16 @@ func (s *State) write(
 				return
 			}
 
-			curAffectedRows, _ = res.RowsAffected()
-			lastInsertID, _ = res.LastInsertId()
-			totalAffectedRows += curAffectedRows
+			for _, r := range res {
+				curAffectedRows, _ = r.RowsAffected()
+				lastInsertID, _ = r.LastInsertId()
+				totalAffectedRows += curAffectedRows
+				meter.write(curAffectedRows)
+			}
 		}
+		usage = meter.done()
 		if s.level == sql.LevelReadUncommitted {
-			if qcnt > 1 {
+			if savepoint {
 				// Release savepoint
 				if _, ierr = s.handler.Exec(`RELEASE SAVEPOINT "?"`, lastSeq); ierr != nil {
 					err = errors.Wrapf(ierr, "failed to release savepoint %d", lastSeq)
@@ -448,6 +1019,8 @@ func (s *State) write(
 			atomic.LoadUint32(&s.hasSchemaChange) != 0 {
 			s.flushHandler()
 		}
+		query.Digest = s.takeDigest()
+		s.fireProbes()
 		writeDone = time.Since(start)
 		if isLeader {
 			s.pool.enqueue(lastSeq, query)
@@ -470,6 +1043,7 @@ func (s *State) write(
 				LogOffset:    lastSeq,
 				AffectedRows: totalAffectedRows,
 				LastInsertID: lastInsertID,
+				Usage:        usage,
 			},
 		},
 	}
@@ -493,18 +1067,23 @@ func (s *State) replay(ctx context.Context, req *types.Request, resp *types.Resp
 		)
 		return
 	}
+	defer s.useSource(req)()
+	defer func() { s.commitChanges(err != nil) }()
+	s.resetDigest()
 	for i, v := range req.Payload.Queries {
-		if _, ierr = s.writeSingle(ctx, &v); ierr != nil {
+		if _, ierr = s.writeQuery(ctx, &v); ierr != nil {
 			err = errors.Wrapf(ierr, "execute at #%d failed", i)
 			return
 		}
 	}
+	query.Digest = s.takeDigest()
 	// Try to commit if the ongoing tx is too large or schema is changed
 	if s.getSeq()-s.getLastCommitPoint() > s.maxTx ||
 		atomic.LoadUint32(&s.hasSchemaChange) != 0 {
 		s.flushHandler()
 	}
 	s.pool.enqueue(lastSeq, query)
+	s.fireProbes()
 	return
 }
 
@@ -517,9 +1096,19 @@ func (s *State) ReplayBlock(block *types.Block) (err error) {
 // ReplayBlockWithContext replays the queries from block with context. It also checks and
 // skips some preceding pooled queries.
 func (s *State) ReplayBlockWithContext(ctx context.Context, block *types.Block) (err error) {
+	_, err = s.ReplayBlockEx(ctx, block)
+	return
+}
+
+// ReplayBlockEx replays the queries from block with context like ReplayBlockWithContext, and
+// returns the digests of the write queries in block if the state digest is enabled and all of
+// them are known.
+func (s *State) ReplayBlockEx(
+	ctx context.Context, block *types.Block) (digests []hash.Hash, err error,
+) {
 	var (
-		ierr   error
-		lastsp uint64 // Last lastSeq
+		lastsp   uint64 // Last lastSeq
+		complete = s.digest != nil
 	)
 	s.Lock()
 	defer s.Unlock()
@@ -536,20 +1125,24 @@ func (s *State) ReplayBlockWithContext(ctx context.Context, block *types.Block)
 		// Match and skip already pooled query
 		if q.Response.ResponseHeader.LogOffset < lastsp {
 			// TODO(), recover logic after sqlchain forks by multiple write point
+			if complete {
+				var d, ok = s.pool.digestOf(q.Response.ResponseHeader.LogOffset)
+				digests = append(digests, d)
+				complete = ok
+			}
 			continue
 		}
 		// Replay query
-		for j, v := range q.Request.Payload.Queries {
-			if q.Request.Header.QueryType != types.WriteQuery {
-				err = errors.Wrapf(ErrInvalidRequest, "replay block at %d:%d", i, j)
-				return
-			}
-			if _, ierr = s.writeSingle(ctx, &v); ierr != nil {
-				err = errors.Wrapf(ierr, "execute at %d:%d failed", i, j)
-				return
-			}
+		s.resetDigest()
+		if err = s.replayQueries(ctx, i, q.Request); err != nil {
+			s.commitChanges(true)
+			return
 		}
+		s.commitChanges(false)
+		query.Digest = s.takeDigest()
+		digests = append(digests, query.Digest)
 		s.pool.enqueue(lastsp, query)
+		s.fireProbes()
 	}
 	// Always try to commit after a block is successfully replayed
 	s.flushHandler()
@@ -559,6 +1152,42 @@ func (s *State) ReplayBlockWithContext(ctx context.Context, block *types.Block)
 	}
 	// Truncate pooled queries
 	s.pool.truncate(lastsp)
+	if !complete {
+		digests = nil
+	} else if digests == nil {
+		// digested without any write query
+		digests = []hash.Hash{}
+	}
+	return
+}
+
+// resetDigest drops the digested rows of the ongoing request if the state digest is enabled.
+func (s *State) resetDigest() {
+	if s.digest != nil {
+		s.digest.reset()
+	}
+}
+
+// takeDigest returns the digest of the ongoing request, or an empty hash if the state digest is
+// disabled.
+func (s *State) takeDigest() (h hash.Hash) {
+	if s.digest != nil {
+		h = s.digest.take()
+	}
+	return
+}
+
+// replayQueries replays the queries of the i-th request in a block.
+func (s *State) replayQueries(ctx context.Context, i int, req *types.Request) (err error) {
+	defer s.useSource(req)()
+	for j, v := range req.Payload.Queries {
+		if req.Header.QueryType != types.WriteQuery {
+			return errors.Wrapf(ErrInvalidRequest, "replay block at %d:%d", i, j)
+		}
+		if _, err = s.writeQuery(ctx, &v); err != nil {
+			return errors.Wrapf(err, "execute at %d:%d failed", i, j)
+		}
+	}
 	return
 }
 
@@ -660,6 +1289,7 @@ func (s *State) commitHandler() {
 			log.WithError(err).Fatal("failed to commit")
 		}
 	}
+	s.publishChanges()
 	// reset schema change flag
 	atomic.StoreUint32(&s.hasSchemaChange, 0)
 	atomic.StoreUint64(&s.lastCommitPoint, s.getSeq())
@@ -670,6 +1300,9 @@ func (s *State) rollbackHandler() {
 		if err := tx.Rollback(); err != nil {
 			log.WithError(err).Fatal("failed to rollback")
 		}
+		if s.capture != nil {
+			s.capture.rollback()
+		}
 	}
 	// reset schema change flag
 	atomic.StoreUint32(&s.hasSchemaChange, 0)
@@ -692,8 +1325,9 @@ func (s *State) QueryWithContext(
 ) {
 	switch req.Header.QueryType {
 	case types.ReadQuery:
-		return s.readTx(ctx, req)
+		return s.readTx(ctx, req, nil, nil)
 	case types.WriteQuery:
+		// the replicated writes are never interrupted, otherwise the replicas may diverge
 		return s.write(ctx, req, isLeader)
 	default:
 		err = ErrInvalidRequest
@@ -701,6 +1335,83 @@ func (s *State) QueryWithContext(
 	return
 }
 
+// CheckWithContext executes the write query(ies) in req and rolls them back, it reports whether
+// the request can be executed on the current state without changing it.
+func (s *State) CheckWithContext(ctx context.Context, req *types.Request) (err error) {
+	if req.Header.QueryType != types.WriteQuery {
+		err = errors.Wrap(ErrInvalidRequest, "only write query can be checked")
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	defer s.useSource(req)()
+	var h sqlContextHandler
+	if th, ok := s.handler.(sqlContextHandler); ok && s.level == sql.LevelReadUncommitted {
+		h = th
+	} else {
+		// NOTE(leventeliu): the non-transactional handler may dispatch statements to different
+		// connections, use a dedicated one to keep the savepoint.
+		var conn *sql.Conn
+		if conn, err = s.strg.Writer().Conn(ctx); err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		h = conn
+	}
+	if _, err = h.ExecContext(ctx, `SAVEPOINT "check"`); err != nil {
+		err = errors.Wrap(err, "failed to create check savepoint")
+		return
+	}
+	defer func() {
+		_, _ = h.ExecContext(ctx, `ROLLBACK TO "check"`)
+		_, _ = h.ExecContext(ctx, `RELEASE SAVEPOINT "check"`)
+	}()
+	for i, v := range req.Payload.Queries {
+		var stmts []types.Query
+		if stmts, err = expandCall(ctx, h, &v); err != nil {
+			err = errors.Wrapf(err, "check at #%d failed", i)
+			return
+		}
+		for _, q := range stmts {
+			var pattern string
+			if _, pattern, _, err = (*stmtCache)(nil).acquire(ctx, q.Pattern, s.extensions()); err != nil {
+				err = errors.Wrapf(err, "check at #%d failed", i)
+				return
+			}
+			if _, err = h.ExecContext(ctx, pattern, buildArgs(q.Args)...); err != nil {
+				err = errors.Wrapf(err, "check at #%d failed", i)
+				return
+			}
+		}
+	}
+	return
+}
+
+// Pin pauses the commits of the state until release is called, and returns the log offset of the
+// next query which is not committed yet.
+func (s *State) Pin() (seq uint64, release func()) {
+	s.Lock()
+	if s.level != sql.LevelReadUncommitted {
+		// the queries are committed on execution
+		return s.getSeq(), s.Unlock
+	}
+	return s.getLastCommitPoint(), s.Unlock
+}
+
+// QueryAttachedWithContext does the read query(ies) in req with the databases attached read-only.
+// The read transaction is started on all the databases while they are pinned by pin, if any.
+func (s *State) QueryAttachedWithContext(
+	ctx context.Context, req *types.Request, attached []Attachment, pin PinFunc,
+) (
+	ref *QueryTracker, resp *types.Response, err error,
+) {
+	if req.Header.QueryType != types.ReadQuery {
+		err = errors.Wrap(ErrInvalidRequest, "attached databases are read-only")
+		return
+	}
+	return s.readTx(ctx, req, attached, pin)
+}
+
 // Replay replays a write log from other peer to replicate storage state.
 func (s *State) Replay(req *types.Request, resp *types.Response) (err error) {
 	return s.ReplayWithContext(context.Background(), req, resp)
@@ -727,6 +1438,13 @@ func (s *State) ReplayWithContext(
 	return
 }
 
+// StmtCacheStats returns the hit and miss counts of the prepared statement caches.
+func (s *State) StmtCacheStats() (hits, misses uint64) {
+	var rh, rm = s.readStmts.stats()
+	var wh, wm = s.writeStmts.stats()
+	return rh + wh, rm + wm
+}
+
 // Stat prints the statistic message of the State object.
 func (s *State) Stat(id proto.DatabaseID) {
 	var (
@@ -737,10 +1455,14 @@ func (s *State) Stat(id proto.DatabaseID) {
 		}()
 		fc = atomic.LoadInt32(&p.failedRequestCount)
 		tc = atomic.LoadInt32(&p.trackerCount)
+
+		hits, misses = s.StmtCacheStats()
 	)
 	log.WithFields(log.Fields{
 		"database_id":               id,
 		"pooled_fail_request_count": fc,
 		"pooled_query_tracker":      tc,
+		"stmt_cache_hits":           hits,
+		"stmt_cache_misses":         misses,
 	}).Info("xeno pool stats")
 }
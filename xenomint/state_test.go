@@ -23,8 +23,10 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
@@ -96,6 +98,259 @@ func TestState(t *testing.T) {
 				So(err, ShouldEqual, sql.ErrTxDone)
 			})
 		})
+		Convey("The storage size should grow with the uncommitted writes", func() {
+			var size1, size2 uint64
+			size1, err = st1.StorageSize()
+			So(err, ShouldBeNil)
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`INSERT INTO t1 VALUES (1, ?)`, strings.Repeat("v", 1<<16)),
+			}), true)
+			So(err, ShouldBeNil)
+			size2, err = st1.StorageSize()
+			So(err, ShouldBeNil)
+			So(size2, ShouldBeGreaterThan, size1+1<<16)
+			err = st1.Close(true)
+			So(err, ShouldBeNil)
+			_, err = st1.StorageSize()
+			So(err, ShouldEqual, ErrStateClosed)
+		})
+		Convey("The snapshot should include the uncommitted writes", func() {
+			var (
+				snap  = fmt.Sprint(fl1, ".snapshot")
+				count int
+			)
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`INSERT INTO t1 VALUES (1, 'v1')`),
+			}), true)
+			So(err, ShouldBeNil)
+			err = st1.Snapshot(func(seq uint64) error {
+				So(seq, ShouldEqual, 2)
+				return xs.Backup(fmt.Sprint("file:", fl1), fmt.Sprint("file:", snap))
+			})
+			So(err, ShouldBeNil)
+			defer func() {
+				for _, suffix := range []string{"", "-shm", "-wal"} {
+					_ = os.Remove(snap + suffix)
+				}
+			}()
+			var strg xi.Storage
+			strg, err = xs.NewSqlite(fmt.Sprint("file:", snap))
+			So(err, ShouldBeNil)
+			err = strg.Reader().QueryRow(`SELECT COUNT(1) FROM t1`).Scan(&count)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+			err = strg.Close()
+			So(err, ShouldBeNil)
+			// the state should be still writable after snapshot
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`INSERT INTO t1 VALUES (2, 'v2')`),
+			}), true)
+			So(err, ShouldBeNil)
+		})
+		Convey("The attached databases should be read at the pinned states", func() {
+			var (
+				resp   *types.Response
+				pinned bool
+				names  []string
+			)
+			names, err = ReferencedDatabases(
+				"SELECT * FROM t1 JOIN `db-x2`.t2 ON t1.k = `db-x2`.t2.k, main.t1 AS t3")
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"db-x2"})
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`INSERT INTO t1 VALUES (1, 'v1'), (2, 'v2')`),
+			}), true)
+			So(err, ShouldBeNil)
+			_, _, err = st2.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t2 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`INSERT INTO t2 VALUES (1, 'w1')`),
+			}), true)
+			So(err, ShouldBeNil)
+			_, _, err = st1.CommitEx()
+			So(err, ShouldBeNil)
+			_, _, err = st2.CommitEx()
+			So(err, ShouldBeNil)
+
+			var (
+				attached = []Attachment{{Name: "db-x2", File: fl2}}
+				req      = buildRequest(types.ReadQuery, []types.Query{
+					buildQuery("SELECT t1.v, t2.v FROM t1 JOIN `db-x2`.t2 AS t2 ON t1.k = t2.k"),
+				})
+			)
+			_, resp, err = st1.QueryAttachedWithContext(
+				context.Background(), req, attached, func() (func(), error) {
+					_, release := st2.Pin()
+					return func() { pinned = true; release() }, nil
+				})
+			So(err, ShouldBeNil)
+			So(pinned, ShouldBeTrue)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+			So(resp.Payload.Rows[0].Values, ShouldResemble, []interface{}{"v1", "w1"})
+
+			// attached databases are read-only
+			_, _, _ = st1.QueryAttachedWithContext(context.Background(), buildRequest(
+				types.ReadQuery, []types.Query{buildQuery("DELETE FROM `db-x2`.t2")},
+			), attached, nil)
+			_, resp, err = st2.Query(buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT COUNT(1) FROM t2`),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 1)
+			_, _, err = st1.QueryAttachedWithContext(context.Background(), buildRequest(
+				types.WriteQuery, []types.Query{buildQuery("DELETE FROM `db-x2`.t2")},
+			), attached, nil)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidRequest)
+			// the attached database is detached after query
+			_, _, err = st1.Query(req, true)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("The checked write query should not change the state", func() {
+			var resp *types.Response
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`INSERT INTO t1 VALUES (1, 'v1')`),
+			}), true)
+			So(err, ShouldBeNil)
+			err = st1.CheckWithContext(context.Background(), buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`INSERT INTO t1 VALUES (2, 'v2')`),
+				buildQuery(`UPDATE t1 SET v = 'x'`),
+			}))
+			So(err, ShouldBeNil)
+			err = st1.CheckWithContext(context.Background(), buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`INSERT INTO t1 VALUES (3, 'v3')`),
+				buildQuery(`INSERT INTO t1 VALUES (1, 'v1')`),
+			}))
+			So(err, ShouldNotBeNil)
+			err = st1.CheckWithContext(context.Background(), buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT * FROM t1`),
+			}))
+			So(errors.Cause(err), ShouldEqual, ErrInvalidRequest)
+			_, resp, err = st1.Query(buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT k, v FROM t1`),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+			So(resp.Payload.Rows[0].Values, ShouldResemble, []interface{}{int64(1), "v1"})
+		})
+		Convey("The read query should be interrupted on its deadline", func() {
+			var (
+				resp   *types.Response
+				req    *types.Request
+				values = make([]string, 100)
+				start  time.Time
+			)
+			for i := range values {
+				values[i] = fmt.Sprintf("(%d, 'v')", i)
+			}
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`INSERT INTO t1 VALUES ` + strings.Join(values, ", ")),
+			}), true)
+			So(err, ShouldBeNil)
+			req = buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT COUNT(1) FROM t1 a, t1 b, t1 c, t1 d, t1 e`),
+			})
+			start = time.Now()
+			req.Header.Deadline = start.Add(100 * time.Millisecond)
+			_, _, err = st1.Query(req, true)
+			So(errors.Cause(err), ShouldEqual, ErrQueryTimeout)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+
+			// the max execution time applies to the requests without deadline
+			st1.SetMaxQueryTime(100 * time.Millisecond)
+			req.Header.Deadline = time.Time{}
+			start = time.Now()
+			_, _, err = st1.Query(req, true)
+			So(errors.Cause(err), ShouldEqual, ErrQueryTimeout)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+			st1.SetMaxQueryTime(0)
+
+			// the state should serve the following queries
+			_, resp, err = st1.Query(buildRequest(types.ReadQuery, []types.Query{
+				buildQuery(`SELECT 1`),
+			}), true)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 1)
+		})
+		Convey("The statements should be interrupted beyond the query limits", func() {
+			var (
+				resp   *types.Response
+				values = make([]string, 100)
+				read   = func(ctx context.Context, pattern string) (err error) {
+					_, resp, err = st1.QueryWithContext(ctx, buildRequest(types.ReadQuery, []types.Query{
+						buildQuery(pattern),
+					}), true)
+					return
+				}
+				shouldExceed = func(err error, limit string, max uint64) {
+					So(err, ShouldNotBeNil)
+					So(errors.Cause(err), ShouldResemble, &LimitError{Limit: limit, Max: max})
+					So(err.Error(), ShouldContainSubstring, ErrLimitExceeded.Error())
+				}
+				ctx = context.Background()
+			)
+			for i := range values {
+				values[i] = fmt.Sprintf("(%d, 'v')", i)
+			}
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+				buildQuery(`INSERT INTO t1 VALUES ` + strings.Join(values, ", ")),
+			}), true)
+			So(err, ShouldBeNil)
+			err = st1.commit()
+			So(err, ShouldBeNil)
+			st1.SetLimits(types.QueryLimits{MaxRows: 10, MaxSteps: 100000, MaxMemory: 1 << 20})
+
+			shouldExceed(read(ctx, `SELECT k FROM t1`), LimitRows, 10)
+			So(st1.LimitViolations(LimitRows), ShouldEqual, 1)
+			shouldExceed(read(ctx, `SELECT COUNT(1) FROM t1 a, t1 b, t1 c`), LimitSteps, 100000)
+			So(st1.LimitViolations(LimitSteps), ShouldEqual, 1)
+			shouldExceed(read(ctx, `SELECT k, hex(zeroblob(300000)) FROM t1 LIMIT 2`), LimitMemory, 1<<20)
+			So(st1.LimitViolations(LimitMemory), ShouldEqual, 1)
+			// the values computed by the statements are limited even if the rows returned are small
+			shouldExceed(read(ctx, `SELECT length(group_concat(hex(zeroblob(20000)))) FROM t1`),
+				LimitMemory, 1<<20)
+			So(st1.LimitViolations(LimitMemory), ShouldEqual, 2)
+			err = read(ctx, `SELECT length(group_concat(hex(zeroblob(200)))) FROM t1`)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 40000+99)
+			err = read(ctx, `SELECT k, v FROM t1 LIMIT 10`)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 10)
+
+			// the limits of the requester override the ones of the database in the reads
+			ctx = WithLimits(ctx, types.QueryLimits{MaxRows: 1000, MaxSteps: 10000000})
+			err = read(ctx, `SELECT k FROM t1`)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows, ShouldHaveLength, 100)
+			err = read(ctx, `SELECT COUNT(1) FROM t1 a, t1 b, t1 c`)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 1000000)
+			shouldExceed(read(ctx, `SELECT k, hex(zeroblob(300000)) FROM t1 LIMIT 2`), LimitMemory, 1<<20)
+
+			// the writes are limited by the steps of the database, and rolled back on violation
+			// without the preceding writes
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`INSERT INTO t1 VALUES (100, 'v')`),
+			}), true)
+			So(err, ShouldBeNil)
+			_, _, err = st1.QueryWithContext(ctx, buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`INSERT INTO t1 SELECT a.k*10000+b.k*100+c.k+100, 'v' FROM t1 a, t1 b, t1 c`),
+			}), true)
+			shouldExceed(err, LimitSteps, 100000)
+			So(st1.LimitViolations(LimitSteps), ShouldEqual, 2)
+			err = read(ctx, `SELECT COUNT(1) FROM t1`)
+			So(err, ShouldBeNil)
+			So(resp.Payload.Rows[0].Values[0], ShouldEqual, 101)
+			_, _, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+				buildQuery(`INSERT INTO t1 VALUES (101, 'v')`),
+			}), true)
+			So(err, ShouldBeNil)
+			st1.SetLimits(types.QueryLimits{})
+		})
 		Convey("The state will report error on read with uncommitted schema change", func() {
 			var (
 				req = buildRequest(types.WriteQuery, []types.Query{
@@ -145,6 +400,63 @@ func TestState(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(resp.Header.RowCount, ShouldEqual, 0)
 			})
+			Convey("The state should meter resource usage of requests", func() {
+				_, resp, err = st1.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[0]...),
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[1]...),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Header.Usage.RowsWritten, ShouldEqual, 2)
+				So(resp.Header.Usage.RowsScanned, ShouldEqual, 0)
+				So(resp.Header.Usage.VMSteps, ShouldBeGreaterThan, 0)
+				_, resp, err = st1.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT k, v FROM t1`),
+				}), true)
+				So(err, ShouldBeNil)
+				// The full scan steps over the rows after the first one
+				So(resp.Header.Usage.RowsScanned, ShouldEqual, 1)
+				So(resp.Header.Usage.RowsWritten, ShouldEqual, 0)
+				So(resp.Header.Usage.ResultBytes, ShouldEqual, 2*(8+2))
+				var scan = resp.Header.Usage
+				_, resp, err = st1.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT k, v FROM t1 WHERE k = ?`, values[0][0]),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Rows, ShouldHaveLength, 1)
+				// The primary key lookup should scan no rows
+				So(resp.Header.Usage.RowsScanned, ShouldEqual, 0)
+				So(resp.Header.Usage.VMSteps, ShouldBeGreaterThan, 0)
+				So(resp.Header.Usage.VMSteps, ShouldBeLessThanOrEqualTo, scan.VMSteps)
+			})
+			Convey("The state should explain query plans without billing rows", func() {
+				_, resp, err = st1.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`EXPLAIN QUERY PLAN SELECT v FROM t1 WHERE k = ? ORDER BY v`, 1),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Columns, ShouldContain, "detail")
+				So(resp.Payload.Rows, ShouldNotBeEmpty)
+				So(resp.Header.Usage.ResultBytes, ShouldEqual, 0)
+				var (
+					data = make([][]interface{}, len(resp.Payload.Rows))
+					plan *types.QueryPlan
+				)
+				for i, v := range resp.Payload.Rows {
+					data[i] = v.Values
+				}
+				plan, err = types.NewQueryPlanFromRows(resp.Payload.Columns, data)
+				So(err, ShouldBeNil)
+				So(plan.Roots, ShouldNotBeEmpty)
+				_, resp, err = st1.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`EXPLAIN INSERT INTO t1 (k, v) VALUES (?, ?)`, values[0]...),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Columns, ShouldContain, "opcode")
+				_, resp, err = st1.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT COUNT(1) FROM t1`),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Rows[0].Values[0], ShouldEqual, 0)
+			})
 			Convey("The state should report invalid request with unknown query type", func() {
 				req = buildRequest(types.QueryType(0xff), []types.Query{
 					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, values[0]...),
@@ -619,32 +931,77 @@ func TestConvertQueryAndBuildArgs(t *testing.T) {
 			"CREATE 1", []types.NamedArg{})
 		So(err, ShouldNotBeNil)
 
-		// contains stateful query parts, create table with default current_timestamp
-		ddlQuery = "CREATE TABLE test (test datetime default current_timestamp)"
+		// explain query plan query
 		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
-			ddlQuery, []types.NamedArg{})
-		So(err, ShouldNotBeNil)
-		So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
+			"EXPLAIN QUERY PLAN SELECT * FROM test", []types.NamedArg{})
+		So(containsDDL, ShouldBeFalse)
+		So(sanitizedQuery, ShouldEqual, "EXPLAIN QUERY PLAN SELECT * FROM test")
+		So(err, ShouldBeNil)
 
-		// contains stateful query parts, using time expression
+		// explain ddl query is not executed
 		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
-			"SELECT current_timestamp", []types.NamedArg{})
-		So(err, ShouldNotBeNil)
-		So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
+			"explain create table test (test int)", []types.NamedArg{})
+		So(containsDDL, ShouldBeFalse)
+		So(err, ShouldBeNil)
 
+		// explained statement is sanitized too
+		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
+			"EXPLAIN QUERY PLAN SELECT date('now', 'localtime')", []types.NamedArg{})
+		So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
+		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
+			"EXPLAIN QUERY PLAN SHOW TABLES", []types.NamedArg{})
+		So(errors.Cause(err), ShouldEqual, ErrInvalidExplainQuery)
 		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
-			"SELECT current_date", []types.NamedArg{})
+			"EXPLAIN QUERY PLAN", []types.NamedArg{})
 		So(err, ShouldNotBeNil)
+
+		// the connection state of the storage is not accessible
+		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
+			"SELECT cql_claim_conn(1)", []types.NamedArg{})
 		So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
 
+		// stateful query parts are not rewritten in schema, create table with default
+		// current_timestamp or random values
+		for _, q := range []string{
+			"CREATE TABLE test (test datetime default current_timestamp)",
+			"CREATE TABLE test (test datetime default (datetime('now')))",
+			"CREATE TABLE test (test int default (random()))",
+		} {
+			_, _, _, err = convertQueryAndBuildArgs(q, []types.NamedArg{})
+			So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
+		}
+
+		// stateful query parts are rewritten, using time expression
+		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
+			"SELECT current_timestamp, CURRENT_DATE, current_time", []types.NamedArg{})
+		So(err, ShouldBeNil)
+		So(sanitizedQuery, ShouldEqual,
+			"SELECT (datetime(cql_now())), (date(cql_now())), (time(cql_now()))")
+
+		// stateful query parts are rewritten, using 'now' time value
+		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
+			`SELECT date('now'), DATETIME("NOW", '+1 day'), strftime('%s', 'now'), 'now'`,
+			[]types.NamedArg{})
+		So(err, ShouldBeNil)
+		So(sanitizedQuery, ShouldEqual,
+			`SELECT date(cql_now()), DATETIME(cql_now(), '+1 day'), strftime('%s', cql_now()), 'now'`)
+
+		// stateful query parts are rewritten, using random function
+		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
+			"SELECT random(), RANDOMBLOB(16); INSERT INTO test VALUES (random())", []types.NamedArg{})
+		So(err, ShouldBeNil)
+		So(sanitizedQuery, ShouldEqual,
+			"SELECT cql_random(), cql_randomblob(16); INSERT INTO test VALUES (cql_random())")
+
+		// contains stateful query parts, using localtime modifier
 		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
-			"SELECT current_time", []types.NamedArg{})
+			"SELECT datetime('now', 'localtime')", []types.NamedArg{})
 		So(err, ShouldNotBeNil)
 		So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
 
-		// contains stateful query parts, using random function
+		// contains stateful query parts, using 'now' in a nested expression
 		containsDDL, sanitizedQuery, sanitizedArgs, err = convertQueryAndBuildArgs(
-			"SELECT random()", []types.NamedArg{})
+			"SELECT date((SELECT 'now'))", []types.NamedArg{})
 		So(err, ShouldNotBeNil)
 		So(errors.Cause(err), ShouldEqual, ErrStatefulQueryParts)
 
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+)
+
+const (
+	// DefaultStmtCacheSize is the default capacity of the prepared statement cache.
+	DefaultStmtCacheSize = 256
+)
+
+// cachedStmt is a sanitized and prepared statement kept in stmtCache. It is reference counted so
+// that an evicted statement is only closed after all the ongoing queries have released it.
+type cachedStmt struct {
+	key      string
+	query    string // the sanitized query
+	stmt     *sql.Stmt
+	numInput int // number of placeholders of the driver statement, or -1 if not known
+	refs     int
+	evicted  bool
+}
+
+// accepts reports whether the statement could be executed with n arguments. A prepared statement
+// requires exactly the number of its placeholders, while a query executed directly just ignores
+// the extra arguments, so the caller should fallback to the latter on mismatch.
+func (cs *cachedStmt) accepts(n int) bool {
+	return cs.numInput < 0 || cs.numInput == n
+}
+
+// numInput returns the number of placeholders of query reported by the driver statement, which is
+// prepared on a dedicated connection of db.
+func numInput(ctx context.Context, db *sql.DB, query string) (n int, err error) {
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	err = conn.Raw(func(dc interface{}) (err error) {
+		var ds driver.Stmt
+		if pc, ok := dc.(driver.ConnPrepareContext); ok {
+			ds, err = pc.PrepareContext(ctx, query)
+		} else {
+			ds, err = dc.(driver.Conn).Prepare(query)
+		}
+		if err != nil {
+			return
+		}
+		defer func() { _ = ds.Close() }()
+		n = ds.NumInput()
+		return
+	})
+	return
+}
+
+// stmtCache is a LRU cache of sanitized and prepared statements keyed by the original query
+// pattern. Statements are prepared on db and bound to transactions on demand.
+type stmtCache struct {
+	sync.Mutex
+	db       *sql.DB
+	capacity int
+	lru      *list.List
+	index    map[string]*list.Element
+
+	// Atomic counters for stats
+	hits   uint64
+	misses uint64
+}
+
+func newStmtCache(db *sql.DB, capacity int) *stmtCache {
+	return &stmtCache{
+		db:       db,
+		capacity: capacity,
+		lru:      list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// acquire returns the cached statement of pattern, or sanitizes and prepares it on a cache miss.
+// The returned statement is nil if the pattern is not cacheable, e.g., a DDL, a transaction
+// control query or a multiple statements query, in which case the sanitized pattern p should be
+// executed directly. A non-nil statement must be released by the caller after use. The pattern is
+// sanitized with the allowed sqlite extensions ext, which is fixed for a database.
+func (c *stmtCache) acquire(
+	ctx context.Context, pattern string, ext types.SQLiteExtension,
+) (containsDDL bool, p string, cs *cachedStmt, err error) {
+	var count int
+	if c == nil {
+		containsDDL, p, _, err = sanitizeQuery(pattern, ext)
+		return
+	}
+	c.Lock()
+	if e, ok := c.index[pattern]; ok {
+		cs = e.Value.(*cachedStmt)
+		cs.refs++
+		p = cs.query
+		c.lru.MoveToFront(e)
+		c.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return
+	}
+	c.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	if containsDDL, p, count, err = sanitizeQuery(pattern, ext); err != nil {
+		return
+	}
+	if containsDDL || count != 1 {
+		return
+	}
+	var (
+		stmt *sql.Stmt
+		n    int
+	)
+	if n, err = numInput(ctx, c.db, p); err != nil {
+		return
+	}
+	if stmt, err = c.db.PrepareContext(ctx, p); err != nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.index[pattern]; ok {
+		// Prepared concurrently by another query, drop ours
+		_ = stmt.Close()
+		cs = e.Value.(*cachedStmt)
+		cs.refs++
+		c.lru.MoveToFront(e)
+		return
+	}
+	cs = &cachedStmt{key: pattern, query: p, stmt: stmt, numInput: n, refs: 1}
+	c.index[pattern] = c.lru.PushFront(cs)
+	for c.lru.Len() > c.capacity {
+		c.evict(c.lru.Back())
+	}
+	return
+}
+
+// release releases a statement returned by acquire.
+func (c *stmtCache) release(cs *cachedStmt) {
+	if c == nil || cs == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	cs.refs--
+	if cs.evicted && cs.refs == 0 {
+		_ = cs.stmt.Close()
+	}
+}
+
+func (c *stmtCache) evict(e *list.Element) {
+	var cs = e.Value.(*cachedStmt)
+	c.lru.Remove(e)
+	delete(c.index, cs.key)
+	cs.evicted = true
+	if cs.refs == 0 {
+		_ = cs.stmt.Close()
+	}
+}
+
+// purge evicts all the cached statements, it should be called on any schema change.
+func (c *stmtCache) purge() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	for e := c.lru.Back(); e != nil; e = c.lru.Back() {
+		c.evict(e)
+	}
+}
+
+// rebind purges all the cached statements, and then the statements are prepared on db.
+func (c *stmtCache) rebind(db *sql.DB) {
+	if c == nil {
+		return
+	}
+	c.purge()
+	c.Lock()
+	defer c.Unlock()
+	c.db = db
+}
+
+func (c *stmtCache) stats() (hits, misses uint64) {
+	if c == nil {
+		return
+	}
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+// bindStmt binds the prepared statement to qer if it is a transaction.
+func bindStmt(ctx context.Context, qer interface{}, stmt *sql.Stmt) *sql.Stmt {
+	if tx, ok := qer.(*sql.Tx); ok {
+		return tx.StmtContext(ctx, stmt)
+	}
+	return stmt
+}
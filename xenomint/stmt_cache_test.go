@@ -0,0 +1,147 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+	xs "github.com/CovenantSQL/CovenantSQL/xenomint/sqlite"
+)
+
+func TestStmtCache(t *testing.T) {
+	Convey("Given a state with statement cache", t, func() {
+		var (
+			filePath = path.Join(testingDataDir, t.Name())
+			state    *State
+			strg     xi.Storage
+			resp     *types.Response
+			err      error
+		)
+		strg, err = xs.NewSqlite(fmt.Sprint("file:", filePath))
+		So(err, ShouldBeNil)
+		state = NewState(sql.LevelReadUncommitted, nodeID, strg)
+		So(state, ShouldNotBeNil)
+		Reset(func() {
+			err = state.Close(true)
+			So(err, ShouldBeNil)
+			err = os.Remove(filePath)
+			So(err, ShouldBeNil)
+			err = os.Remove(fmt.Sprint(filePath, "-shm"))
+			So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+			err = os.Remove(fmt.Sprint(filePath, "-wal"))
+			So(err == nil || os.IsNotExist(err), ShouldBeTrue)
+		})
+		_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+			buildQuery(`CREATE TABLE t1 (k INT, v TEXT, PRIMARY KEY(k))`),
+		}), true)
+		So(err, ShouldBeNil)
+		err = state.commit()
+		So(err, ShouldBeNil)
+		hits, misses := state.StmtCacheStats()
+		So(hits, ShouldEqual, 0)
+		So(misses, ShouldEqual, 1)
+
+		Convey("Repeated patterns should hit the cache", func() {
+			for i := 0; i < 10; i++ {
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, i, fmt.Sprintf("v%d", i)),
+				}), true)
+				So(err, ShouldBeNil)
+			}
+			err = state.commit()
+			So(err, ShouldBeNil)
+			for i := 0; i < 10; i++ {
+				_, resp, err = state.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT v FROM t1 WHERE k=?`, i),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Rows, ShouldHaveLength, 1)
+				So(resp.Payload.Rows[0].Values[0], ShouldEqual, fmt.Sprintf("v%d", i))
+			}
+			hits, misses = state.StmtCacheStats()
+			So(hits, ShouldEqual, 18)
+			So(misses, ShouldEqual, 3)
+			So(state.readStmts.lru.Len(), ShouldEqual, 1)
+			So(state.writeStmts.lru.Len(), ShouldEqual, 1)
+
+			Convey("The cache should be invalidated on DDL", func() {
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`ALTER TABLE t1 ADD COLUMN v2 TEXT`),
+				}), true)
+				So(err, ShouldBeNil)
+				So(state.readStmts.lru.Len(), ShouldEqual, 0)
+				So(state.writeStmts.lru.Len(), ShouldEqual, 0)
+				_, resp, err = state.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT * FROM t1 WHERE k=?`, 1),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Columns, ShouldResemble, []string{"k", "v", "v2"})
+			})
+		})
+		Convey("The cached statements should fallback to direct execution on extra arguments", func() {
+			for i := 0; i < 2; i++ {
+				_, _, err = state.Query(buildRequest(types.WriteQuery, []types.Query{
+					buildQuery(`INSERT INTO t1 (k, v) VALUES (?, ?)`, i, "v", "extra"),
+				}), true)
+				So(err, ShouldBeNil)
+				_, resp, err = state.Query(buildRequest(types.ReadQuery, []types.Query{
+					buildQuery(`SELECT v FROM t1 WHERE k=?`, i, "extra"),
+				}), true)
+				So(err, ShouldBeNil)
+				So(resp.Payload.Rows, ShouldHaveLength, 1)
+			}
+			hits, misses = state.StmtCacheStats()
+			So(hits, ShouldEqual, 2)
+			So(misses, ShouldEqual, 3)
+		})
+		Convey("Stale statements should be closed on release after eviction", func() {
+			var (
+				cache = newStmtCache(strg.Reader(), 1)
+				cs1   *cachedStmt
+				cs2   *cachedStmt
+			)
+			_, _, cs1, err = cache.acquire(context.Background(), `SELECT v FROM t1 WHERE k=?`, 0)
+			So(err, ShouldBeNil)
+			So(cs1, ShouldNotBeNil)
+			So(cs1.numInput, ShouldEqual, 1)
+			So(cs1.accepts(1), ShouldBeTrue)
+			So(cs1.accepts(2), ShouldBeFalse)
+			_, _, cs2, err = cache.acquire(context.Background(), `SELECT k FROM t1 WHERE v=?`, 0)
+			So(err, ShouldBeNil)
+			So(cs2, ShouldNotBeNil)
+			So(cs1.evicted, ShouldBeTrue)
+			err = cs1.stmt.QueryRow(1).Scan(new(interface{}))
+			So(err, ShouldEqual, sql.ErrNoRows)
+			cache.release(cs1)
+			err = cs1.stmt.QueryRow(1).Scan(new(interface{}))
+			So(err, ShouldNotBeNil)
+			So(err, ShouldNotEqual, sql.ErrNoRows)
+			cache.release(cs2)
+			cache.purge()
+			So(cache.lru.Len(), ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2019 The CovenantSQL Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xenomint
+
+import (
+	"time"
+
+	"github.com/CovenantSQL/CovenantSQL/types"
+	xi "github.com/CovenantSQL/CovenantSQL/xenomint/interfaces"
+)
+
+// usageMeter meters the resource usage of a single request. The rows scanned and the instructions
+// executed are reported by the statement status collected by the step budget of the connection, so
+// they are not metered if the storage doesn't support the budget.
+type usageMeter struct {
+	start          time.Time
+	usage          types.ResourceUsage
+	budget         xi.StepBudget
+	scanned, steps uint64 // statement status of the budget when the request begins
+}
+
+func newUsageMeter(b xi.StepBudget) *usageMeter {
+	var m = &usageMeter{start: time.Now(), budget: b}
+	if b != nil {
+		m.scanned, m.steps = b.Usage()
+	}
+	return m
+}
+
+func (m *usageMeter) read(data [][]interface{}) {
+	for _, row := range data {
+		for _, v := range row {
+			m.usage.ResultBytes += estimateValueSize(v)
+		}
+	}
+}
+
+func (m *usageMeter) write(affected int64) {
+	if affected > 0 {
+		m.usage.RowsWritten += uint64(affected)
+	}
+}
+
+// done stops the execution timer and returns the metered usage with the statement status
+// collected since the request begins.
+func (m *usageMeter) done() types.ResourceUsage {
+	m.usage.ExecTime = uint64(time.Since(m.start) / time.Microsecond)
+	if m.budget != nil {
+		var scanned, steps = m.budget.Usage()
+		m.usage.RowsScanned, m.usage.VMSteps = scanned-m.scanned, steps-m.steps
+	}
+	return m.usage
+}
+
+// estimateValueSize returns the estimated encoded size of a value scanned from the sqlite driver.
+func estimateValueSize(v interface{}) uint64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case []byte:
+		return uint64(len(v))
+	case string:
+		return uint64(len(v))
+	default:
+		// int64, float64 and time.Time
+		return 8
+	}
+}